@@ -12,6 +12,7 @@ import (
 	"xiaozhi-esp32-server-golang/internal/app/server/auth"
 	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
 	user_config "xiaozhi-esp32-server-golang/internal/domain/config"
+	"xiaozhi-esp32-server-golang/internal/domain/tracing"
 
 	log "xiaozhi-esp32-server-golang/logger"
 
@@ -62,6 +63,11 @@ func Init(configFile string) error {
 
 	// memory 模块采用懒加载，使用时自动初始化，无需显式初始化
 
+	//init tracing, 失败时不影响服务启动
+	if err := tracing.Init(ctx); err != nil {
+		fmt.Printf("初始化链路追踪失败: %v\n", err)
+	}
+
 	//init auth
 	err = initAuthManager()
 	if err != nil {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
@@ -8,7 +9,9 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 	"xiaozhi-esp32-server-golang/internal/app/server"
+	"xiaozhi-esp32-server-golang/internal/domain/tracing"
 	log "xiaozhi-esp32-server-golang/logger"
 
 	"github.com/spf13/viper"
@@ -52,12 +55,31 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
 	log.Info("服务器已启动，按 Ctrl+C 退出")
-	<-quit
-
-	log.Info("正在关闭服务器...")
+	select {
+	case <-quit:
+		log.Info("正在关闭服务器, 排空进行中的会话...")
+		// 排空期间再次收到信号则直接退出
+		go func() {
+			<-quit
+			log.Warn("再次收到退出信号, 强制退出")
+			os.Exit(1)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), server.DrainTimeout())
+		appInstance.Shutdown(ctx)
+		cancel()
+	case <-appInstance.Done():
+		// 通过 /admin/drain 触发的排空已完成
+	}
 
 	// 停止周期性配置更新服务
 	StopPeriodicConfigUpdate()
 
+	// 上报剩余的链路追踪数据
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := tracing.Shutdown(ctx); err != nil {
+		log.Warnf("上报链路追踪数据失败: %v", err)
+	}
+	cancel()
+
 	log.Info("服务器已关闭")
 }
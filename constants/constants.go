@@ -6,8 +6,11 @@ const (
 )
 
 const (
-	AsrTypeFunAsr = "funasr"
-	AsrTypeDoubao = "doubao"
+	AsrTypeFunAsr   = "funasr"
+	AsrTypeDoubao   = "doubao"
+	AsrTypeSherpa   = "sherpa"
+	AsrTypeOpenAI   = "openai"
+	AsrTypeFailover = "failover"
 )
 
 const (
@@ -0,0 +1,93 @@
+package mqtt_server
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	mqttServer "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 限流统计中内置 mqtt 服务器的维度
+const transportMqtt = "mqtt"
+
+// LimitHook 按设备和 IP 限制并发连接数和每分钟连接次数
+type LimitHook struct {
+	mqttServer.HookBase
+	server  *mqttServer.Server
+	limiter *ratelimit.Limiter
+
+	// *mqttServer.Client => 释放会话名额的函数
+	releases sync.Map
+}
+
+func (h *LimitHook) ID() string {
+	return "limit-hook"
+}
+
+func (h *LimitHook) Provides(b byte) bool {
+	return b == mqttServer.OnConnect || b == mqttServer.OnSessionEstablished || b == mqttServer.OnDisconnect
+}
+
+// OnConnect 鉴权之前检查, 超过限制时回复 connack 后断开
+// 此时还不能占用名额, 鉴权失败的连接不会触发 OnDisconnect
+func (h *LimitHook) OnConnect(cl *mqttServer.Client, pk packets.Packet) error {
+	if isAdminUser(cl) {
+		return nil
+	}
+	deviceId, ip := parseMacFromClientId(cl.ID), remoteIp(cl)
+	err := h.limiter.AllowHandshake(transportMqtt, deviceId, ip)
+	if err == nil {
+		err = h.limiter.CheckSessions(transportMqtt, deviceId, ip)
+	}
+	if err == nil {
+		return nil
+	}
+
+	var limitErr *ratelimit.LimitError
+	if !errors.As(err, &limitErr) {
+		return err
+	}
+	log.Warnf("MQTT客户端 %s(%s) 触发限制: %s", cl.ID, ip, limitErr.Reason)
+
+	code := packets.ErrQuotaExceeded
+	if limitErr.Reason == ratelimit.ReasonHandshakeRate {
+		code = packets.ErrConnectionRateExceeded
+	}
+	if cl.Properties.ProtocolVersion < 5 {
+		// v3 没有对应的返回码
+		code = packets.ErrServerUnavailable
+	} else {
+		code.Reason = limitErr.Reason
+	}
+	if err := h.server.SendConnack(cl, code, false, nil); err != nil {
+		log.Warnf("MQTT客户端 %s 发送connack失败: %v", cl.ID, err)
+	}
+	return code
+}
+
+func (h *LimitHook) OnSessionEstablished(cl *mqttServer.Client, pk packets.Packet) {
+	if isAdminUser(cl) {
+		return
+	}
+	release := h.limiter.Track(parseMacFromClientId(cl.ID), remoteIp(cl))
+	h.releases.Store(cl, release)
+}
+
+func (h *LimitHook) OnDisconnect(cl *mqttServer.Client, err error, expire bool) {
+	if release, ok := h.releases.LoadAndDelete(cl); ok {
+		release.(func())()
+	}
+}
+
+func remoteIp(cl *mqttServer.Client) string {
+	host, _, err := net.SplitHostPort(cl.Net.Remote)
+	if err != nil {
+		return cl.Net.Remote
+	}
+	return host
+}
@@ -9,13 +9,45 @@ import (
 	"github.com/mochi-mqtt/server/v2/listeners"
 	"github.com/spf13/viper"
 
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
-func StartMqttServer() error {
+// 内置MQTT服务器实例, 用于退出时关闭
+var server *mqttServer.Server
+
+type MqttServerOption func(*mqttServerOptions)
+
+type mqttServerOptions struct {
+	limiter *ratelimit.Limiter
+}
+
+// WithLimiter 按设备和 IP 限制并发连接数和每分钟连接次数
+func WithLimiter(limiter *ratelimit.Limiter) MqttServerOption {
+	return func(o *mqttServerOptions) {
+		o.limiter = limiter
+	}
+}
+
+func StartMqttServer(opts ...MqttServerOption) error {
+	options := &mqttServerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	Server := mqttServer.New(&mqttServer.Options{
 		InlineClient: true,
 	})
+	server = Server
+
+	// 未配置限制时不添加
+	if options.limiter != nil {
+		err := Server.AddHook(&LimitHook{server: Server, limiter: options.limiter}, nil)
+		if err != nil {
+			log.Fatalf("添加 LimitHook 失败: %v", err)
+			return err
+		}
+	}
 
 	err := Server.AddHook(&AuthHook{}, nil)
 	if err != nil {
@@ -86,3 +118,12 @@ func StartMqttServer() error {
 	}
 	return nil
 }
+
+// StopMqttServer 关闭内置MQTT服务器的所有监听和客户端连接
+func StopMqttServer() error {
+	if server == nil {
+		return nil
+	}
+	log.Info("MQTT 服务器关闭")
+	return server.Close()
+}
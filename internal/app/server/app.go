@@ -4,14 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 	"xiaozhi-esp32-server-golang/internal/app/mqtt_server"
 	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/cluster"
 	"xiaozhi-esp32-server-golang/internal/app/server/mqtt_udp"
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	"xiaozhi-esp32-server-golang/internal/app/server/reminder"
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/app/server/webrtc"
 	"xiaozhi-esp32-server-golang/internal/app/server/websocket"
 	user_config "xiaozhi-esp32-server-golang/internal/domain/config"
 	config_types "xiaozhi-esp32-server-golang/internal/domain/config/types"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
 	log "xiaozhi-esp32-server-golang/logger"
 
 	cmap "github.com/orcaman/concurrent-map/v2"
@@ -23,26 +31,75 @@ import (
 type App struct {
 	wsServer       *websocket.WebSocketServer
 	mqttUdpAdapter *mqtt_udp.MqttUdpAdapter
+	webRtcServer   *webrtc.WebRtcServer
+	// 多节点部署时的设备路由表, 未启用集群时为 nil
+	registry *cluster.Registry
+	// 各协议共用的连接和频率限制, 未启用时为 nil
+	limiter *ratelimit.Limiter
+	// 定时提醒, 未启用时为 nil
+	reminderScheduler *reminder.Scheduler
+	// 声纹识别, 未启用时为 nil
+	speakerService *speaker.Service
 
 	// ChatManager管理 - 使用concurrent map
 	chatManagers cmap.ConcurrentMap[string, *chat.ChatManager]
+
+	eventHandle *EventHandle
+
+	// 排空状态, 见 Shutdown
+	draining     atomic.Bool
+	shutdownOnce sync.Once
+	done         chan struct{}
 }
 
 func NewApp() *App {
 	var err error
 	app := &App{
 		chatManagers: cmap.New[*chat.ChatManager](),
+		done:         make(chan struct{}),
 	}
+	app.limiter = newLimiter()
 	app.wsServer = app.newWebSocketServer()
 	app.mqttUdpAdapter, err = app.newMqttUdpAdapter()
 	if err != nil {
 		log.Errorf("newMqttUdpAdapter err: %+v", err)
 		return nil
 	}
+	app.webRtcServer, err = app.newWebRtcServer()
+	if err != nil {
+		log.Errorf("newWebRtcServer err: %+v", err)
+		return nil
+	}
+	app.registry, err = app.newRegistry()
+	if err != nil {
+		log.Errorf("newRegistry err: %+v", err)
+		return nil
+	}
+	app.reminderScheduler, err = app.newReminderScheduler()
+	if err != nil {
+		log.Errorf("newReminderScheduler err: %+v", err)
+		return nil
+	}
+	app.speakerService, err = app.newSpeakerService()
+	if err != nil {
+		log.Errorf("newSpeakerService err: %+v", err)
+		return nil
+	}
 	return app
 }
 
+// Run 启动所有服务后返回, 通过 Shutdown 关闭
 func (a *App) Run() {
+	if a.registry != nil {
+		if err := a.registry.Start(); err != nil {
+			log.Fatalf("启动集群注册失败: %+v", err)
+		}
+	}
+	a.registerAdminHandler()
+	// webrtc 信令路由挂在 websocket 的 http server 上, 需在其启动前注册
+	if a.webRtcServer != nil {
+		a.webRtcServer.Start()
+	}
 	go a.wsServer.Start()
 	if viper.GetBool("mqtt_server.enable") {
 		go func() {
@@ -60,16 +117,21 @@ func (a *App) Run() {
 	// 注册聊天相关的本地MCP工具
 	a.registerChatMCPTools()
 
+	if a.reminderScheduler != nil {
+		a.reminderScheduler.Start()
+	}
+
 	a.registerHandler()
 
 	a.initEventHandle()
 
-	select {} // 阻塞主线程
+	// 依赖 eventHandle, 放在最后
+	a.registerMetrics()
 }
 
 func (app *App) initEventHandle() {
-	eventHandle := NewEventHandle()
-	eventHandle.Start()
+	app.eventHandle = NewEventHandle()
+	app.eventHandle.Start()
 }
 
 func (app *App) newMqttUdpAdapter() (*mqtt_udp.MqttUdpAdapter, error) {
@@ -103,30 +165,118 @@ func (app *App) newUdpServer() (*mqtt_udp.UdpServer, error) {
 	externalHost := viper.GetString("udp.external_host")
 	externalPort := viper.GetInt("udp.external_port")
 
-	udpServer := mqtt_udp.NewUDPServer(udpPort, externalHost, externalPort)
+	jitterDepth := mqtt_udp.DefaultJitterBufferDepth
+	if viper.IsSet("udp.jitter_buffer_depth") {
+		jitterDepth = viper.GetInt("udp.jitter_buffer_depth")
+	}
+
+	udpServer := mqtt_udp.NewUDPServer(udpPort, externalHost, externalPort,
+		mqtt_udp.WithJitterBufferDepth(jitterDepth),
+		mqtt_udp.WithReplayWindow(viper.GetInt("udp.replay_window")),
+		mqtt_udp.WithKeyRotation(
+			time.Duration(viper.GetInt("udp.key_rotation_interval"))*time.Second,
+			time.Duration(viper.GetInt("udp.key_overlap"))*time.Second,
+		),
+		mqtt_udp.WithLimiter(app.limiter),
+	)
 	err := udpServer.Start()
 	if err != nil {
 		log.Fatalf("udpServer.Start err: %+v", err)
 		return nil, err
 	}
+	// 与 websocket 共用 http server, 统计中列出了设备id, 需要 admin_token
+	http.HandleFunc("/xiaozhi/api/udp/stats", adminAuth(udpServer.HandleJitterStats))
 	return udpServer, nil
 }
 
 func (app *App) newWebSocketServer() *websocket.WebSocketServer {
 	port := viper.GetInt("websocket.port")
-	return websocket.NewWebSocketServer(port, websocket.WithOnNewConnection(app.OnNewConnection))
+	opts := []websocket.WebSocketServerOption{
+		websocket.WithOnNewConnection(app.OnNewConnection),
+		websocket.WithOnNewTextChat(app.OnNewTextChat),
+		websocket.WithLimiter(app.limiter),
+		websocket.WithTrustedProxies(viper.GetStringSlice("rate_limit.trusted_proxies")),
+	}
+	if viper.GetBool("websocket.tls.enable") {
+		opts = append(opts, websocket.WithTls(&websocket.TlsConfig{
+			CertFile:     viper.GetString("websocket.tls.cert"),
+			KeyFile:      viper.GetString("websocket.tls.key"),
+			ClientCaFile: viper.GetString("websocket.tls.client_ca"),
+			ClientAuth:   viper.GetString("websocket.tls.client_auth"),
+			CnAsDeviceId: viper.GetBool("websocket.tls.cn_as_device_id"),
+		}))
+	}
+	return websocket.NewWebSocketServer(port, opts...)
+}
+
+func (app *App) newWebRtcServer() (*webrtc.WebRtcServer, error) {
+	if !viper.GetBool("webrtc.enable") {
+		return nil, nil
+	}
+	opts := []webrtc.WebRtcServerOption{
+		webrtc.WithIceServers(viper.GetStringSlice("webrtc.ice_servers")),
+		webrtc.WithPublicIps(viper.GetStringSlice("webrtc.public_ips")),
+		webrtc.WithOnNewConnection(app.OnNewConnection),
+		webrtc.WithDeviceIdResolver(app.wsServer.GetDeviceId),
+		webrtc.WithClientIpResolver(app.wsServer.ClientIp),
+		webrtc.WithLimiter(app.limiter),
+	}
+	// 浏览器等 webrtc 客户端不走 ota 激活流程, 开启认证时只允许已激活的设备连接
+	if viper.GetBool("auth.enable") {
+		opts = append(opts, webrtc.WithActivationCheck(isDeviceActivated))
+	}
+	return webrtc.NewWebRtcServer(opts...)
+}
+
+// isDeviceActivated 通过配置提供者检查设备激活状态, 与 ota 使用同一来源
+func isDeviceActivated(ctx context.Context, deviceId string, clientId string) (bool, error) {
+	configProvider, err := user_config.GetProvider(viper.GetString("config_provider.type"))
+	if err != nil {
+		return false, err
+	}
+	return configProvider.IsDeviceActivated(ctx, deviceId, clientId)
 }
 
 func (app *App) startMqttServer() error {
-	return mqtt_server.StartMqttServer()
+	return mqtt_server.StartMqttServer(mqtt_server.WithLimiter(app.limiter))
+}
+
+func newLimiter() *ratelimit.Limiter {
+	if !viper.GetBool("rate_limit.enable") {
+		return nil
+	}
+	limiter := ratelimit.NewLimiter(ratelimit.Config{
+		MaxSessionsPerDevice: viper.GetInt("rate_limit.max_sessions_per_device"),
+		MaxSessionsPerIp:     viper.GetInt("rate_limit.max_sessions_per_ip"),
+		HandshakesPerMinute:  viper.GetInt("rate_limit.handshakes_per_minute"),
+		AudioFramesPerSecond: viper.GetInt("rate_limit.audio_frames_per_second"),
+	})
+	// 与 websocket 共用 http server, 统计中有设备id和客户端ip, 需要 admin_token
+	http.HandleFunc("/xiaozhi/api/ratelimit/stats", adminAuth(limiter.HandleStats))
+	return limiter
 }
 
 // 所有协议新连接都走这里
 func (a *App) OnNewConnection(transport types.IConn) {
 	deviceID := transport.GetDeviceID()
 
+	if a.draining.Load() {
+		log.Infof("服务器排空中, 拒绝设备 %s 的新连接", deviceID)
+		transport.Close()
+		return
+	}
+
 	// 检查是否已存在该设备的ChatManager
 	if existingManager, exists := a.chatManagers.Get(deviceID); exists {
+		// 续连窗口内重连, 复用原有会话, 不触发上下线通知
+		if existingManager.CanResume() {
+			err := existingManager.Resume(transport)
+			if err == nil {
+				a.onReminderDeviceOnline(deviceID)
+				return
+			}
+			log.Warnf("设备 %s 复用会话失败: %v, 重新创建", deviceID, err)
+		}
 		log.Infof("设备 %s 已存在ChatManager，先关闭旧的连接", deviceID)
 		// 关闭旧的ChatManager
 		existingManager.Close()
@@ -134,7 +284,12 @@ func (a *App) OnNewConnection(transport types.IConn) {
 	}
 
 	// 创建新的ChatManager
-	chatManager, err := chat.NewChatManager(deviceID, transport)
+	resumeWindow := time.Duration(viper.GetInt("chat.session_resume_window")) * time.Second
+	opts := []chat.ChatManagerOption{chat.WithResumeWindow(resumeWindow)}
+	if a.speakerService != nil {
+		opts = append(opts, chat.WithChatSessionOptions(chat.WithSpeakerService(a.speakerService)))
+	}
+	chatManager, err := chat.NewChatManager(deviceID, transport, opts...)
 	if err != nil {
 		log.Errorf("创建chatManager失败: %v", err)
 		return
@@ -143,7 +298,9 @@ func (a *App) OnNewConnection(transport types.IConn) {
 	// 存储ChatManager
 	a.chatManagers.Set(deviceID, chatManager)
 
+	a.registerDevice(deviceID)
 	a.DeviceOnline(deviceID)
+	a.onReminderDeviceOnline(deviceID)
 
 	log.Infof("设备 %s 的ChatManager已创建并存储", deviceID)
 
@@ -154,6 +311,7 @@ func (a *App) OnNewConnection(transport types.IConn) {
 			if storedManager, exists := a.chatManagers.Get(deviceID); exists && storedManager == chatManager {
 				a.chatManagers.Remove(deviceID)
 				log.Infof("设备 %s 的ChatManager已从映射中移除", deviceID)
+				a.unregisterDevice(deviceID)
 				a.DeviceOffline(deviceID)
 			}
 		}()
@@ -164,6 +322,17 @@ func (a *App) OnNewConnection(transport types.IConn) {
 	}()
 }
 
+// OnNewTextChat 文本对话入口, 与语音连接共用排空检查
+// 文本对话按请求创建会话, 不登记到 chatManagers, 不会替换设备正在进行的语音会话
+func (a *App) OnNewTextChat(transport types.IConn, opts ...chat.ChatManagerOption) (*chat.ChatManager, error) {
+	deviceID := transport.GetDeviceID()
+	if a.draining.Load() {
+		log.Infof("服务器排空中, 拒绝 %s 的文本对话", deviceID)
+		return nil, types.ErrServerDraining
+	}
+	return chat.NewChatManager(deviceID, transport, opts...)
+}
+
 // GetChatManager 获取指定设备的ChatManager
 func (a *App) GetChatManager(deviceID string) (*chat.ChatManager, bool) {
 	return a.chatManagers.Get(deviceID)
@@ -174,6 +343,7 @@ func (a *App) CloseChatManager(deviceID string) bool {
 	if manager, exists := a.chatManagers.Get(deviceID); exists {
 		manager.Close()
 		a.chatManagers.Remove(deviceID)
+		a.unregisterDevice(deviceID)
 		log.Infof("设备 %s 的ChatManager已关闭并移除", deviceID)
 		return true
 	}
@@ -211,6 +381,12 @@ func (a *App) CloseAllChatManagers() {
 func (s *App) registerChatMCPTools() {
 	// 调用chat包的注册函数
 	chat.RegisterChatMCPTools()
+	if s.reminderScheduler != nil {
+		chat.RegisterReminderMCPTools(s.reminderScheduler)
+	}
+	if s.speakerService != nil {
+		chat.RegisterSpeakerMCPTools(s.speakerService)
+	}
 
 	log.Info("聊天相关的本地MCP工具注册完成")
 }
@@ -254,9 +430,10 @@ func (a *App) registerHandler() {
 // 向客户端注入消息
 func (a *App) HandleInjectMsg(ctx context.Context, eventType string, eventData map[string]interface{}) (string, error) {
 	type InjectMsg struct {
-		SkipLlm  bool   `json:"skip_llm"`
-		DeviceId string `json:"device_id"`
-		Message  string `json:"message"`
+		RequestId string `json:"request_id"`
+		SkipLlm   bool   `json:"skip_llm"`
+		DeviceId  string `json:"device_id"`
+		Message   string `json:"message"`
 	}
 	bodyBytes, _ := json.Marshal(eventData)
 	var msg InjectMsg
@@ -276,21 +453,19 @@ func (a *App) HandleInjectMsg(ctx context.Context, eventType string, eventData m
 		return "", fmt.Errorf("message is required")
 	}
 
-	// 获取指定设备的ChatManager
-	chatManager, exists := a.GetChatManager(msg.DeviceId)
-	if !exists {
-		log.Errorf("HandleInjectMsg: device %s not found or offline", msg.DeviceId)
-		return "", fmt.Errorf("device %s not found or offline", msg.DeviceId)
-	}
-
 	log.Debugf("HandleInjectMsg: injecting message to device %s, skip_llm: %v, message: %s",
 		msg.DeviceId, msg.SkipLlm, msg.Message)
 
-	// 使用ChatManager的公开方法注入消息
-	err = chatManager.InjectMessage(msg.Message, msg.SkipLlm)
+	if !a.claimBroadcastInject(ctx, msg.RequestId) {
+		log.Debugf("HandleInjectMsg: request %s to device %s already handled by other node", msg.RequestId, msg.DeviceId)
+		return "message injected successfully", nil
+	}
+
+	// 设备不在本节点时转发到其所在节点
+	err = a.InjectMessage(ctx, msg.DeviceId, msg.Message, msg.SkipLlm)
 	if err != nil {
 		log.Errorf("HandleInjectMsg: failed to inject message to device %s: %v", msg.DeviceId, err)
-		return "", fmt.Errorf("failed to inject message: %v", err)
+		return "", err
 	}
 
 	return "message injected successfully", nil
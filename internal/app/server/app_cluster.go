@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/cluster"
+	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 管理后台的注入消息是广播给所有节点的, 在该时间窗口内对同一个 request_id 只处理一次
+const injectBroadcastDedupWindow = 5 * time.Second
+
+func (a *App) newRegistry() (*cluster.Registry, error) {
+	if !viper.GetBool("cluster.enable") {
+		return nil, nil
+	}
+	return cluster.NewRegistry(
+		redisdb.GetClient(),
+		cluster.WithNodeId(viper.GetString("cluster.node_id")),
+		cluster.WithKeyPrefix(viper.GetString("redis.key_prefix")),
+		cluster.WithHeartbeatInterval(time.Duration(viper.GetInt("cluster.heartbeat_interval"))*time.Second),
+		cluster.WithForwardTimeout(time.Duration(viper.GetInt("cluster.forward_timeout"))*time.Second),
+		cluster.WithCommandHandler(a.handleClusterCommand),
+	)
+}
+
+// registerAdminHandler 管理接口, 涉及设备的请求在设备不在本节点时转发到其所在节点
+func (a *App) registerAdminHandler() {
+	http.HandleFunc("/admin/inject_msg", adminAuth(a.handleAdminInjectMsg))
+	http.HandleFunc("/admin/kick", adminAuth(a.handleAdminKick))
+	http.HandleFunc("/admin/device_status", adminAuth(a.handleAdminDeviceStatus))
+	http.HandleFunc("/admin/drain", adminAuth(a.handleAdminDrain))
+}
+
+// adminAuth 管理接口与设备共用端口, 请求需带 Authorization: Bearer <server.admin_token>,
+// 未配置 admin_token 时管理接口不可用
+func adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := viper.GetString("server.admin_token")
+		if token == "" {
+			http.Error(w, "未配置server.admin_token, 管理接口不可用", http.StatusForbidden)
+			return
+		}
+		authToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(authToken), []byte(token)) != 1 {
+			log.Warnf("管理接口 %s 认证失败, addr: %s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "管理接口认证失败", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// registerDevice 设备上线时登记到本节点, 若设备之前连在其他节点上则通知其释放旧会话
+func (a *App) registerDevice(deviceID string) {
+	if a.registry == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.DefaultForwardTimeout)
+	defer cancel()
+
+	prevNode, err := a.registry.Register(ctx, deviceID)
+	if err != nil {
+		log.Errorf("设备 %s 注册到集群失败: %v", deviceID, err)
+		return
+	}
+	if prevNode == "" {
+		return
+	}
+	log.Infof("设备 %s 从节点 %s 迁移到本节点, 通知旧节点释放会话", deviceID, prevNode)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cluster.DefaultForwardTimeout)
+		defer cancel()
+		reply, err := a.registry.Forward(ctx, prevNode, &cluster.Command{Type: cluster.CommandKick, DeviceId: deviceID})
+		if err == nil {
+			err = reply.Err()
+		}
+		if err != nil {
+			log.Warnf("通知节点 %s 释放设备 %s 会话失败: %v", prevNode, deviceID, err)
+		}
+	}()
+}
+
+func (a *App) unregisterDevice(deviceID string) {
+	if a.registry == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.DefaultForwardTimeout)
+	defer cancel()
+	if err := a.registry.Unregister(ctx, deviceID); err != nil {
+		log.Warnf("设备 %s 从集群注销失败: %v", deviceID, err)
+	}
+}
+
+// lookupRemoteNode 查询设备所在的其他节点, 设备在本节点或未启用集群时返回空
+func (a *App) lookupRemoteNode(ctx context.Context, deviceID string) (string, error) {
+	if a.registry == nil {
+		return "", nil
+	}
+	if _, exists := a.GetChatManager(deviceID); exists {
+		return "", nil
+	}
+	nodeId, err := a.registry.Lookup(ctx, deviceID)
+	if err != nil {
+		return "", err
+	}
+	if a.registry.IsLocal(nodeId) {
+		return "", nil
+	}
+	return nodeId, nil
+}
+
+func (a *App) forward(ctx context.Context, nodeId string, cmd *cluster.Command) (json.RawMessage, error) {
+	log.Debugf("设备 %s 在节点 %s 上, 转发 %s 命令", cmd.DeviceId, nodeId, cmd.Type)
+	reply, err := a.registry.Forward(ctx, nodeId, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Result, reply.Err()
+}
+
+// InjectMessage 向设备注入消息, 设备在其他节点时转发过去
+func (a *App) InjectMessage(ctx context.Context, deviceID string, message string, skipLlm bool) error {
+	nodeId, err := a.lookupRemoteNode(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("device %s not found or offline", deviceID)
+	}
+	if nodeId != "" {
+		_, err := a.forward(ctx, nodeId, &cluster.Command{
+			Type:     cluster.CommandInject,
+			DeviceId: deviceID,
+			Data:     map[string]interface{}{"message": message, "skip_llm": skipLlm},
+		})
+		return err
+	}
+	return a.injectLocal(deviceID, message, skipLlm)
+}
+
+func (a *App) injectLocal(deviceID string, message string, skipLlm bool) error {
+	chatManager, exists := a.GetChatManager(deviceID)
+	if !exists {
+		return fmt.Errorf("device %s not found or offline", deviceID)
+	}
+	if err := chatManager.InjectMessage(message, skipLlm); err != nil {
+		return fmt.Errorf("failed to inject message: %v", err)
+	}
+	return nil
+}
+
+// claimBroadcastInject 广播来的注入消息由设备所在节点直接处理, 其他节点也会转发一份过去,
+// 按发起方生成的 request_id 在集群内抢占, 保证同一次请求只被执行一次;
+// 没有 request_id 时无法区分重复广播与用户重复发送, 不做去重
+func (a *App) claimBroadcastInject(ctx context.Context, requestId string) bool {
+	if a.registry == nil || requestId == "" {
+		return true
+	}
+	ok, err := a.registry.Claim(ctx, "inject:"+requestId, injectBroadcastDedupWindow)
+	if err != nil {
+		// redis 异常时宁可重复也不丢消息
+		log.Warnf("抢占注入消息处理权失败: %v", err)
+		return true
+	}
+	return ok
+}
+
+// KickDevice 断开设备连接并释放会话, 设备在其他节点时转发过去
+func (a *App) KickDevice(ctx context.Context, deviceID string) error {
+	nodeId, err := a.lookupRemoteNode(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("device %s not found or offline", deviceID)
+	}
+	if nodeId != "" {
+		_, err := a.forward(ctx, nodeId, &cluster.Command{Type: cluster.CommandKick, DeviceId: deviceID})
+		return err
+	}
+	if !a.CloseChatManager(deviceID) {
+		return fmt.Errorf("device %s not found or offline", deviceID)
+	}
+	return nil
+}
+
+// GetDeviceStatus 查询设备会话状态, 设备在其他节点时转发过去
+func (a *App) GetDeviceStatus(ctx context.Context, deviceID string) (*cluster.DeviceStatus, error) {
+	nodeId, err := a.lookupRemoteNode(ctx, deviceID)
+	if err == cluster.ErrDeviceNotFound {
+		return &cluster.DeviceStatus{DeviceId: deviceID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if nodeId == "" {
+		return a.localDeviceStatus(deviceID), nil
+	}
+
+	result, err := a.forward(ctx, nodeId, &cluster.Command{Type: cluster.CommandStatus, DeviceId: deviceID})
+	if err != nil {
+		return nil, err
+	}
+	var status cluster.DeviceStatus
+	if err := json.Unmarshal(result, &status); err != nil {
+		return nil, fmt.Errorf("解析设备状态失败: %v", err)
+	}
+	return &status, nil
+}
+
+func (a *App) localDeviceStatus(deviceID string) *cluster.DeviceStatus {
+	status := &cluster.DeviceStatus{DeviceId: deviceID}
+	if a.registry != nil {
+		status.NodeId = a.registry.NodeId()
+	}
+	chatManager, exists := a.GetChatManager(deviceID)
+	if !exists {
+		return status
+	}
+	status.Online = true
+	status.TransportType = chatManager.GetTransportType()
+	status.Detached = chatManager.IsDetached()
+	if clientState := chatManager.GetClientState(); clientState != nil {
+		status.SessionId = clientState.SessionID
+		status.Status = string(clientState.GetStatus())
+		status.StatusSince = clientState.GetStatusSince()
+	}
+	return status
+}
+
+// handleClusterCommand 处理其他节点转发来的命令, 只操作本节点上的会话
+func (a *App) handleClusterCommand(ctx context.Context, cmd *cluster.Command) (interface{}, error) {
+	switch cmd.Type {
+	case cluster.CommandInject:
+		message, _ := cmd.Data["message"].(string)
+		skipLlm, _ := cmd.Data["skip_llm"].(bool)
+		return nil, a.injectLocal(cmd.DeviceId, message, skipLlm)
+	case cluster.CommandKick:
+		a.CloseChatManager(cmd.DeviceId)
+		return nil, nil
+	case cluster.CommandStatus:
+		return a.localDeviceStatus(cmd.DeviceId), nil
+	case cluster.CommandRemind:
+		message, _ := cmd.Data["message"].(string)
+		return nil, a.remindLocal(cmd.DeviceId, message)
+	default:
+		return nil, fmt.Errorf("unknown command type: %s", cmd.Type)
+	}
+}
+
+type adminInjectRequest struct {
+	DeviceId string `json:"device_id"`
+	Message  string `json:"message"`
+	SkipLlm  bool   `json:"skip_llm"`
+}
+
+func (a *App) handleAdminInjectMsg(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	var req adminInjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求体格式错误", http.StatusBadRequest)
+		return
+	}
+	if req.DeviceId == "" || req.Message == "" {
+		http.Error(w, "缺少device_id或message参数", http.StatusBadRequest)
+		return
+	}
+	if err := a.InjectMessage(r.Context(), req.DeviceId, req.Message, req.SkipLlm); err != nil {
+		writeAdminResponse(w, http.StatusBadGateway, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+func (a *App) handleAdminKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID == "" {
+		http.Error(w, "缺少device_id参数", http.StatusBadRequest)
+		return
+	}
+	if err := a.KickDevice(r.Context(), deviceID); err != nil {
+		writeAdminResponse(w, http.StatusBadGateway, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+func (a *App) handleAdminDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID == "" {
+		http.Error(w, "缺少device_id参数", http.StatusBadRequest)
+		return
+	}
+	status, err := a.GetDeviceStatus(r.Context(), deviceID)
+	if err != nil {
+		writeAdminResponse(w, http.StatusBadGateway, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, status)
+}
+
+func writeAdminResponse(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(data)
+}
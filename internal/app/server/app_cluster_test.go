@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuth(t *testing.T) {
+	handler := adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		code   int
+	}{
+		{name: "未配置令牌", token: "", header: "Bearer ", code: http.StatusForbidden},
+		{name: "缺少令牌", token: "secret", header: "", code: http.StatusUnauthorized},
+		{name: "令牌错误", token: "secret", header: "Bearer wrong", code: http.StatusUnauthorized},
+		{name: "令牌正确", token: "secret", header: "Bearer secret", code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("server.admin_token", tt.token)
+			defer viper.Set("server.admin_token", nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/kick?device_id=dev-1", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/spf13/viper"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
+	"xiaozhi-esp32-server-golang/internal/domain/vad"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// registerMetrics 注册 /metrics, 会话数、资源池这类 gauge 在采集时从各模块读取当前值
+func (a *App) registerMetrics() {
+	if !metrics.Enabled() {
+		return
+	}
+
+	gauges := []struct {
+		name    string
+		help    string
+		labels  []string
+		collect func() []metrics.Sample
+	}{
+		{"active_sessions", "当前会话数, 按传输类型区分", []string{"transport"}, a.collectActiveSessions},
+		{"vad_pool_resources", "vad 资源池中的实例数, state 为 in_use/available", []string{"provider", "state"}, collectVadPool},
+		{"mcp_server_connected", "全局 MCP 服务器是否已连接", []string{"server"}, collectMcpServers},
+		{"mcp_device_connections", "设备侧已连接的 MCP 数, kind 为 ws_endpoint/iot_over_mcp", []string{"kind"}, collectMcpDevices},
+		{"workpool_queue_depth", "后台任务队列中等待处理的任务数", []string{"pool"}, a.collectQueueDepth},
+	}
+	for _, g := range gauges {
+		if err := metrics.RegisterGaugeFunc(g.name, g.help, g.labels, g.collect); err != nil {
+			log.Warnf("注册指标 %s 失败: %v", g.name, err)
+		}
+	}
+
+	path := viper.GetString("metrics.path")
+	if path == "" {
+		path = "/metrics"
+	}
+	// 指标中有 MCP 服务器、各引擎名称和会话数, 与管理接口一样需要 admin_token
+	http.HandleFunc(path, adminAuth(metrics.Handler().ServeHTTP))
+	log.Infof("prometheus 指标已开启, 路径: %s", path)
+}
+
+func (a *App) collectActiveSessions() []metrics.Sample {
+	counts := map[string]int{
+		types.TransportTypeWebsocket: 0,
+		types.TransportTypeMqttUdp:   0,
+		types.TransportTypeWebRtc:    0,
+	}
+	for tuple := range a.chatManagers.IterBuffered() {
+		// 已断开等待重连的会话没有连接, 不计入
+		if transport := tuple.Val.GetTransportType(); transport != "" {
+			counts[transport]++
+		}
+	}
+	samples := make([]metrics.Sample, 0, len(counts))
+	for transport, count := range counts {
+		samples = append(samples, metrics.Sample{Labels: []string{transport}, Value: float64(count)})
+	}
+	return samples
+}
+
+func collectVadPool() []metrics.Sample {
+	var samples []metrics.Sample
+	for provider, stats := range vad.PoolStats() {
+		for state, key := range map[string]string{"in_use": "in_use_resources", "available": "available_resources"} {
+			if count, ok := stats[key].(int); ok {
+				samples = append(samples, metrics.Sample{Labels: []string{provider, state}, Value: float64(count)})
+			}
+		}
+	}
+	return samples
+}
+
+func collectMcpServers() []metrics.Sample {
+	var samples []metrics.Sample
+	for server, connected := range mcp.GetGlobalMCPManager().GetServerStatus() {
+		value := 0.0
+		if connected {
+			value = 1
+		}
+		samples = append(samples, metrics.Sample{Labels: []string{server}, Value: value})
+	}
+	return samples
+}
+
+func collectMcpDevices() []metrics.Sample {
+	wsEndpoint, iotOverMcp := mcp.GetDeviceConnectionCount()
+	return []metrics.Sample{
+		{Labels: []string{"ws_endpoint"}, Value: float64(wsEndpoint)},
+		{Labels: []string{"iot_over_mcp"}, Value: float64(iotOverMcp)},
+	}
+}
+
+func (a *App) collectQueueDepth() []metrics.Sample {
+	var samples []metrics.Sample
+	for pool, depth := range a.eventHandle.QueueDepth() {
+		samples = append(samples, metrics.Sample{Labels: []string{pool}, Value: float64(depth)})
+	}
+	return samples
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/cluster"
+	"xiaozhi-esp32-server-golang/internal/app/server/reminder"
+	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+func (a *App) newReminderScheduler() (*reminder.Scheduler, error) {
+	if !viper.GetBool("reminder.enable") {
+		return nil, nil
+	}
+
+	var store reminder.Store
+	switch storeType := viper.GetString("reminder.store"); storeType {
+	case "redis":
+		redisStore, err := reminder.NewRedisStore(redisdb.GetClient(), viper.GetString("redis.key_prefix"))
+		if err != nil {
+			return nil, err
+		}
+		store = redisStore
+	case "", "memory":
+		if viper.GetBool("cluster.enable") {
+			log.Warn("集群模式下提醒使用内存存储, 设备切换节点后无法投递, 建议使用 redis 存储")
+		}
+		store = reminder.NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("不支持的提醒存储类型: %s", storeType)
+	}
+
+	opts := []reminder.SchedulerOption{
+		reminder.WithCheckInterval(time.Duration(viper.GetInt("reminder.check_interval")) * time.Second),
+		reminder.WithPendingExpire(time.Duration(viper.GetInt("reminder.pending_expire")) * time.Second),
+	}
+	if viper.IsSet("reminder.max_per_device") {
+		opts = append(opts, reminder.WithMaxPerDevice(viper.GetInt("reminder.max_per_device")))
+	}
+	if viper.IsSet("reminder.online_delay") {
+		opts = append(opts, reminder.WithOnlineDelay(time.Duration(viper.GetInt("reminder.online_delay"))*time.Second))
+	}
+	return reminder.NewScheduler(store, a.deliverReminder, opts...), nil
+}
+
+// deliverReminder 到点的提醒播报到设备, 设备在其他节点时转发过去
+func (a *App) deliverReminder(ctx context.Context, r *reminder.Reminder) error {
+	message := fmt.Sprintf("提醒时间到了, %s", r.Content)
+	nodeId, err := a.lookupRemoteNode(ctx, r.DeviceId)
+	if err != nil {
+		return fmt.Errorf("device %s not found or offline", r.DeviceId)
+	}
+	if nodeId != "" {
+		_, err := a.forward(ctx, nodeId, &cluster.Command{
+			Type:     cluster.CommandRemind,
+			DeviceId: r.DeviceId,
+			Data:     map[string]interface{}{"message": message},
+		})
+		return err
+	}
+	return a.remindLocal(r.DeviceId, message)
+}
+
+func (a *App) remindLocal(deviceID string, message string) error {
+	chatManager, exists := a.GetChatManager(deviceID)
+	if !exists {
+		return fmt.Errorf("device %s not found or offline", deviceID)
+	}
+	return chatManager.Remind(message)
+}
+
+// onReminderDeviceOnline 设备连上本节点后投递其离线期间到点的提醒
+func (a *App) onReminderDeviceOnline(deviceID string) {
+	if a.reminderScheduler != nil {
+		a.reminderScheduler.OnDeviceOnline(deviceID)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"xiaozhi-esp32-server-golang/internal/app/mqtt_server"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	// 发送 goodbye 后等待会话自行退出
+	drainCloseTimeout = 5 * time.Second
+	// 等待消息写入和记忆刷新
+	drainFlushTimeout = 10 * time.Second
+)
+
+// DrainTimeout 排空时等待进行中的回复播放完成的最长时间
+func DrainTimeout() time.Duration {
+	if seconds := viper.GetInt("server.drain_timeout"); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultDrainTimeout
+}
+
+// Done 排空并关闭完成后返回
+func (a *App) Done() <-chan struct{} {
+	return a.done
+}
+
+// IsDraining 是否处于排空状态
+func (a *App) IsDraining() bool {
+	return a.draining.Load()
+}
+
+// Shutdown 排空所有会话后关闭服务, 重复调用只执行一次
+//  1. 不再接受新的 websocket/mqtt 会话
+//  2. 等待进行中的 llm/tts 结束, 最长到 ctx 的截止时间
+//  3. 向每个设备发送 goodbye 并关闭会话
+//  4. 等待记忆体刷新和消息写入队列处理完
+//  5. 关闭所有监听
+func (a *App) Shutdown(ctx context.Context) {
+	a.shutdownOnce.Do(func() {
+		defer close(a.done)
+
+		log.Infof("开始排空, 当前会话数: %d", a.GetChatManagerCount())
+		a.draining.Store(true)
+		a.wsServer.Drain()
+		// 已存储的提醒保留, 由其他节点或重启后继续触发
+		if a.reminderScheduler != nil {
+			a.reminderScheduler.Stop()
+		}
+
+		a.waitSessionsIdle(ctx)
+
+		for tuple := range a.chatManagers.IterBuffered() {
+			log.Infof("设备 %s 下发 goodbye 并关闭会话", tuple.Key)
+			tuple.Val.Goodbye()
+		}
+		a.waitSessionsClosed(drainCloseTimeout)
+
+		if a.eventHandle != nil {
+			a.eventHandle.Stop(drainFlushTimeout)
+		}
+
+		a.closeListeners()
+		log.Info("排空完成")
+	})
+}
+
+func (a *App) waitSessionsIdle(ctx context.Context) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		busy := 0
+		for tuple := range a.chatManagers.IterBuffered() {
+			if tuple.Val.IsBusy() {
+				busy++
+			}
+		}
+		if busy == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Warnf("等待回复结束超时, 仍有 %d 个会话在进行中, 强制结束", busy)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitSessionsClosed 会话关闭后由 OnNewConnection 中的协程从映射中移除并通知下线
+func (a *App) waitSessionsClosed(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for a.GetChatManagerCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if count := a.GetChatManagerCount(); count > 0 {
+		log.Warnf("仍有 %d 个会话未退出, 强制关闭", count)
+		a.CloseAllChatManagers()
+	}
+}
+
+func (a *App) closeListeners() {
+	ctx, cancel := context.WithTimeout(context.Background(), drainCloseTimeout)
+	defer cancel()
+	if err := a.wsServer.Shutdown(ctx); err != nil {
+		log.Warnf("关闭 WebSocket 服务失败: %v", err)
+	}
+	if a.mqttUdpAdapter != nil {
+		a.mqttUdpAdapter.Stop()
+	}
+	// mqtt-udp 设备的 goodbye 经由内置 mqtt 服务器下发, 需最后关闭
+	if viper.GetBool("mqtt_server.enable") {
+		if err := mqtt_server.StopMqttServer(); err != nil {
+			log.Warnf("关闭 MQTT 服务失败: %v", err)
+		}
+	}
+	if a.registry != nil {
+		a.registry.Stop()
+	}
+}
+
+// handleAdminDrain 手动触发排空, 如滚动发布前先把节点摘下
+func (a *App) handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.IsDraining() {
+		writeAdminResponse(w, http.StatusOK, map[string]interface{}{"success": true, "message": "already draining"})
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout())
+		defer cancel()
+		a.Shutdown(ctx)
+	}()
+	writeAdminResponse(w, http.StatusAccepted, map[string]interface{}{
+		"success":  true,
+		"sessions": a.GetChatManagerCount(),
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+
+	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// newSpeakerService 声纹识别, 未开启时返回 nil
+func (a *App) newSpeakerService() (*speaker.Service, error) {
+	if !viper.GetBool("speaker.enable") {
+		return nil, nil
+	}
+
+	// 声纹模型依赖 sherpa 构建标签, 未编译时在这里返回明确的错误
+	embedder, err := speaker.NewSherpaEmbedder(
+		viper.GetString("speaker.model"),
+		viper.GetInt("speaker.num_threads"),
+		viper.GetString("speaker.provider"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var store speaker.Store
+	switch storeType := viper.GetString("speaker.store"); storeType {
+	case "redis":
+		redisStore, err := speaker.NewRedisStore(redisdb.GetClient(), viper.GetString("redis.key_prefix"))
+		if err != nil {
+			return nil, err
+		}
+		store = redisStore
+	case "", "memory":
+		log.Warn("声纹使用内存存储, 重启后需要重新登记, 建议使用 redis 存储")
+		store = speaker.NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("不支持的声纹存储类型: %s", storeType)
+	}
+
+	opts := []speaker.ServiceOption{
+		speaker.WithThreshold(float32(viper.GetFloat64("speaker.threshold"))),
+		speaker.WithMinDuration(time.Duration(viper.GetFloat64("speaker.min_duration") * float64(time.Second))),
+	}
+	if viper.IsSet("speaker.max_speakers") {
+		opts = append(opts, speaker.WithMaxSpeakers(viper.GetInt("speaker.max_speakers")))
+	}
+	return speaker.NewService(store, embedder, opts...), nil
+}
@@ -3,19 +3,61 @@ package chat
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/recorder"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	"xiaozhi-esp32-server-golang/internal/domain/audio"
+	"xiaozhi-esp32-server-golang/internal/domain/audio/preprocess"
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
 	log "xiaozhi-esp32-server-golang/logger"
 
 	"github.com/spf13/viper"
 )
 
+// 单次最多补偿的丢失帧数
+const maxConcealFrames = 5
+
+// 每句最多保留的语音时长, 声纹识别用不到更长的语音
+const maxSpeechSeconds = 30
+
 type ASRManagerOption func(*ASRManager)
 
 type ASRManager struct {
 	clientState     *ClientState
 	serverTransport *ServerTransport
+	recorder        *recorder.Recorder
+
+	// 收到识别中间结果时回调, 参数为本句目前已识别的全部文本
+	onPartial func(text string)
+
+	// 是否保留本句送入asr的语音, 供声纹识别使用
+	captureSpeech bool
+	speech        []float32
+	speechMu      sync.Mutex
+}
+
+// WithAsrRecorder 记录上行音频、vad判定和asr结果
+func WithAsrRecorder(rec *recorder.Recorder) ASRManagerOption {
+	return func(a *ASRManager) {
+		a.recorder = rec
+	}
+}
+
+// WithAsrPartialHandler 识别中间结果回调, 用于提前发起 llm 请求
+func WithAsrPartialHandler(onPartial func(text string)) ASRManagerOption {
+	return func(a *ASRManager) {
+		a.onPartial = onPartial
+	}
+}
+
+// WithAsrSpeechCapture 保留本句送入asr的语音, 通过 Speech 取出
+func WithAsrSpeechCapture() ASRManagerOption {
+	return func(a *ASRManager) {
+		a.captureSpeech = true
+	}
 }
 
 func NewASRManager(clientState *ClientState, serverTransport *ServerTransport, opts ...ASRManagerOption) *ASRManager {
@@ -40,15 +82,19 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context, onClose func()) {
 			return
 		}
 		frameSize := state.AsrAudioBuffer.PcmFrameSize
+		preprocessor := a.newPreprocessor(audioFormat.SampleRate)
 
 		vadNeedGetCount := 1
 		if state.DeviceConfig.Vad.Provider == "silero_vad" {
 			vadNeedGetCount = 60 / audioFormat.FrameDuration
 		}
 
-		for {
-			pcmFrame := make([]float32, frameSize)
+		// 传输层判定丢失的连续帧数, 在下一个正常帧到达时做 PLC/FEC 补偿
+		lostCount := 0
+		// 上一次记录的vad判定, 只在变化时记录
+		lastRecordVoice := false
 
+		for {
 			select {
 			case opusFrame, ok := <-state.OpusAudioBuffer:
 				log.Debugf("processAsrAudio 收到音频数据, len: %d", len(opusFrame))
@@ -56,6 +102,13 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context, onClose func()) {
 					log.Debugf("processAsrAudio 音频通道已关闭")
 					return
 				}
+				a.recorder.AudioIn(opusFrame)
+				if len(opusFrame) == 0 {
+					if lostCount < maxConcealFrames {
+						lostCount++
+					}
+					continue
+				}
 
 				var skipVad bool
 				var haveVoice bool
@@ -68,19 +121,21 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context, onClose func()) {
 
 				if state.GetClientVoiceStop() { //已停止 说话 则不接收音频数据
 					//log.Infof("客户端停止说话, 跳过音频数据")
+					lostCount = 0
 					continue
 				}
 
 				//log.Debugf("clientVoiceStop: %+v, asrDataSize: %d, listenMode: %s, isSkipVad: %v\n", state.GetClientVoiceStop(), state.AsrAudioBuffer.GetAsrDataSize(), state.ListenMode, skipVad)
 
-				n, err := audioProcesser.DecoderFloat32(opusFrame, pcmFrame)
+				pcmData, err := audioProcesser.DecodeWithConcealFloat32(opusFrame, lostCount, frameSize)
+				lostCount = 0
 				if err != nil {
 					log.Errorf("解码失败: %v", err)
 					continue
 				}
+				pcmData = preprocessor.Process(pcmData)
 
 				var vadPcmData []float32
-				pcmData := pcmFrame[:n]
 				if !skipVad {
 					//decode opus to pcm
 					state.AsrAudioBuffer.AddAsrAudioData(pcmData)
@@ -109,6 +164,10 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context, onClose func()) {
 							//删除
 							continue
 						}
+						if haveVoice != lastRecordVoice {
+							lastRecordVoice = haveVoice
+							a.recorder.Event(recorder.EventVad, map[string]interface{}{"voice": haveVoice})
+						}
 						//首次触发识别到语音时,为了语音数据完整性 将vadPcmData赋值给pcmData, 之后的音频数据全部进入asr
 						if haveVoice && !clientHaveVoice {
 							//首次获取全部pcm数据送入asr
@@ -132,6 +191,7 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context, onClose func()) {
 
 				if haveVoice {
 					//log.Infof("检测到语音, len: %d", len(pcmData))
+					state.Trace.SpeechStart()
 					state.SetClientHaveVoice(true)
 					state.SetClientHaveVoiceLastTime(time.Now().UnixMilli())
 					if !state.Asr.AutoEnd {
@@ -164,6 +224,7 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context, onClose func()) {
 					//vad识别成功, 往asr音频通道里发送数据
 					//log.Infof("vad识别成功, 往asr音频通道里发送数据, len: %d", len(pcmData))
 					state.Asr.AddAudioData(pcmData)
+					a.appendSpeech(pcmData, audioFormat.SampleRate)
 				}
 
 				//已经有语音了, 但本次没有检测到语音, 则需要判断是否已经停止说话
@@ -172,6 +233,7 @@ func (a *ASRManager) ProcessVadAudio(ctx context.Context, onClose func()) {
 				if clientHaveVoice && lastHaveVoiceTime > 0 && !haveVoice {
 					idleDuration := state.Vad.GetIdleDuration()
 					if state.IsSilence(idleDuration) { //从有声音到 静默的判断
+						a.recorder.Event(recorder.EventVad, map[string]interface{}{"silence": true, "idle_ms": idleDuration})
 						state.OnVoiceSilence()
 						continue
 					}
@@ -196,6 +258,7 @@ func (a *ASRManager) RestartAsrRecognition(ctx context.Context) error {
 
 	state.VoiceStatus.Reset()
 	state.AsrAudioBuffer.ClearAsrAudioData()
+	a.resetSpeech()
 
 	// 等待一小段时间让资源清理
 	select {
@@ -207,9 +270,41 @@ func (a *ASRManager) RestartAsrRecognition(ctx context.Context) error {
 	// 重新创建ASR上下文和通道
 	state.Asr.Ctx, state.Asr.Cancel = context.WithCancel(ctx)
 	state.Asr.AsrAudioChannel = make(chan []float32, 100)
+	state.Trace.AsrStart()
+	provider := state.DeviceConfig.Asr.Provider
+	interimStt := viper.GetBool("chat.interim_stt")
+	// 中间结果是增量片断, 与 RetireAsrResult 一样拼接成整句
+	var partial strings.Builder
+	state.Asr.OnResult = func(result asr_types.StreamingResult) {
+		if result.Error != nil {
+			metrics.ProviderError(metrics.ProviderAsr, provider)
+		}
+		if !result.IsFinal && result.Text != "" {
+			state.Trace.AsrPartial()
+			partial.WriteString(result.Text)
+			text := state.Asr.HotwordCorrector.Correct(partial.String())
+			if interimStt {
+				if err := a.serverTransport.SendAsrPartial(text); err != nil {
+					log.Warnf("发送asr中间结果失败: %v", err)
+				}
+			}
+			if a.onPartial != nil {
+				a.onPartial(text)
+			}
+		}
+		if a.recorder == nil {
+			return
+		}
+		event := map[string]interface{}{"text": result.Text, "is_final": result.IsFinal}
+		if result.Error != nil {
+			event["error"] = result.Error.Error()
+		}
+		a.recorder.Event(recorder.EventAsrResult, event)
+	}
 
 	// 重新启动流式识别
 	asrResultChannel, err := state.AsrProvider.StreamingRecognize(state.Asr.Ctx, state.Asr.AsrAudioChannel)
+	metrics.ProviderRequest(metrics.ProviderAsr, provider, err)
 	if err != nil {
 		log.Errorf("重启ASR流式识别失败: %v", err)
 		return fmt.Errorf("重启ASR流式识别失败: %v", err)
@@ -219,3 +314,55 @@ func (a *ASRManager) RestartAsrRecognition(ctx context.Context) error {
 	log.Debugf("重启ASR识别成功")
 	return nil
 }
+
+func (a *ASRManager) appendSpeech(pcmData []float32, sampleRate int) {
+	if !a.captureSpeech {
+		return
+	}
+	a.speechMu.Lock()
+	defer a.speechMu.Unlock()
+	if len(a.speech) >= maxSpeechSeconds*sampleRate {
+		return
+	}
+	a.speech = append(a.speech, pcmData...)
+}
+
+func (a *ASRManager) resetSpeech() {
+	a.speechMu.Lock()
+	defer a.speechMu.Unlock()
+	a.speech = nil
+}
+
+// Speech 本句目前已送入asr的语音及其采样率, 未开启 WithAsrSpeechCapture 时为空
+func (a *ASRManager) Speech() ([]float32, int) {
+	a.speechMu.Lock()
+	defer a.speechMu.Unlock()
+	return append([]float32(nil), a.speech...), a.clientState.InputAudioFormat.SampleRate
+}
+
+// newPreprocessor 解码后、vad 与 asr 前的音频预处理, 设备或智能体的配置按 key 覆盖全局配置, 未开启时返回 nil
+func (a *ASRManager) newPreprocessor(sampleRate int) *preprocess.Chain {
+	state := a.clientState
+	// viper 返回的是全局配置本身, 复制后再覆盖
+	config := make(map[string]interface{})
+	for k, v := range viper.GetStringMap("audio_preprocess") {
+		config[k] = v
+	}
+	for k, v := range state.DeviceConfig.AudioPreprocess {
+		config[k] = v
+	}
+	// 同一次连接只提示一次, 详细情况见录制的事件
+	var clipWarned bool
+	chain, err := preprocess.NewChain(sampleRate, config, preprocess.WithClipHandler(func(ratio float64) {
+		a.recorder.Event(recorder.EventAudioClip, map[string]interface{}{"ratio": ratio})
+		if !clipWarned {
+			clipWarned = true
+			log.Warnf("设备 %s 上行音频削波, 削波点占比 %.2f%%, 请调低设备麦克风增益", state.DeviceID, ratio*100)
+		}
+	}))
+	if err != nil {
+		log.Errorf("设备 %s 音频预处理配置错误, 不做预处理: %v", state.DeviceID, err)
+		return nil
+	}
+	return chain
+}
@@ -2,7 +2,9 @@ package chat
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -11,6 +13,7 @@ import (
 	types_audio "xiaozhi-esp32-server-golang/internal/data/audio"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
 	userconfig "xiaozhi-esp32-server-golang/internal/domain/config"
+	userconfig_types "xiaozhi-esp32-server-golang/internal/domain/config/types"
 	"xiaozhi-esp32-server-golang/internal/domain/eventbus"
 	"xiaozhi-esp32-server-golang/internal/domain/vad/silero_vad"
 	log "xiaozhi-esp32-server-golang/logger"
@@ -24,10 +27,43 @@ type ChatManager struct {
 	session     *ChatSession
 	ctx         context.Context
 	cancel      context.CancelFunc
+
+	// 断线续连: 连接断开后会话保留 resumeWindow 时长, 期间同一设备重连则复用会话
+	resumeWindow time.Duration
+	resumeTimer  *time.Timer
+	detached     bool
+	closed       bool
+	mu           sync.Mutex
+
+	sessionOpts []ChatSessionOption
+
+	// 不为 nil 时直接使用该配置, 不再从配置提供者获取
+	deviceConfig *userconfig_types.UConfig
 }
 
 type ChatManagerOption func(*ChatManager)
 
+// WithChatSessionOptions 透传 ChatSession 的配置项
+func WithChatSessionOptions(opts ...ChatSessionOption) ChatManagerOption {
+	return func(cm *ChatManager) {
+		cm.sessionOpts = append(cm.sessionOpts, opts...)
+	}
+}
+
+// WithResumeWindow 设置断线续连窗口, <=0 表示不保留会话
+func WithResumeWindow(window time.Duration) ChatManagerOption {
+	return func(cm *ChatManager) {
+		cm.resumeWindow = window
+	}
+}
+
+// WithDeviceConfig 直接指定设备配置, 设备视为已激活, 用于回放测试等没有配置后端的场景
+func WithDeviceConfig(config userconfig_types.UConfig) ChatManagerOption {
+	return func(cm *ChatManager) {
+		cm.deviceConfig = &config
+	}
+}
+
 func NewChatManager(deviceID string, transport types_conn.IConn, options ...ChatManagerOption) (*ChatManager, error) {
 
 	cm := &ChatManager{
@@ -44,7 +80,13 @@ func NewChatManager(deviceID string, transport types_conn.IConn, options ...Chat
 	cm.ctx, cm.cancel = context.WithCancel(ctx)
 
 	// 先创建 clientState，再注册 OnClose 回调，避免竞态条件
-	clientState, err := GenClientState(cm.ctx, cm.DeviceID)
+	var clientState *ClientState
+	var err error
+	if cm.deviceConfig != nil {
+		clientState = NewClientState(cm.ctx, cm.DeviceID, *cm.deviceConfig, true)
+	} else {
+		clientState, err = GenClientState(cm.ctx, cm.DeviceID)
+	}
 	if err != nil {
 		log.Errorf("初始化客户端状态失败: %v", err)
 		cm.transport.Close()
@@ -53,13 +95,14 @@ func NewChatManager(deviceID string, transport types_conn.IConn, options ...Chat
 	cm.clientState = clientState
 
 	// clientState 创建完成后再注册 OnClose 回调
-	cm.transport.OnClose(cm.OnClose)
+	cm.watchTransportClose(cm.transport)
 
 	serverTransport := NewServerTransport(cm.transport, clientState)
 
 	cm.session = NewChatSession(
 		clientState,
 		serverTransport,
+		cm.sessionOpts...,
 	)
 
 	return cm, nil
@@ -77,6 +120,16 @@ func GenClientState(pctx context.Context, deviceID string) (*ClientState, error)
 		return nil, err
 	}
 
+	isDeviceActivated, err := configProvider.IsDeviceActivated(pctx, deviceID, "")
+	if err != nil {
+		log.Errorf("检查设备激活状态失败: %v", err)
+	}
+
+	return NewClientState(pctx, deviceID, deviceConfig, isDeviceActivated), nil
+}
+
+// NewClientState 根据已获取的设备配置创建客户端状态
+func NewClientState(pctx context.Context, deviceID string, deviceConfig userconfig_types.UConfig, isDeviceActivated bool) *ClientState {
 	if deviceConfig.Vad.Provider == "silero_vad" {
 		silero_vad.InitVadPool(deviceConfig.Vad.Config)
 	}
@@ -89,11 +142,6 @@ func GenClientState(pctx context.Context, deviceID string) (*ClientState, error)
 		maxSilenceDuration = 200
 	}
 
-	isDeviceActivated, err := configProvider.IsDeviceActivated(ctx, deviceID, "")
-	if err != nil {
-		log.Errorf("检查设备激活状态失败: %v", err)
-	}
-
 	clientState := &ClientState{
 		IsActivated:  isDeviceActivated,
 		Dialogue:     &Dialogue{},
@@ -133,7 +181,7 @@ func GenClientState(pctx context.Context, deviceID string) (*ClientState, error)
 		clientState.OutputAudioFormat.FrameDuration = 20
 	}
 
-	return clientState, nil
+	return clientState
 }
 
 func (c *ChatManager) Start() error {
@@ -150,6 +198,7 @@ func (c *ChatManager) Start() error {
 
 // 主动关闭断开连接
 func (c *ChatManager) Close() error {
+	c.markClosed()
 	if c.clientState != nil {
 		log.Infof("主动关闭断开连接, 设备 %s", c.clientState.DeviceID)
 	}
@@ -166,6 +215,7 @@ func (c *ChatManager) Close() error {
 
 func (c *ChatManager) OnClose(deviceId string) {
 	log.Infof("设备 %s 断开连接", deviceId)
+	c.markClosed()
 	c.cancel()
 	if c.clientState != nil {
 		eventbus.Get().Publish(eventbus.TopicSessionEnd, c.clientState)
@@ -173,10 +223,131 @@ func (c *ChatManager) OnClose(deviceId string) {
 	return
 }
 
+func (c *ChatManager) markClosed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.resumeTimer != nil {
+		c.resumeTimer.Stop()
+		c.resumeTimer = nil
+	}
+}
+
+func (c *ChatManager) watchTransportClose(transport types_conn.IConn) {
+	transport.OnClose(func(deviceId string) {
+		c.onTransportClose(transport, deviceId)
+	})
+}
+
+// onTransportClose 连接断开回调, 开启了续连窗口时会话挂起等待重连, 否则直接关闭
+func (c *ChatManager) onTransportClose(transport types_conn.IConn, deviceId string) {
+	c.mu.Lock()
+	// 已关闭, 或者是已被新连接替换掉的旧连接, 忽略
+	if c.closed || c.detached || transport != c.transport {
+		c.mu.Unlock()
+		return
+	}
+	if c.resumeWindow <= 0 {
+		c.mu.Unlock()
+		c.OnClose(deviceId)
+		return
+	}
+	c.detached = true
+	c.resumeTimer = time.AfterFunc(c.resumeWindow, func() {
+		c.onResumeTimeout(transport)
+	})
+	c.mu.Unlock()
+
+	log.Infof("设备 %s 断开连接, 保留会话 %v 等待重连", deviceId, c.resumeWindow)
+	c.session.Detach()
+}
+
+func (c *ChatManager) onResumeTimeout(transport types_conn.IConn) {
+	c.mu.Lock()
+	if c.closed || !c.detached || transport != c.transport {
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	log.Infof("设备 %s 未在 %v 内重连, 释放会话", c.DeviceID, c.resumeWindow)
+	c.OnClose(c.DeviceID)
+}
+
+// CanResume 会话是否可以被新连接复用
+func (c *ChatManager) CanResume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.closed && c.resumeWindow > 0
+}
+
+// Resume 设备重连时将新连接挂到现有会话上, 对话历史、mcp会话等状态保持不变
+func (c *ChatManager) Resume(transport types_conn.IConn) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return fmt.Errorf("设备 %s 会话已关闭", c.DeviceID)
+	}
+	if c.resumeTimer != nil {
+		c.resumeTimer.Stop()
+		c.resumeTimer = nil
+	}
+	oldTransport := c.transport
+	wasDetached := c.detached
+	c.transport = transport
+	c.detached = false
+	c.mu.Unlock()
+
+	// 旧连接还未感知到断开(如wifi漫游), 主动关掉, 其OnClose回调会因连接已被替换而忽略
+	if !wasDetached && oldTransport != nil {
+		oldTransport.Close()
+	}
+
+	c.session.Attach(transport)
+	c.watchTransportClose(transport)
+
+	log.Infof("设备 %s 重连, 复用已有会话", c.DeviceID)
+	return nil
+}
+
 func (c *ChatManager) GetClientState() *ClientState {
 	return c.clientState
 }
 
+// IsBusy 是否正在生成或播放回复
+func (c *ChatManager) IsBusy() bool {
+	if c.clientState == nil {
+		return false
+	}
+	status := c.clientState.GetStatus()
+	return status == ClientStatusTTSStart || status == ClientStatusLLMStart
+}
+
+// Goodbye 通知设备结束会话后关闭, 用于服务下线前排空会话
+func (c *ChatManager) Goodbye() {
+	if !c.IsDetached() && c.session != nil {
+		c.session.SendGoodbye()
+	}
+	c.Close()
+}
+
+// IsDetached 连接已断开, 会话是否正处于续连等待中
+func (c *ChatManager) IsDetached() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detached
+}
+
+// GetTransportType 当前连接的传输类型
+func (c *ChatManager) GetTransportType() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.transport == nil {
+		return ""
+	}
+	return c.transport.GetTransportType()
+}
+
 func (c *ChatManager) GetDeviceId() string {
 	return c.clientState.DeviceID
 }
@@ -191,3 +362,16 @@ func (c *ChatManager) InjectMessage(message string, skipLlm bool) error {
 		return c.session.AddAsrResultToQueue(message)
 	}
 }
+
+// Remind 播报提醒, 设备正在拾音或回复时先打断, 使提醒能立即播报
+// 连接已断开时返回错误, 由调用方等设备重连后再投递
+func (c *ChatManager) Remind(message string) error {
+	if c.IsDetached() {
+		return fmt.Errorf("device %s is detached", c.DeviceID)
+	}
+	if c.clientState.GetStatus() != ClientStatusInit {
+		log.Infof("设备 %s 当前状态 %s, 打断后播报提醒", c.DeviceID, c.clientState.GetStatus())
+		c.session.StopSpeaking(true)
+	}
+	return c.InjectMessage(message, true)
+}
@@ -0,0 +1,191 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
+	userconfig_types "xiaozhi-esp32-server-golang/internal/domain/config/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn 不收发数据的 IConn, disconnect 模拟网络断开, Close 与真实连接一样会触发 OnClose 回调
+type fakeConn struct {
+	deviceID string
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	onCloseCbList []func(deviceId string)
+	closeOnce     sync.Once
+	mu            sync.Mutex
+}
+
+func newFakeConn(deviceID string) *fakeConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &fakeConn{deviceID: deviceID, ctx: ctx, cancel: cancel}
+}
+
+func (c *fakeConn) disconnect() {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.mu.Lock()
+		cbs := append([]func(string){}, c.onCloseCbList...)
+		c.mu.Unlock()
+		for _, cb := range cbs {
+			cb(c.deviceID)
+		}
+	})
+}
+
+func (c *fakeConn) isClosed() bool {
+	return c.ctx.Err() != nil
+}
+
+func (c *fakeConn) SendCmd(msg []byte) error     { return nil }
+func (c *fakeConn) SendAudio(audio []byte) error { return nil }
+
+func (c *fakeConn) RecvCmd(ctx context.Context, timeout int) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, errors.New("connection is closed")
+	}
+}
+
+func (c *fakeConn) RecvAudio(ctx context.Context, timeout int) ([]byte, error) {
+	return c.RecvCmd(ctx, timeout)
+}
+
+func (c *fakeConn) GetDeviceID() string { return c.deviceID }
+
+func (c *fakeConn) Close() error {
+	c.disconnect()
+	return nil
+}
+
+func (c *fakeConn) OnClose(cb func(deviceId string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onCloseCbList = append(c.onCloseCbList, cb)
+}
+
+func (c *fakeConn) CloseAudioChannel() error { return nil }
+func (c *fakeConn) GetTransportType() string { return types_conn.TransportTypeWebsocket }
+
+func (c *fakeConn) GetData(key string) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+// newTestChatManager 创建不连接 asr/llm/tts 的 ChatManager, 只初始化续连需要的会话上下文
+func newTestChatManager(t *testing.T, conn *fakeConn, resumeWindow time.Duration) *ChatManager {
+	cm, err := NewChatManager(conn.GetDeviceID(), conn,
+		WithDeviceConfig(userconfig_types.UConfig{}),
+		WithResumeWindow(resumeWindow),
+	)
+	require.NoError(t, err)
+	cm.session.ctx, cm.session.cancel = context.WithCancel(cm.ctx)
+	t.Cleanup(func() { cm.Close() })
+	return cm
+}
+
+func currentTransport(cm *ChatManager) types_conn.IConn {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.transport
+}
+
+func TestChatManager_ResumeWithinWindow(t *testing.T) {
+	const window = 100 * time.Millisecond
+	conn := newFakeConn("dev-1")
+	cm := newTestChatManager(t, conn, window)
+
+	conn.disconnect()
+	assert.True(t, cm.IsDetached(), "断开后会话挂起")
+	assert.True(t, cm.CanResume())
+	assert.NoError(t, cm.ctx.Err())
+
+	reconnect := newFakeConn("dev-1")
+	require.NoError(t, cm.Resume(reconnect))
+	assert.False(t, cm.IsDetached())
+	assert.Same(t, reconnect, currentTransport(cm))
+	assert.Same(t, reconnect, cm.session.serverTransport.conn())
+
+	// 续连后超过窗口时长也不会释放会话
+	time.Sleep(2 * window)
+	assert.NoError(t, cm.ctx.Err())
+
+	// 新连接断开后重新进入等待
+	reconnect.disconnect()
+	assert.True(t, cm.IsDetached())
+}
+
+func TestChatManager_ResumeTimeout(t *testing.T) {
+	const window = 50 * time.Millisecond
+	conn := newFakeConn("dev-1")
+	cm := newTestChatManager(t, conn, window)
+
+	conn.disconnect()
+	require.True(t, cm.IsDetached())
+	assert.Eventually(t, func() bool { return cm.ctx.Err() != nil }, time.Second, 10*time.Millisecond, "窗口内未重连, 释放会话")
+	assert.False(t, cm.CanResume())
+	assert.Error(t, cm.Resume(newFakeConn("dev-1")))
+}
+
+func TestChatManager_NoResumeWindow(t *testing.T) {
+	conn := newFakeConn("dev-1")
+	cm := newTestChatManager(t, conn, 0)
+
+	assert.False(t, cm.CanResume())
+	conn.disconnect()
+	assert.False(t, cm.IsDetached())
+	assert.Error(t, cm.ctx.Err(), "未开启续连时断开即关闭")
+}
+
+func TestChatManager_StaleTransportClose(t *testing.T) {
+	const window = 50 * time.Millisecond
+
+	t.Run("旧连接未断开时重连", func(t *testing.T) {
+		// 如wifi漫游, 旧连接还没感知到断开设备就重连了
+		conn := newFakeConn("dev-1")
+		cm := newTestChatManager(t, conn, window)
+
+		reconnect := newFakeConn("dev-1")
+		require.NoError(t, cm.Resume(reconnect))
+		assert.True(t, conn.isClosed(), "旧连接被主动关闭")
+		assert.False(t, cm.IsDetached(), "旧连接的 OnClose 被忽略")
+		assert.Same(t, reconnect, currentTransport(cm))
+
+		time.Sleep(2 * window)
+		assert.NoError(t, cm.ctx.Err())
+	})
+
+	t.Run("旧连接的超时回调", func(t *testing.T) {
+		conn := newFakeConn("dev-1")
+		cm := newTestChatManager(t, conn, window)
+
+		conn.disconnect()
+		reconnect := newFakeConn("dev-1")
+		require.NoError(t, cm.Resume(reconnect))
+
+		// 计时器已触发但还没拿到锁时, 设备恰好重连成功
+		cm.onResumeTimeout(conn)
+		assert.NoError(t, cm.ctx.Err())
+		cm.onTransportClose(conn, "dev-1")
+		assert.False(t, cm.IsDetached())
+	})
+
+	t.Run("关闭后的断开回调", func(t *testing.T) {
+		conn := newFakeConn("dev-1")
+		cm := newTestChatManager(t, conn, window)
+
+		require.NoError(t, cm.Close())
+		cm.onTransportClose(conn, "dev-1")
+		assert.False(t, cm.IsDetached(), "已关闭的会话不再挂起")
+	})
+}
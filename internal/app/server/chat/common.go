@@ -1,5 +1,10 @@
 package chat
 
+import (
+	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
+	. "xiaozhi-esp32-server-golang/internal/data/client"
+)
+
 func (s *ChatSession) StopSpeaking(isSendTtsStop bool) {
 	s.ClearChatTextQueue()
 	s.llmManager.ClearLLMResponseQueue()
@@ -10,9 +15,29 @@ func (s *ChatSession) StopSpeaking(isSendTtsStop bool) {
 	if isSendTtsStop {
 		s.serverTransport.SendTtsStop()
 	}
+	s.clientState.SetStatus(ClientStatusInit)
+
+}
+
+// stopReply 停止当前的回复, 不影响正在进行的 asr 识别, 用于 realtime 模式下的停止词
+func (s *ChatSession) stopReply() {
+	s.ClearChatTextQueue()
+	s.llmManager.ClearLLMResponseQueue()
+	s.ttsManager.ClearTTSQueue()
+
+	s.clientState.AfterAsrSessionCtx.Cancel()
 
+	s.serverTransport.SendTtsStop()
 }
 
 func (s *ChatSession) MqttClose() {
 	s.serverTransport.SendMqttGoodbye()
 }
+
+// SendGoodbye 通知设备会话结束, mqtt-udp 连接关闭时会自行发送, 此处跳过
+func (s *ChatSession) SendGoodbye() {
+	if s.serverTransport.conn().GetTransportType() == types_conn.TransportTypeMqttUdp {
+		return
+	}
+	s.serverTransport.SendMqttGoodbye()
+}
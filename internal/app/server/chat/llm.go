@@ -11,18 +11,25 @@ import (
 	"sync"
 	"time"
 
+	"xiaozhi-esp32-server-golang/internal/app/server/recorder"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
+	"xiaozhi-esp32-server-golang/internal/domain/emotion"
 	"xiaozhi-esp32-server-golang/internal/domain/eventbus"
+	"xiaozhi-esp32-server-golang/internal/domain/language"
 	"xiaozhi-esp32-server-golang/internal/domain/llm"
 	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
 	"xiaozhi-esp32-server-golang/internal/domain/play_music"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+	"xiaozhi-esp32-server-golang/internal/domain/tracing"
 	"xiaozhi-esp32-server-golang/internal/util"
 	log "xiaozhi-esp32-server-golang/logger"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
 	mcp_go "github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -32,6 +39,9 @@ const (
 	McpReadResourceStreamDoneFlag = "[DONE]"
 )
 
+// 识别出说话人时追加到系统提示词, 让模型知道用户消息开头的名字是谁在说话
+const speakerPrompt = "用户消息开头方括号中的名字是声纹识别出的说话人, 回答时可以称呼对方, 但不要在回答中重复方括号格式。"
+
 type LLMResponseChannelItem struct {
 	ctx          context.Context
 	userMessage  *schema.Message
@@ -48,15 +58,37 @@ type LLMManager struct {
 	einoTools []*schema.ToolInfo
 
 	llmResponseQueue *util.Queue[LLMResponseChannelItem]
+
+	recorder *recorder.Recorder
+
+	// 识别过程中提前发起的请求
+	speculator speculator
+
+	// 各说话人的个性化信息, 见 getMemoryContext
+	speakerContexts  map[string]string
+	speakerContextMu sync.Mutex
+}
+
+type LLMManagerOption func(*LLMManager)
+
+// WithLlmRecorder 记录llm请求、对话消息和工具调用
+func WithLlmRecorder(rec *recorder.Recorder) LLMManagerOption {
+	return func(l *LLMManager) {
+		l.recorder = rec
+	}
 }
 
-func NewLLMManager(clientState *ClientState, serverTransport *ServerTransport, ttsManager *TTSManager) *LLMManager {
-	return &LLMManager{
+func NewLLMManager(clientState *ClientState, serverTransport *ServerTransport, ttsManager *TTSManager, opts ...LLMManagerOption) *LLMManager {
+	l := &LLMManager{
 		clientState:      clientState,
 		serverTransport:  serverTransport,
 		ttsManager:       ttsManager,
 		llmResponseQueue: util.NewQueue[LLMResponseChannelItem](10),
 	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
 }
 
 func (l *LLMManager) Start(ctx context.Context) {
@@ -181,6 +213,8 @@ func (l *LLMManager) handleLLMResponse(ctx context.Context, userMessage *schema.
 	state := l.clientState
 	var toolCalls []schema.ToolCall
 	var fullText bytes.Buffer
+	// 本轮回复的语言, 用于选择音色: 优先用户这句话的语言, 无法判断时按回复的第一句, 整轮回复不再变化
+	replyLang := language.FromContext(ctx)
 
 	//var hasTextResponse bool
 	for {
@@ -208,6 +242,10 @@ func (l *LLMManager) handleLLMResponse(ctx context.Context, userMessage *schema.
 				}
 
 				if llmResponse.Text != "" {
+					if replyLang == "" {
+						replyLang = language.Detect(llmResponse.Text)
+						ctx = language.WithContext(ctx, replyLang)
+					}
 					//hasTextResponse = true
 					// 处理文本内容响应
 					if err := l.ttsManager.handleTextResponse(ctx, llmResponse, true); err != nil {
@@ -315,7 +353,10 @@ func (l *LLMManager) handleToolCallResponse(ctx context.Context, userMessage *sc
 		}
 		log.Infof("进行工具调用请求: %s, 参数: %+v", toolName, toolCall.Function.Arguments)
 		startTs := time.Now().UnixMilli()
-		fcResult, err := tool.InvokableRun(toolCtx, toolCall.Function.Arguments)
+		spanCtx, span := state.Trace.StartSpan(toolCtx, tracing.StageTool, attribute.String("tool", toolName))
+		fcResult, err := tool.InvokableRun(spanCtx, toolCall.Function.Arguments)
+		span.End(err)
+		l.recordToolCall(toolCall, fcResult, time.Now().UnixMilli()-startTs, err)
 		if err != nil {
 			log.Errorf("工具调用失败: %v", err)
 			addMessageFunc(toolCall, fmt.Sprintf("工具 %s 调用失败: %v", toolName, err))
@@ -339,7 +380,7 @@ func (l *LLMManager) handleToolCallResponse(ctx context.Context, userMessage *sc
 			contentList = mcpResp.GetContent()
 		} else if toolCallResult, ok := l.handleToolResult(fcResult); ok {
 			if toolCallResult.IsError {
-				log.Errorf("工具调用失败: %s, 错误: %s", fcResult, toolCallResult.IsError)
+				log.Errorf("工具调用失败: %s, 错误: %t", fcResult, toolCallResult.IsError)
 			}
 			contentList = toolCallResult.Content
 		}
@@ -604,9 +645,33 @@ func (l *LLMManager) DoLLmRequest(ctx context.Context, userMessage *schema.Messa
 	clientState := l.clientState
 
 	l.einoTools = einoTools
+
+	// 识别过程中已按相同的文本提前发起过请求时, 直接接着处理已缓存的回复
+	if isSync && userMessage != nil {
+		if sp := l.speculator.take(userMessage.Content); sp != nil {
+			log.Debugf("使用投机请求的回复, seesionID: %s, text: %s", l.clientState.SessionID, sp.text)
+			clientState.SetStatus(ClientStatusLLMStart)
+			l.recorder.Event(recorder.EventLlmStart, map[string]interface{}{"tools": len(einoTools), "speculative": true})
+			// 后续处理(如工具调用后再次请求)沿用 sp.ctx, 不在返回时取消, 只随本轮被打断时一并取消
+			context.AfterFunc(ctx, sp.cancel)
+			_, err := l.HandleLLMResponseChannelSync(sp.ctx, userMessage, sp.responseSentences, einoTools)
+			if err != nil {
+				log.Errorf("处理 LLM 响应失败, seesionID: %s, error: %v", l.clientState.SessionID, err)
+				return err
+			}
+			return nil
+		}
+	}
+
 	//组装历史消息和当前用户的消息
 	requestMessages := l.GetMessages(ctx, userMessage, MaxMessageCount)
 	clientState.SetStatus(ClientStatusLLMStart)
+	l.recorder.Event(recorder.EventLlmStart, map[string]interface{}{"messages": len(requestMessages), "tools": len(einoTools)})
+	// span 在 llm 响应结束时结束, 回复内容的 tts 和工具调用都挂在它下面
+	ctx, span := clientState.Trace.StartSpan(ctx, tracing.StageLlm,
+		attribute.Int("messages", len(requestMessages)),
+		attribute.Int("tools", len(einoTools)),
+	)
 	responseSentences, err := llm.HandleLLMWithContextAndTools(
 		ctx,
 		clientState.LLMProvider,
@@ -614,7 +679,9 @@ func (l *LLMManager) DoLLmRequest(ctx context.Context, userMessage *schema.Messa
 		einoTools,
 		l.clientState.SessionID,
 	)
+	metrics.ProviderRequest(metrics.ProviderLlm, clientState.DeviceConfig.Llm.Provider, err)
 	if err != nil {
+		span.End(err)
 		log.Errorf("发送带工具的 LLM 请求失败, seesionID: %s, error: %v", l.clientState.SessionID, err)
 		return fmt.Errorf("发送带工具的 LLM 请求失败: %v", err)
 	}
@@ -639,6 +706,44 @@ func (l *LLMManager) DoLLmRequest(ctx context.Context, userMessage *schema.Messa
 	return nil
 }
 
+// Speculate 用识别中间结果提前发起 llm 请求, 只缓存回复不做任何处理, 由最终结果到达后的 DoLLmRequest 决定取用还是丢弃.
+// seq 为 speculator 计时时的序号, 请求发出前中间结果又变化或最终结果已到达时直接放弃
+func (l *LLMManager) Speculate(ctx context.Context, seq uint64, userMessage *schema.Message, einoTools []*schema.ToolInfo) {
+	clientState := l.clientState
+	ctx, cancel := context.WithCancel(ctx)
+	requestMessages := l.GetMessages(ctx, userMessage, MaxMessageCount)
+	ctx, span := clientState.Trace.StartSpan(ctx, tracing.StageLlm,
+		attribute.Int("messages", len(requestMessages)),
+		attribute.Int("tools", len(einoTools)),
+		attribute.Bool("speculative", true),
+	)
+	responseSentences, err := llm.HandleLLMWithContextAndTools(
+		ctx,
+		clientState.LLMProvider,
+		requestMessages,
+		einoTools,
+		clientState.SessionID,
+	)
+	metrics.ProviderRequest(metrics.ProviderLlm, clientState.DeviceConfig.Llm.Provider, err)
+	if err != nil {
+		span.End(err)
+		cancel()
+		log.Warnf("投机请求 llm 失败, seesionID: %s, error: %v", clientState.SessionID, err)
+		return
+	}
+	sp := &speculation{
+		text:              userMessage.Content,
+		ctx:               ctx,
+		cancel:            cancel,
+		responseSentences: responseSentences,
+	}
+	if !l.speculator.set(seq, sp) {
+		cancel()
+		return
+	}
+	log.Debugf("投机请求 llm, seesionID: %s, text: %s", clientState.SessionID, userMessage.Content)
+}
+
 func (l *LLMManager) AddLlmMessage(ctx context.Context, msg *schema.Message) error {
 	if msg == nil {
 		log.Warnf("尝试添加 nil 消息到 LLM 对话历史")
@@ -646,8 +751,14 @@ func (l *LLMManager) AddLlmMessage(ctx context.Context, msg *schema.Message) err
 	}
 	//同步添加到内存中
 	l.clientState.AddMessage(msg)
+	l.recorder.Event(recorder.EventLlmMessage, map[string]interface{}{
+		"role":         msg.Role,
+		"content":      msg.Content,
+		"tool_calls":   msg.ToolCalls,
+		"tool_call_id": msg.ToolCallID,
+	})
 	//发送消息到evenbus进行异步处理, 存储消息、添加到记忆体中
-	eventbus.Get().Publish(eventbus.TopicAddMessage, l.clientState, *msg)
+	eventbus.Get().Publish(eventbus.TopicAddMessage, l.clientState, *msg, l.clientState.MemoryKey(speaker.FromContext(ctx)))
 
 	return nil
 }
@@ -658,13 +769,20 @@ func (l *LLMManager) GetMessages(ctx context.Context, userMessage *schema.Messag
 
 	// 构建 system prompt
 	systemPrompt := l.clientState.SystemPrompt
-	if l.clientState.MemoryContext != "" {
-		systemPrompt += fmt.Sprintf("\n用户个性化信息: \n%s", l.clientState.MemoryContext)
+	if emotion.Enabled() {
+		systemPrompt += "\n" + emotion.Prompt()
+	}
+	speakerName := speaker.FromContext(ctx)
+	if speakerName != "" {
+		systemPrompt += "\n" + speakerPrompt
+	}
+	if memoryContext := l.getMemoryContext(ctx, speakerName); memoryContext != "" {
+		systemPrompt += fmt.Sprintf("\n用户个性化信息: \n%s", memoryContext)
 	}
 
 	//search memory
 	if l.clientState.MemoryProvider != nil && userMessage != nil {
-		memoryContext, err := l.clientState.MemoryProvider.Search(ctx, l.clientState.GetDeviceIDOrAgentID(), userMessage.Content, 10, 180)
+		memoryContext, err := l.clientState.MemoryProvider.Search(ctx, l.clientState.MemoryKey(speakerName), userMessage.Content, 10, 180)
 		if err != nil {
 			log.Errorf("搜索记忆失败: %v", err)
 		}
@@ -685,3 +803,42 @@ func (l *LLMManager) GetMessages(ctx context.Context, userMessage *schema.Messag
 	}
 	return retMessage
 }
+
+// getMemoryContext 说话人的个性化信息, 会话开始时只加载了整个智能体的, 说话人的在第一次用到时加载
+func (l *LLMManager) getMemoryContext(ctx context.Context, speakerName string) string {
+	if speakerName == "" || l.clientState.MemoryProvider == nil {
+		return l.clientState.MemoryContext
+	}
+	l.speakerContextMu.Lock()
+	defer l.speakerContextMu.Unlock()
+	if memoryContext, ok := l.speakerContexts[speakerName]; ok {
+		return memoryContext
+	}
+	memoryContext, err := l.clientState.MemoryProvider.GetContext(ctx, l.clientState.MemoryKey(speakerName), 500)
+	if err != nil {
+		log.Warnf("获取说话人 %s 的memory context失败: %v", speakerName, err)
+		return ""
+	}
+	if l.speakerContexts == nil {
+		l.speakerContexts = make(map[string]string)
+	}
+	l.speakerContexts[speakerName] = memoryContext
+	return memoryContext
+}
+
+func (l *LLMManager) recordToolCall(toolCall schema.ToolCall, result string, costMs int64, err error) {
+	if l.recorder == nil {
+		return
+	}
+	event := map[string]interface{}{
+		"id":        toolCall.ID,
+		"name":      toolCall.Function.Name,
+		"arguments": toolCall.Function.Arguments,
+		"result":    result,
+		"cost_ms":   costMs,
+	}
+	if err != nil {
+		event["error"] = err.Error()
+	}
+	l.recorder.Event(recorder.EventToolCall, event)
+}
@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/reminder"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+//此文件处理提醒/闹钟相关的 local mcp tool
+
+type CreateReminderParams struct {
+	Content      string `json:"content" description:"提醒内容, 到点后会播报给用户, 如: 该去开会了" required:"true"`
+	DelaySeconds int    `json:"delay_seconds,omitempty" description:"多少秒之后提醒, 如20分钟后提醒为1200, 与time二选一"`
+	Time         string `json:"time,omitempty" description:"提醒的具体时间, 格式为 2006-01-02 15:04 或 15:04(今天, 已过则为明天), 与delay_seconds二选一"`
+	Repeat       string `json:"repeat,omitempty" description:"重复方式, 不重复时留空, 每天重复(如每天早上的闹钟)为 daily"`
+}
+
+type CancelReminderParams struct {
+	Id string `json:"id" description:"要取消的提醒id, 可先通过 list_reminders 查询" required:"true"`
+}
+
+// reminderTimeLayouts 支持的提醒时间格式, 不带日期的表示今天或明天
+var reminderTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"15:04:05",
+	"15:04",
+}
+
+// RegisterReminderMCPTools 注册提醒相关的本地MCP工具
+func RegisterReminderMCPTools(scheduler *reminder.Scheduler) {
+	localTools := map[string]LocalMcpTool{
+		"create_reminder": {
+			Name:        "create_reminder",
+			Description: "当用户要求在一段时间后或某个时间点提醒他、设置闹钟时使用, 到点后会主动播报提醒内容",
+			Params:      CreateReminderParams{},
+			Handle: func(ctx context.Context, argumentsInJSON string) (string, error) {
+				return createReminderHandler(ctx, scheduler, argumentsInJSON)
+			},
+		},
+		"list_reminders": {
+			Name:        "list_reminders",
+			Description: "当用户询问设置了哪些提醒或闹钟时使用, 也用于取消提醒前查询提醒id",
+			Params:      struct{}{},
+			Handle: func(ctx context.Context, argumentsInJSON string) (string, error) {
+				return listRemindersHandler(ctx, scheduler)
+			},
+		},
+		"cancel_reminder": {
+			Name:        "cancel_reminder",
+			Description: "当用户要求取消、删除某个提醒或闹钟时使用",
+			Params:      CancelReminderParams{},
+			Handle: func(ctx context.Context, argumentsInJSON string) (string, error) {
+				return cancelReminderHandler(ctx, scheduler, argumentsInJSON)
+			},
+		},
+	}
+
+	for toolName, localTool := range localTools {
+		if viper.IsSet("local_mcp."+toolName) && !viper.GetBool("local_mcp."+toolName) {
+			continue
+		}
+		RegisterLocalMcpFunc(localTool.Name, localTool.Description, localTool.Params, localTool.Handle)
+	}
+}
+
+func createReminderHandler(ctx context.Context, scheduler *reminder.Scheduler, argumentsInJSON string) (string, error) {
+	deviceId, err := deviceIdFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var params CreateReminderParams
+	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil {
+		return NewErrorResponse("create_reminder", "参数解析失败", "PARSE_ERROR", "请检查参数格式是否正确").ToJSON()
+	}
+
+	now := time.Now()
+	var fireAt time.Time
+	switch {
+	case params.DelaySeconds > 0:
+		fireAt = now.Add(time.Duration(params.DelaySeconds) * time.Second)
+	case params.Time != "":
+		fireAt, err = parseReminderTime(params.Time, now)
+		if err != nil {
+			return NewErrorResponse("create_reminder", err.Error(), "INVALID_TIME", "请使用 2006-01-02 15:04 或 15:04 格式").ToJSON()
+		}
+	default:
+		return NewErrorResponse("create_reminder", "缺少提醒时间", "INVALID_TIME", "请提供 delay_seconds 或 time 参数").ToJSON()
+	}
+
+	r, err := scheduler.Create(ctx, deviceId, params.Content, fireAt, params.Repeat)
+	if errors.Is(err, reminder.ErrTooManyReminders) {
+		return NewErrorResponse("create_reminder", "提醒数量已达上限", "TOO_MANY_REMINDERS", "请先取消一些不需要的提醒").ToJSON()
+	}
+	if err != nil {
+		log.Errorf("设备 %s 创建提醒失败: %v", deviceId, err)
+		return NewErrorResponse("create_reminder", fmt.Sprintf("创建提醒失败: %v", err), "CREATE_ERROR", "请检查提醒时间和内容").ToJSON()
+	}
+
+	message := fmt.Sprintf("已设置提醒, 将在%s提醒: %s", formatChineseDateTime(r.FireAt), r.Content)
+	if r.Repeat == reminder.RepeatDaily {
+		message = fmt.Sprintf("已设置每天%02d:%02d的提醒: %s", r.FireAt.Hour(), r.FireAt.Minute(), r.Content)
+	}
+	return NewContentResponse("create_reminder", r, message).ToJSON()
+}
+
+func listRemindersHandler(ctx context.Context, scheduler *reminder.Scheduler) (string, error) {
+	deviceId, err := deviceIdFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	list, err := scheduler.List(ctx, deviceId)
+	if err != nil {
+		log.Errorf("设备 %s 查询提醒失败: %v", deviceId, err)
+		return NewErrorResponse("list_reminders", fmt.Sprintf("查询提醒失败: %v", err), "LIST_ERROR", "请稍后重试").ToJSON()
+	}
+	if len(list) == 0 {
+		return NewContentResponse("list_reminders", list, "当前没有设置任何提醒").ToJSON()
+	}
+
+	lines := make([]string, 0, len(list))
+	for _, r := range list {
+		line := fmt.Sprintf("[%s] %s %s", r.Id, formatChineseDateTime(r.FireAt), r.Content)
+		if r.Repeat == reminder.RepeatDaily {
+			line += " (每天)"
+		}
+		lines = append(lines, line)
+	}
+	message := fmt.Sprintf("共有%d个提醒:\n%s", len(list), strings.Join(lines, "\n"))
+	return NewContentResponse("list_reminders", list, message).ToJSON()
+}
+
+func cancelReminderHandler(ctx context.Context, scheduler *reminder.Scheduler, argumentsInJSON string) (string, error) {
+	deviceId, err := deviceIdFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var params CancelReminderParams
+	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil || params.Id == "" {
+		return NewErrorResponse("cancel_reminder", "参数解析失败", "PARSE_ERROR", "请提供要取消的提醒id").ToJSON()
+	}
+
+	err = scheduler.Cancel(ctx, deviceId, params.Id)
+	if errors.Is(err, reminder.ErrReminderNotFound) {
+		return NewErrorResponse("cancel_reminder", "提醒不存在或已触发", "NOT_FOUND", "请先通过 list_reminders 查询提醒id").ToJSON()
+	}
+	if err != nil {
+		log.Errorf("设备 %s 取消提醒 %s 失败: %v", deviceId, params.Id, err)
+		return NewErrorResponse("cancel_reminder", fmt.Sprintf("取消提醒失败: %v", err), "CANCEL_ERROR", "请稍后重试").ToJSON()
+	}
+
+	response := NewActionResponse("cancel_reminder", "cancel_reminder", "提醒已取消", "completed", false)
+	response.Metadata = map[string]string{"id": params.Id}
+	return response.ToJSON()
+}
+
+// parseReminderTime 解析提醒时间, 只有时分时取今天, 已过则顺延到明天
+func parseReminderTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range reminderTimeLayouts {
+		t, err := time.ParseInLocation(layout, value, now.Location())
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(layout, "2006") {
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+			if !t.After(now) {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析提醒时间: %s", value)
+}
+
+func deviceIdFromContext(ctx context.Context) (string, error) {
+	if chatSessionOperator, ok := ctx.Value("chat_session_operator").(ChatSessionOperator); ok {
+		return chatSessionOperator.GetDeviceId(), nil
+	}
+	log.Warn("从context中未找到chat_session_operator")
+	return "", fmt.Errorf("从context中未找到chat_session_operator")
+}
@@ -10,6 +10,7 @@ import (
 	types_audio "xiaozhi-esp32-server-golang/internal/data/audio"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
 	. "xiaozhi-esp32-server-golang/internal/data/msg"
+	"xiaozhi-esp32-server-golang/internal/domain/emotion"
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
@@ -21,6 +22,8 @@ type ServerTransport struct {
 	McpRecvMsgChan chan []byte
 	closed         bool
 	mu             sync.Mutex
+
+	transportMu sync.RWMutex
 }
 
 func NewServerTransport(transport types_conn.IConn, clientState *ClientState) *ServerTransport {
@@ -31,6 +34,20 @@ func NewServerTransport(transport types_conn.IConn, clientState *ClientState) *S
 	}
 }
 
+// conn 获取当前底层连接, 设备断线续连后会被替换
+func (s *ServerTransport) conn() types_conn.IConn {
+	s.transportMu.RLock()
+	defer s.transportMu.RUnlock()
+	return s.transport
+}
+
+// SetTransport 替换底层连接, 用于设备在续连窗口内重连
+func (s *ServerTransport) SetTransport(transport types_conn.IConn) {
+	s.transportMu.Lock()
+	defer s.transportMu.Unlock()
+	s.transport = transport
+}
+
 func (s *ServerTransport) SendTtsStart() error {
 	msg := ServerMessage{
 		Type:      ServerMessageTypeTts,
@@ -41,11 +58,11 @@ func (s *ServerTransport) SendTtsStart() error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
-	s.clientState.SetTtsStart(true)
+	s.clientState.SetStatus(ClientStatusTTSStart)
 	return nil
 }
 
@@ -59,7 +76,10 @@ func (s *ServerTransport) SendTtsStop() error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	// 无论下发是否成功, 服务端这一轮播放都已结束
+	// 被打断后才结束的 tts 不能把已经开始的拾音改回空闲
+	s.clientState.CompareAndSetStatus(ClientStatusInit, ClientStatusTTSStart, ClientStatusLLMStart)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
@@ -76,7 +96,7 @@ func (s *ServerTransport) SendMqttGoodbye() error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
@@ -96,7 +116,7 @@ func (s *ServerTransport) SendHello(transportType string, audioFormat *types_aud
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
@@ -114,7 +134,7 @@ func (s *ServerTransport) SendIot(msg *ClientMessage) error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
@@ -131,13 +151,28 @@ func (s *ServerTransport) SendAsrResult(text string) error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// SendAsrPartial 下发识别中间结果, text 为本句目前已识别的全部文本, 最终结果仍由 SendAsrResult 下发
+func (s *ServerTransport) SendAsrPartial(text string) error {
+	resp := ServerMessage{
+		Type:      ServerMessageTypeStt,
+		Text:      text,
+		Partial:   true,
+		SessionID: s.clientState.SessionID,
+	}
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.conn().SendCmd(bytes)
+}
+
 func (s *ServerTransport) SendSentenceStart(text string) error {
 	response := ServerMessage{
 		Type:      ServerMessageTypeTts,
@@ -149,11 +184,11 @@ func (s *ServerTransport) SendSentenceStart(text string) error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
-	s.clientState.SetStatus(ClientStatusTTSStart)
+	s.clientState.CompareAndSetStatus(ClientStatusTTSStart, ClientStatusLLMStart)
 	return nil
 }
 
@@ -168,28 +203,43 @@ func (s *ServerTransport) SendSentenceEnd(text string) error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
-	s.clientState.SetStatus(ClientStatusTTSStart)
+	s.clientState.CompareAndSetStatus(ClientStatusTTSStart, ClientStatusLLMStart)
 	return nil
 }
 
+// SendEmotion 下发情绪, 设备据此切换表情
+func (s *ServerTransport) SendEmotion(e string) error {
+	response := ServerMessage{
+		Type:      ServerMessageTypeLlm,
+		Text:      emotion.Emoji(e),
+		Emotion:   e,
+		SessionID: s.clientState.SessionID,
+	}
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return s.conn().SendCmd(bytes)
+}
+
 func (s *ServerTransport) SendCmd(cmdBytes []byte) error {
-	return s.transport.SendCmd(cmdBytes)
+	return s.conn().SendCmd(cmdBytes)
 }
 
 func (s *ServerTransport) SendAudio(audio []byte) error {
-	return s.transport.SendAudio(audio)
+	return s.conn().SendAudio(audio)
 }
 
 func (s *ServerTransport) GetTransportType() string {
-	return s.transport.GetTransportType()
+	return s.conn().GetTransportType()
 }
 
 func (s *ServerTransport) GetData(key string) (interface{}, error) {
-	return s.transport.GetData(key)
+	return s.conn().GetData(key)
 }
 
 func (s *ServerTransport) SendMcpMsg(payload []byte) error {
@@ -202,7 +252,7 @@ func (s *ServerTransport) SendMcpMsg(payload []byte) error {
 	if err != nil {
 		return err
 	}
-	err = s.transport.SendCmd(bytes)
+	err = s.conn().SendCmd(bytes)
 	if err != nil {
 		return err
 	}
@@ -251,18 +301,18 @@ func (s *ServerTransport) Close() error {
 
 	s.closed = true
 
-	if s.transport.GetTransportType() == types_conn.TransportTypeMqttUdp {
+	if s.conn().GetTransportType() == types_conn.TransportTypeMqttUdp {
 		s.SendMqttGoodbye()
 	}
 
 	close(s.McpRecvMsgChan)
-	return s.transport.Close()
+	return s.conn().Close()
 }
 
 func (s *ServerTransport) RecvAudio(ctx context.Context, timeOut int) ([]byte, error) {
-	return s.transport.RecvAudio(ctx, timeOut)
+	return s.conn().RecvAudio(ctx, timeOut)
 }
 
 func (s *ServerTransport) RecvCmd(ctx context.Context, timeOut int) ([]byte, error) {
-	return s.transport.RecvCmd(ctx, timeOut)
+	return s.conn().RecvCmd(ctx, timeOut)
 }
@@ -7,23 +7,30 @@ import (
 	"math/rand"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/schema"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
 
 	"xiaozhi-esp32-server-golang/internal/app/server/auth"
+	"xiaozhi-esp32-server-golang/internal/app/server/recorder"
 	types_conn "xiaozhi-esp32-server-golang/internal/app/server/types"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
 	. "xiaozhi-esp32-server-golang/internal/data/msg"
+	"xiaozhi-esp32-server-golang/internal/domain/asr"
 	user_config "xiaozhi-esp32-server-golang/internal/domain/config"
 	"xiaozhi-esp32-server-golang/internal/domain/eventbus"
+	"xiaozhi-esp32-server-golang/internal/domain/language"
 	"xiaozhi-esp32-server-golang/internal/domain/llm"
 	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
 	"xiaozhi-esp32-server-golang/internal/domain/memory"
 	"xiaozhi-esp32-server-golang/internal/domain/memory/llm_memory"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+	"xiaozhi-esp32-server-golang/internal/domain/tracing"
 	"xiaozhi-esp32-server-golang/internal/domain/tts"
 	"xiaozhi-esp32-server-golang/internal/util"
 	log "xiaozhi-esp32-server-golang/logger"
@@ -44,11 +51,68 @@ type ChatSession struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// 收发循环绑定在当前连接上, 设备重连时单独重启
+	transportCancel context.CancelFunc
+	// vad处理协程, 重复hello时先停掉旧的
+	vadCancel context.CancelFunc
+	loopMu    sync.Mutex
+
 	chatTextQueue *util.Queue[AsrResponseChannelItem]
+
+	ttsManagerOpts []TTSManagerOption
+
+	// 会话录制, 未开启时为 nil
+	recorder *recorder.Recorder
+
+	// 外部指定的提供者, 为 nil 时按设备配置创建
+	asrProvider asr.AsrProvider
+	llmProvider llm.LLMProvider
+	ttsProvider tts.TTSProvider
+
+	// 声纹识别, 未开启时为 nil
+	speakerService *speaker.Service
+	// 最近一句话的声纹, 登记说话人时使用
+	lastEmbedding []float32
+	speakerMu     sync.Mutex
 }
 
 type ChatSessionOption func(*ChatSession)
 
+// WithTtsManagerOptions 透传 TTSManager 的配置项
+func WithTtsManagerOptions(opts ...TTSManagerOption) ChatSessionOption {
+	return func(s *ChatSession) {
+		s.ttsManagerOpts = append(s.ttsManagerOpts, opts...)
+	}
+}
+
+// WithAsrProvider 使用指定的 asr 提供者, 不再按设备配置创建, 回放测试时用于注入桩实现
+func WithAsrProvider(provider asr.AsrProvider) ChatSessionOption {
+	return func(s *ChatSession) {
+		s.asrProvider = provider
+	}
+}
+
+// WithLlmProvider 使用指定的 llm 提供者
+func WithLlmProvider(provider llm.LLMProvider) ChatSessionOption {
+	return func(s *ChatSession) {
+		s.llmProvider = provider
+	}
+}
+
+// WithTtsProvider 使用指定的 tts 提供者
+func WithTtsProvider(provider tts.TTSProvider) ChatSessionOption {
+	return func(s *ChatSession) {
+		s.ttsProvider = provider
+	}
+}
+
+// WithSpeakerService 开启声纹识别, 按说话人区分记忆
+func WithSpeakerService(svc *speaker.Service) ChatSessionOption {
+	return func(s *ChatSession) {
+		s.speakerService = svc
+	}
+}
+
 func NewChatSession(clientState *ClientState, serverTransport *ServerTransport, opts ...ChatSessionOption) *ChatSession {
 	s := &ChatSession{
 		clientState:     clientState,
@@ -59,9 +123,20 @@ func NewChatSession(clientState *ClientState, serverTransport *ServerTransport,
 		opt(s)
 	}
 
-	s.asrManager = NewASRManager(clientState, serverTransport)
-	s.ttsManager = NewTTSManager(clientState, serverTransport)
-	s.llmManager = NewLLMManager(clientState, serverTransport, s.ttsManager)
+	s.recorder = newSessionRecorder(clientState)
+	clientState.Trace = tracing.NewSession(clientState.DeviceID,
+		attribute.String("agent_id", clientState.AgentID),
+		attribute.String("asr", clientState.DeviceConfig.Asr.Provider),
+		attribute.String("llm", clientState.DeviceConfig.Llm.Provider),
+		attribute.String("tts", clientState.DeviceConfig.Tts.Provider),
+	)
+	asrOpts := []ASRManagerOption{WithAsrRecorder(s.recorder), WithAsrPartialHandler(s.onAsrPartial)}
+	if s.speakerService != nil {
+		asrOpts = append(asrOpts, WithAsrSpeechCapture())
+	}
+	s.asrManager = NewASRManager(clientState, serverTransport, asrOpts...)
+	s.ttsManager = NewTTSManager(clientState, serverTransport, append(s.ttsManagerOpts, WithTtsRecorder(s.recorder))...)
+	s.llmManager = NewLLMManager(clientState, serverTransport, s.ttsManager, WithLlmRecorder(s.recorder))
 
 	return s
 }
@@ -80,15 +155,62 @@ func (s *ChatSession) Start(pctx context.Context) error {
 		log.Errorf("初始化对话历史失败: %v", err)
 	}
 
-	go s.CmdMessageLoop(s.ctx)   //处理信令消息
-	go s.AudioMessageLoop(s.ctx) //处理音频数据
+	s.startTransportLoops()
 	go s.processChatText(s.ctx)  //处理 asr后 的对话消息
 	go s.llmManager.Start(s.ctx) //处理 llm后 的一系列返回消息
 	go s.ttsManager.Start(s.ctx) //处理 tts的 消息队列
+	if s.recorder != nil || s.clientState.Trace != nil {
+		go func() {
+			<-s.ctx.Done()
+			s.clientState.Trace.Close()
+			if s.recorder == nil {
+				return
+			}
+			if err := s.recorder.Close(); err != nil {
+				log.Warnf("设备 %s 结束会话录制失败: %v", s.clientState.DeviceID, err)
+			}
+		}()
+	}
 
 	return nil
 }
 
+// startTransportLoops 启动绑定在当前连接上的信令和音频接收循环
+func (s *ChatSession) startTransportLoops() {
+	s.loopMu.Lock()
+	defer s.loopMu.Unlock()
+
+	if s.transportCancel != nil {
+		s.transportCancel()
+	}
+	var ctx context.Context
+	ctx, s.transportCancel = context.WithCancel(s.ctx)
+
+	go s.CmdMessageLoop(ctx)   //处理信令消息
+	go s.AudioMessageLoop(ctx) //处理音频数据
+}
+
+// Detach 连接断开后挂起会话, 停止收发循环并放弃尚未播放完的tts
+// 对话历史、mcp会话等状态保留, 等待设备重连
+func (s *ChatSession) Detach() {
+	s.StopSpeaking(false)
+	s.clientState.SetStatus(ClientStatusInit)
+
+	s.loopMu.Lock()
+	defer s.loopMu.Unlock()
+	if s.transportCancel != nil {
+		s.transportCancel()
+		s.transportCancel = nil
+	}
+}
+
+// Attach 将设备重连后的新连接挂到当前会话上
+func (s *ChatSession) Attach(transport types_conn.IConn) {
+	s.Detach()
+	s.serverTransport.SetTransport(transport)
+	s.startTransportLoops()
+}
+
 // 初始化历史对话记录到内存中
 func (s *ChatSession) initHistoryMessages() error {
 	historyMessages, err := llm_memory.Get().GetMessages(s.ctx, s.clientState.DeviceID, s.clientState.AgentID, 20)
@@ -102,17 +224,25 @@ func (s *ChatSession) initHistoryMessages() error {
 
 // 在mqtt 收到type: listen, state: start后进行
 func (c *ChatSession) InitAsrLlmTts() error {
-	ttsConfig := c.clientState.DeviceConfig.Tts
-	ttsProvider, err := tts.GetTTSProvider(ttsConfig.Provider, ttsConfig.Config)
-	if err != nil {
-		return fmt.Errorf("创建 TTS 提供者失败: %v", err)
+	ttsProvider := c.ttsProvider
+	if ttsProvider == nil {
+		ttsConfig := c.clientState.DeviceConfig.Tts
+		var err error
+		ttsProvider, err = tts.GetTTSProvider(ttsConfig.Provider, ttsConfig.Config)
+		if err != nil {
+			return fmt.Errorf("创建 TTS 提供者失败: %v", err)
+		}
 	}
 	c.clientState.TTSProvider = ttsProvider
 
-	if err := c.clientState.InitLlm(); err != nil {
+	if c.llmProvider != nil {
+		c.clientState.InitLlmWithProvider(c.llmProvider)
+	} else if err := c.clientState.InitLlm(); err != nil {
 		return fmt.Errorf("初始化LLM失败: %v", err)
 	}
-	if err := c.clientState.InitAsr(); err != nil {
+	if c.asrProvider != nil {
+		c.clientState.InitAsrWithProvider(c.asrProvider)
+	} else if err := c.clientState.InitAsr(); err != nil {
 		return fmt.Errorf("初始化ASR失败: %v", err)
 	}
 	c.clientState.SetAsrPcmFrameSize(c.clientState.InputAudioFormat.SampleRate, c.clientState.InputAudioFormat.Channels, c.clientState.InputAudioFormat.FrameDuration)
@@ -124,7 +254,7 @@ func (c *ChatSession) InitAsrLlmTts() error {
 	}
 	c.clientState.MemoryProvider = memoryProvider
 	//初始化memory context
-	context, err := memoryProvider.GetContext(c.ctx, c.clientState.GetDeviceIDOrAgentID(), 500)
+	context, err := memoryProvider.GetContext(c.ctx, c.clientState.MemoryKey(""), 500)
 	if err != nil {
 		log.Warnf("初始化memory context失败: %v", err)
 	}
@@ -155,6 +285,7 @@ func (c *ChatSession) CmdMessageLoop(ctx context.Context) {
 		}
 		recvFailCount = 0
 		log.Infof("收到文本消息: %s", string(message))
+		c.recorder.Event(recorder.EventCmdIn, map[string]interface{}{"data": string(message)})
 		if err := c.HandleTextMessage(message); err != nil {
 			log.Errorf("处理文本消息失败: %v, 原始消息: %s", err, string(message))
 			continue
@@ -239,6 +370,8 @@ func (s *ChatSession) HandleHelloMessage(msg *ClientMessage) error {
 		return s.HandleWebsocketHelloMessage(msg)
 	} else if msg.Transport == types_conn.TransportTypeMqttUdp {
 		return s.HandleMqttHelloMessage(msg)
+	} else if msg.Transport == types_conn.TransportTypeWebRtc {
+		return s.HandleWebRtcHelloMessage(msg)
 	}
 	return fmt.Errorf("不支持的传输类型: %s", msg.Transport)
 }
@@ -324,8 +457,19 @@ func (s *ChatSession) HandleCommonHelloMessage(msg *ClientMessage) error {
 
 	clientState.InputAudioFormat = *msg.AudioParams
 	clientState.SetAsrPcmFrameSize(clientState.InputAudioFormat.SampleRate, clientState.InputAudioFormat.Channels, clientState.InputAudioFormat.FrameDuration)
-
-	s.asrManager.ProcessVadAudio(clientState.Ctx, s.Close)
+	s.recorder.SetAudioFormat(
+		recorder.AudioFormat{SampleRate: clientState.InputAudioFormat.SampleRate, Channels: clientState.InputAudioFormat.Channels},
+		recorder.AudioFormat{SampleRate: clientState.OutputAudioFormat.SampleRate, Channels: clientState.OutputAudioFormat.Channels},
+	)
+
+	s.loopMu.Lock()
+	if s.vadCancel != nil {
+		s.vadCancel()
+	}
+	var vadCtx context.Context
+	vadCtx, s.vadCancel = context.WithCancel(clientState.Ctx)
+	s.loopMu.Unlock()
+	s.asrManager.ProcessVadAudio(vadCtx, s.Close)
 
 	log.Infof("[性能] 设备 %s 公共Hello消息处理完成, 总耗时: %v", msg.DeviceID, time.Since(startTime))
 	return nil
@@ -355,6 +499,21 @@ func (s *ChatSession) HandleWebsocketHelloMessage(msg *ClientMessage) error {
 	return nil
 }
 
+// HandleWebRtcHelloMessage 音频已走 rtp, hello 响应中无需携带额外的传输配置
+func (s *ChatSession) HandleWebRtcHelloMessage(msg *ClientMessage) error {
+	if err := s.HandleCommonHelloMessage(msg); err != nil {
+		log.Errorf("设备 %s 处理公共Hello消息失败: %v", msg.DeviceID, err)
+		return err
+	}
+
+	err := s.serverTransport.SendHello(types_conn.TransportTypeWebRtc, &s.clientState.OutputAudioFormat, nil)
+	if err != nil {
+		log.Errorf("设备 %s 发送Hello响应失败: %v", msg.DeviceID, err)
+		return err
+	}
+	return nil
+}
+
 // handleListenMessage 处理监听消息
 func (s *ChatSession) HandleListenMessage(msg *ClientMessage) error {
 	// 根据状态处理
@@ -392,7 +551,7 @@ func (s *ChatSession) HandleListenDetect(msg *ClientMessage) error {
 		text = removePunctuation(text)
 
 		// 检查是否是唤醒词
-		isWakeupWord := isWakeupWord(text)
+		isWakeupWord := isWakeupWord(text, s.clientState.DeviceConfig.WakeupWords)
 		enableGreeting := viper.GetBool("enable_greeting") // 从配置获取
 
 		var needStartChat bool
@@ -519,7 +678,7 @@ func (s *ChatSession) HandleMcpMessage(msg *ClientMessage) error {
 
 // 释放udp资源
 func (s *ChatSession) HandleGoodByeMessage(msg *ClientMessage) error {
-	s.serverTransport.transport.CloseAudioChannel()
+	s.serverTransport.conn().CloseAudioChannel()
 	return nil
 }
 
@@ -566,7 +725,6 @@ func (s *ChatSession) HandleListenStart(msg *ClientMessage) error {
 	//if s.clientState.ListenMode == "manual" {
 	s.StopSpeaking(false)
 	//}
-	s.clientState.SetStatus(ClientStatusListening)
 
 	return s.OnListenStart()
 }
@@ -594,6 +752,11 @@ func (s *ChatSession) OnListenStart() error {
 	}
 
 	s.clientState.Destroy()
+	if err := s.clientState.SetStatus(ClientStatusListening); err != nil {
+		return err
+	}
+	s.recorder.StartTurn()
+	s.clientState.Trace.StartTurn(s.clientState.ListenMode)
 
 	ctx := s.clientState.SessionCtx.Get(s.clientState.Ctx)
 
@@ -647,6 +810,29 @@ func (s *ChatSession) OnListenStart() error {
 			log.Debugf("处理asr结果: %s, 耗时: %d ms", text, s.clientState.GetAsrDuration())
 
 			if text != "" {
+				s.llmManager.speculator.onFinal()
+				s.recorder.Event(recorder.EventAsrFinal, map[string]interface{}{"text": text, "asr_ms": s.clientState.GetAsrDuration()})
+				s.clientState.Trace.AsrFinal(text)
+
+				// 停止词与设备的 abort 一样处理, 不发给 llm
+				if isStopPhrase(text) {
+					log.Infof("设备 %s 说出停止词: %s, 停止播放", s.clientState.DeviceID, text)
+					if !s.clientState.IsRealTime() {
+						s.StopSpeaking(true)
+						return
+					}
+					// realtime模式下设备一直在收音, 只停止当前的回复, 与正常识别一样继续重启asr识别
+					s.stopReply()
+					startIdleTime = time.Now().Unix()
+					s.clientState.Trace.StartTurn(s.clientState.ListenMode)
+					if restartErr := s.asrManager.RestartAsrRecognition(ctx); restartErr != nil {
+						log.Errorf("重启ASR识别失败: %v", restartErr)
+						s.Close()
+						return
+					}
+					continue
+				}
+
 				//如果是realtime模式下，需要停止 当前的llm和tts
 				if s.clientState.IsRealTime() && viper.GetInt("chat.realtime_mode") == 2 {
 					s.clientState.AfterAsrSessionCtx.Cancel()
@@ -666,7 +852,7 @@ func (s *ChatSession) OnListenStart() error {
 					return
 				}
 
-				err = s.AddAsrResultToQueue(text)
+				err = s.addAsrResultToQueue(text, s.identifySpeaker(ctx))
 				if err != nil {
 					log.Errorf("开始对话失败: %v", err)
 					s.Close()
@@ -674,6 +860,7 @@ func (s *ChatSession) OnListenStart() error {
 				}
 
 				if s.clientState.IsRealTime() {
+					s.clientState.Trace.StartTurn(s.clientState.ListenMode)
 					if restartErr := s.asrManager.RestartAsrRecognition(ctx); restartErr != nil {
 						log.Errorf("重启ASR识别失败: %v", restartErr)
 						s.Close()
@@ -684,18 +871,20 @@ func (s *ChatSession) OnListenStart() error {
 				}
 				return
 			} else {
+				s.llmManager.speculator.reset()
 				select {
 				case <-ctx.Done():
 					log.Debugf("asr ctx done")
 					return
 				default:
 				}
-				log.Debugf("ready Restart Asr, s.clientState.Status: %s", s.clientState.Status)
-				if s.clientState.Status == ClientStatusListening || s.clientState.Status == ClientStatusListenStop {
+				status := s.clientState.GetStatus()
+				log.Debugf("ready Restart Asr, s.clientState.Status: %s", status)
+				if status == ClientStatusListening || status == ClientStatusListenStop {
 					// text 为空，检查是否需要重新启动ASR
 					diffTs := time.Now().Unix() - startIdleTime
 					if startIdleTime > 0 && diffTs <= maxIdleTime {
-						log.Warnf("ASR识别结果为空，尝试重启ASR识别, diff ts: %s", diffTs)
+						log.Warnf("ASR识别结果为空，尝试重启ASR识别, diff ts: %d", diffTs)
 						if restartErr := s.asrManager.RestartAsrRecognition(ctx); restartErr != nil {
 							log.Errorf("重启ASR识别失败: %v", restartErr)
 							s.Close()
@@ -717,10 +906,18 @@ func (s *ChatSession) OnListenStart() error {
 
 // startChat 开始对话
 func (s *ChatSession) AddAsrResultToQueue(text string) error {
-	log.Debugf("AddAsrResultToQueue text: %s", text)
+	return s.addAsrResultToQueue(text, "")
+}
+
+// addAsrResultToQueue speakerName 为识别出的说话人, 未识别出时为空
+func (s *ChatSession) addAsrResultToQueue(text string, speakerName string) error {
+	log.Debugf("AddAsrResultToQueue text: %s, speaker: %s", text, speakerName)
 	sessionCtx := s.clientState.SessionCtx.Get(s.clientState.Ctx)
+	// 实时模式下入队后即开始新的一轮, 回复仍记在识别出这句话的一轮
+	ctx := s.clientState.Trace.WithTurn(s.clientState.AfterAsrSessionCtx.Get(sessionCtx))
+	ctx = speaker.WithContext(ctx, speakerName)
 	item := AsrResponseChannelItem{
-		ctx:  s.clientState.AfterAsrSessionCtx.Get(sessionCtx),
+		ctx:  language.WithContext(ctx, language.Detect(text)),
 		text: text,
 	}
 	err := s.chatTextQueue.Push(item)
@@ -774,7 +971,9 @@ func (s *ChatSession) Close() {
 	}
 
 	// 取消会话级别的上下文
-	s.cancel()
+	if s.cancel != nil {
+		s.cancel()
+	}
 
 	if s.clientState != nil {
 		eventbus.Get().Publish(eventbus.TopicSessionEnd, s.clientState)
@@ -792,25 +991,52 @@ func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
 	}
 
 	//当收到停止说话或退出说话时, 则退出对话
+	if isExitText(text) {
+		s.Close()
+		return nil
+	}
+
+	sessionID := s.clientState.SessionID
+
+	// 直接创建Eino原生消息, 识别出说话人时在内容前标注
+	userMessage := &schema.Message{
+		Role:    schema.User,
+		Content: speaker.Annotate(speaker.FromContext(ctx), text),
+	}
+
+	einoTools := s.getEinoTools(ctx)
+
+	toolNameList := make([]string, 0)
+	for _, tool := range einoTools {
+		toolNameList = append(toolNameList, tool.Name)
+	}
+
+	// 发送带工具的LLM请求
+	log.Infof("使用 %d 个MCP工具发送LLM请求, tools: %+v", len(einoTools), toolNameList)
+
+	err := s.llmManager.DoLLmRequest(ctx, userMessage, einoTools, true)
+	if err != nil {
+		log.Errorf("发送带工具的 LLM 请求失败, seesionID: %s, error: %v", sessionID, err)
+		return fmt.Errorf("发送带工具的 LLM 请求失败: %v", err)
+	}
+	return nil
+}
+
+func isExitText(text string) bool {
 	clearText := strings.TrimSpace(text)
 	exitWords := []string{"再见", "退下吧", "退出", "退出对话", "停止", "停止说话"}
 	for _, word := range exitWords {
 		if strings.Contains(clearText, word) {
-			s.Close()
-			return nil
+			return true
 		}
 	}
+	return false
+}
 
+// getEinoTools 获取设备可用的MCP工具并转换为Eino ToolInfo格式
+func (s *ChatSession) getEinoTools(ctx context.Context) []*schema.ToolInfo {
 	clientState := s.clientState
 
-	sessionID := clientState.SessionID
-
-	// 直接创建Eino原生消息
-	userMessage := &schema.Message{
-		Role:    schema.User,
-		Content: text,
-	}
-
 	// 获取全局MCP工具列表
 	mcpTools, err := mcp.GetToolsByDeviceId(clientState.DeviceID, clientState.AgentID)
 	if err != nil {
@@ -830,19 +1056,83 @@ func (s *ChatSession) actionDoChat(ctx context.Context, text string) error {
 		log.Errorf("转换MCP工具失败: %v", err)
 		einoTools = nil
 	}
+	return einoTools
+}
 
-	toolNameList := make([]string, 0)
-	for _, tool := range einoTools {
-		toolNameList = append(toolNameList, tool.Name)
+// onAsrPartial 识别中间结果稳定一段时间不变后, 按中间结果提前发起 llm 请求.
+// 实时模式下上一轮的回复可能仍在进行, 对话历史不确定, 不做投机
+func (s *ChatSession) onAsrPartial(text string) {
+	if !speculativeEnabled() || s.clientState.IsRealTime() {
+		return
 	}
+	s.llmManager.speculator.onPartial(text, s.speculate)
+}
 
-	// 发送带工具的LLM请求
-	log.Infof("使用 %d 个MCP工具发送LLM请求, tools: %+v", len(einoTools), toolNameList)
+func (s *ChatSession) speculate(seq uint64, text string) {
+	if isExitText(text) {
+		return
+	}
+	// 与 AddAsrResultToQueue 使用同一个 ctx, 打断时随本轮一起取消
+	sessionCtx := s.clientState.SessionCtx.Get(s.clientState.Ctx)
+	ctx := s.clientState.Trace.WithTurn(s.clientState.AfterAsrSessionCtx.Get(sessionCtx))
+	ctx = language.WithContext(ctx, language.Detect(text))
+	// 按目前的语音识别说话人, 与最终结果不一致时内容不同, 自然不会命中
+	speakerName := s.matchSpeaker(ctx)
+	ctx = speaker.WithContext(ctx, speakerName)
+	userMessage := &schema.Message{
+		Role:    schema.User,
+		Content: speaker.Annotate(speakerName, text),
+	}
+	s.llmManager.Speculate(ctx, seq, userMessage, s.getEinoTools(ctx))
+}
 
-	err = s.llmManager.DoLLmRequest(ctx, userMessage, einoTools, true)
+// identifySpeaker 识别本句的说话人并记下声纹, 未开启或未识别出时返回空
+func (s *ChatSession) identifySpeaker(ctx context.Context) string {
+	if s.speakerService == nil {
+		return ""
+	}
+	embedding, name := s.embedSpeech(ctx)
+	s.speakerMu.Lock()
+	s.lastEmbedding = embedding
+	s.speakerMu.Unlock()
+	return name
+}
+
+// matchSpeaker 只识别, 不改变 lastEmbedding
+func (s *ChatSession) matchSpeaker(ctx context.Context) string {
+	if s.speakerService == nil {
+		return ""
+	}
+	_, name := s.embedSpeech(ctx)
+	return name
+}
+
+func (s *ChatSession) embedSpeech(ctx context.Context) ([]float32, string) {
+	samples, sampleRate := s.asrManager.Speech()
+	embedding, err := s.speakerService.Embed(samples, sampleRate)
 	if err != nil {
-		log.Errorf("发送带工具的 LLM 请求失败, seesionID: %s, error: %v", sessionID, err)
-		return fmt.Errorf("发送带工具的 LLM 请求失败: %v", err)
+		if err != speaker.ErrSpeechTooShort {
+			log.Warnf("提取声纹失败: %v", err)
+		}
+		return nil, ""
 	}
-	return nil
+	name, err := s.speakerService.Identify(ctx, s.clientState.GetDeviceIDOrAgentID(), embedding)
+	if err != nil {
+		log.Warnf("识别说话人失败: %v", err)
+	}
+	return embedding, name
+}
+
+// EnrollSpeaker 用触发本次对话的那句话登记说话人
+func (s *ChatSession) EnrollSpeaker(ctx context.Context, name string) (*speaker.Speaker, error) {
+	if s.speakerService == nil {
+		return nil, fmt.Errorf("声纹识别未开启")
+	}
+	s.speakerMu.Lock()
+	embedding := s.lastEmbedding
+	s.speakerMu.Unlock()
+	if embedding == nil {
+		return nil, speaker.ErrSpeechTooShort
+	}
+	return s.speakerService.Enroll(ctx, s.clientState.GetDeviceIDOrAgentID(), name, embedding)
 }
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	llm_memory "xiaozhi-esp32-server-golang/internal/domain/memory/llm_memory"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
 	log "xiaozhi-esp32-server-golang/logger"
 )
 
@@ -76,6 +77,15 @@ func (c *ChatManager) LocalMcpClearHistory() error {
 	return nil
 }
 
+// 登记说话人
+func (c *ChatManager) LocalMcpEnrollSpeaker(ctx context.Context, name string) (*speaker.Speaker, error) {
+	return c.session.EnrollSpeaker(ctx, name)
+}
+
+func (c *ChatManager) GetSpeakerOwner() string {
+	return c.clientState.GetDeviceIDOrAgentID()
+}
+
 type PlayMusicParams struct {
 	Name string `json:"name,omitempty" description:"音乐的名称"`
 	//Welcome string `json:"welcome" description:"搜索音乐会耗时过长，用于安抚用户的提示语" required:"true"`
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"xiaozhi-esp32-server-golang/internal/app/server/recorder"
+	. "xiaozhi-esp32-server-golang/internal/data/client"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+// newSessionRecorder 按配置为指定设备或智能体开启会话录制, 未开启时返回 nil
+func newSessionRecorder(clientState *ClientState) *recorder.Recorder {
+	if !viper.GetBool("recorder.enable") {
+		return nil
+	}
+	devices := viper.GetStringSlice("recorder.devices")
+	agents := viper.GetStringSlice("recorder.agents")
+	// 两个列表都为空时录制所有会话
+	if len(devices) > 0 || len(agents) > 0 {
+		if !containsString(devices, clientState.DeviceID) && !containsString(agents, clientState.AgentID) {
+			return nil
+		}
+	}
+
+	rec, err := recorder.New(viper.GetString("recorder.dir"), clientState.DeviceID, map[string]interface{}{
+		"agent_id": clientState.AgentID,
+		"asr":      clientState.DeviceConfig.Asr.Provider,
+		"llm":      clientState.DeviceConfig.Llm.Provider,
+		"tts":      clientState.DeviceConfig.Tts.Provider,
+		"vad":      clientState.DeviceConfig.Vad.Provider,
+	})
+	if err != nil {
+		log.Errorf("设备 %s 开启会话录制失败: %v", clientState.DeviceID, err)
+		return nil
+	}
+	log.Infof("设备 %s 开启会话录制: %s", clientState.DeviceID, rec.Dir())
+	return rec
+}
+
+func containsString(list []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
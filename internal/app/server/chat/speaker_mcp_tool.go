@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+//此文件处理声纹登记相关的 local mcp tool
+
+type EnrollSpeakerParams struct {
+	Name string `json:"name" description:"说话人的名字或称呼, 如: 爸爸、小明" required:"true"`
+}
+
+type RemoveSpeakerParams struct {
+	Name string `json:"name" description:"要删除的说话人名字, 可先通过 list_speakers 查询" required:"true"`
+}
+
+// RegisterSpeakerMCPTools 注册声纹相关的本地MCP工具
+func RegisterSpeakerMCPTools(svc *speaker.Service) {
+	localTools := map[string]LocalMcpTool{
+		"enroll_speaker": {
+			Name:        "enroll_speaker",
+			Description: "当用户介绍自己是谁、要求记住他的声音时使用, 如: 我是爸爸, 记住我的声音. 用用户刚说的这句话登记声纹, 多登记几次识别更准",
+			Params:      EnrollSpeakerParams{},
+			Handle: func(ctx context.Context, argumentsInJSON string) (string, error) {
+				return enrollSpeakerHandler(ctx, argumentsInJSON)
+			},
+		},
+		"list_speakers": {
+			Name:        "list_speakers",
+			Description: "当用户询问记住了哪些人的声音时使用",
+			Params:      struct{}{},
+			Handle: func(ctx context.Context, argumentsInJSON string) (string, error) {
+				return listSpeakersHandler(ctx, svc)
+			},
+		},
+		"remove_speaker": {
+			Name:        "remove_speaker",
+			Description: "当用户要求忘记、删除某个人的声音时使用",
+			Params:      RemoveSpeakerParams{},
+			Handle: func(ctx context.Context, argumentsInJSON string) (string, error) {
+				return removeSpeakerHandler(ctx, svc, argumentsInJSON)
+			},
+		},
+	}
+
+	for toolName, localTool := range localTools {
+		if viper.IsSet("local_mcp."+toolName) && !viper.GetBool("local_mcp."+toolName) {
+			continue
+		}
+		RegisterLocalMcpFunc(localTool.Name, localTool.Description, localTool.Params, localTool.Handle)
+	}
+}
+
+func enrollSpeakerHandler(ctx context.Context, argumentsInJSON string) (string, error) {
+	chatSessionOperator, ok := ctx.Value("chat_session_operator").(ChatSessionOperator)
+	if !ok {
+		log.Warn("从context中未找到chat_session_operator")
+		return "", fmt.Errorf("从context中未找到chat_session_operator")
+	}
+
+	var params EnrollSpeakerParams
+	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil || strings.TrimSpace(params.Name) == "" {
+		return NewErrorResponse("enroll_speaker", "参数解析失败", "PARSE_ERROR", "请提供说话人的名字").ToJSON()
+	}
+
+	sp, err := chatSessionOperator.LocalMcpEnrollSpeaker(ctx, params.Name)
+	if errors.Is(err, speaker.ErrSpeechTooShort) {
+		return NewErrorResponse("enroll_speaker", "刚才那句话太短, 无法登记声纹", "SPEECH_TOO_SHORT", "请让用户多说几个字, 如: 我是爸爸, 请记住我的声音").ToJSON()
+	}
+	if errors.Is(err, speaker.ErrTooManySpeakers) {
+		return NewErrorResponse("enroll_speaker", "登记的人数已达上限", "TOO_MANY_SPEAKERS", "请先删除一些不需要的声纹").ToJSON()
+	}
+	if err != nil {
+		log.Errorf("设备 %s 登记声纹失败: %v", chatSessionOperator.GetDeviceId(), err)
+		return NewErrorResponse("enroll_speaker", fmt.Sprintf("登记声纹失败: %v", err), "ENROLL_ERROR", "请稍后重试").ToJSON()
+	}
+
+	message := fmt.Sprintf("已记住%s的声音", sp.Name)
+	if sp.Samples > 1 {
+		message = fmt.Sprintf("已更新%s的声音, 共登记%d句", sp.Name, sp.Samples)
+	}
+	return NewContentResponse("enroll_speaker", map[string]interface{}{"name": sp.Name, "samples": sp.Samples}, message).ToJSON()
+}
+
+func listSpeakersHandler(ctx context.Context, svc *speaker.Service) (string, error) {
+	chatSessionOperator, ok := ctx.Value("chat_session_operator").(ChatSessionOperator)
+	if !ok {
+		log.Warn("从context中未找到chat_session_operator")
+		return "", fmt.Errorf("从context中未找到chat_session_operator")
+	}
+	owner := chatSessionOperator.GetSpeakerOwner()
+	list, err := svc.List(ctx, owner)
+	if err != nil {
+		log.Errorf("%s 查询声纹失败: %v", owner, err)
+		return NewErrorResponse("list_speakers", fmt.Sprintf("查询声纹失败: %v", err), "LIST_ERROR", "请稍后重试").ToJSON()
+	}
+	names := make([]string, 0, len(list))
+	for _, sp := range list {
+		names = append(names, sp.Name)
+	}
+	if len(names) == 0 {
+		return NewContentResponse("list_speakers", names, "还没有记住任何人的声音").ToJSON()
+	}
+	return NewContentResponse("list_speakers", names, fmt.Sprintf("记住了%d个人的声音: %s", len(names), strings.Join(names, "、"))).ToJSON()
+}
+
+func removeSpeakerHandler(ctx context.Context, svc *speaker.Service, argumentsInJSON string) (string, error) {
+	chatSessionOperator, ok := ctx.Value("chat_session_operator").(ChatSessionOperator)
+	if !ok {
+		log.Warn("从context中未找到chat_session_operator")
+		return "", fmt.Errorf("从context中未找到chat_session_operator")
+	}
+
+	var params RemoveSpeakerParams
+	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err != nil || strings.TrimSpace(params.Name) == "" {
+		return NewErrorResponse("remove_speaker", "参数解析失败", "PARSE_ERROR", "请提供要删除的说话人名字").ToJSON()
+	}
+
+	owner := chatSessionOperator.GetSpeakerOwner()
+	err := svc.Remove(ctx, owner, params.Name)
+	if errors.Is(err, speaker.ErrSpeakerNotFound) {
+		return NewErrorResponse("remove_speaker", "没有登记过这个人的声音", "NOT_FOUND", "请先通过 list_speakers 查询").ToJSON()
+	}
+	if err != nil {
+		log.Errorf("%s 删除声纹 %s 失败: %v", owner, params.Name, err)
+		return NewErrorResponse("remove_speaker", fmt.Sprintf("删除声纹失败: %v", err), "REMOVE_ERROR", "请稍后重试").ToJSON()
+	}
+
+	response := NewActionResponse("remove_speaker", "remove_speaker", "已忘记"+strings.TrimSpace(params.Name)+"的声音", "completed", false)
+	response.Metadata = map[string]string{"name": strings.TrimSpace(params.Name)}
+	return response.ToJSON()
+}
@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+// 中间结果保持不变多久后发起投机请求
+const defaultSpeculativeStableMs = 300
+
+// speculation 识别过程中按中间结果提前发起的 llm 请求, 回复缓存在 responseSentences 中,
+// 在最终结果确认之前不写对话历史、不合成语音
+type speculation struct {
+	text string
+	// 请求所在的 ctx, 携带 llm span, 取消即放弃这次请求
+	ctx               context.Context
+	cancel            context.CancelFunc
+	responseSentences chan llm_common.LLMResponseStruct
+}
+
+// speculator 管理一句话内的投机请求: 中间结果变化时重新计时, 稳定后发起请求, 最终结果出来后按文本取用或丢弃
+type speculator struct {
+	mu sync.Mutex
+	// 每次中间结果变化或最终结果到达时递增, 过期的计时器和请求据此丢弃
+	seq      uint64
+	lastText string
+	timer    *time.Timer
+	pending  *speculation
+}
+
+func speculativeEnabled() bool {
+	return viper.GetBool("chat.speculative_llm.enable")
+}
+
+func speculativeStable() time.Duration {
+	stableMs := viper.GetInt("chat.speculative_llm.stable_ms")
+	if stableMs <= 0 {
+		stableMs = defaultSpeculativeStableMs
+	}
+	return time.Duration(stableMs) * time.Millisecond
+}
+
+// onPartial 中间结果变化时丢弃已发起的请求并重新计时, 稳定时长内没有新的变化则调用 start
+func (sp *speculator) onPartial(text string, start func(seq uint64, text string)) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if text == sp.lastText {
+		return
+	}
+	sp.lastText = text
+	sp.seq++
+	sp.stopLocked()
+	sp.dropLocked()
+	seq := sp.seq
+	sp.timer = time.AfterFunc(speculativeStable(), func() {
+		start(seq, text)
+	})
+}
+
+// onFinal 最终结果已到达, 不再发起新的请求, 已发起的留给 take 比对
+func (sp *speculator) onFinal() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.seq++
+	sp.lastText = ""
+	sp.stopLocked()
+}
+
+// set 登记已发起的请求, seq 已过期时返回 false, 由调用方取消
+func (sp *speculator) set(seq uint64, s *speculation) bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if seq != sp.seq {
+		return false
+	}
+	sp.dropLocked()
+	sp.pending = s
+	return true
+}
+
+// take 取出与最终文本一致的请求, 不一致或已被取消的直接取消掉, 没有可用的请求时返回 nil
+func (sp *speculator) take(text string) *speculation {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	s := sp.pending
+	sp.pending = nil
+	if s == nil {
+		return nil
+	}
+	if s.ctx.Err() != nil || !sameUtterance(s.text, text) {
+		log.Debugf("投机请求未命中, 请求文本: %s, 最终文本: %s", s.text, text)
+		s.cancel()
+		metrics.LlmSpeculation(false)
+		return nil
+	}
+	metrics.LlmSpeculation(true)
+	return s
+}
+
+// reset 取消计时和未取用的请求
+func (sp *speculator) reset() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.seq++
+	sp.lastText = ""
+	sp.stopLocked()
+	sp.dropLocked()
+}
+
+func (sp *speculator) stopLocked() {
+	if sp.timer != nil {
+		sp.timer.Stop()
+		sp.timer = nil
+	}
+}
+
+func (sp *speculator) dropLocked() {
+	if sp.pending != nil {
+		sp.pending.cancel()
+		sp.pending = nil
+	}
+}
+
+// sameUtterance 忽略标点、空白和大小写后两段文本是否相同, 中间结果和最终结果常只差标点
+func sameUtterance(a, b string) bool {
+	return normalizeUtterance(a) == normalizeUtterance(b)
+}
+
+func normalizeUtterance(text string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		if unicode.IsPunct(r) || unicode.IsSpace(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
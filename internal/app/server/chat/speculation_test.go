@@ -0,0 +1,227 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameUtterance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "今天天气怎么样", b: "今天天气怎么样", want: true},
+		{a: "今天天气怎么样", b: "今天天气怎么样？", want: true},
+		{a: "今天，天气怎么样", b: "今天天气怎么样。", want: true},
+		{a: "Hello World", b: "hello, world!", want: true},
+		{a: "打开 灯", b: "打开灯", want: true},
+		{a: "你好~", b: "你好", want: true},
+		{a: "", b: "。", want: true},
+		{a: "今天天气", b: "今天天气怎么样", want: false},
+		{a: "打开灯", b: "关闭灯", want: false},
+		{a: "一二三", b: "123", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"|"+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, sameUtterance(tt.a, tt.b))
+		})
+	}
+}
+
+func setSpeculativeStable(t *testing.T, stableMs int) {
+	viper.Set("chat.speculative_llm.stable_ms", stableMs)
+	t.Cleanup(func() { viper.Set("chat.speculative_llm.stable_ms", nil) })
+}
+
+type speculateCall struct {
+	seq  uint64
+	text string
+}
+
+func TestSpeculator_OnPartial(t *testing.T) {
+	setSpeculativeStable(t, 20)
+
+	var mu sync.Mutex
+	var calls []speculateCall
+	start := func(seq uint64, text string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, speculateCall{seq: seq, text: text})
+	}
+	getCalls := func() []speculateCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]speculateCall(nil), calls...)
+	}
+
+	sp := &speculator{}
+	// 稳定时长内中间结果又变化, 只按最后的文本发起一次
+	sp.onPartial("今天", start)
+	sp.onPartial("今天天气", start)
+	assert.Eventually(t, func() bool { return len(getCalls()) == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, []speculateCall{{seq: 2, text: "今天天气"}}, getCalls())
+
+	// 相同的中间结果不重新计时
+	sp.onPartial("今天天气", start)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, getCalls(), 1)
+
+	// 最终结果到达后, 还在计时的请求不再发起
+	sp.onPartial("今天天气怎么样", start)
+	sp.onFinal()
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, getCalls(), 1)
+}
+
+func newTestSpeculation(text string) *speculation {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &speculation{text: text, ctx: ctx, cancel: cancel, responseSentences: make(chan llm_common.LLMResponseStruct)}
+}
+
+func TestSpeculator_Set(t *testing.T) {
+	setSpeculativeStable(t, 1000)
+	noop := func(uint64, string) {}
+
+	tests := []struct {
+		name string
+		// 计时开始后、请求登记前发生的事件
+		after func(sp *speculator)
+		want  bool
+	}{
+		{name: "序号未变", after: func(sp *speculator) {}, want: true},
+		{name: "中间结果已变化", after: func(sp *speculator) { sp.onPartial("今天天气怎么样", noop) }, want: false},
+		{name: "最终结果已到达", after: func(sp *speculator) { sp.onFinal() }, want: false},
+		{name: "会话已重置", after: func(sp *speculator) { sp.reset() }, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := &speculator{}
+			sp.onPartial("今天天气", noop)
+			seq := sp.seq
+			tt.after(sp)
+			defer sp.reset()
+
+			s := newTestSpeculation("今天天气")
+			assert.Equal(t, tt.want, sp.set(seq, s))
+		})
+	}
+
+	// 新的请求替换旧的, 旧的被取消
+	sp := &speculator{}
+	first, second := newTestSpeculation("今天"), newTestSpeculation("今天天气")
+	require.True(t, sp.set(0, first))
+	require.True(t, sp.set(0, second))
+	assert.Error(t, first.ctx.Err())
+	assert.NoError(t, second.ctx.Err())
+}
+
+func TestSpeculator_Take(t *testing.T) {
+	tests := []struct {
+		name     string
+		pending  string
+		canceled bool
+		final    string
+		hit      bool
+	}{
+		{name: "文本一致", pending: "今天天气怎么样", final: "今天天气怎么样", hit: true},
+		{name: "只差标点", pending: "今天天气怎么样", final: "今天天气怎么样？", hit: true},
+		{name: "最终结果更长", pending: "今天天气", final: "今天天气怎么样", hit: false},
+		{name: "最终结果被修正", pending: "今天天气怎么样", final: "明天天气怎么样", hit: false},
+		{name: "请求已被取消", pending: "今天天气怎么样", canceled: true, final: "今天天气怎么样", hit: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := &speculator{}
+			s := newTestSpeculation(tt.pending)
+			require.True(t, sp.set(0, s))
+			if tt.canceled {
+				s.cancel()
+			}
+
+			got := sp.take(tt.final)
+			if tt.hit {
+				assert.Same(t, s, got)
+				assert.NoError(t, s.ctx.Err(), "命中的请求继续使用")
+			} else {
+				assert.Nil(t, got)
+				assert.Error(t, s.ctx.Err(), "未命中的请求被取消")
+			}
+			assert.Nil(t, sp.take(tt.final), "请求只能取用一次")
+		})
+	}
+
+	// 没有发起过请求
+	assert.Nil(t, (&speculator{}).take("今天天气怎么样"))
+
+	// 重置时取消未取用的请求
+	sp := &speculator{}
+	s := newTestSpeculation("今天天气怎么样")
+	require.True(t, sp.set(0, s))
+	sp.reset()
+	assert.Error(t, s.ctx.Err())
+	assert.Nil(t, sp.take("今天天气怎么样"))
+}
+
+// fakeLlm 模拟首句耗时固定的 llm
+func fakeLlm(ctx context.Context, firstSentence time.Duration) chan llm_common.LLMResponseStruct {
+	ch := make(chan llm_common.LLMResponseStruct, 1)
+	go func() {
+		defer close(ch)
+		select {
+		case <-time.After(firstSentence):
+			ch <- llm_common.LLMResponseStruct{Text: "今天晴，", IsStart: true}
+		case <-ctx.Done():
+		}
+	}()
+	return ch
+}
+
+// TestSpeculator_FirstSentenceLatency 按设备一句话的时间线比较最终结果到达后拿到首句回复的耗时:
+// 中间结果在说完时已稳定, 再经过断句等待后才出最终结果; 投机请求在中间结果稳定后就已发出
+func TestSpeculator_FirstSentenceLatency(t *testing.T) {
+	const (
+		stableMs = 20
+		// 中间结果最后一次变化到最终结果到达的间隔, 主要是 vad 判定说完的静音时长
+		finalDelay = 250 * time.Millisecond
+		// llm 首句耗时
+		llmFirstSentence = 400 * time.Millisecond
+		text             = "今天天气怎么样"
+	)
+	setSpeculativeStable(t, stableMs)
+
+	// 不投机: 最终结果到达后才请求 llm
+	time.Sleep(finalDelay)
+	finalAt := time.Now()
+	<-fakeLlm(context.Background(), llmFirstSentence)
+	baseline := time.Since(finalAt)
+
+	// 投机: 中间结果稳定后请求, 最终结果一致时直接取用
+	sp := &speculator{}
+	sp.onPartial(text, func(seq uint64, text string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := &speculation{text: text, ctx: ctx, cancel: cancel, responseSentences: fakeLlm(ctx, llmFirstSentence)}
+		if !sp.set(seq, s) {
+			cancel()
+		}
+	})
+	time.Sleep(finalDelay)
+	sp.onFinal()
+	finalAt = time.Now()
+	s := sp.take(text + "？")
+	require.NotNil(t, s)
+	<-s.responseSentences
+	speculative := time.Since(finalAt)
+
+	t.Logf("首句耗时: 不投机 %v, 投机 %v", baseline, speculative)
+	// 理论上节省 finalDelay - stableMs = 230ms
+	assert.Less(t, speculative, baseline-150*time.Millisecond)
+}
@@ -3,11 +3,20 @@ package chat
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/recorder"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
+	"xiaozhi-esp32-server-golang/internal/domain/emotion"
+	"xiaozhi-esp32-server-golang/internal/domain/language"
 	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
+	"xiaozhi-esp32-server-golang/internal/domain/tracing"
+	"xiaozhi-esp32-server-golang/internal/domain/tts"
 	"xiaozhi-esp32-server-golang/internal/util"
 	log "xiaozhi-esp32-server-golang/logger"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TTSQueueItem struct {
@@ -27,6 +36,35 @@ type TTSManager struct {
 	clientState     *ClientState
 	serverTransport *ServerTransport
 	ttsQueue        *util.Queue[TTSQueueItem]
+
+	// 文本模式: 对端不实时播放音频, 不需要流控; textModeAudio 为 false 时不进行tts合成
+	textMode      bool
+	textModeAudio bool
+
+	recorder *recorder.Recorder
+
+	// 当前回复的情绪, 只在 tts 队列的消费协程中读写
+	currentEmotion string
+
+	// 按语言切换音色的 tts, 值为 nil 表示该语言使用默认音色.
+	// 欢迎语不经过队列, 可能与队列同时合成, 需要加锁
+	voiceProviders map[string]tts.TTSProvider
+	voiceMu        sync.Mutex
+}
+
+// WithTextMode 文本对话模式, withAudio 表示是否仍然合成音频
+func WithTextMode(withAudio bool) TTSManagerOption {
+	return func(t *TTSManager) {
+		t.textMode = true
+		t.textModeAudio = withAudio
+	}
+}
+
+// WithTtsRecorder 记录下发的句子和tts音频
+func WithTtsRecorder(rec *recorder.Recorder) TTSManagerOption {
+	return func(t *TTSManager) {
+		t.recorder = rec
+	}
 }
 
 // NewTTSManager 只接受WithClientState
@@ -35,6 +73,7 @@ func NewTTSManager(clientState *ClientState, serverTransport *ServerTransport, o
 		clientState:     clientState,
 		serverTransport: serverTransport,
 		ttsQueue:        util.NewQueue[TTSQueueItem](10),
+		voiceProviders:  make(map[string]tts.TTSProvider),
 	}
 	for _, opt := range opts {
 		opt(t)
@@ -109,10 +148,32 @@ func (t *TTSManager) handleTts(ctx context.Context, llmResponse llm_common.LLMRe
 	if llmResponse.Text == "" {
 		return nil
 	}
+	if emotion.Enabled() {
+		ctx, llmResponse.Text = t.handleEmotion(ctx, llmResponse)
+		if llmResponse.Text == "" {
+			return nil
+		}
+	}
+
+	if t.textMode && !t.textModeAudio {
+		if err := t.serverTransport.SendSentenceStart(llmResponse.Text); err != nil {
+			return fmt.Errorf("发送 TTS 文本失败: %s, %v", llmResponse.Text, err)
+		}
+		if err := t.serverTransport.SendSentenceEnd(llmResponse.Text); err != nil {
+			return fmt.Errorf("发送 TTS 文本失败: %s, %v", llmResponse.Text, err)
+		}
+		return nil
+	}
+
+	ctx, span := t.clientState.Trace.StartSpan(ctx, tracing.StageTts, attribute.Int("text_length", len([]rune(llmResponse.Text))))
+	// 正常情况下合成结束时就已结束, 这里只兜底出错的情况
+	defer span.End(nil)
 
 	// 使用带上下文的TTS处理
-	outputChan, err := t.clientState.TTSProvider.TextToSpeechStream(ctx, llmResponse.Text, t.clientState.OutputAudioFormat.SampleRate, t.clientState.OutputAudioFormat.Channels, t.clientState.OutputAudioFormat.FrameDuration)
+	outputChan, err := t.ttsProvider(ctx, llmResponse.Text).TextToSpeechStream(ctx, llmResponse.Text, t.clientState.OutputAudioFormat.SampleRate, t.clientState.OutputAudioFormat.Channels, t.clientState.OutputAudioFormat.FrameDuration)
+	metrics.ProviderRequest(metrics.ProviderTts, t.clientState.DeviceConfig.Tts.Provider, err)
 	if err != nil {
+		span.End(err)
 		log.Errorf("生成 TTS 音频失败: %v", err)
 		return fmt.Errorf("生成 TTS 音频失败: %v", err)
 	}
@@ -121,9 +182,11 @@ func (t *TTSManager) handleTts(ctx context.Context, llmResponse llm_common.LLMRe
 		log.Errorf("发送 TTS 文本失败: %s, %v", llmResponse.Text, err)
 		return fmt.Errorf("发送 TTS 文本失败: %s, %v", llmResponse.Text, err)
 	}
+	t.recorder.Event(recorder.EventTtsStart, map[string]interface{}{"text": llmResponse.Text})
 
 	// 发送音频帧
-	if err := t.SendTTSAudio(ctx, outputChan, llmResponse.IsStart); err != nil {
+	if err := t.sendTTSAudio(ctx, outputChan, llmResponse.IsStart, span); err != nil {
+		span.End(err)
 		log.Errorf("发送 TTS 音频失败: %s, %v", llmResponse.Text, err)
 		return fmt.Errorf("发送 TTS 音频失败: %s, %v", llmResponse.Text, err)
 	}
@@ -132,10 +195,85 @@ func (t *TTSManager) handleTts(ctx context.Context, llmResponse llm_common.LLMRe
 		log.Errorf("发送 TTS 文本失败: %s, %v", llmResponse.Text, err)
 		return fmt.Errorf("发送 TTS 文本失败: %s, %v", llmResponse.Text, err)
 	}
+	t.recorder.Event(recorder.EventTtsEnd, map[string]interface{}{"text": llmResponse.Text})
 
 	return nil
 }
 
+// ttsProvider 按本轮回复的语言选择音色, 同一次回复的各句使用同一个音色,
+// 不经过 llm 的句子(欢迎语等)没有回复语言, 按句子本身判断
+func (t *TTSManager) ttsProvider(ctx context.Context, text string) tts.TTSProvider {
+	lang := language.FromContext(ctx)
+	if lang == "" {
+		lang = language.Detect(text)
+	}
+	if lang == "" {
+		return t.clientState.TTSProvider
+	}
+	t.voiceMu.Lock()
+	defer t.voiceMu.Unlock()
+	provider, ok := t.voiceProviders[lang]
+	if !ok {
+		ttsConfig := t.clientState.DeviceConfig.Tts
+		if voiceConfig := tts.VoiceConfig(ttsConfig.Provider, ttsConfig.Config, lang); voiceConfig != nil {
+			var err error
+			if provider, err = tts.GetTTSProvider(ttsConfig.Provider, voiceConfig); err != nil {
+				log.Errorf("创建语言 %s 的 tts 失败, 使用默认音色: %v", lang, err)
+			}
+		}
+		t.voiceProviders[lang] = provider
+	}
+	if provider == nil {
+		return t.clientState.TTSProvider
+	}
+	return provider
+}
+
+// handleEmotion 取出句首的情绪, 变化时先于句子下发给设备, 返回带有情绪的 ctx 和去掉情绪后的文本.
+// 同一次回复中没有标注情绪的句子沿用前面的情绪
+func (t *TTSManager) handleEmotion(ctx context.Context, llmResponse llm_common.LLMResponseStruct) (context.Context, string) {
+	if llmResponse.IsStart {
+		t.currentEmotion = ""
+	}
+	e, text := emotion.Parse(llmResponse.Text)
+	if e != "" && e != t.currentEmotion {
+		t.currentEmotion = e
+		if err := t.serverTransport.SendEmotion(e); err != nil {
+			log.Errorf("发送情绪失败: %s, %v", e, err)
+		}
+	}
+	ctx = emotion.WithContext(ctx, t.currentEmotion)
+	if llmResponse.IsEnd {
+		t.currentEmotion = ""
+	}
+	return ctx, text
+}
+
+// sendTTSAudioNoPacing 文本模式下对端不做实时播放, 合成多少发多少
+func (t *TTSManager) sendTTSAudioNoPacing(ctx context.Context, audioChan chan []byte, span *tracing.Span) error {
+	first := true
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frame, ok := <-audioChan:
+			if !ok {
+				span.End(nil)
+				return nil
+			}
+			if err := t.serverTransport.SendAudio(frame); err != nil {
+				return fmt.Errorf("发送 TTS 音频 len: %d 失败: %v", len(frame), err)
+			}
+			t.recorder.AudioOut(frame)
+			if first {
+				first = false
+				span.Mark(tracing.MarkTtsFirstFrame)
+				span.AudioSent()
+			}
+		}
+	}
+}
+
 // getAlignedDuration 计算当前时间与开始时间的差值，向上对齐到frameDuration
 func getAlignedDuration(startTime time.Time, frameDuration time.Duration) time.Duration {
 	elapsed := time.Since(startTime)
@@ -145,6 +283,15 @@ func getAlignedDuration(startTime time.Time, frameDuration time.Duration) time.D
 }
 
 func (t *TTSManager) SendTTSAudio(ctx context.Context, audioChan chan []byte, isStart bool) error {
+	return t.sendTTSAudio(ctx, audioChan, isStart, nil)
+}
+
+// sendTTSAudio span 为这句话的 tts span, 在首帧下发时打点, 合成结束(通道关闭)时结束
+func (t *TTSManager) sendTTSAudio(ctx context.Context, audioChan chan []byte, isStart bool, span *tracing.Span) error {
+	if t.textMode {
+		return t.sendTTSAudioNoPacing(ctx, audioChan, span)
+	}
+
 	totalFrames := 0 // 跟踪已发送的总帧数
 
 	isStatistic := true
@@ -182,6 +329,7 @@ func (t *TTSManager) SendTTSAudio(ctx context.Context, audioChan chan []byte, is
 			return nil
 		case frame, ok := <-audioChan:
 			if !ok {
+				span.End(nil)
 				// 通道已关闭，所有帧已处理完毕
 				// 为确保终端播放完成：等待已发送帧的总时长与从开始发送以来的实际耗时之间的差值
 				elapsed := time.Since(startTime)
@@ -208,12 +356,18 @@ func (t *TTSManager) SendTTSAudio(ctx context.Context, audioChan chan []byte, is
 				log.Errorf("发送 TTS 音频失败: 第 %d 帧, len: %d, 错误: %v", totalFrames, len(frame), err)
 				return fmt.Errorf("发送 TTS 音频 len: %d 失败: %v", len(frame), err)
 			}
+			t.recorder.AudioOut(frame)
 
 			totalFrames++
 			if totalFrames%100 == 0 {
 				log.Debugf("SendTTSAudio 已发送 %d 帧", totalFrames)
 			}
 
+			if totalFrames == 1 {
+				span.Mark(tracing.MarkTtsFirstFrame)
+				span.AudioSent()
+			}
+
 			// 统计信息记录（仅在开始时记录一次）
 			if isStart && isStatistic && totalFrames == 1 {
 				log.Debugf("从接收音频结束 asr->llm->tts首帧 整体 耗时: %d ms", t.clientState.GetAsrLlmTtsDuration())
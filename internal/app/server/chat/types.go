@@ -1,6 +1,10 @@
 package chat
 
-import "context"
+import (
+	"context"
+
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+)
 
 // ChatSessionOperator 定义 local mcp tool 需要的 ChatSession 操作接口
 // 这个接口用于解耦 LLMManager 和 ChatSession，避免循环依赖
@@ -14,7 +18,15 @@ type ChatSessionOperator interface {
 	// LocalMcpPlayMusic 播放音乐
 	LocalMcpPlayMusic(ctx context.Context, params *PlayMusicParams) error
 
+	// GetDeviceId 当前会话的设备id
+	GetDeviceId() string
+
+	// LocalMcpEnrollSpeaker 用刚才那句话登记说话人的声纹
+	LocalMcpEnrollSpeaker(ctx context.Context, name string) (*speaker.Speaker, error)
+
+	// GetSpeakerOwner 声纹所属的智能体或设备
+	GetSpeakerOwner() string
+
 	// 未来可以根据需要添加其他操作
-	// GetDeviceID() string
 	// IsActive() bool
 }
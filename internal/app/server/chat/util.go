@@ -4,6 +4,8 @@ import (
 	"strings"
 	"unicode"
 
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
+
 	"github.com/spf13/viper"
 )
 
@@ -22,13 +24,15 @@ func removePunctuation(text string) string {
 	return builder.String()
 }
 
-// isWakeupWord 检查文本是否是唤醒词
-func isWakeupWord(text string) bool {
-	wakeupWords := viper.GetStringSlice("wakeup_words")
-	for _, word := range wakeupWords {
-		if text == word {
-			return true
-		}
-	}
-	return false
+// isWakeupWord 检查文本是否是唤醒词, 全局唤醒词与智能体唤醒词合并后按拼音模糊匹配
+func isWakeupWord(text string, agentWords []string) bool {
+	words := append(viper.GetStringSlice("wakeup_words"), agentWords...)
+	_, ok := hotword.NewPhraseMatcher(words, viper.GetInt("phrase_match_distance")).Match(text)
+	return ok
+}
+
+// isStopPhrase 检查文本是否是停止词, 如 "别说了"
+func isStopPhrase(text string) bool {
+	_, ok := hotword.NewPhraseMatcher(viper.GetStringSlice("stop_phrases"), viper.GetInt("phrase_match_distance")).Match(text)
+	return ok
 }
@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// 节点间转发的命令类型
+const (
+	CommandInject = "inject" // 向设备注入消息
+	CommandKick   = "kick"   // 断开设备连接
+	CommandStatus = "status" // 查询设备会话状态
+	CommandRemind = "remind" // 向设备播报到点的提醒
+)
+
+// Command 节点间转发的命令
+type Command struct {
+	Id       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	DeviceId string                 `json:"device_id"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	FromNode string                 `json:"from_node"`
+	ReplyTo  string                 `json:"reply_to,omitempty"`
+}
+
+// Reply 命令处理结果
+type Reply struct {
+	Id     string          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Err 对端处理失败时返回对应的错误
+func (r *Reply) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return errors.New(r.Error)
+}
+
+// DeviceStatus 设备会话状态, 用于 status 命令
+type DeviceStatus struct {
+	DeviceId      string `json:"device_id"`
+	NodeId        string `json:"node_id"`
+	Online        bool   `json:"online"`
+	TransportType string `json:"transport_type,omitempty"`
+	SessionId     string `json:"session_id,omitempty"`
+	Status        string `json:"status,omitempty"`
+	// 进入当前对话状态的时间
+	StatusSince time.Time `json:"status_since,omitempty"`
+	// 连接已断开, 会话处于续连等待中
+	Detached bool `json:"detached"`
+}
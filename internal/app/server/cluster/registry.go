@@ -0,0 +1,424 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	DefaultHeartbeatInterval = 5 * time.Second
+	DefaultForwardTimeout    = 5 * time.Second
+	// 心跳丢失多少个周期后认为节点已下线
+	heartbeatMissLimit = 3
+)
+
+// ErrDeviceNotFound 设备不在任何节点上
+var ErrDeviceNotFound = errors.New("device not found in cluster")
+
+// 仅当 key 仍指向本节点时才删除, 避免误删设备在其他节点上的新注册
+var compareAndDelScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// 仅当 key 仍指向本节点时才续期
+var compareAndExpireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// CommandHandler 处理其他节点转发过来的命令, 返回值会序列化后回给发送方
+type CommandHandler func(ctx context.Context, cmd *Command) (interface{}, error)
+
+// Registry 基于 redis 的设备->节点路由表
+// 每个节点定期上报心跳并为本节点上的设备续期, 通过 redis pub/sub 在节点间转发命令
+type Registry struct {
+	client    *redis.Client
+	keyPrefix string
+	nodeId    string
+
+	heartbeatInterval time.Duration
+	forwardTimeout    time.Duration
+
+	handler CommandHandler
+
+	// 本节点上的设备
+	devices map[string]struct{}
+	mu      sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	pubsub *redis.PubSub
+}
+
+type RegistryOption func(*Registry)
+
+// WithNodeId 节点id, 为空时使用 hostname
+func WithNodeId(nodeId string) RegistryOption {
+	return func(r *Registry) {
+		if nodeId != "" {
+			r.nodeId = nodeId
+		}
+	}
+}
+
+func WithKeyPrefix(keyPrefix string) RegistryOption {
+	return func(r *Registry) {
+		r.keyPrefix = keyPrefix
+	}
+}
+
+func WithHeartbeatInterval(interval time.Duration) RegistryOption {
+	return func(r *Registry) {
+		if interval > 0 {
+			r.heartbeatInterval = interval
+		}
+	}
+}
+
+func WithForwardTimeout(timeout time.Duration) RegistryOption {
+	return func(r *Registry) {
+		if timeout > 0 {
+			r.forwardTimeout = timeout
+		}
+	}
+}
+
+func WithCommandHandler(handler CommandHandler) RegistryOption {
+	return func(r *Registry) {
+		r.handler = handler
+	}
+}
+
+func NewRegistry(client *redis.Client, opts ...RegistryOption) (*Registry, error) {
+	if client == nil {
+		return nil, fmt.Errorf("redis 未初始化")
+	}
+	r := &Registry{
+		client:            client,
+		heartbeatInterval: DefaultHeartbeatInterval,
+		forwardTimeout:    DefaultForwardTimeout,
+		devices:           make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.nodeId == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("获取 hostname 失败: %v", err)
+		}
+		r.nodeId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	return r, nil
+}
+
+func (r *Registry) NodeId() string {
+	return r.nodeId
+}
+
+// Start 上报首次心跳, 订阅本节点的命令通道并启动心跳/清理协程
+func (r *Registry) Start() error {
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	if err := r.heartbeat(r.ctx); err != nil {
+		return fmt.Errorf("上报节点心跳失败: %v", err)
+	}
+
+	r.pubsub = r.client.Subscribe(r.ctx, r.nodeChannel(r.nodeId))
+	if _, err := r.pubsub.Receive(r.ctx); err != nil {
+		r.pubsub.Close()
+		return fmt.Errorf("订阅节点命令通道失败: %v", err)
+	}
+
+	go r.commandLoop(r.pubsub.Channel())
+	go r.heartbeatLoop()
+
+	log.Infof("集群节点 %s 已启动, 心跳间隔 %v", r.nodeId, r.heartbeatInterval)
+	return nil
+}
+
+// Stop 注销本节点及其上的所有设备
+func (r *Registry) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	if r.pubsub != nil {
+		r.pubsub.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.forwardTimeout)
+	defer cancel()
+
+	r.mu.Lock()
+	devices := make([]string, 0, len(r.devices))
+	for deviceId := range r.devices {
+		devices = append(devices, deviceId)
+	}
+	r.devices = make(map[string]struct{})
+	r.mu.Unlock()
+
+	for _, deviceId := range devices {
+		compareAndDelScript.Run(ctx, r.client, []string{r.deviceKey(deviceId)}, r.nodeId)
+	}
+	r.client.Del(ctx, r.nodeKey(r.nodeId), r.nodeDevicesKey(r.nodeId))
+	r.client.SRem(ctx, r.nodesKey(), r.nodeId)
+	log.Infof("集群节点 %s 已注销", r.nodeId)
+}
+
+// Register 将设备注册到本节点, 返回设备之前所在的节点(没有或就是本节点时为空)
+func (r *Registry) Register(ctx context.Context, deviceId string) (string, error) {
+	r.mu.Lock()
+	r.devices[deviceId] = struct{}{}
+	r.mu.Unlock()
+
+	prev, err := r.client.SetArgs(ctx, r.deviceKey(deviceId), r.nodeId, redis.SetArgs{
+		Get: true,
+		TTL: r.entryTTL(),
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return "", fmt.Errorf("注册设备 %s 失败: %v", deviceId, err)
+	}
+	if err := r.client.SAdd(ctx, r.nodeDevicesKey(r.nodeId), deviceId).Err(); err != nil {
+		log.Warnf("记录节点 %s 设备 %s 失败: %v", r.nodeId, deviceId, err)
+	}
+	if prev == r.nodeId {
+		return "", nil
+	}
+	return prev, nil
+}
+
+// Unregister 设备从本节点下线, 若设备已在其他节点重新注册则不影响其路由
+func (r *Registry) Unregister(ctx context.Context, deviceId string) error {
+	r.mu.Lock()
+	delete(r.devices, deviceId)
+	r.mu.Unlock()
+
+	r.client.SRem(ctx, r.nodeDevicesKey(r.nodeId), deviceId)
+	if err := compareAndDelScript.Run(ctx, r.client, []string{r.deviceKey(deviceId)}, r.nodeId).Err(); err != nil {
+		return fmt.Errorf("注销设备 %s 失败: %v", deviceId, err)
+	}
+	return nil
+}
+
+// Lookup 查询设备所在节点
+func (r *Registry) Lookup(ctx context.Context, deviceId string) (string, error) {
+	nodeId, err := r.client.Get(ctx, r.deviceKey(deviceId)).Result()
+	if err == redis.Nil {
+		return "", ErrDeviceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("查询设备 %s 所在节点失败: %v", deviceId, err)
+	}
+	return nodeId, nil
+}
+
+// IsLocal 设备是否在本节点
+func (r *Registry) IsLocal(nodeId string) bool {
+	return nodeId == r.nodeId
+}
+
+// Forward 将命令发送到指定节点并等待其处理结果
+func (r *Registry) Forward(ctx context.Context, nodeId string, cmd *Command) (*Reply, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.forwardTimeout)
+	defer cancel()
+
+	cmd.Id = uuid.New().String()
+	cmd.FromNode = r.nodeId
+	cmd.ReplyTo = r.replyChannel(cmd.Id)
+
+	// 先订阅回复通道再发布命令, 避免回复先于订阅到达
+	replySub := r.client.Subscribe(ctx, cmd.ReplyTo)
+	defer replySub.Close()
+	if _, err := replySub.Receive(ctx); err != nil {
+		return nil, fmt.Errorf("订阅回复通道失败: %v", err)
+	}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	receivers, err := r.client.Publish(ctx, r.nodeChannel(nodeId), data).Result()
+	if err != nil {
+		return nil, fmt.Errorf("转发命令到节点 %s 失败: %v", nodeId, err)
+	}
+	if receivers == 0 {
+		return nil, fmt.Errorf("节点 %s 不在线", nodeId)
+	}
+
+	select {
+	case m, ok := <-replySub.Channel():
+		if !ok {
+			return nil, fmt.Errorf("回复通道已关闭")
+		}
+		var reply Reply
+		if err := json.Unmarshal([]byte(m.Payload), &reply); err != nil {
+			return nil, fmt.Errorf("解析节点 %s 回复失败: %v", nodeId, err)
+		}
+		return &reply, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("等待节点 %s 回复超时", nodeId)
+	}
+}
+
+// Claim 在集群内抢占一次性的处理权, 同一 name 在 ttl 内只有一个调用方返回 true
+func (r *Registry) Claim(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	key := redisdb.GetKeyWithPrefix(r.keyPrefix, "cluster:claim:"+name)
+	return r.client.SetNX(ctx, key, r.nodeId, ttl).Result()
+}
+
+func (r *Registry) commandLoop(ch <-chan *redis.Message) {
+	for m := range ch {
+		var cmd Command
+		if err := json.Unmarshal([]byte(m.Payload), &cmd); err != nil {
+			log.Warnf("解析集群命令失败: %v", err)
+			continue
+		}
+		go r.handleCommand(&cmd)
+	}
+}
+
+func (r *Registry) handleCommand(cmd *Command) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.forwardTimeout)
+	defer cancel()
+
+	log.Debugf("收到节点 %s 转发的命令 %s, 设备 %s", cmd.FromNode, cmd.Type, cmd.DeviceId)
+
+	reply := Reply{Id: cmd.Id}
+	if r.handler == nil {
+		reply.Error = "command handler not set"
+	} else if result, err := r.handler(ctx, cmd); err != nil {
+		reply.Error = err.Error()
+	} else if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			reply.Error = err.Error()
+		} else {
+			reply.Result = data
+		}
+	}
+
+	if cmd.ReplyTo == "" {
+		return
+	}
+	data, _ := json.Marshal(reply)
+	if err := r.client.Publish(ctx, cmd.ReplyTo, data).Err(); err != nil {
+		log.Warnf("回复节点 %s 命令 %s 失败: %v", cmd.FromNode, cmd.Id, err)
+	}
+}
+
+func (r *Registry) heartbeatLoop() {
+	ticker := time.NewTicker(r.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.heartbeat(r.ctx); err != nil {
+				log.Warnf("集群节点 %s 心跳失败: %v", r.nodeId, err)
+				continue
+			}
+			r.cleanupDeadNodes(r.ctx)
+		}
+	}
+}
+
+// heartbeat 续期节点存活 key 和本节点上所有设备的路由
+func (r *Registry) heartbeat(ctx context.Context) error {
+	ttl := r.entryTTL()
+	if err := r.client.Set(ctx, r.nodeKey(r.nodeId), time.Now().Unix(), ttl).Err(); err != nil {
+		return err
+	}
+	if err := r.client.SAdd(ctx, r.nodesKey(), r.nodeId).Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	devices := make([]string, 0, len(r.devices))
+	for deviceId := range r.devices {
+		devices = append(devices, deviceId)
+	}
+	r.mu.Unlock()
+
+	if len(devices) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	for _, deviceId := range devices {
+		compareAndExpireScript.Eval(ctx, pipe, []string{r.deviceKey(deviceId)}, r.nodeId, ttl.Milliseconds())
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// cleanupDeadNodes 清理心跳已过期节点遗留的设备路由, 多个节点同时执行也是幂等的
+func (r *Registry) cleanupDeadNodes(ctx context.Context) {
+	nodes, err := r.client.SMembers(ctx, r.nodesKey()).Result()
+	if err != nil {
+		log.Warnf("获取集群节点列表失败: %v", err)
+		return
+	}
+	for _, nodeId := range nodes {
+		if nodeId == r.nodeId {
+			continue
+		}
+		alive, err := r.client.Exists(ctx, r.nodeKey(nodeId)).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+
+		devices, err := r.client.SMembers(ctx, r.nodeDevicesKey(nodeId)).Result()
+		if err != nil {
+			continue
+		}
+		for _, deviceId := range devices {
+			compareAndDelScript.Run(ctx, r.client, []string{r.deviceKey(deviceId)}, nodeId)
+		}
+		r.client.Del(ctx, r.nodeDevicesKey(nodeId))
+		r.client.SRem(ctx, r.nodesKey(), nodeId)
+		log.Infof("集群节点 %s 心跳超时, 已清理其 %d 个设备路由", nodeId, len(devices))
+	}
+}
+
+func (r *Registry) entryTTL() time.Duration {
+	return r.heartbeatInterval * heartbeatMissLimit
+}
+
+func (r *Registry) deviceKey(deviceId string) string {
+	return redisdb.GetKeyWithPrefix(r.keyPrefix, "cluster:device:"+deviceId)
+}
+
+func (r *Registry) nodeKey(nodeId string) string {
+	return redisdb.GetKeyWithPrefix(r.keyPrefix, "cluster:node:"+nodeId)
+}
+
+func (r *Registry) nodeDevicesKey(nodeId string) string {
+	return redisdb.GetKeyWithPrefix(r.keyPrefix, "cluster:node_devices:"+nodeId)
+}
+
+func (r *Registry) nodesKey() string {
+	return redisdb.GetKeyWithPrefix(r.keyPrefix, "cluster:nodes")
+}
+
+func (r *Registry) nodeChannel(nodeId string) string {
+	return redisdb.GetKeyWithPrefix(r.keyPrefix, "cluster:cmd:"+nodeId)
+}
+
+func (r *Registry) replyChannel(id string) string {
+	return redisdb.GetKeyWithPrefix(r.keyPrefix, "cluster:reply:"+id)
+}
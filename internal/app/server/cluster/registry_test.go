@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRegistry(t *testing.T, mr *miniredis.Miniredis, nodeId string, opts ...RegistryOption) *Registry {
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	r, err := NewRegistry(client, append([]RegistryOption{
+		WithNodeId(nodeId),
+		WithKeyPrefix("test"),
+		WithForwardTimeout(time.Second),
+	}, opts...)...)
+	require.NoError(t, err)
+	return r
+}
+
+func TestRegistry_RegisterLookup(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	nodeA := newTestRegistry(t, mr, "node-a")
+	nodeB := newTestRegistry(t, mr, "node-b")
+
+	_, err := nodeA.Lookup(ctx, "dev-1")
+	assert.ErrorIs(t, err, ErrDeviceNotFound)
+
+	prev, err := nodeA.Register(ctx, "dev-1")
+	require.NoError(t, err)
+	assert.Empty(t, prev)
+	nodeId, err := nodeB.Lookup(ctx, "dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, "node-a", nodeId)
+	assert.True(t, nodeA.IsLocal(nodeId))
+	assert.False(t, nodeB.IsLocal(nodeId))
+
+	// 在本节点重复注册不返回旧节点
+	prev, err = nodeA.Register(ctx, "dev-1")
+	require.NoError(t, err)
+	assert.Empty(t, prev)
+
+	// 设备切换到其他节点, 返回旧节点以便通知其释放会话
+	prev, err = nodeB.Register(ctx, "dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, "node-a", prev)
+
+	// 旧节点随后注销不影响新路由
+	require.NoError(t, nodeA.Unregister(ctx, "dev-1"))
+	nodeId, err = nodeA.Lookup(ctx, "dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, "node-b", nodeId)
+
+	require.NoError(t, nodeB.Unregister(ctx, "dev-1"))
+	_, err = nodeA.Lookup(ctx, "dev-1")
+	assert.ErrorIs(t, err, ErrDeviceNotFound)
+}
+
+func TestRegistry_Heartbeat(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	nodeA := newTestRegistry(t, mr, "node-a", WithHeartbeatInterval(time.Second))
+	nodeB := newTestRegistry(t, mr, "node-b", WithHeartbeatInterval(time.Second))
+
+	_, err := nodeB.Register(ctx, "dev-1")
+	require.NoError(t, err)
+	require.NoError(t, nodeB.heartbeat(ctx))
+	require.NoError(t, nodeA.heartbeat(ctx))
+
+	// 心跳续期设备路由
+	mr.FastForward(2 * time.Second)
+	require.NoError(t, nodeB.heartbeat(ctx))
+	mr.FastForward(2 * time.Second)
+	nodeId, err := nodeA.Lookup(ctx, "dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, "node-b", nodeId)
+
+	// 节点 b 心跳超时后, 其他节点清理其遗留的路由
+	mr.FastForward(2 * time.Second)
+	require.NoError(t, nodeA.heartbeat(ctx))
+	nodeA.cleanupDeadNodes(ctx)
+	_, err = nodeA.Lookup(ctx, "dev-1")
+	assert.ErrorIs(t, err, ErrDeviceNotFound)
+	nodes, err := mr.Members("test:cluster:nodes")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-a"}, nodes)
+}
+
+func TestRegistry_Forward(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	nodeA := newTestRegistry(t, mr, "node-a")
+	nodeB := newTestRegistry(t, mr, "node-b", WithCommandHandler(func(ctx context.Context, cmd *Command) (interface{}, error) {
+		switch cmd.Type {
+		case CommandStatus:
+			return &DeviceStatus{DeviceId: cmd.DeviceId, NodeId: "node-b", Online: true}, nil
+		case CommandKick:
+			return nil, nil
+		default:
+			return nil, errors.New("unknown command")
+		}
+	}))
+	require.NoError(t, nodeA.Start())
+	defer nodeA.Stop()
+	require.NoError(t, nodeB.Start())
+	defer nodeB.Stop()
+
+	tests := []struct {
+		name   string
+		nodeId string
+		cmd    Command
+		check  func(t *testing.T, reply *Reply, err error)
+	}{
+		{
+			name:   "带返回结果",
+			nodeId: "node-b",
+			cmd:    Command{Type: CommandStatus, DeviceId: "dev-1"},
+			check: func(t *testing.T, reply *Reply, err error) {
+				require.NoError(t, err)
+				require.NoError(t, reply.Err())
+				var status DeviceStatus
+				require.NoError(t, json.Unmarshal(reply.Result, &status))
+				assert.Equal(t, "dev-1", status.DeviceId)
+				assert.True(t, status.Online)
+			},
+		},
+		{
+			name:   "无返回结果",
+			nodeId: "node-b",
+			cmd:    Command{Type: CommandKick, DeviceId: "dev-1"},
+			check: func(t *testing.T, reply *Reply, err error) {
+				require.NoError(t, err)
+				assert.NoError(t, reply.Err())
+				assert.Empty(t, reply.Result)
+			},
+		},
+		{
+			name:   "对端处理失败",
+			nodeId: "node-b",
+			cmd:    Command{Type: "unknown", DeviceId: "dev-1"},
+			check: func(t *testing.T, reply *Reply, err error) {
+				require.NoError(t, err)
+				assert.EqualError(t, reply.Err(), "unknown command")
+			},
+		},
+		{
+			name:   "节点未设置处理函数",
+			nodeId: "node-a",
+			cmd:    Command{Type: CommandKick, DeviceId: "dev-1"},
+			check: func(t *testing.T, reply *Reply, err error) {
+				require.NoError(t, err)
+				assert.Error(t, reply.Err())
+			},
+		},
+		{
+			name:   "节点不在线",
+			nodeId: "node-c",
+			cmd:    Command{Type: CommandKick, DeviceId: "dev-1"},
+			check: func(t *testing.T, reply *Reply, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := tt.cmd
+			reply, err := nodeA.Forward(ctx, tt.nodeId, &cmd)
+			tt.check(t, reply, err)
+		})
+	}
+}
+
+func TestRegistry_Stop(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	nodeA := newTestRegistry(t, mr, "node-a")
+	nodeB := newTestRegistry(t, mr, "node-b")
+	require.NoError(t, nodeA.Start())
+
+	_, err := nodeA.Register(ctx, "dev-1")
+	require.NoError(t, err)
+	_, err = nodeA.Register(ctx, "dev-2")
+	require.NoError(t, err)
+	// dev-2 已切换到节点 b, 节点 a 注销时不能删除
+	_, err = nodeB.Register(ctx, "dev-2")
+	require.NoError(t, err)
+
+	nodeA.Stop()
+	_, err = nodeB.Lookup(ctx, "dev-1")
+	assert.ErrorIs(t, err, ErrDeviceNotFound)
+	nodeId, err := nodeB.Lookup(ctx, "dev-2")
+	require.NoError(t, err)
+	assert.Equal(t, "node-b", nodeId)
+	assert.False(t, mr.Exists("test:cluster:node:node-a"))
+}
+
+func TestRegistry_Claim(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	nodeA := newTestRegistry(t, mr, "node-a")
+	nodeB := newTestRegistry(t, mr, "node-b")
+
+	ok, err := nodeA.Claim(ctx, "inject:1", time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = nodeB.Claim(ctx, "inject:1", time.Second)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mr.FastForward(2 * time.Second)
+	ok, err = nodeB.Claim(ctx, "inject:1", time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"time"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
 	"xiaozhi-esp32-server-golang/internal/domain/eventbus"
 	"xiaozhi-esp32-server-golang/internal/domain/memory/llm_memory"
@@ -12,6 +14,8 @@ import (
 )
 
 type EventHandle struct {
+	addMessagePool *workpool.Pool
+	sessionEndPool *workpool.Pool
 }
 
 func NewEventHandle() *EventHandle {
@@ -19,15 +23,50 @@ func NewEventHandle() *EventHandle {
 }
 
 func (s *EventHandle) Start() error {
-	go s.HandleAddMessage()
-	go s.HandleSessionEnd()
+	s.HandleAddMessage()
+	s.HandleSessionEnd()
 	return nil
 }
 
+// Stop 停止接收新任务, 等待队列中的消息写入和记忆刷新完成, 超时后放弃
+func (s *EventHandle) Stop(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		// 先停消息写入, 保证 flush 时消息已落到记忆体中
+		if s.addMessagePool != nil {
+			s.addMessagePool.Stop()
+		}
+		if s.sessionEndPool != nil {
+			s.sessionEndPool.Stop()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("事件处理队列已清空")
+	case <-time.After(timeout):
+		log.Warnf("等待事件处理队列清空超时(%v)", timeout)
+	}
+}
+
+// QueueDepth 各任务队列中等待处理的任务数
+func (s *EventHandle) QueueDepth() map[string]int {
+	depth := make(map[string]int)
+	if s.addMessagePool != nil {
+		depth["add_message"] = s.addMessagePool.QueueLen()
+	}
+	if s.sessionEndPool != nil {
+		depth["session_end"] = s.sessionEndPool.QueueLen()
+	}
+	return depth
+}
+
 func (s *EventHandle) HandleAddMessage() {
 	type AddMessageJob struct {
 		clientState *ClientState
 		Msg         schema.Message
+		// 发布时的记忆体 id, 异步执行时说话人可能已经换了
+		MemoryKey string
 	}
 	f := func(job workpool.Job) error {
 		addMessageJob, ok := job.(AddMessageJob)
@@ -35,11 +74,13 @@ func (s *EventHandle) HandleAddMessage() {
 			return fmt.Errorf("invalid job info")
 		}
 		clientState := addMessageJob.clientState
+		// 任务异步执行, 会话可能已经结束, 不能沿用会话的取消信号
+		ctx := context.WithoutCancel(clientState.Ctx)
 		//添加到 消息列表中
-		llm_memory.Get().AddMessage(clientState.Ctx, clientState.DeviceID, clientState.AgentID, addMessageJob.Msg)
+		llm_memory.Get().AddMessage(ctx, clientState.DeviceID, clientState.AgentID, addMessageJob.Msg)
 		//将消息加到 长期记忆体中
 		if clientState.MemoryProvider != nil {
-			err := clientState.MemoryProvider.AddMessage(clientState.Ctx, clientState.GetDeviceIDOrAgentID(), addMessageJob.Msg)
+			err := clientState.MemoryProvider.AddMessage(ctx, addMessageJob.MemoryKey, addMessageJob.Msg)
 			if err != nil {
 				return fmt.Errorf("add message to memory provider failed: %w", err)
 			}
@@ -47,10 +88,12 @@ func (s *EventHandle) HandleAddMessage() {
 		return nil
 	}
 	workPool := workpool.NewWorkPool(10, 1000, workpool.JobHandler(f))
-	eventbus.Get().Subscribe(eventbus.TopicAddMessage, func(clientState *ClientState, msg schema.Message) {
+	s.addMessagePool = workPool
+	eventbus.Get().Subscribe(eventbus.TopicAddMessage, func(clientState *ClientState, msg schema.Message, memoryKey string) {
 		workPool.Submit(AddMessageJob{
 			clientState: clientState,
 			Msg:         msg,
+			MemoryKey:   memoryKey,
 		})
 	})
 }
@@ -62,16 +105,19 @@ func (s *EventHandle) HandleSessionEnd() error {
 			return fmt.Errorf("invalid job info")
 		}
 
-		//将消息加到 长期记忆体中
+		//将消息加到 长期记忆体中, 识别出说话人时每位说话人的记忆分别刷新
 		if clientState.MemoryProvider != nil {
-			err := clientState.MemoryProvider.Flush(clientState.Ctx, clientState.GetDeviceIDOrAgentID())
-			if err != nil {
-				return fmt.Errorf("add message to memory provider failed: %w", err)
+			for _, memoryKey := range clientState.MemoryKeys() {
+				err := clientState.MemoryProvider.Flush(context.WithoutCancel(clientState.Ctx), memoryKey)
+				if err != nil {
+					return fmt.Errorf("add message to memory provider failed: %w", err)
+				}
 			}
 		}
 		return nil
 	}
 	workPool := workpool.NewWorkPool(10, 1000, workpool.JobHandler(f))
+	s.sessionEndPool = workPool
 	eventbus.Get().Subscribe(eventbus.TopicSessionEnd, func(clientState *ClientState) {
 		if clientState == nil {
 			log.Warnf("HandleSessionEnd: clientState is nil, skipping")
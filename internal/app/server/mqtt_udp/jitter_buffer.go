@@ -0,0 +1,132 @@
+package mqtt_udp
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// 默认缓存深度(包数), 60ms 帧长下约 180ms
+	DefaultJitterBufferDepth = 3
+	// 序列号跳变超过该值时认为对端重置了序列号, 不再做丢包补偿
+	jitterMaxGap = 50
+)
+
+// JitterStats 抖动缓冲统计
+type JitterStats struct {
+	Received  uint64 `json:"received"`  // 收到的包数
+	Lost      uint64 `json:"lost"`      // 判定丢失并交给解码端补偿的包数
+	Late      uint64 `json:"late"`      // 已过输出点才到达而被丢弃的包数
+	Duplicate uint64 `json:"duplicate"` // 重复包数
+	Reordered uint64 `json:"reordered"` // 乱序到达但被重新排好序的包数
+	Resync    uint64 `json:"resync"`    // 序列号重置次数
+}
+
+// JitterBuffer 按序列号对上行音频包重新排序
+// 输出的帧中空切片表示丢失的帧, 由解码端做 PLC/FEC 补偿
+type JitterBuffer struct {
+	depth   int
+	started bool
+	nextSeq uint32
+	packets map[uint32][]byte
+	stats   JitterStats
+	mu      sync.Mutex
+}
+
+func NewJitterBuffer(depth int) *JitterBuffer {
+	if depth <= 0 {
+		depth = DefaultJitterBufferDepth
+	}
+	return &JitterBuffer{
+		depth:   depth,
+		packets: make(map[uint32][]byte),
+	}
+}
+
+// Push 放入一个包, 返回当前可以按序输出的帧
+func (j *JitterBuffer) Push(seq uint32, payload []byte) [][]byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.stats.Received++
+	if !j.started {
+		j.started = true
+		j.nextSeq = seq
+	}
+
+	// 使用有符号差值处理序列号回绕
+	diff := int32(seq - j.nextSeq)
+	if diff > jitterMaxGap || diff < -jitterMaxGap {
+		out := j.drainWithoutConceal()
+		j.stats.Resync++
+		j.nextSeq = seq
+		j.packets[seq] = payload
+		return append(out, j.pop(false)...)
+	}
+	if diff < 0 {
+		j.stats.Late++
+		return nil
+	}
+	if _, ok := j.packets[seq]; ok {
+		j.stats.Duplicate++
+		return nil
+	}
+	if diff == 0 && len(j.packets) > 0 {
+		j.stats.Reordered++
+	}
+	j.packets[seq] = payload
+	return j.pop(false)
+}
+
+// Flush 音频流停顿时输出缓存中剩余的帧, 中间的空洞按丢包处理
+func (j *JitterBuffer) Flush() [][]byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pop(true)
+}
+
+func (j *JitterBuffer) Stats() JitterStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stats
+}
+
+func (j *JitterBuffer) pop(force bool) [][]byte {
+	var out [][]byte
+	for len(j.packets) > 0 {
+		if payload, ok := j.packets[j.nextSeq]; ok {
+			out = append(out, payload)
+			delete(j.packets, j.nextSeq)
+			j.nextSeq++
+			continue
+		}
+		// 期望的包还没到, 缓存未满时继续等待
+		if !force && len(j.packets) < j.depth {
+			break
+		}
+		out = append(out, []byte{})
+		j.stats.Lost++
+		j.nextSeq++
+	}
+	return out
+}
+
+// drainWithoutConceal 序列号重置时按序输出剩余的包, 不再补偿空洞
+func (j *JitterBuffer) drainWithoutConceal() [][]byte {
+	if len(j.packets) == 0 {
+		return nil
+	}
+	seqs := make([]uint32, 0, len(j.packets))
+	for seq := range j.packets {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(a, b int) bool {
+		return int32(seqs[a]-j.nextSeq) < int32(seqs[b]-j.nextSeq)
+	})
+	out := make([][]byte, 0, len(seqs))
+	for _, seq := range seqs {
+		out = append(out, j.packets[seq])
+		delete(j.packets, seq)
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package mqtt_udp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func frame(b byte) []byte {
+	return []byte{b}
+}
+
+func TestJitterBuffer_InOrder(t *testing.T) {
+	jb := NewJitterBuffer(3)
+
+	for i := uint32(0); i < 5; i++ {
+		out := jb.Push(100+i, frame(byte(i)))
+		assert.Equal(t, [][]byte{frame(byte(i))}, out)
+	}
+	stats := jb.Stats()
+	assert.Equal(t, uint64(5), stats.Received)
+	assert.Equal(t, uint64(0), stats.Lost)
+}
+
+func TestJitterBuffer_Reorder(t *testing.T) {
+	jb := NewJitterBuffer(3)
+
+	assert.Len(t, jb.Push(1, frame(1)), 1)
+	// 3 先于 2 到达, 需要等待 2
+	assert.Empty(t, jb.Push(3, frame(3)))
+	assert.Equal(t, [][]byte{frame(2), frame(3)}, jb.Push(2, frame(2)))
+
+	stats := jb.Stats()
+	assert.Equal(t, uint64(1), stats.Reordered)
+	assert.Equal(t, uint64(0), stats.Lost)
+}
+
+func TestJitterBuffer_LossAndLate(t *testing.T) {
+	jb := NewJitterBuffer(2)
+
+	assert.Len(t, jb.Push(1, frame(1)), 1)
+	assert.Empty(t, jb.Push(3, frame(3)))
+	// 缓存中的重复包
+	assert.Empty(t, jb.Push(3, frame(3)))
+	// 缓存达到深度, 2 判定为丢失, 以空帧输出
+	assert.Equal(t, [][]byte{{}, frame(3), frame(4)}, jb.Push(4, frame(4)))
+	// 2 迟到, 丢弃
+	assert.Empty(t, jb.Push(2, frame(2)))
+
+	stats := jb.Stats()
+	assert.Equal(t, uint64(1), stats.Lost)
+	assert.Equal(t, uint64(1), stats.Late)
+	assert.Equal(t, uint64(1), stats.Duplicate)
+}
+
+func TestJitterBuffer_Flush(t *testing.T) {
+	jb := NewJitterBuffer(5)
+
+	assert.Len(t, jb.Push(10, frame(10)), 1)
+	assert.Empty(t, jb.Push(12, frame(12)))
+	assert.Equal(t, [][]byte{{}, frame(12)}, jb.Flush())
+	assert.Empty(t, jb.Flush())
+}
+
+func TestJitterBuffer_SeqWrapAndResync(t *testing.T) {
+	jb := NewJitterBuffer(3)
+
+	assert.Len(t, jb.Push(0xFFFFFFFF, frame(1)), 1)
+	assert.Equal(t, [][]byte{frame(2)}, jb.Push(0, frame(2)))
+
+	// 对端重启, 序列号大幅跳变, 不做补偿直接从新序列号开始
+	assert.Equal(t, [][]byte{frame(3)}, jb.Push(5000, frame(3)))
+	assert.Equal(t, [][]byte{frame(4)}, jb.Push(5001, frame(4)))
+
+	stats := jb.Stats()
+	assert.Equal(t, uint64(1), stats.Resync)
+	assert.Equal(t, uint64(0), stats.Lost)
+}
@@ -12,6 +12,7 @@ import (
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
 	"xiaozhi-esp32-server-golang/internal/data/client"
 	. "xiaozhi-esp32-server-golang/internal/data/client"
+	. "xiaozhi-esp32-server-golang/internal/data/msg"
 	. "xiaozhi-esp32-server-golang/logger"
 	log "xiaozhi-esp32-server-golang/logger"
 )
@@ -72,7 +73,7 @@ func (s *MqttUdpAdapter) Start() error {
 	const retryInterval = 5 * time.Second
 
 	Info("MqttUdpAdapter开始启动，尝试连接MQTT服务器...")
-	Info("MQTT配置: Broker=%s:%d, ClientID=%s, Username=%s", s.mqttConfig.Broker, s.mqttConfig.Port, s.mqttConfig.ClientID, s.mqttConfig.Username)
+	Infof("MQTT配置: Broker=%s:%d, ClientID=%s, Username=%s", s.mqttConfig.Broker, s.mqttConfig.Port, s.mqttConfig.ClientID, s.mqttConfig.Username)
 
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(fmt.Sprintf("%s://%s:%d", s.mqttConfig.Type, s.mqttConfig.Broker, s.mqttConfig.Port))
@@ -122,6 +123,17 @@ func (s *MqttUdpAdapter) Start() error {
 	return nil
 }
 
+// Stop 断开与MQTT服务器的连接并关闭UDP监听
+func (s *MqttUdpAdapter) Stop() {
+	if s.client != nil && s.client.IsConnected() {
+		s.client.Disconnect(250)
+	}
+	if s.udpServer != nil {
+		s.udpServer.Stop()
+	}
+	Info("MqttUdpAdapter已停止")
+}
+
 func (s *MqttUdpAdapter) checkClientActive() error {
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -216,10 +228,19 @@ func (s *MqttUdpAdapter) processMessage() {
 				s.SetDeviceSession(deviceId, deviceSession)
 
 				deviceSession.OnClose(s.handleDisconnect)
+				deviceSession.StartKeyRotation(s.udpServer.KeyRotationInterval())
 
 				s.onNewConnection(deviceSession)
 			}
 
+			// 密钥轮换确认只在 udp 层处理, 不交给会话
+			if clientMsg.Type == MessageTypeUdpKey {
+				if deviceSession.UdpSession.CommitKeyRotation() {
+					Debugf("设备 %s 确认切换udp密钥", deviceId)
+				}
+				continue
+			}
+
 			err := deviceSession.PushMsgToRecvCmd(msg.Payload())
 			if err != nil {
 				Errorf("InternalRecvCmd失败: %v", err)
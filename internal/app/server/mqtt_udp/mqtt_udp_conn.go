@@ -2,10 +2,12 @@ package mqtt_udp
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"sync"
 	"time"
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
 
 	log "xiaozhi-esp32-server-golang/logger"
 
@@ -61,6 +63,53 @@ func NewMqttUdpConn(deviceID string, pubTopic string, mqttClient mqtt.Client, ud
 	}
 }
 
+// StartKeyRotation 定期通过 mqtt 下发新的 udp 密钥
+func (c *MqttUdpConn) StartKeyRotation(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.rotateKey(); err != nil {
+					log.Warnf("设备 %s 下发新udp密钥失败: %v", c.DeviceId, err)
+				}
+			}
+		}
+	}()
+}
+
+func (c *MqttUdpConn) rotateKey() error {
+	// 设备还没有建立udp通道
+	if c.UdpSession.RemoteAddr == nil {
+		return nil
+	}
+	aesKey, fullNonce, err := c.UdpSession.RotateKey()
+	if err != nil {
+		return err
+	}
+	serverMsg := msg.ServerMessage{
+		Type: msg.ServerMessageTypeUdpKey,
+		Udp: &msg.UdpConfig{
+			Server: c.udpServer.externalHost,
+			Port:   c.udpServer.externalPort,
+			Key:    aesKey,
+			Nonce:  fullNonce,
+		},
+	}
+	data, err := json.Marshal(serverMsg)
+	if err != nil {
+		return err
+	}
+	log.Debugf("设备 %s 下发新udp密钥", c.DeviceId)
+	return c.SendCmd(data)
+}
+
 // SendCmd 通过 MQTT-UDP 发送命令（需对接实际发送逻辑）
 func (c *MqttUdpConn) SendCmd(msg []byte) error {
 	log.Debugf("MQTT发送消息, topic: %s, 消息长度: %d", c.PubTopic, len(msg))
@@ -169,6 +218,15 @@ func (c *MqttUdpConn) SetData(key string, value interface{}) {
 }
 
 func (c *MqttUdpConn) GetData(key string) (interface{}, error) {
+	// 密钥会轮换, 以 udp 会话中当前生效的为准
+	switch key {
+	case "aes_key":
+		aesKey, _ := c.UdpSession.GetAesKeyAndNonce()
+		return aesKey, nil
+	case "full_nonce":
+		_, fullNonce := c.UdpSession.GetAesKeyAndNonce()
+		return fullNonce, nil
+	}
 	value, ok := c.data.Load(key)
 	if !ok {
 		return nil, errors.New("key not found")
@@ -0,0 +1,130 @@
+package mqtt_udp
+
+const (
+	// DefaultReplayWindowSize 默认防重放窗口大小(包数)
+	DefaultReplayWindowSize = 64
+
+	// DefaultMaxForwardJump 单个包最多让窗口前移的包数, 约 6 秒的 60ms 帧.
+	// 序列号在明文包头中, 一个伪造的大序列号会让之后所有真实的包都被当作重放, 所以超过该跨度的包不直接前移窗口
+	DefaultMaxForwardJump = 100
+	// resyncPackets 连续收到这么多个超出跨度但彼此相邻递增的包时, 认为设备确实长时间丢包, 以这些包重新同步窗口
+	resyncPackets = 3
+)
+
+// ReplayWindow 基于序列号的滑动防重放窗口
+// 记录最大序列号之前 size 个包是否已收到, 重复的包和落在窗口之外的旧包都会被拒绝
+type ReplayWindow struct {
+	size    uint32
+	maxJump uint32
+	started bool
+	top     uint32   // 已收到的最大序列号
+	bitmap  []uint64 // 第 i 位表示 top-i 是否已收到
+
+	// 最近一个超出前跳上限的序列号, 以及连续相邻递增的此类包数
+	suspect      uint32
+	suspectCount int
+}
+
+func NewReplayWindow(size int) *ReplayWindow {
+	if size <= 0 {
+		size = DefaultReplayWindowSize
+	}
+	words := (size + 63) / 64
+	return &ReplayWindow{
+		size:    uint32(words * 64),
+		maxJump: DefaultMaxForwardJump,
+		bitmap:  make([]uint64, words),
+	}
+}
+
+// Check 判断序列号是否可以接受, 不修改窗口状态, 超出前跳上限的包也不接受, 由调用方决定是否 Resync
+func (w *ReplayWindow) Check(seq uint32) bool {
+	if !w.started {
+		return true
+	}
+	// 使用有符号差值处理序列号回绕
+	diff := int32(seq - w.top)
+	if diff > 0 {
+		return uint32(diff) <= w.maxJump
+	}
+	offset := uint32(-diff)
+	if offset >= w.size {
+		return false
+	}
+	return !w.test(offset)
+}
+
+// Accept 记录序列号, 返回 false 表示是重放或跨度异常的包
+func (w *ReplayWindow) Accept(seq uint32) bool {
+	if !w.Check(seq) {
+		return false
+	}
+	w.suspectCount = 0
+	if !w.started {
+		w.started = true
+		w.top = seq
+		w.set(0)
+		return true
+	}
+
+	diff := int32(seq - w.top)
+	if diff > 0 {
+		w.shift(uint32(diff))
+		w.top = seq
+		w.set(0)
+		return true
+	}
+	w.set(uint32(-diff))
+	return true
+}
+
+// Resync 记录一个超出前跳上限的包, 连续 resyncPackets 个相邻递增的此类包后以它重新同步窗口并接受该包
+// 单个伪造的包不会生效, 设备长时间丢包后恢复发送时, 连续几个包即可让窗口跟上
+func (w *ReplayWindow) Resync(seq uint32) bool {
+	if diff := int32(seq - w.top); !w.started || diff <= 0 || uint32(diff) <= w.maxJump {
+		return false
+	}
+	if diff := int32(seq - w.suspect); w.suspectCount > 0 && diff > 0 && uint32(diff) <= resyncPackets {
+		w.suspectCount++
+	} else {
+		w.suspectCount = 1
+	}
+	w.suspect = seq
+	if w.suspectCount < resyncPackets {
+		return false
+	}
+	w.suspectCount = 0
+	w.shift(w.size)
+	w.top = seq
+	w.set(0)
+	return true
+}
+
+// shift 窗口整体前移 n 位
+func (w *ReplayWindow) shift(n uint32) {
+	if n >= w.size {
+		for i := range w.bitmap {
+			w.bitmap[i] = 0
+		}
+		return
+	}
+	words, bits := int(n/64), n%64
+	for i := len(w.bitmap) - 1; i >= 0; i-- {
+		var v uint64
+		if src := i - words; src >= 0 {
+			v = w.bitmap[src] << bits
+			if bits > 0 && src-1 >= 0 {
+				v |= w.bitmap[src-1] >> (64 - bits)
+			}
+		}
+		w.bitmap[i] = v
+	}
+}
+
+func (w *ReplayWindow) test(offset uint32) bool {
+	return w.bitmap[offset/64]&(1<<(offset%64)) != 0
+}
+
+func (w *ReplayWindow) set(offset uint32) {
+	w.bitmap[offset/64] |= 1 << (offset % 64)
+}
@@ -0,0 +1,92 @@
+package mqtt_udp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayWindow_Duplicate(t *testing.T) {
+	w := NewReplayWindow(64)
+	for seq := uint32(1); seq <= 10; seq++ {
+		assert.True(t, w.Accept(seq))
+	}
+	assert.False(t, w.Accept(10))
+	assert.False(t, w.Accept(5))
+}
+
+func TestReplayWindow_Reorder(t *testing.T) {
+	w := NewReplayWindow(64)
+	assert.True(t, w.Accept(100))
+	assert.True(t, w.Accept(103))
+	// 窗口内乱序到达的包可以接受, 但只接受一次
+	assert.True(t, w.Accept(101))
+	assert.True(t, w.Accept(102))
+	assert.False(t, w.Accept(101))
+	assert.True(t, w.Accept(104))
+}
+
+func TestReplayWindow_OutOfWindow(t *testing.T) {
+	w := NewReplayWindow(64)
+	assert.True(t, w.Accept(1000))
+	assert.True(t, w.Check(1000-63))
+	assert.False(t, w.Check(1000-64))
+	assert.False(t, w.Accept(10))
+
+	// 超过窗口大小的前移后旧记录全部清空
+	assert.True(t, w.Accept(1080))
+	assert.False(t, w.Accept(1000))
+	assert.True(t, w.Accept(1079))
+}
+
+func TestReplayWindow_ForwardJump(t *testing.T) {
+	w := NewReplayWindow(64)
+	assert.True(t, w.Accept(100))
+
+	// 伪造的大序列号不移动窗口, 之后真实的包照常接受
+	assert.False(t, w.Accept(100+DefaultMaxForwardJump+1))
+	assert.False(t, w.Resync(100+DefaultMaxForwardJump+1))
+	assert.True(t, w.Accept(101))
+	assert.True(t, w.Accept(101+DefaultMaxForwardJump))
+
+	// 窗口内的旧包和重复包不参与重新同步
+	assert.False(t, w.Resync(150))
+	assert.False(t, w.Resync(101))
+
+	// 设备长时间丢包后恢复, 连续几个相邻递增的包重新同步窗口
+	top := uint32(5000)
+	assert.False(t, w.Resync(top))
+	assert.False(t, w.Resync(top+1))
+	assert.True(t, w.Resync(top+3))
+	assert.False(t, w.Accept(top+3))
+	assert.True(t, w.Accept(top+2))
+	assert.True(t, w.Accept(top+4))
+	assert.False(t, w.Accept(101+DefaultMaxForwardJump))
+
+	// 中间收到正常的包时重新计数
+	assert.False(t, w.Resync(top+1000))
+	assert.False(t, w.Resync(top+1001))
+	assert.True(t, w.Accept(top+5))
+	assert.False(t, w.Resync(top+1002))
+}
+
+func TestReplayWindow_MultiWord(t *testing.T) {
+	w := NewReplayWindow(200)
+	assert.True(t, w.Accept(10))
+	assert.True(t, w.Accept(80))
+	assert.True(t, w.Accept(150))
+	// 跨越多个 uint64 的移位后仍能识别已收到的包
+	assert.False(t, w.Accept(10))
+	assert.False(t, w.Accept(80))
+	assert.True(t, w.Accept(11))
+}
+
+func TestReplayWindow_Wraparound(t *testing.T) {
+	w := NewReplayWindow(64)
+	assert.True(t, w.Accept(0xFFFFFFFE))
+	assert.True(t, w.Accept(0xFFFFFFFF))
+	assert.True(t, w.Accept(0))
+	assert.True(t, w.Accept(1))
+	assert.False(t, w.Accept(0xFFFFFFFF))
+	assert.False(t, w.Accept(0))
+}
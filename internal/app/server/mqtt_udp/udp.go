@@ -1,20 +1,45 @@
 package mqtt_udp
 
 import (
+	"bytes"
+	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	. "xiaozhi-esp32-server-golang/logger"
 )
 
 const (
 	UdpSessionStatusActive = "active"
 	UdpSessionStatusClosed = "closed"
+
+	// 上行音频停顿超过该时长时, 输出抖动缓冲中剩余的包
+	jitterFlushDelay = 200 * time.Millisecond
+
+	// 默认的密钥轮换重叠时间, 切换后旧密钥在该时间内仍可解密上行
+	DefaultKeyOverlap = 10 * time.Second
 )
 
+// ErrReplayPacket 重复、过旧或序列号跨度异常的包
+var ErrReplayPacket = errors.New("replayed packet")
+
+// udpKey 一组 aes key/nonce 及其防重放窗口, 密钥轮换期间新旧密钥同时有效
+type udpKey struct {
+	aesKey   [16]byte
+	nonce    [8]byte
+	block    cipher.Block
+	replay   *ReplayWindow
+	expireAt time.Time
+}
+
 // Session 表示一个UDP会话
 type UdpSession struct {
 	ID          string
@@ -30,10 +55,32 @@ type UdpSession struct {
 	LocalSeq    uint32
 	Block       cipher.Block
 	RemoteSeq   uint32
-	RecvChannel chan []byte //发送的音频数据
+	RecvChannel chan []byte //发送的音频数据, 空切片表示丢失的帧
 	SendChannel chan []byte //接收的音频数据
 	Status      string
 	Lock        sync.Mutex
+
+	jitterBuffer *JitterBuffer
+	flushTimer   *time.Timer
+
+	// 当前密钥(AesKey/Nonce/Block)的防重放窗口
+	replayWindow     *ReplayWindow
+	replayWindowSize int
+	// 已下发给设备但设备还未确认的新密钥
+	pendingKey *udpKey
+	// 切换前的旧密钥, keyOverlap 内仍接受其加密的上行包
+	prevKey       *udpKey
+	keyOverlap    time.Duration
+	replayDropped uint64
+	// 上一个上行包的 opus TOC 字节, 轮换期间用于判断包是哪个密钥加密的
+	lastToc byte
+	hasToc  bool
+	keyLock sync.Mutex
+
+	// 上行音频帧率限制, 为 nil 时不限制
+	audioLimiter *ratelimit.AudioLimiter
+	// 释放按 IP 占用的会话名额
+	releaseLimit func()
 }
 
 // decrypt 解密数据
@@ -45,22 +92,126 @@ func (s *UdpSession) Decrypt(data []byte) ([]byte, error) {
 	// 提取序列号
 	seqNum := binary.BigEndian.Uint32(data[12:16])
 
-	// 检查序列号
-	/*if seqNum < s.RemoteSeq {
-		return nil, fmt.Errorf("序列号过期: got %d, expected >= %d", seqNum, s.RemoteSeq)
-	}*/
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+
+	// nonce 中只有连接id是服务端下发的, 其后4字节设备会写入自己的时间戳
+	if !bytes.Equal(data[4:8], s.Nonce[:4]) {
+		return nil, fmt.Errorf("未知的连接id: %s", hex.EncodeToString(data[4:8]))
+	}
+
+	decrypted, replay := s.selectKey(nonce, ciphertext)
+	// 序列号跨度过大的包只有解密出的 TOC 与之前一致时才参与重新同步, 伪造的包几乎不可能连续通过
+	if !replay.Accept(seqNum) && (!s.matchToc(decrypted) || !replay.Resync(seqNum)) {
+		s.replayDropped++
+		return nil, ErrReplayPacket
+	}
 	s.RemoteSeq = seqNum
+	if len(decrypted) > 0 {
+		s.lastToc, s.hasToc = decrypted[0], true
+	}
+
+	return decrypted, nil
+}
 
-	// 解密数据
-	stream := cipher.NewCTR(s.Block, nonce)
+// selectKey 选择解密用的密钥, 返回解密后的数据和该密钥的防重放窗口
+// 包中不带密钥标识, 轮换期间依次用当前、待切换、旧密钥解密, 设备的编码参数固定,
+// 解密出的 opus TOC 字节与上一包相同即认为密钥正确, 都不相同时按当前密钥处理.
+// AES-CTR 没有校验, 约 1/256 的旧密钥包也会碰巧匹配, 所以这里只决定这一个包如何解密,
+// 不会切换密钥, 切换只在设备通过 udp_key 消息确认后进行(CommitKeyRotation)
+func (s *UdpSession) selectKey(nonce []byte, ciphertext []byte) ([]byte, *ReplayWindow) {
+	if s.prevKey != nil && time.Now().After(s.prevKey.expireAt) {
+		s.prevKey = nil
+	}
+
+	current := decryptWith(s.Block, nonce, ciphertext)
+	if (s.pendingKey == nil && s.prevKey == nil) || !s.hasToc || s.matchToc(current) {
+		return current, s.replayWindow
+	}
+	// 设备收到新密钥后立即切换, 确认消息经 mqtt 到达前的上行包已是新密钥加密
+	if s.pendingKey != nil {
+		if decrypted := decryptWith(s.pendingKey.block, nonce, ciphertext); s.matchToc(decrypted) {
+			return decrypted, s.pendingKey.replay
+		}
+	}
+	if s.prevKey != nil {
+		if decrypted := decryptWith(s.prevKey.block, nonce, ciphertext); s.matchToc(decrypted) {
+			return decrypted, s.prevKey.replay
+		}
+	}
+	return current, s.replayWindow
+}
+
+func (s *UdpSession) matchToc(decrypted []byte) bool {
+	return len(decrypted) > 0 && decrypted[0] == s.lastToc
+}
+
+func decryptWith(block cipher.Block, nonce []byte, ciphertext []byte) []byte {
 	decrypted := make([]byte, len(ciphertext))
-	stream.XORKeyStream(decrypted, ciphertext)
+	cipher.NewCTR(block, nonce).XORKeyStream(decrypted, ciphertext)
+	return decrypted
+}
 
-	return decrypted, nil
+// RotateKey 生成新的密钥并返回下发给设备的 key/nonce
+// 在设备通过 udp_key 消息确认之前, 下行仍使用旧密钥加密
+func (s *UdpSession) RotateKey() (string, string, error) {
+	key := &udpKey{replay: NewReplayWindow(s.replayWindowSize)}
+	if _, err := rand.Read(key.aesKey[:]); err != nil {
+		return "", "", err
+	}
+	// 只换 key, 连接id保持不变, 后4字节与创建会话时一样填时间戳, 设备发包时会覆盖
+	copy(key.nonce[:4], s.Nonce[:4])
+	binary.BigEndian.PutUint32(key.nonce[4:], uint32(time.Now().Unix()))
+	block, err := aes.NewCipher(key.aesKey[:])
+	if err != nil {
+		return "", "", fmt.Errorf("创建AES块失败: %v", err)
+	}
+	key.block = block
+
+	s.keyLock.Lock()
+	s.pendingKey = key
+	s.keyLock.Unlock()
+
+	strAesKey, strFullNonce := formatKeyAndNonce(key.aesKey, key.nonce)
+	return strAesKey, strFullNonce, nil
+}
+
+// CommitKeyRotation 设备确认已切换到新密钥, 返回是否有待切换的密钥
+func (s *UdpSession) CommitKeyRotation() bool {
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+	if s.pendingKey == nil {
+		return false
+	}
+	s.commitKey()
+	return true
+}
+
+func (s *UdpSession) commitKey() {
+	overlap := s.keyOverlap
+	if overlap <= 0 {
+		overlap = DefaultKeyOverlap
+	}
+	s.prevKey = &udpKey{
+		aesKey:   s.AesKey,
+		nonce:    s.Nonce,
+		block:    s.Block,
+		replay:   s.replayWindow,
+		expireAt: time.Now().Add(overlap),
+	}
+	s.AesKey = s.pendingKey.aesKey
+	s.Nonce = s.pendingKey.nonce
+	s.Block = s.pendingKey.block
+	s.replayWindow = s.pendingKey.replay
+	s.pendingKey = nil
+	Infof("设备 %s udp 密钥已切换, 旧密钥 %v 后失效", s.DeviceId, overlap)
 }
 
 // encrypt 加密数据
 func (s *UdpSession) Encrypt(data []byte) ([]byte, error) {
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+
 	// 预分配内存，避免扩容
 	encrypted := make([]byte, 16+len(data))
 
@@ -79,31 +230,80 @@ func (s *UdpSession) Encrypt(data []byte) ([]byte, error) {
 }
 
 func (s *UdpSession) GetAesKeyAndNonce() (string, string) {
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+	return formatKeyAndNonce(s.AesKey, s.Nonce)
+}
+
+func formatKeyAndNonce(aesKey [16]byte, nonce [8]byte) (string, string) {
 	//处理
-	strAesKey := hex.EncodeToString(s.AesKey[:])
+	strAesKey := hex.EncodeToString(aesKey[:])
 
 	// 构造 fullNonce: 前缀2字节0100 + 长度2字节0000 + 真实nonce(8字节) + seq(4字节00000000)
 	prefix := []byte{0x01, 0x00}
 	length := []byte{0x00, 0x00}
 	seq := []byte{0x00, 0x00, 0x00, 0x00}
-	fullNonce := append(append(append(prefix, length...), s.Nonce[:]...), seq...)
+	fullNonce := append(append(append(prefix, length...), nonce[:]...), seq...)
 	strFullNonce := hex.EncodeToString(fullNonce)
 
 	return strAesKey, strFullNonce
 }
 
-func (s *UdpSession) RecvData(data []byte) (bool, error) {
+// RecvData 上行音频先经过抖动缓冲重排, 再按序写入 RecvChannel
+func (s *UdpSession) RecvData(seq uint32, data []byte) (bool, error) {
 	s.Lock.Lock()
 	defer s.Lock.Unlock()
 	if s.Status == UdpSessionStatusClosed {
 		return false, nil
 	}
-	select {
-	case s.RecvChannel <- data:
-		return true, nil
-	default:
-		return false, fmt.Errorf("recv channel is full")
+	if s.jitterBuffer == nil {
+		return s.deliver([][]byte{data})
+	}
+
+	frames := s.jitterBuffer.Push(seq, data)
+	if s.flushTimer == nil {
+		s.flushTimer = time.AfterFunc(jitterFlushDelay, s.flushJitterBuffer)
+	} else {
+		s.flushTimer.Reset(jitterFlushDelay)
+	}
+	return s.deliver(frames)
+}
+
+func (s *UdpSession) flushJitterBuffer() {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	if s.Status == UdpSessionStatusClosed {
+		return
+	}
+	if _, err := s.deliver(s.jitterBuffer.Flush()); err != nil {
+		Warnf("设备 %s 输出抖动缓冲失败: %v", s.DeviceId, err)
+	}
+}
+
+func (s *UdpSession) deliver(frames [][]byte) (bool, error) {
+	for _, frame := range frames {
+		select {
+		case s.RecvChannel <- frame:
+		default:
+			return false, fmt.Errorf("recv channel is full")
+		}
+	}
+	return true, nil
+}
+
+// GetJitterStats 获取上行音频的丢包/乱序统计
+func (s *UdpSession) GetJitterStats() JitterStats {
+	if s.jitterBuffer == nil {
+		return JitterStats{}
 	}
+	return s.jitterBuffer.Stats()
+}
+
+// GetReplayDropped 被防重放窗口丢弃的包数
+func (s *UdpSession) GetReplayDropped() uint64 {
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+	return s.replayDropped
 }
 
 // SendAudioData 发送音频数据
@@ -124,7 +324,22 @@ func (s *UdpSession) SendAudioData(data []byte) (bool, error) {
 func (s *UdpSession) Destroy() {
 	s.Lock.Lock()
 	defer s.Lock.Unlock()
+	if s.Status == UdpSessionStatusClosed {
+		return
+	}
 	s.Status = UdpSessionStatusClosed
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+	}
+	if s.jitterBuffer != nil {
+		Infof("设备 %s udp 上行音频统计: %+v", s.DeviceId, s.jitterBuffer.Stats())
+	}
+	if dropped := s.GetReplayDropped(); dropped > 0 {
+		Infof("设备 %s udp 丢弃重放包 %d 个", s.DeviceId, dropped)
+	}
+	if s.releaseLimit != nil {
+		s.releaseLimit()
+	}
 	close(s.RecvChannel)
 	close(s.SendChannel)
 }
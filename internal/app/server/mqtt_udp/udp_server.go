@@ -5,11 +5,17 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
 	. "xiaozhi-esp32-server-golang/logger"
 )
 
@@ -31,18 +37,68 @@ type UdpServer struct {
 	nonce2Session sync.Map //nonce => UdpSession
 	addr2Session  sync.Map //addr => UdpSession
 	mqttAdapter   *MqttUdpAdapter
+	jitterDepth   int //抖动缓冲深度(包数), <0 表示关闭
+	replayWindow  int //防重放窗口大小(包数)
+	closed        atomic.Bool
 	sync.RWMutex
+
+	// 密钥轮换间隔, 0 表示不轮换
+	keyRotationInterval time.Duration
+	keyOverlap          time.Duration
+
+	// 按 IP 的会话数/握手频率和每个会话的音频帧率限制, 为 nil 时不限制
+	limiter *ratelimit.Limiter
+}
+
+type UdpServerOption func(*UdpServer)
+
+// WithJitterBufferDepth 设置上行音频抖动缓冲深度, <0 关闭抖动缓冲
+func WithJitterBufferDepth(depth int) UdpServerOption {
+	return func(s *UdpServer) {
+		s.jitterDepth = depth
+	}
+}
+
+// WithReplayWindow 设置防重放窗口大小(包数)
+func WithReplayWindow(size int) UdpServerOption {
+	return func(s *UdpServer) {
+		s.replayWindow = size
+	}
+}
+
+// WithKeyRotation 设置密钥轮换间隔和新旧密钥的重叠时间, interval<=0 不轮换
+func WithKeyRotation(interval time.Duration, overlap time.Duration) UdpServerOption {
+	return func(s *UdpServer) {
+		s.keyRotationInterval = interval
+		if overlap > 0 {
+			s.keyOverlap = overlap
+		}
+	}
+}
+
+// WithLimiter 设置按 IP 的会话数/握手频率和音频帧率限制
+func WithLimiter(limiter *ratelimit.Limiter) UdpServerOption {
+	return func(s *UdpServer) {
+		s.limiter = limiter
+	}
 }
 
 // NewUDPServer 创建新的UDP服务器
-func NewUDPServer(udpPort int, externalHost string, externalPort int) *UdpServer {
-	return &UdpServer{
+func NewUDPServer(udpPort int, externalHost string, externalPort int, opts ...UdpServerOption) *UdpServer {
+	s := &UdpServer{
 		udpPort:       udpPort,
 		externalHost:  externalHost,
 		externalPort:  externalPort,
 		nonce2Session: sync.Map{},
 		addr2Session:  sync.Map{},
+		jitterDepth:   DefaultJitterBufferDepth,
+		replayWindow:  DefaultReplayWindowSize,
+		keyOverlap:    DefaultKeyOverlap,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Start 启动UDP服务器
@@ -69,12 +125,24 @@ func (s *UdpServer) Start() error {
 	return nil
 }
 
+// Stop 关闭UDP监听
+func (s *UdpServer) Stop() error {
+	if s.conn == nil || !s.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	Infof("UDP服务器关闭, 端口 %d", s.udpPort)
+	return s.conn.Close()
+}
+
 // handlePackets 处理接收到的数据包
 func (s *UdpServer) handlePackets() {
 	buffer := make([]byte, 4096) // 使用默认的缓冲区大小
 	for {
 		n, addr, err := s.conn.ReadFromUDP(buffer)
 		if err != nil {
+			if s.closed.Load() {
+				return
+			}
 			Errorf("读取UDP数据失败: %v", err)
 			continue
 		}
@@ -84,6 +152,7 @@ func (s *UdpServer) handlePackets() {
 		copy(data, buffer[:n])
 
 		// 处理数据包
+		metrics.UdpPacketIn()
 		s.processPacket(addr, data)
 	}
 }
@@ -118,8 +187,11 @@ func (s *UdpServer) processPacket(addr *net.UDPAddr, data []byte) {
 			Warnf("session不存在 addr: %s", addr)
 			return
 		}
-		udpSession.RemoteAddr = addr
-		s.addUdpSession(addr, udpSession)
+		if err := s.bindAddr(udpSession, addr); err != nil {
+			// 受限的地址可能在持续灌包, 只在调试时打印, 通过统计接口查看
+			Debugf("设备 %s addr: %s 触发限制: %v", udpSession.DeviceId, addr, err)
+			return
+		}
 	}
 
 	if udpSession == nil {
@@ -132,11 +204,21 @@ func (s *UdpServer) processPacket(addr *net.UDPAddr, data []byte) {
 
 	decrypted, err := udpSession.Decrypt(data)
 	if err != nil {
+		metrics.UdpDecryptError(err == ErrReplayPacket)
+		if err == ErrReplayPacket {
+			Debugf("addr: %s 丢弃重放包, seq: %d", addr, binary.BigEndian.Uint32(data[12:16]))
+			return
+		}
 		Errorf("addr: %s 解密失败: %v", addr, err)
 		return
 	}
+	if !udpSession.audioLimiter.Allow() {
+		Debugf("addr: %s 上行音频帧率超限, 丢弃", addr)
+		return
+	}
 	Debugf("收到音频数据, addr: %s, 大小: %d 字节", addr, len(decrypted))
-	ok, err := udpSession.RecvData(decrypted)
+	seq := binary.BigEndian.Uint32(data[12:16])
+	ok, err := udpSession.RecvData(seq, decrypted)
 	if err != nil {
 		Errorf("addr: %s 接收数据失败: %v", addr, err)
 		return
@@ -153,6 +235,38 @@ func (s *UdpServer) processPacket(addr *net.UDPAddr, data []byte) {
 	}*/
 }
 
+// bindAddr 将设备的 udp 地址绑定到会话上, 首次绑定或 NAT 重绑定到新 IP 时计入该 IP 的握手次数和会话数
+func (s *UdpServer) bindAddr(session *UdpSession, addr *net.UDPAddr) error {
+	ip := addr.IP.String()
+	var release func()
+	if session.RemoteAddr == nil || !session.RemoteAddr.IP.Equal(addr.IP) {
+		if err := s.limiter.AllowHandshake(types.TransportTypeMqttUdp, "", ip); err != nil {
+			return err
+		}
+		var err error
+		release, err = s.limiter.Acquire(types.TransportTypeMqttUdp, "", ip)
+		if err != nil {
+			return err
+		}
+	}
+
+	session.RemoteAddr = addr
+	s.addUdpSession(addr, session)
+	if release != nil {
+		session.Lock.Lock()
+		if session.Status == UdpSessionStatusClosed {
+			release()
+		} else {
+			if session.releaseLimit != nil {
+				session.releaseLimit()
+			}
+			session.releaseLimit = release
+		}
+		session.Lock.Unlock()
+	}
+	return nil
+}
+
 // cleanupSessions 清理过期会话
 func (s *UdpServer) cleanupSessions() {
 	ticker := time.NewTicker(time.Minute)
@@ -220,6 +334,14 @@ func (s *UdpServer) CreateSession(deviceId, clientId string) *UdpSession {
 		SendChannel: make(chan []byte, 100),
 		Status:      UdpSessionStatusActive,
 		Lock:        sync.Mutex{},
+
+		replayWindow:     NewReplayWindow(s.replayWindow),
+		replayWindowSize: s.replayWindow,
+		keyOverlap:       s.keyOverlap,
+		audioLimiter:     s.limiter.NewAudioLimiter(types.TransportTypeMqttUdp, deviceId),
+	}
+	if s.jitterDepth >= 0 {
+		session.jitterBuffer = NewJitterBuffer(s.jitterDepth)
 	}
 	//通过channel发送音频数据, 当channel关闭的时候停止
 	go func() {
@@ -238,6 +360,7 @@ func (s *UdpServer) CreateSession(deviceId, clientId string) *UdpSession {
 				Errorf("发送音频数据失败: %v", err)
 				continue
 			}
+			metrics.UdpPacketOut()
 			//Debugf("发送音频数据成功, nonce: %s, 大小: %d 字节, 发送字节数: %d", hex.EncodeToString(encrypted[:16]), len(encrypted), n)
 		}
 	}()
@@ -272,6 +395,37 @@ func (s *UdpServer) GetNonce(connID string) *UdpSession {
 	return nil
 }
 
+// KeyRotationInterval 密钥轮换间隔, 0 表示不轮换
+func (s *UdpServer) KeyRotationInterval() time.Duration {
+	return s.keyRotationInterval
+}
+
+// GetJitterStats 按设备获取上行音频的丢包/乱序统计
+func (s *UdpServer) GetJitterStats() map[string]JitterStats {
+	stats := make(map[string]JitterStats)
+	s.nonce2Session.Range(func(key, value interface{}) bool {
+		session := value.(*UdpSession)
+		stats[session.DeviceId] = session.GetJitterStats()
+		return true
+	})
+	return stats
+}
+
+// HandleJitterStats http接口, 可通过 device_id 参数过滤
+func (s *UdpServer) HandleJitterStats(w http.ResponseWriter, r *http.Request) {
+	stats := s.GetJitterStats()
+	if deviceID := r.URL.Query().Get("device_id"); deviceID != "" {
+		deviceStats, ok := stats[deviceID]
+		if !ok {
+			http.Error(w, "设备不存在", http.StatusNotFound)
+			return
+		}
+		stats = map[string]JitterStats{deviceID: deviceStats}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
 // generateSessionID 生成会话ID
 func generateSessionID() string {
 	b := make([]byte, 8)
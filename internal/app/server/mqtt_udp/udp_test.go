@@ -0,0 +1,209 @@
+package mqtt_udp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUdpSession(t *testing.T) *UdpSession {
+	s := &UdpSession{
+		DeviceId:     "test-device",
+		replayWindow: NewReplayWindow(DefaultReplayWindowSize),
+		keyOverlap:   time.Minute,
+	}
+	copy(s.AesKey[:], "0123456789abcdef")
+	copy(s.Nonce[:4], "conn")
+	binary.BigEndian.PutUint32(s.Nonce[4:], uint32(time.Now().Unix()))
+	block, err := aes.NewCipher(s.AesKey[:])
+	require.NoError(t, err)
+	s.Block = block
+	return s
+}
+
+const (
+	// 设备上行 opus 帧的 TOC 字节, 编码参数固定时每帧相同
+	testToc = 0x58
+	// 设备写入 nonce 的时间戳, 固定取值使错误密钥解密的结果可复现
+	testTimestamp = 1700000000
+)
+
+// opusFrame 构造一个以固定 TOC 开头的上行帧
+func opusFrame(data string) []byte {
+	return append([]byte{testToc}, data...)
+}
+
+// devicePacket 按设备端的格式用下发的 key/nonce 加密上行包, nonce 后4字节写入设备时间戳
+func devicePacket(t *testing.T, strAesKey string, strFullNonce string, seq uint32, payload []byte) []byte {
+	aesKey, err := hex.DecodeString(strAesKey)
+	require.NoError(t, err)
+	fullNonce, err := hex.DecodeString(strFullNonce)
+	require.NoError(t, err)
+	block, err := aes.NewCipher(aesKey)
+	require.NoError(t, err)
+
+	packet := make([]byte, 16+len(payload))
+	copy(packet, fullNonce)
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(payload)))
+	binary.BigEndian.PutUint32(packet[8:], testTimestamp)
+	binary.BigEndian.PutUint32(packet[12:], seq)
+	cipher.NewCTR(block, packet[:16]).XORKeyStream(packet[16:], payload)
+	return packet
+}
+
+func TestUdpSession_RejectReplay(t *testing.T) {
+	s := newTestUdpSession(t)
+	oldKey, oldNonce := s.GetAesKeyAndNonce()
+
+	packet := devicePacket(t, oldKey, oldNonce, 1, opusFrame("hello"))
+	data, err := s.Decrypt(packet)
+	require.NoError(t, err)
+	assert.Equal(t, opusFrame("hello"), data)
+	assert.Equal(t, uint32(1), s.RemoteSeq)
+
+	_, err = s.Decrypt(packet)
+	assert.Equal(t, ErrReplayPacket, err)
+	assert.Equal(t, uint64(1), s.GetReplayDropped())
+}
+
+func TestUdpSession_UnknownConnId(t *testing.T) {
+	s := newTestUdpSession(t)
+	key, nonce := s.GetAesKeyAndNonce()
+
+	packet := devicePacket(t, key, nonce, 1, opusFrame("a"))
+	copy(packet[4:8], "xxxx")
+	_, err := s.Decrypt(packet)
+	assert.Error(t, err)
+}
+
+// fixPendingKey 把待切换的随机密钥换成固定值, 使用错误密钥解密的结果可复现
+func fixPendingKey(t *testing.T, s *UdpSession, aesKey string) string {
+	copy(s.pendingKey.aesKey[:], aesKey)
+	block, err := aes.NewCipher(s.pendingKey.aesKey[:])
+	require.NoError(t, err)
+	s.pendingKey.block = block
+	return hex.EncodeToString(s.pendingKey.aesKey[:])
+}
+
+func TestUdpSession_RotateKey(t *testing.T) {
+	s := newTestUdpSession(t)
+	oldKey, oldNonce := s.GetAesKeyAndNonce()
+
+	newKey, newNonce, err := s.RotateKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKey, newKey)
+	// 连接id不变, 服务端仍能找到该会话
+	assert.Equal(t, oldNonce[8:16], newNonce[8:16])
+	newKey = fixPendingKey(t, s, "fedcba9876543210")
+
+	// 设备切换前, 下行仍使用旧密钥
+	current, _ := s.GetAesKeyAndNonce()
+	assert.Equal(t, oldKey, current)
+	data, err := s.Decrypt(devicePacket(t, oldKey, oldNonce, 1, opusFrame("a")))
+	require.NoError(t, err)
+	assert.Equal(t, opusFrame("a"), data)
+
+	// 设备确认前已用新密钥发来的上行包可以解密, 但下行不切换
+	data, err = s.Decrypt(devicePacket(t, newKey, newNonce, 1, opusFrame("b")))
+	require.NoError(t, err)
+	assert.Equal(t, opusFrame("b"), data)
+	current, _ = s.GetAesKeyAndNonce()
+	assert.Equal(t, oldKey, current)
+
+	// 设备通过 udp_key 确认后切换
+	require.True(t, s.CommitKeyRotation())
+	current, _ = s.GetAesKeyAndNonce()
+	assert.Equal(t, newKey, current)
+
+	// 重叠期内旧密钥的在途包仍可解密, 但不能重放
+	data, err = s.Decrypt(devicePacket(t, oldKey, oldNonce, 2, opusFrame("c")))
+	require.NoError(t, err)
+	assert.Equal(t, opusFrame("c"), data)
+	_, err = s.Decrypt(devicePacket(t, oldKey, oldNonce, 1, opusFrame("a")))
+	assert.Equal(t, ErrReplayPacket, err)
+
+	// 新密钥继续使用
+	data, err = s.Decrypt(devicePacket(t, newKey, newNonce, 2, opusFrame("d")))
+	require.NoError(t, err)
+	assert.Equal(t, opusFrame("d"), data)
+
+	// 重叠期过后旧密钥失效, 只按当前密钥解密
+	s.prevKey.expireAt = time.Now().Add(-time.Second)
+	data, err = s.Decrypt(devicePacket(t, oldKey, oldNonce, 3, opusFrame("e")))
+	require.NoError(t, err)
+	assert.NotEqual(t, opusFrame("e"), data)
+	assert.Nil(t, s.prevKey)
+}
+
+func TestUdpSession_RotateKeyNoCommitWithoutAck(t *testing.T) {
+	s := newTestUdpSession(t)
+	oldKey, oldNonce := s.GetAesKeyAndNonce()
+	_, err := s.Decrypt(devicePacket(t, oldKey, oldNonce, 1, opusFrame("a")))
+	require.NoError(t, err)
+
+	_, _, err = s.RotateKey()
+	require.NoError(t, err)
+	fixPendingKey(t, s, "fedcba9876543210")
+
+	// 构造一个旧密钥加密、TOC 与之前不同, 用新密钥解密后 TOC 碰巧一致的包, 约 1/256 的包会这样
+	probe := devicePacket(t, oldKey, oldNonce, 2, []byte{0, 0})
+	toc := testToc ^ decryptWith(s.pendingKey.block, probe[:16], probe[16:])[0]
+	require.NotEqual(t, byte(testToc), toc)
+	data, err := s.Decrypt(devicePacket(t, oldKey, oldNonce, 2, []byte{toc, 0}))
+	require.NoError(t, err)
+	assert.Equal(t, byte(testToc), data[0])
+
+	// 误判只影响这一个包, 没有设备确认不会切换密钥
+	current, _ := s.GetAesKeyAndNonce()
+	assert.Equal(t, oldKey, current)
+	data, err = s.Decrypt(devicePacket(t, oldKey, oldNonce, 3, opusFrame("y")))
+	require.NoError(t, err)
+	assert.Equal(t, opusFrame("y"), data)
+}
+
+func TestUdpSession_ForwardJump(t *testing.T) {
+	s := newTestUdpSession(t)
+	key, nonce := s.GetAesKeyAndNonce()
+	_, err := s.Decrypt(devicePacket(t, key, nonce, 1, opusFrame("a")))
+	require.NoError(t, err)
+
+	// 伪造的大序列号包不会让之后真实的包被当作重放
+	spoofed := devicePacket(t, key, nonce, 100000, opusFrame("spoofed"))
+	spoofed[16] ^= 0xFF
+	for i := 0; i < 5; i++ {
+		_, err = s.Decrypt(spoofed)
+		assert.Equal(t, ErrReplayPacket, err)
+	}
+	data, err := s.Decrypt(devicePacket(t, key, nonce, 2, opusFrame("b")))
+	require.NoError(t, err)
+	assert.Equal(t, opusFrame("b"), data)
+
+	// 设备长时间丢包后恢复发送, 连续几个包后窗口跟上
+	for seq := uint32(5000); seq < 5000+resyncPackets-1; seq++ {
+		_, err = s.Decrypt(devicePacket(t, key, nonce, seq, opusFrame("c")))
+		assert.Equal(t, ErrReplayPacket, err)
+	}
+	for seq := uint32(5000 + resyncPackets - 1); seq < 5010; seq++ {
+		data, err = s.Decrypt(devicePacket(t, key, nonce, seq, opusFrame("d")))
+		require.NoError(t, err)
+		assert.Equal(t, opusFrame("d"), data)
+	}
+}
+
+func TestUdpSession_CommitKeyRotation(t *testing.T) {
+	s := newTestUdpSession(t)
+	assert.False(t, s.CommitKeyRotation())
+
+	newKey, _, err := s.RotateKey()
+	require.NoError(t, err)
+	assert.True(t, s.CommitKeyRotation())
+	current, _ := s.GetAesKeyAndNonce()
+	assert.Equal(t, newKey, current)
+	assert.False(t, s.CommitKeyRotation())
+}
@@ -0,0 +1,341 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 拒绝/断开原因, 同时作为统计的维度和下发给设备的关闭原因
+const (
+	ReasonDeviceSessions = "too_many_device_sessions" // 同一 Device-Id 并发会话过多
+	ReasonIpSessions     = "too_many_ip_sessions"     // 同一 IP 并发会话过多
+	ReasonHandshakeRate  = "handshake_rate_exceeded"  // 每分钟握手次数过多
+	ReasonAudioRate      = "audio_rate_exceeded"      // 每秒上行音频帧过多
+)
+
+const (
+	handshakeWindow = time.Minute
+	// 统计中按设备/IP记录的条目上限, 防止伪造大量 Device-Id 撑爆内存
+	maxThrottledKeys  = 1000
+	throttledOtherKey = "other"
+)
+
+// Config 各项限制, <=0 表示不限制
+type Config struct {
+	MaxSessionsPerDevice int
+	MaxSessionsPerIp     int
+	// 每个 Device-Id 和每个 IP 每分钟允许的握手次数
+	HandshakesPerMinute int
+	// 每个会话每秒允许的上行音频帧数
+	AudioFramesPerSecond int
+}
+
+// LimitError 触发限制时返回, Reason 为上面的常量之一
+type LimitError struct {
+	Reason string
+	Key    string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Key)
+}
+
+// Limiter 按 Device-Id 和 IP 做并发会话数和握手频率限制, 各协议共用一个实例
+// nil 的 Limiter 不做任何限制
+type Limiter struct {
+	config Config
+
+	sessions   map[string]int
+	handshakes map[string]*handshakeCounter
+	lastSweep  time.Time
+
+	// transport => reason => 次数
+	rejected map[string]map[string]uint64
+	// device:xx / ip:xx => 次数
+	throttled map[string]uint64
+
+	mu sync.Mutex
+}
+
+type handshakeCounter struct {
+	start time.Time
+	count int
+}
+
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{
+		config:     config,
+		sessions:   make(map[string]int),
+		handshakes: make(map[string]*handshakeCounter),
+		lastSweep:  time.Now(),
+		rejected:   make(map[string]map[string]uint64),
+		throttled:  make(map[string]uint64),
+	}
+}
+
+func deviceKey(deviceId string) string {
+	return "device:" + deviceId
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// AllowHandshake 记录一次握手(建立连接/OTA请求等), 超过每分钟次数时返回 *LimitError
+func (l *Limiter) AllowHandshake(transport string, deviceId string, ip string) error {
+	if l == nil || l.config.HandshakesPerMinute <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweepHandshakes(now)
+	for _, key := range identityKeys(deviceId, ip) {
+		counter, ok := l.handshakes[key]
+		if !ok || now.Sub(counter.start) >= handshakeWindow {
+			counter = &handshakeCounter{start: now}
+			l.handshakes[key] = counter
+		}
+		counter.count++
+		if counter.count > l.config.HandshakesPerMinute {
+			return l.reject(transport, ReasonHandshakeRate, key)
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) sweepHandshakes(now time.Time) {
+	if now.Sub(l.lastSweep) < handshakeWindow {
+		return
+	}
+	l.lastSweep = now
+	for key, counter := range l.handshakes {
+		if now.Sub(counter.start) >= handshakeWindow {
+			delete(l.handshakes, key)
+		}
+	}
+}
+
+// CheckSessions 只检查并发会话数是否已达上限, 不占用名额
+func (l *Limiter) CheckSessions(transport string, deviceId string, ip string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.checkSessions(transport, deviceId, ip)
+}
+
+// Acquire 占用一个并发会话名额, 会话结束时必须调用返回的 release, 可重复调用
+func (l *Limiter) Acquire(transport string, deviceId string, ip string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkSessions(transport, deviceId, ip); err != nil {
+		return nil, err
+	}
+	return l.track(deviceId, ip), nil
+}
+
+// Track 不检查上限直接占用名额, 用于已经在别处检查过的连接
+func (l *Limiter) Track(deviceId string, ip string) func() {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.track(deviceId, ip)
+}
+
+func (l *Limiter) track(deviceId string, ip string) func() {
+	keys := identityKeys(deviceId, ip)
+	for _, key := range keys {
+		l.sessions[key]++
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, key := range keys {
+				if l.sessions[key]--; l.sessions[key] <= 0 {
+					delete(l.sessions, key)
+				}
+			}
+		})
+	}
+}
+
+func (l *Limiter) checkSessions(transport string, deviceId string, ip string) error {
+	if deviceId != "" && l.config.MaxSessionsPerDevice > 0 {
+		key := deviceKey(deviceId)
+		if l.sessions[key] >= l.config.MaxSessionsPerDevice {
+			return l.reject(transport, ReasonDeviceSessions, key)
+		}
+	}
+	if ip != "" && l.config.MaxSessionsPerIp > 0 {
+		key := ipKey(ip)
+		if l.sessions[key] >= l.config.MaxSessionsPerIp {
+			return l.reject(transport, ReasonIpSessions, key)
+		}
+	}
+	return nil
+}
+
+// NewAudioLimiter 为一个会话创建上行音频帧限速器, 未配置时返回 nil
+func (l *Limiter) NewAudioLimiter(transport string, deviceId string) *AudioLimiter {
+	if l == nil || l.config.AudioFramesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(l.config.AudioFramesPerSecond)
+	return &AudioLimiter{
+		limiter:   l,
+		transport: transport,
+		deviceId:  deviceId,
+		rate:      rate,
+		tokens:    rate,
+		last:      time.Now(),
+	}
+}
+
+// reject 记录一次拒绝, 调用方需持有锁
+func (l *Limiter) reject(transport string, reason string, key string) error {
+	reasons, ok := l.rejected[transport]
+	if !ok {
+		reasons = make(map[string]uint64)
+		l.rejected[transport] = reasons
+	}
+	reasons[reason]++
+
+	if _, ok := l.throttled[key]; !ok && len(l.throttled) >= maxThrottledKeys {
+		key = throttledOtherKey
+	}
+	l.throttled[key]++
+	return &LimitError{Reason: reason, Key: key}
+}
+
+func identityKeys(deviceId string, ip string) []string {
+	keys := make([]string, 0, 2)
+	if deviceId != "" {
+		keys = append(keys, deviceKey(deviceId))
+	}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+// Stats 限流统计
+type Stats struct {
+	// transport => reason => 次数
+	Rejected map[string]map[string]uint64 `json:"rejected"`
+	// device:xx / ip:xx => 被限制的次数
+	Throttled map[string]uint64 `json:"throttled"`
+	// 当前占用的并发会话数
+	Sessions map[string]int `json:"sessions"`
+}
+
+func (l *Limiter) Stats() Stats {
+	stats := Stats{
+		Rejected:  make(map[string]map[string]uint64),
+		Throttled: make(map[string]uint64),
+		Sessions:  make(map[string]int),
+	}
+	if l == nil {
+		return stats
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for transport, reasons := range l.rejected {
+		stats.Rejected[transport] = make(map[string]uint64, len(reasons))
+		for reason, count := range reasons {
+			stats.Rejected[transport][reason] = count
+		}
+	}
+	for key, count := range l.throttled {
+		stats.Throttled[key] = count
+	}
+	for key, count := range l.sessions {
+		stats.Sessions[key] = count
+	}
+	return stats
+}
+
+// HandleStats http接口, 查看哪些设备/IP被限制
+func (l *Limiter) HandleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.Stats())
+}
+
+// RejectHttp 触发限制时返回 429, 响应体为限制原因, 各 http 接入点共用
+func RejectHttp(w http.ResponseWriter, deviceId string, ip string, err error) {
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Warnf("设备 %s(%s) 触发限制: %s", deviceId, ip, limitErr.Reason)
+	http.Error(w, limitErr.Reason, http.StatusTooManyRequests)
+}
+
+// AudioLimiter 单个会话的上行音频令牌桶, 允许 1 秒的突发
+type AudioLimiter struct {
+	limiter   *Limiter
+	transport string
+	deviceId  string
+
+	rate   float64
+	tokens float64
+	last   time.Time
+	// 连续被丢弃的帧数
+	dropped int
+	mu      sync.Mutex
+}
+
+// Allow 是否接收这一帧, 超出时记入统计; nil 的 AudioLimiter 总是允许
+func (a *AudioLimiter) Allow() bool {
+	if a == nil {
+		return true
+	}
+	a.mu.Lock()
+	now := time.Now()
+	a.tokens += now.Sub(a.last).Seconds() * a.rate
+	if a.tokens > a.rate {
+		a.tokens = a.rate
+	}
+	a.last = now
+	allowed := a.tokens >= 1
+	if allowed {
+		a.tokens--
+		a.dropped = 0
+	} else {
+		a.dropped++
+	}
+	a.mu.Unlock()
+
+	if !allowed {
+		a.limiter.mu.Lock()
+		a.limiter.reject(a.transport, ReasonAudioRate, deviceKey(a.deviceId))
+		a.limiter.mu.Unlock()
+	}
+	return allowed
+}
+
+// Flooding 连续丢弃的帧已超过 1 秒的配额, 说明不是偶发的突发而是持续灌包
+func (a *AudioLimiter) Flooding() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return float64(a.dropped) >= a.rate
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func limitReason(err error) string {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.Reason
+	}
+	return ""
+}
+
+func TestLimiter_Sessions(t *testing.T) {
+	l := NewLimiter(Config{MaxSessionsPerDevice: 1, MaxSessionsPerIp: 2})
+
+	release1, err := l.Acquire("websocket", "dev1", "1.1.1.1")
+	require.NoError(t, err)
+
+	_, err = l.Acquire("websocket", "dev1", "2.2.2.2")
+	assert.Equal(t, ReasonDeviceSessions, limitReason(err))
+
+	release2, err := l.Acquire("websocket", "dev2", "1.1.1.1")
+	require.NoError(t, err)
+	_, err = l.Acquire("websocket", "dev3", "1.1.1.1")
+	assert.Equal(t, ReasonIpSessions, limitReason(err))
+
+	// 释放后名额归还, 重复释放不会多还
+	release1()
+	release1()
+	_, err = l.Acquire("websocket", "dev1", "2.2.2.2")
+	assert.NoError(t, err)
+	_, err = l.Acquire("websocket", "dev3", "1.1.1.1")
+	assert.NoError(t, err)
+	_, err = l.Acquire("websocket", "dev4", "1.1.1.1")
+	assert.Equal(t, ReasonIpSessions, limitReason(err))
+	release2()
+
+	stats := l.Stats()
+	assert.Equal(t, uint64(1), stats.Rejected["websocket"][ReasonDeviceSessions])
+	assert.Equal(t, uint64(2), stats.Rejected["websocket"][ReasonIpSessions])
+	assert.Equal(t, uint64(2), stats.Throttled["ip:1.1.1.1"])
+}
+
+func TestLimiter_Handshakes(t *testing.T) {
+	l := NewLimiter(Config{HandshakesPerMinute: 2})
+
+	assert.NoError(t, l.AllowHandshake("ota", "dev1", "1.1.1.1"))
+	assert.NoError(t, l.AllowHandshake("websocket", "dev1", "1.1.1.1"))
+	err := l.AllowHandshake("websocket", "dev1", "1.1.1.1")
+	assert.Equal(t, ReasonHandshakeRate, limitReason(err))
+
+	// 同一 IP 下的其他设备也受 IP 维度的限制
+	err = l.AllowHandshake("websocket", "dev2", "1.1.1.1")
+	assert.Equal(t, ReasonHandshakeRate, limitReason(err))
+	assert.NoError(t, l.AllowHandshake("websocket", "dev3", "2.2.2.2"))
+
+	// 窗口过期后重新计数
+	l.mu.Lock()
+	for _, counter := range l.handshakes {
+		counter.start = counter.start.Add(-time.Minute)
+	}
+	l.mu.Unlock()
+	assert.NoError(t, l.AllowHandshake("websocket", "dev1", "1.1.1.1"))
+}
+
+func TestAudioLimiter(t *testing.T) {
+	l := NewLimiter(Config{AudioFramesPerSecond: 10})
+	a := l.NewAudioLimiter("udp", "dev1")
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, a.Allow())
+	}
+	assert.False(t, a.Allow())
+	assert.False(t, a.Flooding())
+	for i := 0; i < 9; i++ {
+		a.Allow()
+	}
+	assert.True(t, a.Flooding())
+	assert.Equal(t, uint64(10), l.Stats().Rejected["udp"][ReasonAudioRate])
+
+	// 令牌按时间补充
+	a.mu.Lock()
+	a.last = a.last.Add(-200 * time.Millisecond)
+	a.mu.Unlock()
+	assert.True(t, a.Allow())
+	assert.False(t, a.Flooding())
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	var l *Limiter
+	assert.NoError(t, l.AllowHandshake("websocket", "dev1", "1.1.1.1"))
+	release, err := l.Acquire("websocket", "dev1", "1.1.1.1")
+	require.NoError(t, err)
+	release()
+	assert.True(t, l.NewAudioLimiter("websocket", "dev1").Allow())
+}
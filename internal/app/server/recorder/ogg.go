@@ -0,0 +1,213 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Ogg 页头标志
+const (
+	oggFlagBOS = 0x02
+	oggFlagEOS = 0x04
+)
+
+const oggOpusVendor = "xiaozhi-esp32-server-golang"
+
+var oggCrcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggCrc(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCrcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// OggOpusWriter 把 opus 帧原样封装为 ogg 文件(RFC 7845), 每页一个包, 不做转码
+type OggOpusWriter struct {
+	w        io.Writer
+	serial   uint32
+	pageSeq  uint32
+	granule  uint64
+	pending  []byte
+	finished bool
+}
+
+// NewOggOpusWriter 写入 OpusHead 和 OpusTags 头, sampleRate 仅作为原始采样率记录
+func NewOggOpusWriter(w io.Writer, sampleRate int, channels int, serial uint32) (*OggOpusWriter, error) {
+	o := &OggOpusWriter{w: w, serial: serial}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = byte(channels)
+	// pre-skip、输出增益和声道映射族均为 0
+	binary.LittleEndian.PutUint32(head[12:], uint32(sampleRate))
+	if err := o.writePage(head, oggFlagBOS, 0); err != nil {
+		return nil, err
+	}
+
+	tags := make([]byte, 8+4+len(oggOpusVendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(oggOpusVendor)))
+	copy(tags[12:], oggOpusVendor)
+	binary.LittleEndian.PutUint32(tags[12+len(oggOpusVendor):], 0)
+	if err := o.writePage(tags, 0, 0); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// WritePacket 写入一个 opus 包, 最后一个包要在 Close 时带上结束标志, 所以延后一个包写出
+func (o *OggOpusWriter) WritePacket(packet []byte) error {
+	if len(packet) == 0 {
+		return nil
+	}
+	if o.pending != nil {
+		if err := o.flushPending(0); err != nil {
+			return err
+		}
+	}
+	o.pending = append([]byte(nil), packet...)
+	return nil
+}
+
+// Close 写出最后一页, 不关闭底层 writer
+func (o *OggOpusWriter) Close() error {
+	if o.finished {
+		return nil
+	}
+	o.finished = true
+	if o.pending == nil {
+		return o.writePage(nil, oggFlagEOS, o.granule)
+	}
+	return o.flushPending(oggFlagEOS)
+}
+
+func (o *OggOpusWriter) flushPending(flag byte) error {
+	o.granule += uint64(OpusPacketSamples(o.pending))
+	err := o.writePage(o.pending, flag, o.granule)
+	o.pending = nil
+	return err
+}
+
+func (o *OggOpusWriter) writePage(packet []byte, flag byte, granule uint64) error {
+	segments := len(packet)/255 + 1
+	if packet == nil {
+		segments = 0
+	}
+	page := make([]byte, 27+segments, 27+segments+len(packet))
+	copy(page, "OggS")
+	page[5] = flag
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], o.serial)
+	binary.LittleEndian.PutUint32(page[18:], o.pageSeq)
+	page[26] = byte(segments)
+	for i := 0; i < segments; i++ {
+		if i < segments-1 {
+			page[27+i] = 255
+		} else {
+			page[27+i] = byte(len(packet) % 255)
+		}
+	}
+	page = append(page, packet...)
+	binary.LittleEndian.PutUint32(page[22:], oggCrc(page))
+
+	o.pageSeq++
+	_, err := o.w.Write(page)
+	return err
+}
+
+// OpusPacketSamples 根据 TOC 计算 opus 包在 48kHz 下的采样数(RFC 6716 3.1)
+func OpusPacketSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+
+	var frameSamples int
+	switch {
+	case config < 12:
+		// SILK: 10/20/40/60ms
+		frameSamples = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		// Hybrid: 10/20ms
+		frameSamples = []int{480, 960}[config%2]
+	default:
+		// CELT: 2.5/5/10/20ms
+		frameSamples = []int{120, 240, 480, 960}[config%4]
+	}
+
+	frames := 1
+	switch toc & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+		frames = int(packet[1] & 0x3f)
+	}
+	return frameSamples * frames
+}
+
+// ReadOggOpus 读取 ogg 文件中的全部 opus 包, 跳过 OpusHead/OpusTags 头, 返回头中记录的采样率和声道数
+func ReadOggOpus(r io.Reader) (packets [][]byte, sampleRate int, channels int, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var packet []byte
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			return nil, 0, 0, errors.New("不是有效的 ogg 页")
+		}
+		segments := int(data[26])
+		if len(data) < 27+segments {
+			return nil, 0, 0, errors.New("ogg 页不完整")
+		}
+		table := data[27 : 27+segments]
+		body := data[27+segments:]
+		for _, size := range table {
+			if len(body) < int(size) {
+				return nil, 0, 0, errors.New("ogg 页不完整")
+			}
+			packet = append(packet, body[:size]...)
+			body = body[size:]
+			// 长度为 255 的段表示包在下一段(或下一页)继续
+			if size < 255 {
+				switch {
+				case bytes.HasPrefix(packet, []byte("OpusHead")):
+					if len(packet) >= 16 {
+						channels = int(packet[9])
+						sampleRate = int(binary.LittleEndian.Uint32(packet[12:]))
+					}
+				case bytes.HasPrefix(packet, []byte("OpusTags")):
+				default:
+					packets = append(packets, packet)
+				}
+				packet = nil
+			}
+		}
+		data = body
+	}
+	return packets, sampleRate, channels, nil
+}
@@ -0,0 +1,277 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 事件类型
+const (
+	EventSessionStart = "session_start"
+	EventSessionEnd   = "session_end"
+	EventAudioFormat  = "audio_format"
+	EventTurnStart    = "turn_start"
+	EventCmdIn        = "cmd_in"
+	EventAudioIn      = "audio_in"
+	EventAudioLost    = "audio_lost"
+	EventAudioOut     = "audio_out"
+	EventAudioClip    = "audio_clip"
+	EventVad          = "vad"
+	EventAsrResult    = "asr_result"
+	EventAsrFinal     = "asr_final"
+	EventLlmStart     = "llm_start"
+	EventLlmMessage   = "llm_message"
+	EventToolCall     = "tool_call"
+	EventTtsStart     = "tts_start"
+	EventTtsEnd       = "tts_end"
+)
+
+const eventsFileName = "events.ndjson"
+
+// Recorder 录制一次会话的全部过程, 每个会话一个目录:
+//
+//	events.ndjson       事件日志, 每行一个带时间戳的事件
+//	turn_001_in.ogg     第 1 轮设备上行的 opus 音频
+//	turn_001_out.ogg    第 1 轮下发的 tts opus 音频
+//
+// 设备拾音开始时进入新的一轮, 拾音之前的欢迎语等下发音频记在第 0 轮
+// 所有方法对 nil 安全, 未开启录制时传 nil 即可
+type Recorder struct {
+	dir   string
+	start time.Time
+
+	file   *os.File
+	writer *bufio.Writer
+
+	turn      int
+	in        *oggTrack
+	out       *oggTrack
+	inFormat  AudioFormat
+	outFormat AudioFormat
+
+	closed bool
+	mu     sync.Mutex
+}
+
+// AudioFormat 写入 ogg 头所需的音频参数
+type AudioFormat struct {
+	SampleRate int `json:"sample_rate"`
+	Channels   int `json:"channels"`
+}
+
+// oggTrack 一轮中一个方向的音频文件
+type oggTrack struct {
+	name   string
+	file   *os.File
+	writer *OggOpusWriter
+	seq    int
+}
+
+// New 在 baseDir/deviceId 下创建本次会话的录制目录
+func New(baseDir string, deviceId string, meta map[string]interface{}) (*Recorder, error) {
+	start := time.Now()
+	dir := filepath.Join(baseDir, sanitizeName(deviceId), start.Format("20060102-150405.000"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建录制目录失败: %v", err)
+	}
+	file, err := os.Create(filepath.Join(dir, eventsFileName))
+	if err != nil {
+		return nil, fmt.Errorf("创建事件日志失败: %v", err)
+	}
+
+	r := &Recorder{
+		dir:    dir,
+		start:  start,
+		file:   file,
+		writer: bufio.NewWriter(file),
+		// 未收到 hello 前按默认的 16k 单声道
+		inFormat:  AudioFormat{SampleRate: 16000, Channels: 1},
+		outFormat: AudioFormat{SampleRate: 16000, Channels: 1},
+	}
+	data := map[string]interface{}{"device_id": deviceId}
+	for k, v := range meta {
+		data[k] = v
+	}
+	r.Event(EventSessionStart, data)
+	return r, nil
+}
+
+// Dir 录制目录
+func (r *Recorder) Dir() string {
+	if r == nil {
+		return ""
+	}
+	return r.dir
+}
+
+// Event 记录一个事件, data 中的字段平铺到事件中
+func (r *Recorder) Event(eventType string, data map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeEvent(eventType, data)
+}
+
+func (r *Recorder) writeEvent(eventType string, data map[string]interface{}) {
+	if r.closed {
+		return
+	}
+	now := time.Now()
+	event := make(map[string]interface{}, len(data)+4)
+	for k, v := range data {
+		event[k] = v
+	}
+	event["ts"] = now.UnixMilli()
+	event["offset_ms"] = now.Sub(r.start).Milliseconds()
+	event["turn"] = r.turn
+	event["type"] = eventType
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Warnf("录制事件 %s 序列化失败: %v", eventType, err)
+		return
+	}
+	r.writer.Write(line)
+	r.writer.WriteByte('\n')
+}
+
+// SetAudioFormat 设置上下行音频参数, 对之后新建的 ogg 文件生效
+func (r *Recorder) SetAudioFormat(in AudioFormat, out AudioFormat) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFormat, r.outFormat = in, out
+	r.writeEvent(EventAudioFormat, map[string]interface{}{"input": in, "output": out})
+}
+
+// StartTurn 进入新的一轮对话, 结束上一轮的音频文件
+func (r *Recorder) StartTurn() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closeTracks()
+	r.writer.Flush()
+	r.turn++
+	r.writeEvent(EventTurnStart, nil)
+}
+
+// AudioIn 记录设备上行的一帧 opus 音频, 空帧表示传输层判定的丢帧
+func (r *Recorder) AudioIn(frame []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(frame) == 0 {
+		r.writeEvent(EventAudioLost, nil)
+		return
+	}
+	r.writeAudio(&r.in, "in", r.inFormat, EventAudioIn, frame)
+}
+
+// AudioOut 记录下发给设备的一帧 tts opus 音频
+func (r *Recorder) AudioOut(frame []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeAudio(&r.out, "out", r.outFormat, EventAudioOut, frame)
+}
+
+func (r *Recorder) writeAudio(track **oggTrack, direction string, format AudioFormat, eventType string, frame []byte) {
+	if r.closed {
+		return
+	}
+	if *track == nil {
+		t, err := r.openTrack(direction, format)
+		if err != nil {
+			log.Warnf("录制 %s 打开音频文件失败: %v", r.dir, err)
+			return
+		}
+		*track = t
+	}
+	t := *track
+	if err := t.writer.WritePacket(frame); err != nil {
+		log.Warnf("录制 %s 写入音频失败: %v", t.name, err)
+		return
+	}
+	t.seq++
+	r.writeEvent(eventType, map[string]interface{}{"file": t.name, "seq": t.seq, "len": len(frame)})
+}
+
+func (r *Recorder) openTrack(direction string, format AudioFormat) (*oggTrack, error) {
+	name := fmt.Sprintf("turn_%03d_%s.ogg", r.turn, direction)
+	file, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	writer, err := NewOggOpusWriter(file, format.SampleRate, format.Channels, crc32.ChecksumIEEE([]byte(name)))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &oggTrack{name: name, file: file, writer: writer}, nil
+}
+
+func (r *Recorder) closeTracks() {
+	for _, track := range []**oggTrack{&r.in, &r.out} {
+		t := *track
+		if t == nil {
+			continue
+		}
+		if err := t.writer.Close(); err != nil {
+			log.Warnf("录制 %s 结束音频文件失败: %v", t.name, err)
+		}
+		t.file.Close()
+		*track = nil
+	}
+}
+
+// Close 结束录制, 重复调用无副作用
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closeTracks()
+	r.writeEvent(EventSessionEnd, map[string]interface{}{"duration_ms": time.Since(r.start).Milliseconds()})
+	r.closed = true
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// sanitizeName 设备id中的 ':' 等字符不适合作为目录名
+func sanitizeName(name string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' {
+			return c
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,176 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type oggPage struct {
+	flag    byte
+	granule uint64
+	seq     uint32
+	packet  []byte
+}
+
+// parseOggPages 解析 ogg 页并校验 crc, 每页只有一个包
+func parseOggPages(t *testing.T, data []byte) []oggPage {
+	var pages []oggPage
+	for len(data) > 0 {
+		require.True(t, len(data) >= 27)
+		require.Equal(t, "OggS", string(data[:4]))
+		segments := int(data[26])
+		size := 0
+		for _, s := range data[27 : 27+segments] {
+			size += int(s)
+		}
+		pageLen := 27 + segments + size
+		page := append([]byte(nil), data[:pageLen]...)
+		crc := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		assert.Equal(t, oggCrc(page), crc)
+
+		pages = append(pages, oggPage{
+			flag:    data[5],
+			granule: binary.LittleEndian.Uint64(data[6:]),
+			seq:     binary.LittleEndian.Uint32(data[18:]),
+			packet:  data[27+segments : pageLen],
+		})
+		data = data[pageLen:]
+	}
+	return pages
+}
+
+func readEvents(t *testing.T, dir string) []map[string]interface{} {
+	file, err := os.Open(filepath.Join(dir, eventsFileName))
+	require.NoError(t, err)
+	defer file.Close()
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestOggOpusWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewOggOpusWriter(&buf, 16000, 1, 1)
+	require.NoError(t, err)
+
+	// CELT 20ms 单帧
+	frame := append([]byte{0xf8}, make([]byte, 300)...)
+	require.NoError(t, w.WritePacket(frame))
+	require.NoError(t, w.WritePacket(frame[:50]))
+	require.NoError(t, w.Close())
+
+	pages := parseOggPages(t, buf.Bytes())
+	if assert.Len(t, pages, 4) {
+		assert.Equal(t, byte(oggFlagBOS), pages[0].flag)
+		assert.Equal(t, "OpusHead", string(pages[0].packet[:8]))
+		assert.Equal(t, byte(1), pages[0].packet[9])
+		assert.Equal(t, uint32(16000), binary.LittleEndian.Uint32(pages[0].packet[12:]))
+		assert.Equal(t, "OpusTags", string(pages[1].packet[:8]))
+
+		assert.Equal(t, frame, pages[2].packet)
+		assert.Equal(t, uint64(960), pages[2].granule)
+		assert.Equal(t, byte(oggFlagEOS), pages[3].flag)
+		assert.Equal(t, uint64(1920), pages[3].granule)
+		assert.Equal(t, uint32(3), pages[3].seq)
+	}
+}
+
+func TestReadOggOpus(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewOggOpusWriter(&buf, 24000, 1, 7)
+	require.NoError(t, err)
+	// 超过 255 字节的包需要多个段
+	frames := [][]byte{append([]byte{0xf8}, make([]byte, 600)...), {0xf8, 1, 2}, append([]byte{0xf8}, make([]byte, 254)...)}
+	for _, frame := range frames {
+		require.NoError(t, w.WritePacket(frame))
+	}
+	require.NoError(t, w.Close())
+
+	packets, sampleRate, channels, err := ReadOggOpus(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, frames, packets)
+	assert.Equal(t, 24000, sampleRate)
+	assert.Equal(t, 1, channels)
+
+	_, _, _, err = ReadOggOpus(bytes.NewReader([]byte("not ogg data at all, definitely not")))
+	assert.Error(t, err)
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	assert.Equal(t, 0, OpusPacketSamples(nil))
+	// SILK 60ms
+	assert.Equal(t, 2880, OpusPacketSamples([]byte{3 << 3}))
+	// Hybrid 10ms, 两帧
+	assert.Equal(t, 960, OpusPacketSamples([]byte{12<<3 | 1}))
+	// CELT 2.5ms, 任意帧数
+	assert.Equal(t, 120*5, OpusPacketSamples([]byte{16<<3 | 3, 5}))
+}
+
+func TestRecorder(t *testing.T) {
+	baseDir := t.TempDir()
+	r, err := New(baseDir, "ba:8f:17:de:94:94", map[string]interface{}{"agent_id": "agent1"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(baseDir, "ba_8f_17_de_94_94"), filepath.Dir(r.Dir()))
+
+	frame := []byte{0xf8, 1, 2, 3}
+	// 拾音之前的欢迎语记在第 0 轮
+	r.AudioOut(frame)
+	r.SetAudioFormat(AudioFormat{SampleRate: 16000, Channels: 1}, AudioFormat{SampleRate: 24000, Channels: 1})
+	r.StartTurn()
+	r.AudioIn(frame)
+	r.AudioIn(nil)
+	r.AudioIn(frame)
+	r.Event(EventAsrFinal, map[string]interface{}{"text": "你好"})
+	r.AudioOut(frame)
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	// 关闭后的写入被忽略
+	r.AudioIn(frame)
+
+	var types []string
+	for _, event := range readEvents(t, r.Dir()) {
+		types = append(types, event["type"].(string))
+	}
+	assert.Equal(t, []string{
+		EventSessionStart, EventAudioOut, EventAudioFormat, EventTurnStart,
+		EventAudioIn, EventAudioLost, EventAudioIn, EventAsrFinal, EventAudioOut, EventSessionEnd,
+	}, types)
+
+	events := readEvents(t, r.Dir())
+	assert.Equal(t, "agent1", events[0]["agent_id"])
+	assert.Equal(t, float64(1), events[7]["turn"])
+	assert.Equal(t, "turn_001_in.ogg", events[6]["file"])
+	assert.Equal(t, float64(2), events[6]["seq"])
+
+	for name, packets := range map[string]int{"turn_000_out.ogg": 1, "turn_001_in.ogg": 2, "turn_001_out.ogg": 1} {
+		data, err := os.ReadFile(filepath.Join(r.Dir(), name))
+		require.NoError(t, err)
+		// 两个头页 + 每帧一页
+		assert.Len(t, parseOggPages(t, data), 2+packets, name)
+	}
+	out, _ := os.ReadFile(filepath.Join(r.Dir(), "turn_001_out.ogg"))
+	assert.Equal(t, uint32(24000), binary.LittleEndian.Uint32(parseOggPages(t, out)[0].packet[12:]))
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var r *Recorder
+	r.StartTurn()
+	r.AudioIn([]byte{1})
+	r.AudioOut([]byte{1})
+	r.Event(EventVad, nil)
+	assert.NoError(t, r.Close())
+}
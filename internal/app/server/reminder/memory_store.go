@@ -0,0 +1,96 @@
+package reminder
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore 进程内存储, 重启后丢失, 适合单节点部署
+type MemoryStore struct {
+	reminders map[string]*Reminder
+	pending   map[string][]*Reminder
+	mu        sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		reminders: make(map[string]*Reminder),
+		pending:   make(map[string][]*Reminder),
+	}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, r *Reminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *r
+	s.reminders[r.Id] = &copied
+	return nil
+}
+
+func (s *MemoryStore) Remove(ctx context.Context, deviceId string, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reminders[id]
+	if !ok || r.DeviceId != deviceId {
+		return false, nil
+	}
+	delete(s.reminders, id)
+	return true, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, deviceId string) ([]*Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []*Reminder
+	for _, r := range s.reminders {
+		if r.DeviceId == deviceId {
+			copied := *r
+			list = append(list, &copied)
+		}
+	}
+	sortByFireAt(list)
+	return list, nil
+}
+
+func (s *MemoryStore) PopDue(ctx context.Context, now time.Time, limit int) ([]*Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*Reminder
+	for _, r := range s.reminders {
+		if !r.FireAt.After(now) {
+			due = append(due, r)
+		}
+	}
+	sortByFireAt(due)
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	for _, r := range due {
+		delete(s.reminders, r.Id)
+	}
+	return due, nil
+}
+
+func (s *MemoryStore) AddPending(ctx context.Context, r *Reminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *r
+	s.pending[r.DeviceId] = append(s.pending[r.DeviceId], &copied)
+	return nil
+}
+
+func (s *MemoryStore) PopPending(ctx context.Context, deviceId string) ([]*Reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.pending[deviceId]
+	delete(s.pending, deviceId)
+	return list, nil
+}
+
+func sortByFireAt(list []*Reminder) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].FireAt.Before(list[j].FireAt)
+	})
+}
@@ -0,0 +1,204 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 原子地取出到点的提醒, 保证多节点下每条只被一个节点取到
+// KEYS[1]: 按触发时间排序的 zset  ARGV[1]: 当前时间(毫秒)  ARGV[2]: 数量上限  ARGV[3]: 提醒内容 key 前缀
+var popDueScript = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+local result = {}
+for _, id in ipairs(ids) do
+	redis.call("ZREM", KEYS[1], id)
+	local key = ARGV[3] .. id
+	local data = redis.call("GET", key)
+	if data then
+		redis.call("DEL", key)
+		table.insert(result, data)
+	end
+end
+return result`)
+
+// 原子地取出并清空设备的待投递队列
+var popPendingScript = redis.NewScript(`
+local list = redis.call("LRANGE", KEYS[1], 0, -1)
+redis.call("DEL", KEYS[1])
+return list`)
+
+// 待投递队列的过期时间, 设备长期不上线时自动清理
+const pendingKeyTTL = 7 * 24 * time.Hour
+
+// RedisStore 基于 redis 的存储, 多节点部署时共用
+//
+//	reminder:schedule          zset, member 为提醒id, score 为触发时间
+//	reminder:item:{id}         提醒内容
+//	reminder:device:{deviceId} set, 设备的提醒id
+//	reminder:pending:{deviceId} list, 设备离线时暂存的提醒
+type RedisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func NewRedisStore(client *redis.Client, keyPrefix string) (*RedisStore, error) {
+	if client == nil {
+		return nil, fmt.Errorf("redis 未初始化")
+	}
+	return &RedisStore{client: client, keyPrefix: keyPrefix}, nil
+}
+
+func (s *RedisStore) Add(ctx context.Context, r *Reminder) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, s.itemKey(r.Id), data, 0)
+	pipe.SAdd(ctx, s.deviceKey(r.DeviceId), r.Id)
+	pipe.ZAdd(ctx, s.scheduleKey(), redis.Z{Score: float64(r.FireAt.UnixMilli()), Member: r.Id})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) Remove(ctx context.Context, deviceId string, id string) (bool, error) {
+	r, err := s.get(ctx, id)
+	if err != nil || r == nil || r.DeviceId != deviceId {
+		return false, err
+	}
+	pipe := s.client.TxPipeline()
+	removed := pipe.ZRem(ctx, s.scheduleKey(), id)
+	pipe.Del(ctx, s.itemKey(id))
+	pipe.SRem(ctx, s.deviceKey(deviceId), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	// 已被调度器取走则视为删除失败
+	return removed.Val() > 0, nil
+}
+
+func (s *RedisStore) List(ctx context.Context, deviceId string) ([]*Reminder, error) {
+	ids, err := s.client.SMembers(ctx, s.deviceKey(deviceId)).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.itemKey(id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Reminder
+	var stale []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// 已触发的提醒, 顺带从设备集合中清理
+			stale = append(stale, ids[i])
+			continue
+		}
+		var r Reminder
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			log.Warnf("解析提醒 %s 失败: %v", ids[i], err)
+			continue
+		}
+		list = append(list, &r)
+	}
+	if len(stale) > 0 {
+		s.client.SRem(ctx, s.deviceKey(deviceId), stale...)
+	}
+	sortByFireAt(list)
+	return list, nil
+}
+
+func (s *RedisStore) PopDue(ctx context.Context, now time.Time, limit int) ([]*Reminder, error) {
+	values, err := popDueScript.Run(ctx, s.client,
+		[]string{s.scheduleKey()},
+		now.UnixMilli(), limit, s.itemKey(""),
+	).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	due := make([]*Reminder, 0, len(values))
+	for _, data := range values {
+		var r Reminder
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			log.Warnf("解析提醒失败: %v", err)
+			continue
+		}
+		s.client.SRem(ctx, s.deviceKey(r.DeviceId), r.Id)
+		due = append(due, &r)
+	}
+	return due, nil
+}
+
+func (s *RedisStore) AddPending(ctx context.Context, r *Reminder) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	key := s.pendingKey(r.DeviceId)
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.Expire(ctx, key, pendingKeyTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) PopPending(ctx context.Context, deviceId string) ([]*Reminder, error) {
+	values, err := popPendingScript.Run(ctx, s.client, []string{s.pendingKey(deviceId)}).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Reminder, 0, len(values))
+	for _, data := range values {
+		var r Reminder
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			log.Warnf("解析待投递提醒失败: %v", err)
+			continue
+		}
+		list = append(list, &r)
+	}
+	return list, nil
+}
+
+func (s *RedisStore) get(ctx context.Context, id string) (*Reminder, error) {
+	data, err := s.client.Get(ctx, s.itemKey(id)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r Reminder
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *RedisStore) scheduleKey() string {
+	return redisdb.GetKeyWithPrefix(s.keyPrefix, "reminder:schedule")
+}
+
+func (s *RedisStore) itemKey(id string) string {
+	return redisdb.GetKeyWithPrefix(s.keyPrefix, "reminder:item:"+id)
+}
+
+func (s *RedisStore) deviceKey(deviceId string) string {
+	return redisdb.GetKeyWithPrefix(s.keyPrefix, "reminder:device:"+deviceId)
+}
+
+func (s *RedisStore) pendingKey(deviceId string) string {
+	return redisdb.GetKeyWithPrefix(s.keyPrefix, "reminder:pending:"+deviceId)
+}
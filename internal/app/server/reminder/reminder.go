@@ -0,0 +1,58 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 重复方式
+const (
+	RepeatNone  = ""
+	RepeatDaily = "daily"
+)
+
+var (
+	ErrReminderNotFound = errors.New("reminder not found")
+	ErrTooManyReminders = errors.New("too many reminders")
+)
+
+// Reminder 一条定时提醒, 到点后播报到设备
+type Reminder struct {
+	Id        string    `json:"id"`
+	DeviceId  string    `json:"device_id"`
+	Content   string    `json:"content"`
+	FireAt    time.Time `json:"fire_at"`
+	Repeat    string    `json:"repeat,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// 到点时设备离线, 进入待投递队列的时间
+	QueuedAt time.Time `json:"queued_at,omitempty"`
+}
+
+// next 重复提醒的下一次触发时间, 跳过已经错过的周期
+func (r *Reminder) next(now time.Time) (time.Time, bool) {
+	if r.Repeat != RepeatDaily {
+		return time.Time{}, false
+	}
+	fireAt := r.FireAt.AddDate(0, 0, 1)
+	for !fireAt.After(now) {
+		fireAt = fireAt.AddDate(0, 0, 1)
+	}
+	return fireAt, true
+}
+
+// Store 提醒的持久化存储
+type Store interface {
+	// Add 新增或覆盖一条提醒
+	Add(ctx context.Context, r *Reminder) error
+	// Remove 删除设备的某条提醒, 不存在时返回 false
+	Remove(ctx context.Context, deviceId string, id string) (bool, error)
+	// List 设备所有未触发的提醒, 按触发时间排序
+	List(ctx context.Context, deviceId string) ([]*Reminder, error)
+	// PopDue 取出并删除已到点的提醒, 多个节点共用存储时每条只会被取出一次
+	PopDue(ctx context.Context, now time.Time, limit int) ([]*Reminder, error)
+	// AddPending 设备离线时暂存已到点的提醒
+	AddPending(ctx context.Context, r *Reminder) error
+	// PopPending 取出并删除设备暂存的提醒
+	PopPending(ctx context.Context, deviceId string) ([]*Reminder, error)
+}
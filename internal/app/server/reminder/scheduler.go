@@ -0,0 +1,254 @@
+package reminder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	DefaultCheckInterval = time.Second
+	DefaultMaxPerDevice  = 20
+	DefaultPendingExpire = 24 * time.Hour
+	DefaultOnlineDelay   = 3 * time.Second
+	// 每次检查最多取出的到点提醒数
+	popBatchSize = 100
+	// 单次投递的超时时间
+	deliverTimeout = 5 * time.Second
+)
+
+// DeliverFunc 把到点的提醒播报到设备, 返回错误时视为设备离线, 提醒进入待投递队列
+type DeliverFunc func(ctx context.Context, r *Reminder) error
+
+// Scheduler 定期检查到点的提醒并投递到设备
+type Scheduler struct {
+	store   Store
+	deliver DeliverFunc
+
+	checkInterval time.Duration
+	maxPerDevice  int
+	// 待投递的提醒超过该时间后丢弃, 设备很久之后才上线时不再播报过期的提醒
+	pendingExpire time.Duration
+	// 设备上线后等待握手完成再投递
+	onlineDelay time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type SchedulerOption func(*Scheduler)
+
+func WithCheckInterval(interval time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		if interval > 0 {
+			s.checkInterval = interval
+		}
+	}
+}
+
+// WithMaxPerDevice 每个设备最多的提醒数, <=0 表示不限制
+func WithMaxPerDevice(max int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.maxPerDevice = max
+	}
+}
+
+// WithPendingExpire 待投递提醒的有效期, <=0 表示不过期
+func WithPendingExpire(expire time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.pendingExpire = expire
+	}
+}
+
+func WithOnlineDelay(delay time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		if delay >= 0 {
+			s.onlineDelay = delay
+		}
+	}
+}
+
+func NewScheduler(store Store, deliver DeliverFunc, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		store:         store,
+		deliver:       deliver,
+		checkInterval: DefaultCheckInterval,
+		maxPerDevice:  DefaultMaxPerDevice,
+		pendingExpire: DefaultPendingExpire,
+		onlineDelay:   DefaultOnlineDelay,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.loop()
+	log.Infof("提醒调度已启动, 检查间隔 %v", s.checkInterval)
+}
+
+// Stop 停止调度, 已存储的提醒不受影响, 下次启动后继续触发
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Create 为设备创建一条提醒
+func (s *Scheduler) Create(ctx context.Context, deviceId string, content string, fireAt time.Time, repeat string) (*Reminder, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, fmt.Errorf("提醒内容不能为空")
+	}
+	if repeat != RepeatNone && repeat != RepeatDaily {
+		return nil, fmt.Errorf("不支持的重复方式: %s", repeat)
+	}
+	now := time.Now()
+	if !fireAt.After(now) {
+		return nil, fmt.Errorf("提醒时间 %s 已过", fireAt.Format("2006-01-02 15:04:05"))
+	}
+	if s.maxPerDevice > 0 {
+		list, err := s.store.List(ctx, deviceId)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) >= s.maxPerDevice {
+			return nil, ErrTooManyReminders
+		}
+	}
+
+	r := &Reminder{
+		Id:        strings.ReplaceAll(uuid.New().String(), "-", "")[:8],
+		DeviceId:  deviceId,
+		Content:   content,
+		FireAt:    fireAt,
+		Repeat:    repeat,
+		CreatedAt: now,
+	}
+	if err := s.store.Add(ctx, r); err != nil {
+		return nil, err
+	}
+	log.Infof("设备 %s 创建提醒 %s, 触发时间 %s, 内容: %s", deviceId, r.Id, fireAt.Format("2006-01-02 15:04:05"), content)
+	return r, nil
+}
+
+func (s *Scheduler) List(ctx context.Context, deviceId string) ([]*Reminder, error) {
+	return s.store.List(ctx, deviceId)
+}
+
+// Cancel 取消设备的提醒, 不存在或已触发时返回 ErrReminderNotFound
+func (s *Scheduler) Cancel(ctx context.Context, deviceId string, id string) error {
+	ok, err := s.store.Remove(ctx, deviceId, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrReminderNotFound
+	}
+	log.Infof("设备 %s 取消提醒 %s", deviceId, id)
+	return nil
+}
+
+// OnDeviceOnline 设备上线时投递离线期间到点的提醒
+func (s *Scheduler) OnDeviceOnline(deviceId string) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(s.onlineDelay):
+		}
+		s.deliverPending(deviceId)
+	}()
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.check(now)
+		}
+	}
+}
+
+// check 取出到点的提醒逐条投递
+func (s *Scheduler) check(now time.Time) {
+	for {
+		due, err := s.store.PopDue(s.ctx, now, popBatchSize)
+		if err != nil {
+			log.Errorf("获取到点的提醒失败: %v", err)
+			return
+		}
+		for _, r := range due {
+			s.fire(r, now)
+		}
+		if len(due) < popBatchSize {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) fire(r *Reminder, now time.Time) {
+	// 重复的提醒先排好下一次, 投递失败也不影响后续周期
+	if fireAt, ok := r.next(now); ok {
+		next := *r
+		next.FireAt = fireAt
+		if err := s.store.Add(s.ctx, &next); err != nil {
+			log.Errorf("设备 %s 提醒 %s 设置下次触发时间失败: %v", r.DeviceId, r.Id, err)
+		}
+	}
+
+	if err := s.deliverOne(r); err != nil {
+		log.Infof("设备 %s 提醒 %s 投递失败: %v, 等待设备上线后投递", r.DeviceId, r.Id, err)
+		r.QueuedAt = now
+		if err := s.store.AddPending(s.ctx, r); err != nil {
+			log.Errorf("设备 %s 提醒 %s 加入待投递队列失败: %v", r.DeviceId, r.Id, err)
+		}
+	}
+}
+
+func (s *Scheduler) deliverPending(deviceId string) {
+	list, err := s.store.PopPending(s.ctx, deviceId)
+	if err != nil {
+		log.Errorf("获取设备 %s 待投递的提醒失败: %v", deviceId, err)
+		return
+	}
+	now := time.Now()
+	for i, r := range list {
+		if s.pendingExpire > 0 && now.Sub(r.QueuedAt) > s.pendingExpire {
+			log.Infof("设备 %s 提醒 %s 已过期, 丢弃", deviceId, r.Id)
+			continue
+		}
+		if err := s.deliverOne(r); err != nil {
+			// 设备又断开了, 剩下的放回队列等下次上线
+			log.Infof("设备 %s 待投递提醒 %s 投递失败: %v", deviceId, r.Id, err)
+			for _, rest := range list[i:] {
+				if err := s.store.AddPending(s.ctx, rest); err != nil {
+					log.Errorf("设备 %s 提醒 %s 放回待投递队列失败: %v", deviceId, rest.Id, err)
+				}
+			}
+			return
+		}
+	}
+}
+
+func (s *Scheduler) deliverOne(r *Reminder) error {
+	ctx, cancel := context.WithTimeout(s.ctx, deliverTimeout)
+	defer cancel()
+	return s.deliver(ctx, r)
+}
@@ -0,0 +1,176 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDevices 模拟设备在线状态, 记录投递到设备的提醒
+type fakeDevices struct {
+	online    map[string]bool
+	delivered []string
+	mu        sync.Mutex
+}
+
+func (d *fakeDevices) setOnline(deviceId string, online bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.online[deviceId] = online
+}
+
+func (d *fakeDevices) deliver(ctx context.Context, r *Reminder) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.online[r.DeviceId] {
+		return errors.New("offline")
+	}
+	d.delivered = append(d.delivered, r.DeviceId+":"+r.Content)
+	return nil
+}
+
+func (d *fakeDevices) getDelivered() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.delivered...)
+}
+
+func newTestScheduler(opts ...SchedulerOption) (*Scheduler, *fakeDevices) {
+	devices := &fakeDevices{online: make(map[string]bool)}
+	opts = append([]SchedulerOption{WithOnlineDelay(0)}, opts...)
+	return NewScheduler(NewMemoryStore(), devices.deliver, opts...), devices
+}
+
+func TestScheduler_Fire(t *testing.T) {
+	s, devices := newTestScheduler()
+	ctx := context.Background()
+	devices.setOnline("dev1", true)
+
+	now := time.Now()
+	_, err := s.Create(ctx, "dev1", "喝水", now.Add(20*time.Minute), RepeatNone)
+	require.NoError(t, err)
+	r2, err := s.Create(ctx, "dev1", "开会", now.Add(10*time.Minute), RepeatNone)
+	require.NoError(t, err)
+
+	list, err := s.List(ctx, "dev1")
+	require.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, r2.Id, list[0].Id)
+	}
+
+	s.check(now.Add(15 * time.Minute))
+	assert.Equal(t, []string{"dev1:开会"}, devices.getDelivered())
+
+	s.check(now.Add(30 * time.Minute))
+	assert.Equal(t, []string{"dev1:开会", "dev1:喝水"}, devices.getDelivered())
+	list, _ = s.List(ctx, "dev1")
+	assert.Empty(t, list)
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s, devices := newTestScheduler()
+	ctx := context.Background()
+	devices.setOnline("dev1", true)
+
+	now := time.Now()
+	r, err := s.Create(ctx, "dev1", "喝水", now.Add(time.Minute), RepeatNone)
+	require.NoError(t, err)
+
+	// 不能取消其他设备的提醒
+	assert.ErrorIs(t, s.Cancel(ctx, "dev2", r.Id), ErrReminderNotFound)
+	assert.NoError(t, s.Cancel(ctx, "dev1", r.Id))
+	assert.ErrorIs(t, s.Cancel(ctx, "dev1", r.Id), ErrReminderNotFound)
+
+	s.check(now.Add(time.Hour))
+	assert.Empty(t, devices.getDelivered())
+}
+
+func TestScheduler_Create(t *testing.T) {
+	s, _ := newTestScheduler(WithMaxPerDevice(1))
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := s.Create(ctx, "dev1", " ", now.Add(time.Minute), RepeatNone)
+	assert.Error(t, err)
+	_, err = s.Create(ctx, "dev1", "喝水", now.Add(-time.Minute), RepeatNone)
+	assert.Error(t, err)
+	_, err = s.Create(ctx, "dev1", "喝水", now.Add(time.Minute), "weekly")
+	assert.Error(t, err)
+
+	_, err = s.Create(ctx, "dev1", "喝水", now.Add(time.Minute), RepeatNone)
+	require.NoError(t, err)
+	_, err = s.Create(ctx, "dev1", "开会", now.Add(time.Minute), RepeatNone)
+	assert.ErrorIs(t, err, ErrTooManyReminders)
+}
+
+func TestScheduler_Offline(t *testing.T) {
+	s, devices := newTestScheduler(WithPendingExpire(time.Hour))
+	ctx := context.Background()
+
+	now := time.Now()
+	_, err := s.Create(ctx, "dev1", "喝水", now.Add(time.Minute), RepeatNone)
+	require.NoError(t, err)
+	_, err = s.Create(ctx, "dev1", "开会", now.Add(2*time.Minute), RepeatNone)
+	require.NoError(t, err)
+
+	// 离线时到点, 进入待投递队列
+	s.check(now.Add(3 * time.Minute))
+	assert.Empty(t, devices.getDelivered())
+
+	devices.setOnline("dev1", true)
+	s.OnDeviceOnline("dev1")
+	assert.Eventually(t, func() bool {
+		return len(devices.getDelivered()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"dev1:喝水", "dev1:开会"}, devices.getDelivered())
+
+	// 已投递的不会重复投递
+	s.deliverPending("dev1")
+	assert.Len(t, devices.getDelivered(), 2)
+}
+
+func TestScheduler_PendingExpire(t *testing.T) {
+	s, devices := newTestScheduler(WithPendingExpire(time.Hour))
+	ctx := context.Background()
+
+	now := time.Now()
+	_, err := s.Create(ctx, "dev1", "喝水", now.Add(time.Minute), RepeatNone)
+	require.NoError(t, err)
+	s.check(now.Add(2 * time.Minute))
+
+	devices.setOnline("dev1", true)
+	s.deliverPending("dev1")
+	assert.Equal(t, []string{"dev1:喝水"}, devices.getDelivered())
+
+	// 排队超过有效期的直接丢弃
+	require.NoError(t, s.store.AddPending(ctx, &Reminder{Id: "old", DeviceId: "dev1", Content: "过期", QueuedAt: now.Add(-2 * time.Hour)}))
+	s.deliverPending("dev1")
+	assert.Equal(t, []string{"dev1:喝水"}, devices.getDelivered())
+}
+
+func TestScheduler_RepeatDaily(t *testing.T) {
+	s, devices := newTestScheduler()
+	ctx := context.Background()
+	devices.setOnline("dev1", true)
+
+	now := time.Now()
+	fireAt := now.Add(time.Hour)
+	r, err := s.Create(ctx, "dev1", "起床", fireAt, RepeatDaily)
+	require.NoError(t, err)
+
+	// 错过了两天, 只补播一次, 下次触发时间在当前之后
+	s.check(fireAt.AddDate(0, 0, 2).Add(time.Minute))
+	assert.Equal(t, []string{"dev1:起床"}, devices.getDelivered())
+
+	list, err := s.List(ctx, "dev1")
+	require.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, r.Id, list[0].Id)
+		assert.True(t, list[0].FireAt.Equal(fireAt.AddDate(0, 0, 3)))
+	}
+}
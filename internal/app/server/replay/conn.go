@@ -0,0 +1,215 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// Conn 实现 types.IConn, 代替真实设备连接:
+// 上行的信令和音频由回放脚本注入, 下发的信令和音频全部记录下来供断言
+type Conn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	deviceID      string
+	transportType string
+
+	cmdChan   chan []byte
+	audioChan chan []byte
+
+	onCloseCbList []func(deviceId string)
+	closeOnce     sync.Once
+
+	messages    []msg.ServerMessage
+	audioFrames [][]byte
+	// 每次有新的下发内容时关闭并重建, 用于唤醒等待方
+	updated chan struct{}
+
+	closed bool
+	sync.Mutex
+}
+
+func NewConn(deviceID string, transportType string) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	if transportType == "" {
+		transportType = types.TransportTypeWebsocket
+	}
+	return &Conn{
+		ctx:           ctx,
+		cancel:        cancel,
+		deviceID:      deviceID,
+		transportType: transportType,
+		cmdChan:       make(chan []byte, 100),
+		audioChan:     make(chan []byte, 500),
+		updated:       make(chan struct{}),
+	}
+}
+
+// PushCmd 注入一条设备上行的信令
+func (c *Conn) PushCmd(data []byte) error {
+	select {
+	case c.cmdChan <- data:
+		return nil
+	case <-c.ctx.Done():
+		return errors.New("connection is closed")
+	}
+}
+
+// PushAudio 注入一帧设备上行的 opus 音频, 空帧表示传输层判定的丢帧
+func (c *Conn) PushAudio(frame []byte) error {
+	select {
+	case c.audioChan <- frame:
+		return nil
+	case <-c.ctx.Done():
+		return errors.New("connection is closed")
+	}
+}
+
+// Messages 已下发的全部信令
+func (c *Conn) Messages() []msg.ServerMessage {
+	c.Lock()
+	defer c.Unlock()
+	return append([]msg.ServerMessage(nil), c.messages...)
+}
+
+// AudioFrames 已下发的全部 tts 音频帧
+func (c *Conn) AudioFrames() [][]byte {
+	c.Lock()
+	defer c.Unlock()
+	return append([][]byte(nil), c.audioFrames...)
+}
+
+// WaitMessage 等待第 from 条(含)之后出现匹配 expect 的信令, 返回其下标
+func (c *Conn) WaitMessage(ctx context.Context, from int, expect Expect) (int, error) {
+	for {
+		c.Lock()
+		for i := from; i < len(c.messages); i++ {
+			if expect.Match(c.messages[i]) {
+				c.Unlock()
+				return i, nil
+			}
+		}
+		updated := c.updated
+		c.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		}
+	}
+}
+
+// Done 连接被会话侧关闭时触发
+func (c *Conn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// NotifyClose 模拟设备断开连接
+func (c *Conn) NotifyClose() {
+	c.closeOnce.Do(func() {
+		for _, cb := range c.onCloseCbList {
+			cb(c.deviceID)
+		}
+	})
+}
+
+func (c *Conn) notifyUpdated() {
+	close(c.updated)
+	c.updated = make(chan struct{})
+}
+
+func (c *Conn) SendCmd(data []byte) error {
+	var serverMsg msg.ServerMessage
+	if err := json.Unmarshal(data, &serverMsg); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return errors.New("connection is closed")
+	}
+	log.Debugf("回放设备 %s 收到信令: %s", c.deviceID, string(data))
+	c.messages = append(c.messages, serverMsg)
+	c.notifyUpdated()
+	return nil
+}
+
+func (c *Conn) SendAudio(audio []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return errors.New("connection is closed")
+	}
+	c.audioFrames = append(c.audioFrames, append([]byte(nil), audio...))
+	return nil
+}
+
+func (c *Conn) RecvCmd(ctx context.Context, timeout int) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, errors.New("connection is closed")
+	case data := <-c.cmdChan:
+		return data, nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (c *Conn) RecvAudio(ctx context.Context, timeout int) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, errors.New("connection is closed")
+	case data := <-c.audioChan:
+		return data, nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (c *Conn) GetDeviceID() string {
+	return c.deviceID
+}
+
+func (c *Conn) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.cancel()
+	c.notifyUpdated()
+	return nil
+}
+
+func (c *Conn) OnClose(cb func(deviceId string)) {
+	c.onCloseCbList = append(c.onCloseCbList, cb)
+}
+
+func (c *Conn) CloseAudioChannel() error {
+	return nil
+}
+
+func (c *Conn) GetTransportType() string {
+	return c.transportType
+}
+
+func (c *Conn) GetData(key string) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+var _ types.IConn = (*Conn)(nil)
@@ -0,0 +1,228 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/auth"
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+	"xiaozhi-esp32-server-golang/internal/domain/mcp"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 服务启动时初始化的全局依赖, 回放不经过 App 启动流程, 在首次回放前初始化
+var initOnce sync.Once
+
+// Runner 用 Conn 和桩提供者驱动一个真实的 ChatManager 执行回放脚本
+type Runner struct {
+	script *Script
+	// 回放速度倍数, <=0 表示不等待
+	speed   float64
+	timeout time.Duration
+
+	chatManagerOpts []chat.ChatManagerOption
+}
+
+type RunnerOption func(*Runner)
+
+// WithSpeed 按录制时的节奏加速回放, 1 为实时, <=0 表示步骤间和音频帧间都不等待
+func WithSpeed(speed float64) RunnerOption {
+	return func(r *Runner) {
+		r.speed = speed
+	}
+}
+
+// WithTimeout 等待下发信令的默认超时
+func WithTimeout(timeout time.Duration) RunnerOption {
+	return func(r *Runner) {
+		r.timeout = timeout
+	}
+}
+
+// WithChatManagerOptions 透传 ChatManager 的配置项
+func WithChatManagerOptions(opts ...chat.ChatManagerOption) RunnerOption {
+	return func(r *Runner) {
+		r.chatManagerOpts = append(r.chatManagerOpts, opts...)
+	}
+}
+
+func NewRunner(script *Script, opts ...RunnerOption) *Runner {
+	r := &Runner{
+		script:  script,
+		speed:   1,
+		timeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Result 回放结束时设备侧收到的全部内容, 以及桩提供者的调用记录
+type Result struct {
+	Messages    []msg.ServerMessage
+	AudioFrames [][]byte
+
+	Asr *StubAsr
+	Llm *StubLlm
+	Tts *StubTts
+}
+
+// Match 检查 expects 是否按顺序出现在下发的信令中, 中间允许有其他信令
+func (r *Result) Match(expects ...Expect) error {
+	i := 0
+	for _, m := range r.Messages {
+		if i < len(expects) && expects[i].Match(m) {
+			i++
+		}
+	}
+	if i == len(expects) {
+		return nil
+	}
+	return fmt.Errorf("未收到期望的信令 %s, 实际收到: %s", expects[i], r.describe())
+}
+
+// Count 匹配 expect 的信令数
+func (r *Result) Count(expect Expect) int {
+	count := 0
+	for _, m := range r.Messages {
+		if expect.Match(m) {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *Result) describe() string {
+	list := make([]string, 0, len(r.Messages))
+	for _, m := range r.Messages {
+		list = append(list, Expect{Type: m.Type, State: m.State, Text: m.Text}.String())
+	}
+	return "[" + strings.Join(list, ", ") + "]"
+}
+
+// Run 执行脚本中的全部步骤, 再等待脚本中期望的信令全部出现, 结束后关闭会话
+// 返回的 error 不为 nil 时 Result 中仍是已收到的内容, 便于定位
+func (r *Runner) Run(ctx context.Context) (*Result, error) {
+	initOnce.Do(func() {
+		if auth.A() == nil {
+			auth.Init()
+		}
+		// 只创建管理器不连接 MCP 服务, 会话中获取工具时为空
+		mcp.GetGlobalMCPManager()
+	})
+
+	script := r.script
+	deviceID := script.DeviceId
+	if deviceID == "" {
+		deviceID = "replay-device"
+	}
+
+	conn := NewConn(deviceID, script.Transport)
+	result := &Result{
+		Asr: NewStubAsr(script.Asr),
+		Llm: NewStubLlm(script.Llm),
+		Tts: NewStubTts(script.Tts),
+	}
+
+	opts := []chat.ChatManagerOption{
+		chat.WithDeviceConfig(script.deviceConfig()),
+		chat.WithChatSessionOptions(
+			chat.WithAsrProvider(result.Asr),
+			chat.WithLlmProvider(result.Llm),
+			chat.WithTtsProvider(result.Tts),
+		),
+	}
+	chatManager, err := chat.NewChatManager(deviceID, conn, append(opts, r.chatManagerOpts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("创建会话失败: %v", err)
+	}
+	go func() {
+		if err := chatManager.Start(); err != nil {
+			log.Errorf("回放设备 %s 会话启动失败: %v", deviceID, err)
+		}
+	}()
+	defer chatManager.Close()
+
+	err = r.run(ctx, conn)
+	result.Messages = conn.Messages()
+	result.AudioFrames = conn.AudioFrames()
+	return result, err
+}
+
+func (r *Runner) run(ctx context.Context, conn *Conn) error {
+	script := r.script
+	frameDuration := time.Duration(script.audioParams().FrameDuration) * time.Millisecond
+	// 下一次等待从这条信令开始匹配
+	cursor := 0
+
+	for i, step := range script.Steps {
+		if !sleepCtx(ctx, r.scale(time.Duration(step.DelayMs)*time.Millisecond)) {
+			return ctx.Err()
+		}
+
+		switch {
+		case step.Cmd != nil:
+			if err := conn.PushCmd(step.Cmd); err != nil {
+				return fmt.Errorf("第 %d 步发送信令失败: %v", i, err)
+			}
+		case step.Lost > 0:
+			for n := 0; n < step.Lost; n++ {
+				if err := conn.PushAudio(nil); err != nil {
+					return fmt.Errorf("第 %d 步发送音频失败: %v", i, err)
+				}
+			}
+		case step.Wait != nil:
+			timeout := r.timeout
+			if step.TimeoutMs > 0 {
+				timeout = time.Duration(step.TimeoutMs) * time.Millisecond
+			}
+			waitCtx, cancel := context.WithTimeout(ctx, timeout)
+			index, err := conn.WaitMessage(waitCtx, cursor, *step.Wait)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("第 %d 步等待信令 %s 失败: %v", i, step.Wait, err)
+			}
+			cursor = index + 1
+		case step.Disconnect:
+			conn.NotifyClose()
+		default:
+			frames, err := script.frames(step)
+			if err != nil {
+				return fmt.Errorf("第 %d 步读取音频失败: %v", i, err)
+			}
+			for n, frame := range frames {
+				if n > 0 && !sleepCtx(ctx, r.scale(frameDuration)) {
+					return ctx.Err()
+				}
+				if err := conn.PushAudio(frame); err != nil {
+					return fmt.Errorf("第 %d 步发送音频失败: %v", i, err)
+				}
+			}
+		}
+	}
+
+	cursor = 0
+	for _, expect := range script.Expect {
+		waitCtx, cancel := context.WithTimeout(ctx, r.timeout)
+		index, err := conn.WaitMessage(waitCtx, cursor, expect)
+		cancel()
+		if err != nil {
+			result := &Result{Messages: conn.Messages()}
+			return fmt.Errorf("未收到期望的信令 %s: %v, 实际收到: %s", expect, err, result.describe())
+		}
+		cursor = index + 1
+	}
+	return nil
+}
+
+func (r *Runner) scale(d time.Duration) time.Duration {
+	if r.speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(d) / r.speed)
+}
@@ -0,0 +1,214 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/recorder"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	types_audio "xiaozhi-esp32-server-golang/internal/data/audio"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+	config_types "xiaozhi-esp32-server-golang/internal/domain/config/types"
+)
+
+var testAudioParams = types_audio.AudioFormat{Format: "opus", SampleRate: 16000, Channels: 1, FrameDuration: 60}
+
+func TestRunner_ScriptFile(t *testing.T) {
+	script, err := LoadScript("testdata/manual_turn.json")
+	require.NoError(t, err)
+
+	result, err := NewRunner(script).Run(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "你好呀，今天过得怎么样？", strings.Join(result.Tts.Texts(), ""))
+	assert.Equal(t, 1, result.Asr.Consumed())
+	if requests := result.Llm.Requests(); assert.Len(t, requests, 1) {
+		last := requests[0][len(requests[0])-1]
+		assert.Equal(t, "你好", last.Content)
+	}
+	assert.NotEmpty(t, result.AudioFrames)
+}
+
+func TestRunner_AbortDuringTts(t *testing.T) {
+	script := &Script{
+		DeviceId:    "replay-abort",
+		AudioParams: testAudioParams,
+		Asr:         []AsrTurn{{Text: "讲个故事"}},
+		Llm:         []LlmTurn{{Chunks: []string{"从前有座山。", "山里有座庙。"}, ChunkDelayMs: 2000}},
+		Steps: []Step{
+			Hello(types.TransportTypeWebsocket, testAudioParams),
+			Wait(Expect{Type: msg.ServerMessageTypeHello}),
+			ListenStart("manual"),
+			{DelayMs: 100, SilenceFrames: 5},
+			ListenStop(),
+			Wait(Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart}),
+			Abort(),
+			// 等过第二句原本的生成时间
+			{DelayMs: 2500},
+		},
+	}
+
+	result, err := NewRunner(script).Run(context.Background())
+	require.NoError(t, err)
+
+	assert.NoError(t, result.Match(
+		Expect{Type: msg.ServerMessageTypeStt, Text: "讲个故事"},
+		Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "从前有座山。"},
+		Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateStop},
+	))
+	assert.Equal(t, 0, result.Count(Expect{Type: msg.ServerMessageTypeTts, Text: "山里有座庙。"}))
+	assert.Equal(t, []string{"从前有座山。"}, result.Tts.Texts())
+}
+
+func TestRunner_RealtimeInterrupt(t *testing.T) {
+	viper.Set("chat.realtime_mode", 2)
+	defer viper.Set("chat.realtime_mode", nil)
+
+	script := &Script{
+		DeviceId:    "replay-realtime",
+		AudioParams: testAudioParams,
+		Config: &config_types.UConfig{
+			Asr: config_types.AsrConfig{Provider: "replay", Config: map[string]interface{}{"auto_end": true}},
+			Llm: config_types.LlmConfig{Provider: "replay", Config: map[string]interface{}{"type": "replay"}},
+			Tts: config_types.TtsConfig{Provider: "replay"},
+		},
+		Asr: []AsrTurn{{Text: "今天天气怎么样", AfterFrames: 5}, {Text: "明天呢", AfterFrames: 5}},
+		Llm: []LlmTurn{
+			{Chunks: []string{"今天晴天。", "气温二十度。"}, ChunkDelayMs: 3000},
+			{Chunks: []string{"明天下雨。"}},
+		},
+		Steps: []Step{
+			Hello(types.TransportTypeWebsocket, testAudioParams),
+			Wait(Expect{Type: msg.ServerMessageTypeHello}),
+			ListenStart("realtime"),
+			{DelayMs: 100, SilenceFrames: 20},
+			Wait(Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "明天下雨。"}),
+		},
+		Expect: []Expect{{Type: msg.ServerMessageTypeTts, State: msg.MessageStateStop}},
+	}
+
+	result, err := NewRunner(script).Run(context.Background())
+	require.NoError(t, err)
+
+	assert.NoError(t, result.Match(
+		Expect{Type: msg.ServerMessageTypeStt, Text: "今天天气怎么样"},
+		Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "今天晴天。"},
+		Expect{Type: msg.ServerMessageTypeStt, Text: "明天呢"},
+		Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "明天下雨。"},
+	))
+	// 第二句话打断了第一轮回复
+	assert.Equal(t, 0, result.Count(Expect{Type: msg.ServerMessageTypeTts, Text: "气温二十度。"}))
+	assert.Len(t, result.Llm.Requests(), 2)
+}
+
+func TestRunner_RealtimeStopPhrase(t *testing.T) {
+	viper.Set("stop_phrases", []string{"别说了"})
+	defer viper.Set("stop_phrases", nil)
+
+	script := &Script{
+		DeviceId:    "replay-stop-phrase",
+		AudioParams: testAudioParams,
+		Config: &config_types.UConfig{
+			Asr: config_types.AsrConfig{Provider: "replay", Config: map[string]interface{}{"auto_end": true}},
+			Llm: config_types.LlmConfig{Provider: "replay", Config: map[string]interface{}{"type": "replay"}},
+			Tts: config_types.TtsConfig{Provider: "replay"},
+		},
+		Asr: []AsrTurn{{Text: "讲个故事", AfterFrames: 5}, {Text: "别说了", AfterFrames: 5}, {Text: "今天天气怎么样", AfterFrames: 5}},
+		Llm: []LlmTurn{
+			{Chunks: []string{"从前有座山。", "山里有座庙。"}, ChunkDelayMs: 3000},
+			{Chunks: []string{"今天晴天。"}},
+		},
+		// 每句播放 1.2s, 停止词在第一句播放过程中识别出来
+		Tts: TtsScript{FramesPerSentence: 20},
+		Steps: []Step{
+			Hello(types.TransportTypeWebsocket, testAudioParams),
+			Wait(Expect{Type: msg.ServerMessageTypeHello}),
+			ListenStart("realtime"),
+			{DelayMs: 100, SilenceFrames: 20},
+			Wait(Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "今天晴天。"}),
+		},
+	}
+
+	result, err := NewRunner(script).Run(context.Background())
+	require.NoError(t, err)
+
+	assert.NoError(t, result.Match(
+		Expect{Type: msg.ServerMessageTypeStt, Text: "讲个故事"},
+		Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "从前有座山。"},
+		Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateStop},
+		// 停止后继续识别下一句
+		Expect{Type: msg.ServerMessageTypeStt, Text: "今天天气怎么样"},
+		Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "今天晴天。"},
+	))
+	assert.Equal(t, 0, result.Count(Expect{Type: msg.ServerMessageTypeTts, Text: "山里有座庙。"}))
+	// 停止词不发给设备, 也不发给 llm
+	assert.Equal(t, 0, result.Count(Expect{Type: msg.ServerMessageTypeStt, Text: "别说了"}))
+	assert.Len(t, result.Llm.Requests(), 2)
+	assert.Equal(t, 3, result.Asr.Consumed())
+}
+
+func TestRunner_WaitTimeout(t *testing.T) {
+	script := &Script{
+		DeviceId:    "replay-timeout",
+		AudioParams: testAudioParams,
+		Steps: []Step{
+			Hello(types.TransportTypeWebsocket, testAudioParams),
+			Wait(Expect{Type: msg.ServerMessageTypeStt}),
+		},
+	}
+
+	result, err := NewRunner(script, WithTimeout(300*time.Millisecond)).Run(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, result.Count(Expect{Type: msg.ServerMessageTypeHello}))
+}
+
+func TestLoadRecording(t *testing.T) {
+	rec, err := recorder.New(t.TempDir(), "ba:8f:17:de:94:94", nil)
+	require.NoError(t, err)
+
+	hello, _ := json.Marshal(map[string]interface{}{
+		"type":         "hello",
+		"transport":    "udp",
+		"audio_params": map[string]interface{}{"format": "opus", "sample_rate": 16000, "channels": 1, "frame_duration": 20},
+	})
+	rec.Event(recorder.EventCmdIn, map[string]interface{}{"data": string(hello)})
+	rec.SetAudioFormat(recorder.AudioFormat{SampleRate: 16000, Channels: 1}, recorder.AudioFormat{SampleRate: 24000, Channels: 1})
+	rec.Event(recorder.EventCmdIn, map[string]interface{}{"data": `{"type":"listen","state":"start","mode":"manual"}`})
+	rec.StartTurn()
+	rec.AudioIn([]byte{0x48, 1})
+	rec.AudioIn(nil)
+	rec.AudioIn([]byte{0x48, 2})
+	rec.Event(recorder.EventAsrFinal, map[string]interface{}{"text": "你好"})
+	rec.Event(recorder.EventLlmMessage, map[string]interface{}{"role": "user", "content": "你好"})
+	rec.Event(recorder.EventTtsStart, map[string]interface{}{"text": "你好呀。"})
+	rec.Event(recorder.EventLlmMessage, map[string]interface{}{"role": "assistant", "content": "你好呀。"})
+	require.NoError(t, rec.Close())
+
+	script, err := LoadRecording(rec.Dir())
+	require.NoError(t, err)
+
+	assert.Equal(t, "ba:8f:17:de:94:94", script.DeviceId)
+	assert.Equal(t, 20, script.AudioParams.FrameDuration)
+	assert.Equal(t, []AsrTurn{{Text: "你好"}}, script.Asr)
+	assert.Equal(t, []LlmTurn{{Chunks: []string{"你好呀。"}}}, script.Llm)
+	assert.Equal(t, []Expect{
+		{Type: msg.ServerMessageTypeStt, Text: "你好"},
+		{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "你好呀。"},
+	}, script.Expect)
+
+	if assert.Len(t, script.Steps, 5) {
+		var cmd map[string]interface{}
+		require.NoError(t, json.Unmarshal(script.Steps[0].Cmd, &cmd))
+		assert.Equal(t, types.TransportTypeWebsocket, cmd["transport"])
+		assert.Equal(t, [][]byte{{0x48, 1}}, script.Steps[2].Frames)
+		assert.Equal(t, 1, script.Steps[3].Lost)
+		assert.Equal(t, [][]byte{{0x48, 2}}, script.Steps[4].Frames)
+	}
+}
@@ -0,0 +1,323 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"xiaozhi-esp32-server-golang/constants"
+	"xiaozhi-esp32-server-golang/internal/app/server/recorder"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	types_audio "xiaozhi-esp32-server-golang/internal/data/audio"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+	config_types "xiaozhi-esp32-server-golang/internal/domain/config/types"
+)
+
+// Script 回放脚本: 设备侧按顺序执行的步骤, 桩 asr/llm/tts 的返回内容, 以及期望收到的下发信令
+type Script struct {
+	DeviceId  string `json:"device_id"`
+	Transport string `json:"transport,omitempty"`
+	// 上行音频参数, 决定按什么节奏发送音频帧
+	AudioParams types_audio.AudioFormat `json:"audio_params"`
+	// 设备配置, 为空时使用桩提供者的默认配置
+	Config *config_types.UConfig `json:"config,omitempty"`
+
+	Asr []AsrTurn `json:"asr,omitempty"`
+	Llm []LlmTurn `json:"llm,omitempty"`
+	Tts TtsScript `json:"tts,omitempty"`
+
+	Steps []Step `json:"steps"`
+	// 按顺序出现即可, 中间允许有其他信令
+	Expect []Expect `json:"expect,omitempty"`
+
+	// 脚本文件所在目录, 用于解析音频文件的相对路径
+	dir string
+}
+
+// AsrTurn 一次识别的结果
+type AsrTurn struct {
+	Text string `json:"text"`
+	// 收到多少帧音频后直接返回结果(模拟 asr 自动断句), 0 表示等音频输入结束
+	AfterFrames int `json:"after_frames,omitempty"`
+	DelayMs     int `json:"delay_ms,omitempty"`
+}
+
+// LlmTurn 一次请求的回复, 按分片流式返回
+type LlmTurn struct {
+	Chunks       []string `json:"chunks"`
+	ChunkDelayMs int      `json:"chunk_delay_ms,omitempty"`
+}
+
+// TtsScript 桩 tts 的合成参数
+type TtsScript struct {
+	FramesPerSentence int `json:"frames_per_sentence,omitempty"`
+	FrameDelayMs      int `json:"frame_delay_ms,omitempty"`
+}
+
+// Step 设备侧的一个动作, 先等待 DelayMs 再执行, 每步只填一种动作
+type Step struct {
+	DelayMs int `json:"delay_ms,omitempty"`
+
+	// 上行信令
+	Cmd json.RawMessage `json:"cmd,omitempty"`
+	// 上行音频: ogg/opus 文件、若干帧静音或原始 opus 帧, 按帧时长的节奏发送
+	Audio         string   `json:"audio,omitempty"`
+	SilenceFrames int      `json:"silence_frames,omitempty"`
+	Frames        [][]byte `json:"frames,omitempty"`
+	// 传输层丢帧
+	Lost int `json:"lost,omitempty"`
+	// 等待下发匹配的信令, 从上一次等到的信令之后开始匹配
+	Wait      *Expect `json:"wait,omitempty"`
+	TimeoutMs int     `json:"timeout_ms,omitempty"`
+	// 模拟设备断开连接
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+// Expect 下发信令的匹配条件, 空字段不参与匹配
+type Expect struct {
+	Type  string `json:"type"`
+	State string `json:"state,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+func (e Expect) Match(m msg.ServerMessage) bool {
+	return (e.Type == "" || e.Type == m.Type) &&
+		(e.State == "" || e.State == m.State) &&
+		(e.Text == "" || e.Text == m.Text)
+}
+
+func (e Expect) String() string {
+	s := e.Type
+	if e.State != "" {
+		s += "/" + e.State
+	}
+	if e.Text != "" {
+		s += fmt.Sprintf("(%s)", e.Text)
+	}
+	return s
+}
+
+// Cmd 构造发送信令的步骤
+func Cmd(v interface{}) Step {
+	data, _ := json.Marshal(v)
+	return Step{Cmd: data}
+}
+
+func Hello(transport string, audioParams types_audio.AudioFormat) Step {
+	return Cmd(map[string]interface{}{
+		"type":         msg.MessageTypeHello,
+		"version":      1,
+		"transport":    transport,
+		"audio_params": audioParams,
+	})
+}
+
+func ListenStart(mode string) Step {
+	return Cmd(map[string]interface{}{"type": msg.MessageTypeListen, "state": msg.MessageStateStart, "mode": mode})
+}
+
+func ListenStop() Step {
+	return Cmd(map[string]interface{}{"type": msg.MessageTypeListen, "state": msg.MessageStateStop})
+}
+
+func Abort() Step {
+	return Cmd(map[string]interface{}{"type": msg.MessageTypeAbort})
+}
+
+func Silence(frames int) Step {
+	return Step{SilenceFrames: frames}
+}
+
+func Wait(expect Expect) Step {
+	return Step{Wait: &expect}
+}
+
+// LoadScript 读取 json 格式的回放脚本
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script Script
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("解析回放脚本 %s 失败: %v", path, err)
+	}
+	script.dir = filepath.Dir(path)
+	return &script, nil
+}
+
+func (s *Script) deviceConfig() config_types.UConfig {
+	if s.Config != nil {
+		return *s.Config
+	}
+	return config_types.UConfig{
+		SystemPrompt: "你是一个回放测试用的助手",
+		Asr:          config_types.AsrConfig{Provider: "replay"},
+		Llm:          config_types.LlmConfig{Provider: "replay", Config: map[string]interface{}{"type": "replay"}},
+		Tts:          config_types.TtsConfig{Provider: "replay"},
+		Vad:          config_types.VadConfig{Provider: constants.VadTypeWebRTCVad},
+	}
+}
+
+func (s *Script) audioParams() types_audio.AudioFormat {
+	params := s.AudioParams
+	if params.SampleRate == 0 {
+		params.SampleRate = 16000
+	}
+	if params.Channels == 0 {
+		params.Channels = 1
+	}
+	if params.FrameDuration == 0 {
+		params.FrameDuration = 60
+	}
+	if params.Format == "" {
+		params.Format = "opus"
+	}
+	return params
+}
+
+// frames 取出一步中要发送的音频帧
+func (s *Script) frames(step Step) ([][]byte, error) {
+	switch {
+	case step.Audio != "":
+		path := step.Audio
+		if !filepath.IsAbs(path) && s.dir != "" {
+			path = filepath.Join(s.dir, path)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		packets, _, _, err := recorder.ReadOggOpus(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取音频 %s 失败: %v", path, err)
+		}
+		return packets, nil
+	case step.SilenceFrames > 0:
+		frames := make([][]byte, step.SilenceFrames)
+		for i := range frames {
+			frames[i] = SilenceFrame(s.audioParams().FrameDuration)
+		}
+		return frames, nil
+	}
+	return step.Frames, nil
+}
+
+// LoadRecording 把会话录制目录转换为回放脚本:
+// 上行信令和音频按录制时的间隔回放, 录到的识别结果和 llm 回复作为桩的返回内容,
+// 识别结果和 tts 句子作为期望的下发信令. 工具调用不会回放, 只保留最终的文本回复
+func LoadRecording(dir string) (*Script, error) {
+	file, err := os.Open(filepath.Join(dir, "events.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	script := &Script{Transport: types.TransportTypeWebsocket, dir: dir}
+	tracks := make(map[string][][]byte)
+	var lastOffset int64
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var event recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("解析录制事件失败: %v", err)
+		}
+		delay := int(event.OffsetMs - lastOffset)
+
+		switch event.Type {
+		case recorder.EventSessionStart:
+			script.DeviceId = event.DeviceId
+		case recorder.EventAudioFormat:
+			script.AudioParams.SampleRate = event.Input.SampleRate
+			script.AudioParams.Channels = event.Input.Channels
+		case recorder.EventCmdIn:
+			cmd, err := rewriteHello(event.Data, script)
+			if err != nil {
+				return nil, err
+			}
+			script.Steps = append(script.Steps, Step{DelayMs: delay, Cmd: cmd})
+			lastOffset = event.OffsetMs
+		case recorder.EventAudioIn:
+			packets, ok := tracks[event.File]
+			if !ok {
+				packets, err = readTrack(filepath.Join(dir, event.File))
+				if err != nil {
+					return nil, err
+				}
+				tracks[event.File] = packets
+			}
+			if event.Seq < 1 || event.Seq > len(packets) {
+				return nil, fmt.Errorf("录制音频 %s 缺少第 %d 帧", event.File, event.Seq)
+			}
+			script.Steps = append(script.Steps, Step{DelayMs: delay, Frames: [][]byte{packets[event.Seq-1]}})
+			lastOffset = event.OffsetMs
+		case recorder.EventAudioLost:
+			script.Steps = append(script.Steps, Step{DelayMs: delay, Lost: 1})
+			lastOffset = event.OffsetMs
+		case recorder.EventAsrFinal:
+			script.Asr = append(script.Asr, AsrTurn{Text: event.Text})
+			script.Expect = append(script.Expect, Expect{Type: msg.ServerMessageTypeStt, Text: event.Text})
+		case recorder.EventLlmMessage:
+			if event.Role == "assistant" && event.Content != "" {
+				script.Llm = append(script.Llm, LlmTurn{Chunks: []string{event.Content}})
+			}
+		case recorder.EventTtsStart:
+			script.Expect = append(script.Expect, Expect{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: event.Text})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// recordedEvent 回放用到的录制事件字段
+type recordedEvent struct {
+	Type     string `json:"type"`
+	OffsetMs int64  `json:"offset_ms"`
+
+	DeviceId string               `json:"device_id"`
+	Input    recorder.AudioFormat `json:"input"`
+	Data     string               `json:"data"`
+	File     string               `json:"file"`
+	Seq      int                  `json:"seq"`
+	Text     string               `json:"text"`
+	Role     string               `json:"role"`
+	Content  string               `json:"content"`
+}
+
+func readTrack(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	packets, _, _, err := recorder.ReadOggOpus(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取录制音频 %s 失败: %v", path, err)
+	}
+	return packets, nil
+}
+
+// rewriteHello 回放统一走 websocket 流程, mqtt-udp 的 hello 改写为 websocket, 同时取出帧时长
+func rewriteHello(data string, script *Script) (json.RawMessage, error) {
+	var cmd map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &cmd); err != nil {
+		return nil, fmt.Errorf("解析录制信令失败: %v, %s", err, data)
+	}
+	if cmd["type"] != msg.MessageTypeHello {
+		return json.RawMessage(data), nil
+	}
+	cmd["transport"] = types.TransportTypeWebsocket
+	if params, ok := cmd["audio_params"].(map[string]interface{}); ok {
+		if frameDuration, ok := params["frame_duration"].(float64); ok {
+			script.AudioParams.FrameDuration = int(frameDuration)
+		}
+	}
+	return json.Marshal(cmd)
+}
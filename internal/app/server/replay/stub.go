@@ -0,0 +1,230 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+// SilenceFrame 只有 TOC 字节的 opus 包(SILK 宽带单声道), 解码器按丢帧补偿输出静音
+func SilenceFrame(frameDuration int) []byte {
+	// SILK WB 的 config 8~11 分别对应 10/20/40/60ms
+	config := byte(11)
+	switch frameDuration {
+	case 10:
+		config = 8
+	case 20:
+		config = 9
+	case 40:
+		config = 10
+	}
+	return []byte{config << 3}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// StubAsr 按脚本顺序返回识别结果, 每次流式识别消耗一条
+type StubAsr struct {
+	turns []AsrTurn
+	next  int
+	mu    sync.Mutex
+}
+
+func NewStubAsr(turns []AsrTurn) *StubAsr {
+	return &StubAsr{turns: turns}
+}
+
+func (s *StubAsr) peek() AsrTurn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next < len(s.turns) {
+		return s.turns[s.next]
+	}
+	return AsrTurn{}
+}
+
+func (s *StubAsr) pop() AsrTurn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next < len(s.turns) {
+		s.next++
+		return s.turns[s.next-1]
+	}
+	return AsrTurn{}
+}
+
+// Consumed 已返回的识别结果数
+func (s *StubAsr) Consumed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next
+}
+
+func (s *StubAsr) Process(pcmData []float32) (string, error) {
+	return s.pop().Text, nil
+}
+
+// StreamingRecognize 音频输入结束(静音或手动停止)时返回结果, 设置了 AfterFrames 时收到足够音频即返回
+// 识别被取消时不消耗脚本中的结果
+func (s *StubAsr) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan asr_types.StreamingResult, error) {
+	afterFrames := s.peek().AfterFrames
+	results := make(chan asr_types.StreamingResult, 1)
+	go func() {
+		defer close(results)
+		frames := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-audioStream:
+				if ok {
+					frames++
+					if afterFrames <= 0 || frames < afterFrames {
+						continue
+					}
+				}
+				turn := s.pop()
+				if !sleepCtx(ctx, time.Duration(turn.DelayMs)*time.Millisecond) {
+					return
+				}
+				results <- asr_types.StreamingResult{Text: turn.Text, IsFinal: true}
+				return
+			}
+		}
+	}()
+	return results, nil
+}
+
+// StubLlm 按脚本顺序返回回复, 每次请求消耗一条, 并记录每次请求的对话
+type StubLlm struct {
+	turns    []LlmTurn
+	next     int
+	requests [][]*schema.Message
+	mu       sync.Mutex
+}
+
+func NewStubLlm(turns []LlmTurn) *StubLlm {
+	return &StubLlm{turns: turns}
+}
+
+func (s *StubLlm) ResponseWithContext(ctx context.Context, sessionID string, dialogue []*schema.Message, functions []*schema.ToolInfo) chan *schema.Message {
+	s.mu.Lock()
+	s.requests = append(s.requests, append([]*schema.Message(nil), dialogue...))
+	var turn LlmTurn
+	if s.next < len(s.turns) {
+		turn = s.turns[s.next]
+		s.next++
+	}
+	s.mu.Unlock()
+
+	out := make(chan *schema.Message, len(turn.Chunks))
+	go func() {
+		defer close(out)
+		for i, chunk := range turn.Chunks {
+			if i > 0 && !sleepCtx(ctx, time.Duration(turn.ChunkDelayMs)*time.Millisecond) {
+				return
+			}
+			select {
+			case out <- &schema.Message{Role: schema.Assistant, Content: chunk}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *StubLlm) ResponseWithVllm(ctx context.Context, file []byte, text string, mimeType string) (string, error) {
+	return "", errors.New("回放不支持视觉模型")
+}
+
+func (s *StubLlm) GetModelInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"model_name": "replay",
+		"type":       "replay",
+	}
+}
+
+// Requests 每次请求时传入的对话
+func (s *StubLlm) Requests() [][]*schema.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]*schema.Message(nil), s.requests...)
+}
+
+// StubTts 每句话固定合成若干帧静音 opus, 并记录合成过的文本
+type StubTts struct {
+	framesPerSentence int
+	frameDelay        time.Duration
+	texts             []string
+	mu                sync.Mutex
+}
+
+func NewStubTts(config TtsScript) *StubTts {
+	framesPerSentence := config.FramesPerSentence
+	if framesPerSentence <= 0 {
+		framesPerSentence = 3
+	}
+	return &StubTts{
+		framesPerSentence: framesPerSentence,
+		frameDelay:        time.Duration(config.FrameDelayMs) * time.Millisecond,
+	}
+}
+
+func (s *StubTts) record(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.texts = append(s.texts, text)
+}
+
+// Texts 合成过的全部文本
+func (s *StubTts) Texts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.texts...)
+}
+
+func (s *StubTts) TextToSpeech(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) ([][]byte, error) {
+	s.record(text)
+	frames := make([][]byte, 0, s.framesPerSentence)
+	for i := 0; i < s.framesPerSentence; i++ {
+		frames = append(frames, SilenceFrame(frameDuration))
+	}
+	return frames, nil
+}
+
+func (s *StubTts) TextToSpeechStream(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) (chan []byte, error) {
+	s.record(text)
+	out := make(chan []byte, s.framesPerSentence)
+	go func() {
+		defer close(out)
+		for i := 0; i < s.framesPerSentence; i++ {
+			if i > 0 && !sleepCtx(ctx, s.frameDelay) {
+				return
+			}
+			select {
+			case out <- SilenceFrame(frameDuration):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -1,13 +1,21 @@
 package types
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrServerDraining 服务器排空中, 不再接受新的会话
+var ErrServerDraining = errors.New("server is draining")
 
-// IConn 是协议无关的连接接口，由 websocket/mqtt_udp 等协议适配器实现
+// IConn 是协议无关的连接接口，由 websocket/mqtt_udp/webrtc 等协议适配器实现
 // 你可以根据实际需要扩展方法
 
 const (
 	TransportTypeWebsocket = "websocket"
 	TransportTypeMqttUdp   = "udp"
+	TransportTypeWebRtc    = "webrtc"
+	TransportTypeText      = "text" // http 文本对话, 无实时音频
 )
 
 type IConn interface {
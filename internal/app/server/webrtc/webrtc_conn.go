@@ -0,0 +1,303 @@
+package webrtc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+)
+
+const (
+	// 信令使用的 data channel 名称
+	DataChannelLabel = "xiaozhi"
+	// opus 在 rtp 中固定使用 48k 时钟
+	opusClockRate = 48000
+	// 抖动缓冲最多容忍的乱序/迟到包数
+	maxLatePackets = 50
+	// 无法从 opus toc 中解析帧长时使用的默认帧长
+	defaultFrameDuration = 60 * time.Millisecond
+)
+
+// WebRtcConn 实现 types.IConn 接口，音频走 rtp/srtp，信令走 data channel
+type WebRtcConn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	onCloseCbList []func(deviceId string)
+	closeOnce     sync.Once
+
+	pc          *webrtc.PeerConnection
+	dataChannel *webrtc.DataChannel
+	audioTrack  *webrtc.TrackLocalStaticSample
+	deviceID    string
+
+	recvCmdChan   chan []byte
+	recvAudioChan chan []byte
+
+	closed bool
+	sync.RWMutex
+}
+
+// NewWebRtcConn 基于已创建好的 PeerConnection 创建 WebRtcConn, 需在设置 remote description 之前调用
+func NewWebRtcConn(pc *webrtc.PeerConnection, deviceID string) (*WebRtcConn, error) {
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeOpus,
+		ClockRate: opusClockRate,
+		Channels:  2,
+	}, "audio", "xiaozhi")
+	if err != nil {
+		return nil, err
+	}
+	rtpSender, err := pc.AddTrack(audioTrack)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	instance := &WebRtcConn{
+		ctx:           ctx,
+		cancel:        cancel,
+		pc:            pc,
+		audioTrack:    audioTrack,
+		deviceID:      deviceID,
+		recvCmdChan:   make(chan []byte, 100),
+		recvAudioChan: make(chan []byte, 100),
+	}
+
+	// 读取rtcp, 否则 nack 等拦截器无法工作
+	go func() {
+		rtcpBuf := make([]byte, 1500)
+		for {
+			if _, _, err := rtpSender.Read(rtcpBuf); err != nil {
+				return
+			}
+		}
+	}()
+
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() != DataChannelLabel {
+			log.Warnf("设备 %s 未知的 data channel: %s", deviceID, dc.Label())
+			return
+		}
+		instance.Lock()
+		instance.dataChannel = dc
+		instance.Unlock()
+
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if !msg.IsString {
+				return
+			}
+			select {
+			case instance.recvCmdChan <- msg.Data:
+			case <-instance.ctx.Done():
+			default:
+				log.Errorf("recv cmd channel is full")
+			}
+		})
+		dc.OnClose(func() {
+			instance.notifyClose()
+		})
+	})
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeAudio {
+			return
+		}
+		go instance.readRemoteAudio(track)
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Debugf("设备 %s webrtc 连接状态: %s", deviceID, state.String())
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			instance.notifyClose()
+		}
+	})
+
+	return instance, nil
+}
+
+// readRemoteAudio 读取上行 rtp 包, 经 samplebuilder 重排序后得到完整的 opus 帧
+func (w *WebRtcConn) readRemoteAudio(track *webrtc.TrackRemote) {
+	builder := samplebuilder.New(maxLatePackets, &codecs.OpusPacket{}, track.Codec().ClockRate)
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		default:
+		}
+
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			log.Debugf("设备 %s 读取 rtp 失败: %v", w.deviceID, err)
+			return
+		}
+		builder.Push(packet)
+
+		for sample := builder.Pop(); sample != nil; sample = builder.Pop() {
+			select {
+			case w.recvAudioChan <- sample.Data:
+			case <-w.ctx.Done():
+				return
+			default:
+				log.Errorf("recv audio channel is full")
+			}
+		}
+	}
+}
+
+func (w *WebRtcConn) notifyClose() {
+	w.closeOnce.Do(func() {
+		// 复制一份后再回调, 回调中可能再次访问连接
+		w.RLock()
+		cbList := append([]func(deviceId string){}, w.onCloseCbList...)
+		w.RUnlock()
+		for _, cb := range cbList {
+			cb(w.deviceID)
+		}
+	})
+}
+
+func (w *WebRtcConn) SendCmd(msg []byte) error {
+	w.RLock()
+	defer w.RUnlock()
+
+	if w.closed {
+		return errors.New("connection is closed")
+	}
+	if w.dataChannel == nil || w.dataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		return errors.New("data channel is not open")
+	}
+	if err := w.dataChannel.SendText(string(msg)); err != nil {
+		log.Errorf("send cmd error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (w *WebRtcConn) SendAudio(audio []byte) error {
+	w.RLock()
+	defer w.RUnlock()
+
+	if w.closed {
+		return errors.New("connection is closed")
+	}
+	err := w.audioTrack.WriteSample(media.Sample{
+		Data:     audio,
+		Duration: opusPacketDuration(audio),
+	})
+	if err != nil {
+		log.Errorf("send audio error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (w *WebRtcConn) RecvCmd(ctx context.Context, timeout int) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		log.Debugf("recv cmd context done")
+		return nil, ctx.Err()
+	case <-w.ctx.Done():
+		return nil, errors.New("connection is closed")
+	case msg := <-w.recvCmdChan:
+		return msg, nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (w *WebRtcConn) RecvAudio(ctx context.Context, timeout int) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		log.Debugf("recv audio context done")
+		return nil, ctx.Err()
+	case <-w.ctx.Done():
+		return nil, errors.New("connection is closed")
+	case audio := <-w.recvAudioChan:
+		return audio, nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (w *WebRtcConn) Close() error {
+	w.Lock()
+	if w.closed {
+		w.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.cancel()
+	w.Unlock()
+
+	// 接收通道不主动关闭, 由 ctx 通知读取方, 避免回调里向已关闭的通道写数据
+	// pc.Close 会触发 closed 状态回调, 不能持锁调用
+	if err := w.pc.Close(); err != nil {
+		log.Errorf("关闭 PeerConnection 失败: %v", err)
+	}
+	return nil
+}
+
+func (w *WebRtcConn) OnClose(cb func(deviceId string)) {
+	w.Lock()
+	defer w.Unlock()
+	w.onCloseCbList = append(w.onCloseCbList, cb)
+}
+
+func (w *WebRtcConn) GetDeviceID() string {
+	return w.deviceID
+}
+
+func (w *WebRtcConn) GetTransportType() string {
+	return types.TransportTypeWebRtc
+}
+
+func (w *WebRtcConn) GetData(key string) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (w *WebRtcConn) CloseAudioChannel() error {
+	return nil
+}
+
+// opusPacketDuration 根据 opus toc 字节计算一个包的播放时长 (RFC 6716 3.1)
+func opusPacketDuration(packet []byte) time.Duration {
+	if len(packet) == 0 {
+		return defaultFrameDuration
+	}
+	toc := packet[0]
+	config := toc >> 3
+
+	var frameDuration time.Duration
+	switch {
+	case config < 12: // SILK
+		frameDuration = []time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // Hybrid
+		frameDuration = []time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT
+		frameDuration = []time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	frameCount := 1
+	switch toc & 0x03 {
+	case 1, 2:
+		frameCount = 2
+	case 3:
+		if len(packet) < 2 {
+			return defaultFrameDuration
+		}
+		frameCount = int(packet[1] & 0x3F)
+	}
+	if frameCount == 0 {
+		return defaultFrameDuration
+	}
+	return frameDuration * time.Duration(frameCount)
+}
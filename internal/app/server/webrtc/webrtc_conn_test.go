@@ -0,0 +1,35 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpusPacketDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   time.Duration
+	}{
+		{name: "SILK 10ms", packet: []byte{0x00}, want: 10 * time.Millisecond},
+		{name: "SILK 60ms", packet: []byte{0x18}, want: 60 * time.Millisecond},
+		{name: "SILK 宽带 60ms", packet: []byte{0x58}, want: 60 * time.Millisecond},
+		{name: "SILK 20ms 两帧", packet: []byte{0x09}, want: 40 * time.Millisecond},
+		{name: "Hybrid 20ms", packet: []byte{0x68}, want: 20 * time.Millisecond},
+		{name: "Hybrid 10ms 两帧不等长", packet: []byte{0x62}, want: 20 * time.Millisecond},
+		{name: "CELT 2.5ms", packet: []byte{0x80}, want: 2500 * time.Microsecond},
+		{name: "CELT 20ms", packet: []byte{0xF8}, want: 20 * time.Millisecond},
+		{name: "CELT 20ms 三帧", packet: []byte{0xFB, 0x03}, want: 60 * time.Millisecond},
+		{name: "帧数字节忽略 vbr 和 padding 标志", packet: []byte{0xFB, 0xC2}, want: 40 * time.Millisecond},
+		{name: "缺少帧数字节", packet: []byte{0xFB}, want: defaultFrameDuration},
+		{name: "帧数为0", packet: []byte{0xFB, 0x00}, want: defaultFrameDuration},
+		{name: "空包", packet: nil, want: defaultFrameDuration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, opusPacketDuration(tt.packet))
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// WebRtcServer 通过 http offer/answer 建立 webrtc 连接
+type WebRtcServer struct {
+	api *webrtc.API
+	// ICE 服务器列表, 如 stun:stun.l.google.com:19302
+	iceServers []string
+	// NAT 场景下对外公布的 ip
+	publicIps []string
+	// 等待 ICE 候选收集完成的超时时间
+	gatherTimeout time.Duration
+
+	onNewConnection types.OnNewConnection
+	// 从请求中识别设备, 与 websocket 保持一致
+	deviceIdResolver func(r *http.Request) (string, error)
+	// 开启认证时检查设备是否已激活, 为 nil 时不检查
+	activationCheck func(ctx context.Context, deviceId string, clientId string) (bool, error)
+	// 连接数/握手频率限制, 为 nil 时不限制
+	limiter *ratelimit.Limiter
+	// 获取限流用的客户端ip, 与 websocket 共用受信任代理配置
+	clientIpResolver func(r *http.Request) string
+}
+
+type WebRtcServerOption func(*WebRtcServer)
+
+func WithIceServers(iceServers []string) WebRtcServerOption {
+	return func(s *WebRtcServer) {
+		s.iceServers = iceServers
+	}
+}
+
+func WithPublicIps(publicIps []string) WebRtcServerOption {
+	return func(s *WebRtcServer) {
+		s.publicIps = publicIps
+	}
+}
+
+func WithOnNewConnection(onNewConnection types.OnNewConnection) WebRtcServerOption {
+	return func(s *WebRtcServer) {
+		s.onNewConnection = onNewConnection
+	}
+}
+
+// WithDeviceIdResolver 设置设备识别方式, 未设置时直接取 Device-Id 请求头
+func WithDeviceIdResolver(resolver func(r *http.Request) (string, error)) WebRtcServerOption {
+	return func(s *WebRtcServer) {
+		s.deviceIdResolver = resolver
+	}
+}
+
+// WithActivationCheck 设置设备激活检查, 未激活的设备不能建立连接
+func WithActivationCheck(check func(ctx context.Context, deviceId string, clientId string) (bool, error)) WebRtcServerOption {
+	return func(s *WebRtcServer) {
+		s.activationCheck = check
+	}
+}
+
+// WithLimiter 设置按 Device-Id/IP 的连接和频率限制
+func WithLimiter(limiter *ratelimit.Limiter) WebRtcServerOption {
+	return func(s *WebRtcServer) {
+		s.limiter = limiter
+	}
+}
+
+// WithClientIpResolver 设置客户端ip的获取方式, 未设置时使用直连地址
+func WithClientIpResolver(resolver func(r *http.Request) string) WebRtcServerOption {
+	return func(s *WebRtcServer) {
+		s.clientIpResolver = resolver
+	}
+}
+
+// NewWebRtcServer 创建 webrtc 服务
+func NewWebRtcServer(opts ...WebRtcServerOption) (*WebRtcServer, error) {
+	s := &WebRtcServer{
+		gatherTimeout: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("注册编解码器失败: %v", err)
+	}
+	// 默认拦截器包含 nack/rtcp report, 配合接收端的 samplebuilder 处理丢包和乱序
+	interceptorRegistry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+		return nil, fmt.Errorf("注册拦截器失败: %v", err)
+	}
+
+	settingEngine := webrtc.SettingEngine{}
+	if len(s.publicIps) > 0 {
+		settingEngine.SetNAT1To1IPs(s.publicIps, webrtc.ICECandidateTypeHost)
+	}
+
+	s.api = webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+		webrtc.WithSettingEngine(settingEngine),
+	)
+	return s, nil
+}
+
+// Start 注册 offer/answer 路由, 与 websocket 服务共用同一个 http server
+func (s *WebRtcServer) Start() {
+	http.HandleFunc("/xiaozhi/webrtc/offer", s.handleOffer)
+	log.Infof("WebRTC 信令端点: POST /xiaozhi/webrtc/offer")
+}
+
+type offerRequest struct {
+	DeviceId string `json:"device_id"`
+	Type     string `json:"type"`
+	Sdp      string `json:"sdp"`
+}
+
+type answerResponse struct {
+	Type string `json:"type"`
+	Sdp  string `json:"sdp"`
+}
+
+// handleOffer 接收客户端 offer, 返回收集完 ICE 候选的 answer
+func (s *WebRtcServer) handleOffer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持 POST 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req offerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求体格式错误", http.StatusBadRequest)
+		return
+	}
+	// 请求体中的 device_id 与请求头等价, 同样要经过证书 CN 校验
+	if r.Header.Get("Device-Id") == "" {
+		r.Header.Set("Device-Id", req.DeviceId)
+	}
+	deviceID, err := s.getDeviceId(r)
+	if err != nil {
+		log.Warnf("设备身份校验失败: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if deviceID == "" {
+		log.Warn("缺少 Device-Id 请求头")
+		http.Error(w, "缺少 Device-Id 请求头", http.StatusBadRequest)
+		return
+	}
+	if req.Type != "" && req.Type != "offer" {
+		http.Error(w, "sdp 类型必须为 offer", http.StatusBadRequest)
+		return
+	}
+	if s.activationCheck != nil {
+		activated, err := s.activationCheck(r.Context(), deviceID, r.Header.Get("Client-Id"))
+		if err != nil {
+			log.Errorf("检查设备 %s 激活状态失败: %v", deviceID, err)
+			http.Error(w, "内部服务器错误", http.StatusInternalServerError)
+			return
+		}
+		if !activated {
+			log.Warnf("设备 %s 未激活, 拒绝 webrtc 连接", deviceID)
+			http.Error(w, "设备未激活", http.StatusForbidden)
+			return
+		}
+	}
+
+	ip := s.clientIp(r)
+	if err := s.limiter.AllowHandshake(types.TransportTypeWebRtc, deviceID, ip); err != nil {
+		ratelimit.RejectHttp(w, deviceID, ip, err)
+		return
+	}
+	release, err := s.limiter.Acquire(types.TransportTypeWebRtc, deviceID, ip)
+	if err != nil {
+		ratelimit.RejectHttp(w, deviceID, ip, err)
+		return
+	}
+
+	answer, conn, err := s.negotiate(deviceID, req.Sdp)
+	if err != nil {
+		release()
+		log.Errorf("设备 %s webrtc 协商失败: %v", deviceID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conn.OnClose(func(string) {
+		release()
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(answerResponse{Type: "answer", Sdp: answer.SDP}); err != nil {
+		log.Errorf("设备 %s 返回 answer 失败: %v", deviceID, err)
+		conn.Close()
+		return
+	}
+
+	if s.onNewConnection != nil {
+		s.onNewConnection(conn)
+	}
+}
+
+func (s *WebRtcServer) getDeviceId(r *http.Request) (string, error) {
+	if s.deviceIdResolver == nil {
+		return r.Header.Get("Device-Id"), nil
+	}
+	return s.deviceIdResolver(r)
+}
+
+func (s *WebRtcServer) clientIp(r *http.Request) string {
+	if s.clientIpResolver != nil {
+		return s.clientIpResolver(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *WebRtcServer) negotiate(deviceID string, offerSdp string) (*webrtc.SessionDescription, *WebRtcConn, error) {
+	config := webrtc.Configuration{}
+	if len(s.iceServers) > 0 {
+		config.ICEServers = []webrtc.ICEServer{{URLs: s.iceServers}}
+	}
+	pc, err := s.api.NewPeerConnection(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建 PeerConnection 失败: %v", err)
+	}
+
+	conn, err := NewWebRtcConn(pc, deviceID)
+	if err != nil {
+		pc.Close()
+		return nil, nil, fmt.Errorf("创建 WebRtcConn 失败: %v", err)
+	}
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSdp}
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("设置 offer 失败: %v", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("创建 answer 失败: %v", err)
+	}
+
+	// 不使用 trickle ice, 一次性返回完整的候选
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("设置 answer 失败: %v", err)
+	}
+	select {
+	case <-gatherComplete:
+	case <-time.After(s.gatherTimeout):
+		log.Warnf("设备 %s ICE 候选收集超时, 使用已收集的候选", deviceID)
+	}
+
+	return pc.LocalDescription(), conn, nil
+}
@@ -0,0 +1,162 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+)
+
+// newClientOffer 模拟浏览器创建带音频和信令 data channel 的 offer
+func newClientOffer(t *testing.T) string {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio)
+	require.NoError(t, err)
+	_, err = pc.CreateDataChannel(DataChannelLabel, nil)
+	require.NoError(t, err)
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	<-gatherComplete
+	return pc.LocalDescription().SDP
+}
+
+func postOffer(s *WebRtcServer, deviceId string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/xiaozhi/webrtc/offer", strings.NewReader(body))
+	if deviceId != "" {
+		req.Header.Set("Device-Id", deviceId)
+	}
+	w := httptest.NewRecorder()
+	s.handleOffer(w, req)
+	return w
+}
+
+func offerBody(t *testing.T, deviceId string, sdp string) string {
+	body, err := json.Marshal(offerRequest{DeviceId: deviceId, Type: "offer", Sdp: sdp})
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHandleOffer_Reject(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []WebRtcServerOption
+		deviceId string
+		body     string
+		code     int
+	}{
+		{name: "请求体格式错误", body: `{`, code: http.StatusBadRequest},
+		{name: "缺少设备", body: `{"type":"offer","sdp":"v=0"}`, code: http.StatusBadRequest},
+		{name: "sdp类型错误", deviceId: "dev-1", body: `{"type":"answer","sdp":"v=0"}`, code: http.StatusBadRequest},
+		{
+			name: "设备身份校验失败",
+			opts: []WebRtcServerOption{WithDeviceIdResolver(func(r *http.Request) (string, error) {
+				return "", errors.New("Device-Id 与客户端证书CN不一致")
+			})},
+			deviceId: "dev-1",
+			body:     `{"type":"offer","sdp":"v=0"}`,
+			code:     http.StatusForbidden,
+		},
+		{
+			name: "设备未激活",
+			opts: []WebRtcServerOption{WithActivationCheck(func(ctx context.Context, deviceId string, clientId string) (bool, error) {
+				return false, nil
+			})},
+			deviceId: "dev-1",
+			body:     `{"type":"offer","sdp":"v=0"}`,
+			code:     http.StatusForbidden,
+		},
+		{
+			name: "激活检查失败",
+			opts: []WebRtcServerOption{WithActivationCheck(func(ctx context.Context, deviceId string, clientId string) (bool, error) {
+				return false, errors.New("backend unavailable")
+			})},
+			deviceId: "dev-1",
+			body:     `{"type":"offer","sdp":"v=0"}`,
+			code:     http.StatusInternalServerError,
+		},
+		{name: "sdp无效", deviceId: "dev-1", body: `{"type":"offer","sdp":"v=0"}`, code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var connected bool
+			opts := append([]WebRtcServerOption{WithOnNewConnection(func(conn types.IConn) {
+				connected = true
+			})}, tt.opts...)
+			s, err := NewWebRtcServer(opts...)
+			require.NoError(t, err)
+
+			w := postOffer(s, tt.deviceId, tt.body)
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+			assert.False(t, connected)
+		})
+	}
+
+	s, err := NewWebRtcServer()
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/xiaozhi/webrtc/offer", nil)
+	w := httptest.NewRecorder()
+	s.handleOffer(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandleOffer(t *testing.T) {
+	conns := make(chan types.IConn, 1)
+	s, err := NewWebRtcServer(
+		WithOnNewConnection(func(conn types.IConn) {
+			conns <- conn
+		}),
+		// 与 websocket 一样以解析出的设备为准, 这里模拟证书 CN 映射
+		WithDeviceIdResolver(func(r *http.Request) (string, error) {
+			if r.Header.Get("Device-Id") != "aa:bb:cc:dd:ee:ff" {
+				return "", errors.New("Device-Id 与客户端证书CN不一致")
+			}
+			return "aa:bb:cc:dd:ee:ff", nil
+		}),
+		WithActivationCheck(func(ctx context.Context, deviceId string, clientId string) (bool, error) {
+			return deviceId == "aa:bb:cc:dd:ee:ff", nil
+		}),
+		WithLimiter(ratelimit.NewLimiter(ratelimit.Config{MaxSessionsPerDevice: 1})),
+	)
+	require.NoError(t, err)
+	s.gatherTimeout = time.Second
+
+	// 请求体中的 device_id 同样经过设备识别
+	w := postOffer(s, "", offerBody(t, "aa:bb:cc:dd:ee:ff", newClientOffer(t)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var answer answerResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &answer))
+	assert.Equal(t, "answer", answer.Type)
+	assert.Contains(t, answer.Sdp, "opus")
+
+	conn := <-conns
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", conn.GetDeviceID())
+	assert.Equal(t, types.TransportTypeWebRtc, conn.GetTransportType())
+
+	// 同一设备的并发会话超出限制
+	w = postOffer(s, "aa:bb:cc:dd:ee:ff", offerBody(t, "", newClientOffer(t)))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// 连接关闭后释放名额
+	require.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		w = postOffer(s, "aa:bb:cc:dd:ee:ff", offerBody(t, "", newClientOffer(t)))
+		return w.Code == http.StatusOK
+	}, 5*time.Second, 100*time.Millisecond)
+	(<-conns).Close()
+}
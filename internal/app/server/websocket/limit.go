@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// OTA 请求的限流统计维度
+const transportOta = "ota"
+
+// ClientIp 获取客户端ip, 只有直连地址是受信任的反向代理时才采用代理传入的地址, 否则请求头可被伪造
+func (s *WebSocketServer) ClientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.isTrustedProxy(host) {
+		return host
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	// 从右往左跳过受信任的代理, 第一个不受信任的地址即客户端地址
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if ip != "" && (i == 0 || !s.isTrustedProxy(ip)) {
+				return ip
+			}
+		}
+	}
+	return host
+}
+
+func (s *WebSocketServer) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies 解析代理地址列表, 支持单个ip和CIDR
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	ipNets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Warnf("忽略无效的受信任代理地址 %s: %v", proxy, err)
+			continue
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets
+}
@@ -0,0 +1,47 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIp(t *testing.T) {
+	s := &WebSocketServer{trustedProxies: parseTrustedProxies([]string{"10.0.0.1", "172.16.0.0/12", "bad-proxy"})}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIp     string
+		forwarded  string
+		want       string
+	}{
+		{name: "直连", remoteAddr: "1.2.3.4:5678", want: "1.2.3.4"},
+		{name: "不受信任的来源伪造请求头", remoteAddr: "1.2.3.4:5678", realIp: "9.9.9.9", forwarded: "8.8.8.8", want: "1.2.3.4"},
+		{name: "受信任代理的X-Real-IP", remoteAddr: "10.0.0.1:5678", realIp: "9.9.9.9", forwarded: "8.8.8.8", want: "9.9.9.9"},
+		{name: "受信任代理的X-Forwarded-For", remoteAddr: "172.16.1.1:5678", forwarded: "8.8.8.8", want: "8.8.8.8"},
+		{name: "跳过链路上的受信任代理", remoteAddr: "10.0.0.1:5678", forwarded: "7.7.7.7, 8.8.8.8, 172.16.2.2", want: "8.8.8.8"},
+		{name: "全部是受信任代理时取最左侧", remoteAddr: "10.0.0.1:5678", forwarded: "172.16.3.3, 172.16.2.2", want: "172.16.3.3"},
+		{name: "受信任代理未传请求头", remoteAddr: "10.0.0.1:5678", want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/xiaozhi/v1/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIp != "" {
+				r.Header.Set("X-Real-IP", tt.realIp)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			assert.Equal(t, tt.want, s.ClientIp(r))
+		})
+	}
+
+	// 未配置受信任代理时只使用直连地址
+	r := httptest.NewRequest("GET", "/xiaozhi/v1/", nil)
+	r.RemoteAddr = "10.0.0.1:5678"
+	r.Header.Set("X-Real-IP", "9.9.9.9")
+	assert.Equal(t, "10.0.0.1", (&WebSocketServer{}).ClientIp(r))
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
 	"xiaozhi-esp32-server-golang/internal/data/client"
 	user_config "xiaozhi-esp32-server-golang/internal/domain/config"
 	ctypes "xiaozhi-esp32-server-golang/internal/domain/config/types"
@@ -29,8 +30,13 @@ func (s *WebSocketServer) handleOta(w http.ResponseWriter, r *http.Request) {
 		ip = r.RemoteAddr
 	}
 
-	//从header头部获取Device-Id和Client-Id
-	deviceId := r.Header.Get("Device-Id")
+	//从header头部获取Device-Id和Client-Id, 双向认证时Device-Id取自客户端证书
+	deviceId, err := s.GetDeviceId(r)
+	if err != nil {
+		log.Warnf("设备身份校验失败: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	clientId := r.Header.Get("Client-Id")
 
 	if deviceId == "" || clientId == "" {
@@ -38,6 +44,10 @@ func (s *WebSocketServer) handleOta(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "缺少Device-Id或Client-Id", http.StatusBadRequest)
 		return
 	}
+	if err := s.limiter.AllowHandshake(transportOta, deviceId, s.ClientIp(r)); err != nil {
+		ratelimit.RejectHttp(w, deviceId, s.ClientIp(r), err)
+		return
+	}
 
 	//deviceId = strings.ReplaceAll(deviceId, ":", "_")
 
@@ -153,7 +163,12 @@ func getMqttInfo(deviceId, clientId, otaConfigPrefix, ip string) *MqttInfo {
 
 // handleOtaActivate 设备激活接口
 func (s *WebSocketServer) handleOtaActivate(w http.ResponseWriter, r *http.Request) {
-	deviceId := r.Header.Get("Device-Id")
+	deviceId, err := s.GetDeviceId(r)
+	if err != nil {
+		log.Warnf("设备身份校验失败: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	clientId := r.Header.Get("Client-Id")
 	if deviceId == "" || clientId == "" {
 		log.Errorf("缺少Device-Id或Client-Id")
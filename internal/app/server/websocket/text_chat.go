@@ -0,0 +1,230 @@
+package websocket
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	user_config "xiaozhi-esp32-server-golang/internal/domain/config"
+	ctypes "xiaozhi-esp32-server-golang/internal/domain/config/types"
+	log "xiaozhi-esp32-server-golang/logger"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	textChatTimeout = 2 * time.Minute
+	// 按智能体对话时的会话标识前缀, 对话历史按该标识保存, 与设备的历史互不影响
+	textChatAgentPrefix = "agent_"
+)
+
+// OnNewTextChat 创建文本对话会话, 由 App 统一做排空检查
+type OnNewTextChat func(conn types.IConn, opts ...chat.ChatManagerOption) (*chat.ChatManager, error)
+
+type textChatRequest struct {
+	DeviceId string `json:"device_id"`
+	// 与 device_id 二选一, 不经过设备直接和智能体对话
+	AgentId string `json:"agent_id"`
+	Text    string `json:"text"`
+	// 是否需要返回每句的合成音频
+	Audio bool `json:"audio"`
+}
+
+// handleTextChat 文本对话接口, 复用设备或智能体的 prompt/记忆/MCP工具, 以 SSE 流式返回每句回复
+func (s *WebSocketServer) handleTextChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, "服务器正在下线", http.StatusServiceUnavailable)
+		return
+	}
+	if code, err := checkTextChatToken(r); err != nil {
+		log.Warnf("文本对话认证失败: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	var req textChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "请求体格式错误", http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" {
+		http.Error(w, "缺少text参数", http.StatusBadRequest)
+		return
+	}
+	sessionID, opts, code, err := s.resolveTextChatTarget(r, &req)
+	if err != nil {
+		log.Warnf("文本对话参数错误: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	ip := s.ClientIp(r)
+	if err := s.limiter.AllowHandshake(types.TransportTypeText, sessionID, ip); err != nil {
+		ratelimit.RejectHttp(w, sessionID, ip, err)
+		return
+	}
+	release, err := s.limiter.Acquire(types.TransportTypeText, sessionID, ip)
+	if err != nil {
+		ratelimit.RejectHttp(w, sessionID, ip, err)
+		return
+	}
+	defer release()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+	if s.onNewTextChat == nil {
+		http.Error(w, "文本对话未开启", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn := NewTextChatConn(sessionID)
+	opts = append(opts, chat.WithChatSessionOptions(chat.WithTtsManagerOptions(chat.WithTextMode(req.Audio))))
+	chatManager, err := s.onNewTextChat(conn, opts...)
+	if err != nil {
+		if errors.Is(err, types.ErrServerDraining) {
+			http.Error(w, "服务器正在下线", http.StatusServiceUnavailable)
+			return
+		}
+		log.Errorf("%s 创建文本对话失败: %v", sessionID, err)
+		http.Error(w, "创建对话失败", http.StatusInternalServerError)
+		return
+	}
+	defer chatManager.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if req.Audio {
+		writeSseEvent(w, TextChatEvent{Event: TextChatEventStart, Data: map[string]interface{}{
+			"audio_params": chatManager.GetClientState().OutputAudioFormat,
+		}})
+		flusher.Flush()
+	}
+
+	go func() {
+		if err := chatManager.Start(); err != nil {
+			conn.Fail(err)
+		}
+	}()
+
+	// 与语音对话走同一条 asr结果 -> llm -> tts 链路, 对话历史保持一致
+	if err := chatManager.InjectMessage(req.Text, false); err != nil {
+		conn.Fail(err)
+	}
+
+	streamTextChat(w, flusher, r, conn, textChatTimeout)
+}
+
+// checkTextChatToken 校验 text_chat.token, 未配置令牌时接口不可用
+func checkTextChatToken(r *http.Request) (int, error) {
+	token := viper.GetString("text_chat.token")
+	if token == "" {
+		return http.StatusForbidden, errors.New("文本对话接口未开启")
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+		return http.StatusUnauthorized, errors.New("无效的令牌")
+	}
+	return http.StatusOK, nil
+}
+
+// resolveTextChatTarget 确定对话的设备或智能体, 返回会话标识和创建会话的选项
+func (s *WebSocketServer) resolveTextChatTarget(r *http.Request, req *textChatRequest) (string, []chat.ChatManagerOption, int, error) {
+	if req.AgentId != "" {
+		if req.DeviceId != "" || r.Header.Get("Device-Id") != "" {
+			return "", nil, http.StatusBadRequest, errors.New("device_id 和 agent_id 只能指定一个")
+		}
+		config, err := getAgentConfig(r.Context(), req.AgentId)
+		if err != nil {
+			return "", nil, http.StatusBadRequest, fmt.Errorf("获取智能体 %s 配置失败: %v", req.AgentId, err)
+		}
+		return textChatAgentPrefix + req.AgentId, []chat.ChatManagerOption{chat.WithDeviceConfig(config)}, http.StatusOK, nil
+	}
+
+	// 请求体中的 device_id 与请求头等价, 同样要经过证书 CN 校验
+	if r.Header.Get("Device-Id") == "" {
+		r.Header.Set("Device-Id", req.DeviceId)
+	}
+	deviceID, err := s.GetDeviceId(r)
+	if err != nil {
+		return "", nil, http.StatusForbidden, err
+	}
+	if deviceID == "" {
+		return "", nil, http.StatusBadRequest, errors.New("缺少Device-Id或agent_id")
+	}
+	return deviceID, nil, http.StatusOK, nil
+}
+
+// getAgentConfig 按智能体获取配置, 需要配置提供者实现 AgentConfigProvider
+func getAgentConfig(ctx context.Context, agentID string) (ctypes.UConfig, error) {
+	provider, err := user_config.GetProvider(viper.GetString("config_provider.type"))
+	if err != nil {
+		return ctypes.UConfig{}, err
+	}
+	agentProvider, ok := provider.(user_config.AgentConfigProvider)
+	if !ok {
+		return ctypes.UConfig{}, errors.New("配置提供者不支持按智能体获取配置")
+	}
+	return agentProvider.GetAgentConfig(ctx, agentID)
+}
+
+// streamTextChat 把会话产生的事件写成 SSE, 直到本轮对话结束、客户端断开或超时
+func streamTextChat(w http.ResponseWriter, flusher http.Flusher, r *http.Request, conn *TextChatConn, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case event := <-conn.Events():
+			writeSseEvent(w, event)
+			flusher.Flush()
+			if event.Event == TextChatEventDone || event.Event == TextChatEventError {
+				return
+			}
+		case <-conn.Done():
+			// 会话已关闭, 把剩余事件发完后结束
+			for {
+				select {
+				case event := <-conn.Events():
+					writeSseEvent(w, event)
+				default:
+					writeSseEvent(w, TextChatEvent{Event: TextChatEventDone, Data: map[string]int{}})
+					flusher.Flush()
+					return
+				}
+			}
+		case <-r.Context().Done():
+			log.Infof("%s 文本对话客户端断开", conn.GetDeviceID())
+			conn.NotifyClose()
+			return
+		case <-timer.C:
+			writeSseEvent(w, TextChatEvent{Event: TextChatEventError, Data: map[string]string{"message": "对话超时"}})
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+func writeSseEvent(w http.ResponseWriter, event TextChatEvent) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Errorf("序列化SSE事件失败: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data)
+}
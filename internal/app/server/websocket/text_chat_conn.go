@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	TextChatEventStart    = "start"
+	TextChatEventSentence = "sentence"
+	TextChatEventMessage  = "message"
+	TextChatEventDone     = "done"
+	TextChatEventError    = "error"
+)
+
+// TextChatEvent 推送给 SSE 客户端的事件
+type TextChatEvent struct {
+	Event string
+	Data  interface{}
+}
+
+// TextChatSentence 一句回复, Audio 为该句按帧拆分的 base64 opus 数据
+type TextChatSentence struct {
+	Index int      `json:"index"`
+	Text  string   `json:"text"`
+	Audio []string `json:"audio,omitempty"`
+}
+
+// TextChatConn 实现 types.IConn 接口, 将 ChatSession 下发的信令和音频转换为 SSE 事件
+type TextChatConn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	onCloseCbList []func(deviceId string)
+	closeOnce     sync.Once
+
+	deviceID  string
+	eventChan chan TextChatEvent
+
+	sentenceIndex int
+	sentenceText  string
+	audioFrames   []string
+	// 本轮对话已结束, 之后的信令直接丢弃
+	finished bool
+
+	closed bool
+	sync.Mutex
+}
+
+func NewTextChatConn(deviceID string) *TextChatConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TextChatConn{
+		ctx:       ctx,
+		cancel:    cancel,
+		deviceID:  deviceID,
+		eventChan: make(chan TextChatEvent, 100),
+	}
+}
+
+// Events 事件通道, 由 http handler 读取后写成 SSE
+func (c *TextChatConn) Events() <-chan TextChatEvent {
+	return c.eventChan
+}
+
+// Done 连接被会话侧关闭(如命中退出词)时触发
+func (c *TextChatConn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+func (c *TextChatConn) pushEvent(event TextChatEvent) {
+	select {
+	case c.eventChan <- event:
+	case <-c.ctx.Done():
+	}
+}
+
+// Fail 会话异常时通知客户端并结束本轮对话
+func (c *TextChatConn) Fail(err error) {
+	c.Lock()
+	if c.finished {
+		c.Unlock()
+		return
+	}
+	c.finished = true
+	c.Unlock()
+	c.pushEvent(TextChatEvent{Event: TextChatEventError, Data: map[string]string{"message": err.Error()}})
+}
+
+// NotifyClose http 客户端断开时调用, 通知上层释放会话
+func (c *TextChatConn) NotifyClose() {
+	c.closeOnce.Do(func() {
+		for _, cb := range c.onCloseCbList {
+			cb(c.deviceID)
+		}
+	})
+}
+
+func (c *TextChatConn) SendCmd(data []byte) error {
+	var serverMsg msg.ServerMessage
+	if err := json.Unmarshal(data, &serverMsg); err != nil {
+		return err
+	}
+
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return errors.New("connection is closed")
+	}
+	if c.finished {
+		c.Unlock()
+		return nil
+	}
+
+	var event *TextChatEvent
+	switch {
+	case serverMsg.Type == msg.ServerMessageTypeTts && serverMsg.State == msg.MessageStateSentenceStart:
+		c.sentenceText = serverMsg.Text
+		c.audioFrames = nil
+	case serverMsg.Type == msg.ServerMessageTypeTts && serverMsg.State == msg.MessageStateSentenceEnd:
+		event = &TextChatEvent{Event: TextChatEventSentence, Data: TextChatSentence{
+			Index: c.sentenceIndex,
+			Text:  c.sentenceText,
+			Audio: c.audioFrames,
+		}}
+		c.sentenceIndex++
+		c.sentenceText = ""
+		c.audioFrames = nil
+	case serverMsg.Type == msg.ServerMessageTypeTts && serverMsg.State == msg.MessageStateStop,
+		serverMsg.Type == msg.ServerMessageTypeGoodBye:
+		c.finished = true
+		event = &TextChatEvent{Event: TextChatEventDone, Data: map[string]int{"sentences": c.sentenceIndex}}
+	case serverMsg.Type == msg.ServerMessageTypeTts || serverMsg.Type == msg.MessageTypeHello:
+		// tts start 等信令对文本客户端无意义
+	default:
+		event = &TextChatEvent{Event: TextChatEventMessage, Data: json.RawMessage(data)}
+	}
+	c.Unlock()
+
+	if event != nil {
+		c.pushEvent(*event)
+	}
+	return nil
+}
+
+func (c *TextChatConn) SendAudio(audio []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return errors.New("connection is closed")
+	}
+	c.audioFrames = append(c.audioFrames, base64.StdEncoding.EncodeToString(audio))
+	return nil
+}
+
+// RecvCmd 文本对话的输入由 http 请求直接注入, 连接上不会再收到信令
+func (c *TextChatConn) RecvCmd(ctx context.Context, timeout int) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, errors.New("connection is closed")
+	case <-time.After(time.Duration(timeout) * time.Second):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (c *TextChatConn) RecvAudio(ctx context.Context, timeout int) ([]byte, error) {
+	return c.RecvCmd(ctx, timeout)
+}
+
+func (c *TextChatConn) GetDeviceID() string {
+	return c.deviceID
+}
+
+func (c *TextChatConn) Close() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.cancel()
+	log.Debugf("设备 %s 文本对话连接关闭", c.deviceID)
+	return nil
+}
+
+func (c *TextChatConn) OnClose(cb func(deviceId string)) {
+	c.onCloseCbList = append(c.onCloseCbList, cb)
+}
+
+func (c *TextChatConn) CloseAudioChannel() error {
+	return nil
+}
+
+func (c *TextChatConn) GetTransportType() string {
+	return types.TransportTypeText
+}
+
+func (c *TextChatConn) GetData(key string) (interface{}, error) {
+	return nil, errors.New("not implemented")
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/chat"
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
+	"xiaozhi-esp32-server-golang/internal/app/server/types"
+	"xiaozhi-esp32-server-golang/internal/data/msg"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleTextChat(t *testing.T) {
+	// 模拟管理后台, 只认识智能体 12
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("agent_id") != "12" {
+			http.Error(w, "agent not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"prompt":"你是小智","agent_id":"12"}}`)
+	}))
+	defer backend.Close()
+
+	viper.Set("text_chat.token", "secret")
+	viper.Set("config_provider.type", "manager")
+	viper.Set("manager.backend_url", backend.URL)
+	defer func() {
+		viper.Set("text_chat.token", nil)
+		viper.Set("config_provider.type", nil)
+		viper.Set("manager.backend_url", nil)
+	}()
+
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		deviceId string
+		body     string
+		newErr   error
+		code     int
+		// 期望创建会话时使用的标识, 为空表示不应走到创建会话
+		session string
+	}{
+		{name: "非POST请求", method: http.MethodGet, token: "secret", body: `{}`, code: http.StatusMethodNotAllowed},
+		{name: "缺少令牌", body: `{"device_id":"dev-1","text":"你好"}`, code: http.StatusUnauthorized},
+		{name: "令牌错误", token: "wrong", body: `{"device_id":"dev-1","text":"你好"}`, code: http.StatusUnauthorized},
+		{name: "请求体格式错误", token: "secret", body: `{`, code: http.StatusBadRequest},
+		{name: "缺少text", token: "secret", body: `{"device_id":"dev-1"}`, code: http.StatusBadRequest},
+		{name: "缺少设备和智能体", token: "secret", body: `{"text":"你好"}`, code: http.StatusBadRequest},
+		{name: "设备和智能体同时指定", token: "secret", deviceId: "dev-1", body: `{"agent_id":"12","text":"你好"}`, code: http.StatusBadRequest},
+		{name: "智能体不存在", token: "secret", body: `{"agent_id":"13","text":"你好"}`, code: http.StatusBadRequest},
+		{name: "请求头中的设备", token: "secret", deviceId: "dev-1", body: `{"device_id":"dev-2","text":"你好"}`, newErr: errors.New("创建失败"), code: http.StatusInternalServerError, session: "dev-1"},
+		{name: "请求体中的设备", token: "secret", body: `{"device_id":"dev-2","text":"你好"}`, newErr: errors.New("创建失败"), code: http.StatusInternalServerError, session: "dev-2"},
+		{name: "智能体", token: "secret", body: `{"agent_id":"12","text":"你好"}`, newErr: errors.New("创建失败"), code: http.StatusInternalServerError, session: "agent_12"},
+		{name: "服务器排空中", token: "secret", body: `{"device_id":"dev-1","text":"你好"}`, newErr: types.ErrServerDraining, code: http.StatusServiceUnavailable, session: "dev-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var session string
+			s := &WebSocketServer{onNewTextChat: func(conn types.IConn, opts ...chat.ChatManagerOption) (*chat.ChatManager, error) {
+				session = conn.GetDeviceID()
+				return nil, tt.newErr
+			}}
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/xiaozhi/api/chat", strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.deviceId != "" {
+				req.Header.Set("Device-Id", tt.deviceId)
+			}
+			w := httptest.NewRecorder()
+			s.handleTextChat(w, req)
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+			assert.Equal(t, tt.session, session)
+		})
+	}
+}
+
+func TestHandleTextChat_Disabled(t *testing.T) {
+	s := &WebSocketServer{}
+	req := httptest.NewRequest(http.MethodPost, "/xiaozhi/api/chat", strings.NewReader(`{"device_id":"dev-1","text":"你好"}`))
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	s.handleTextChat(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code, "未配置令牌时接口不可用")
+}
+
+func TestHandleTextChat_Limit(t *testing.T) {
+	viper.Set("text_chat.token", "secret")
+	defer viper.Set("text_chat.token", nil)
+
+	s := &WebSocketServer{
+		limiter: ratelimit.NewLimiter(ratelimit.Config{HandshakesPerMinute: 1}),
+		onNewTextChat: func(conn types.IConn, opts ...chat.ChatManagerOption) (*chat.ChatManager, error) {
+			return nil, types.ErrServerDraining
+		},
+	}
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/xiaozhi/api/chat", strings.NewReader(`{"device_id":"dev-1","text":"你好"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.handleTextChat(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, codes)
+}
+
+// readSseEvents 解析 SSE 响应, 返回事件名和数据
+func readSseEvents(t *testing.T, body string) ([]string, []string) {
+	var names, data []string
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		lines := strings.Split(block, "\n")
+		require.Len(t, lines, 2)
+		names = append(names, strings.TrimPrefix(lines[0], "event: "))
+		data = append(data, strings.TrimPrefix(lines[1], "data: "))
+	}
+	return names, data
+}
+
+func sendServerMessage(t *testing.T, conn *TextChatConn, serverMsg msg.ServerMessage) {
+	data, err := json.Marshal(serverMsg)
+	require.NoError(t, err)
+	require.NoError(t, conn.SendCmd(data))
+}
+
+func TestStreamTextChat(t *testing.T) {
+	conn := NewTextChatConn("dev-1")
+	go func() {
+		sendServerMessage(t, conn, msg.ServerMessage{Type: msg.ServerMessageTypeTts, State: msg.MessageStateStart})
+		sendServerMessage(t, conn, msg.ServerMessage{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceStart, Text: "你好"})
+		conn.SendAudio([]byte{0x01, 0x02})
+		sendServerMessage(t, conn, msg.ServerMessage{Type: msg.ServerMessageTypeTts, State: msg.MessageStateSentenceEnd, Text: "你好"})
+		sendServerMessage(t, conn, msg.ServerMessage{Type: msg.ServerMessageTypeTts, State: msg.MessageStateStop})
+	}()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/xiaozhi/api/chat", nil)
+	streamTextChat(w, w, req, conn, time.Second)
+
+	names, data := readSseEvents(t, w.Body.String())
+	assert.Equal(t, []string{TextChatEventSentence, TextChatEventDone}, names)
+	var sentence TextChatSentence
+	require.NoError(t, json.Unmarshal([]byte(data[0]), &sentence))
+	assert.Equal(t, TextChatSentence{Index: 0, Text: "你好", Audio: []string{"AQI="}}, sentence)
+	assert.JSONEq(t, `{"sentences":1}`, data[1])
+}
+
+func TestStreamTextChat_ClientClose(t *testing.T) {
+	conn := NewTextChatConn("dev-1")
+	closed := make(chan string, 1)
+	conn.OnClose(func(deviceId string) {
+		closed <- deviceId
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/xiaozhi/api/chat", nil).WithContext(ctx)
+	streamTextChat(w, w, req, conn, time.Second)
+	assert.Equal(t, "dev-1", <-closed, "客户端断开时通知会话释放")
+}
+
+func TestStreamTextChat_Timeout(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/xiaozhi/api/chat", nil)
+	streamTextChat(w, w, req, NewTextChatConn("dev-1"), 10*time.Millisecond)
+
+	names, _ := readSseEvents(t, w.Body.String())
+	assert.Equal(t, []string{TextChatEventError}, names)
+}
@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 客户端证书校验方式
+const (
+	ClientAuthNone    = "none"    // 不校验客户端证书
+	ClientAuthRequest = "request" // 客户端提供证书时校验, 未提供也允许连接
+	ClientAuthRequire = "require" // 必须提供有效的客户端证书
+)
+
+// 证书文件变化后延迟一段时间再加载, 避免证书和私钥只更新了一半
+const certReloadDelay = 500 * time.Millisecond
+
+// TlsConfig websocket/ota 服务的 tls 配置
+type TlsConfig struct {
+	CertFile string
+	KeyFile  string
+	// 校验客户端证书的 CA, 为空时不开启双向认证
+	ClientCaFile string
+	ClientAuth   string
+	// 使用客户端证书的 CN 作为 Device-Id
+	CnAsDeviceId bool
+}
+
+// certReloader 持有当前生效的证书, 文件变化时重新加载, 加载失败时保留旧证书
+type certReloader struct {
+	config *TlsConfig
+
+	cert     *tls.Certificate
+	clientCa *x509.CertPool
+	mu       sync.RWMutex
+
+	watcher *fsnotify.Watcher
+}
+
+func newCertReloader(config *TlsConfig) (*certReloader, error) {
+	r := &certReloader{config: config}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %v", err)
+	}
+
+	var clientCa *x509.CertPool
+	if r.config.ClientCaFile != "" {
+		caPem, err := os.ReadFile(r.config.ClientCaFile)
+		if err != nil {
+			return fmt.Errorf("读取客户端CA失败: %v", err)
+		}
+		clientCa = x509.NewCertPool()
+		if !clientCa.AppendCertsFromPEM(caPem) {
+			return fmt.Errorf("解析客户端CA失败: %s", r.config.ClientCaFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCa = clientCa
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// tlsConfig 每次握手都取最新的证书和客户端CA
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			clientCa := r.clientCa
+			r.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: r.getCertificate,
+			}
+			if clientCa != nil {
+				config.ClientCAs = clientCa
+				config.ClientAuth = clientAuthType(r.config.ClientAuth)
+			}
+			return config, nil
+		},
+	}
+}
+
+func clientAuthType(clientAuth string) tls.ClientAuthType {
+	switch clientAuth {
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert
+	case ClientAuthNone:
+		return tls.NoClientCert
+	default:
+		return tls.VerifyClientCertIfGiven
+	}
+}
+
+// watch 监听证书所在目录, 兼容 k8s secret 等通过替换软链接更新证书的方式
+func (r *certReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]struct{})
+	for _, file := range []string{r.config.CertFile, r.config.KeyFile, r.config.ClientCaFile} {
+		if file == "" {
+			continue
+		}
+		dirs[filepath.Dir(file)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("监听证书目录 %s 失败: %v", dir, err)
+		}
+	}
+	r.watcher = watcher
+
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(certReloadDelay, func() {
+					if err := r.reload(); err != nil {
+						log.Errorf("证书重新加载失败, 继续使用旧证书: %v", err)
+						return
+					}
+					log.Infof("证书已重新加载: %s", r.config.CertFile)
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("证书文件监听出错: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *certReloader) close() {
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+}
+
+// GetDeviceId 获取请求的 Device-Id, 挂在同一个 http server 上的设备接口(含 webrtc 信令)都通过它识别设备
+// 开启了证书 CN 映射且客户端提供了证书时以 CN 为准, 请求头中的 Device-Id 与其不一致则拒绝
+func (s *WebSocketServer) GetDeviceId(r *http.Request) (string, error) {
+	deviceId := r.Header.Get("Device-Id")
+	if s.tlsConfig == nil || !s.tlsConfig.CnAsDeviceId || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return deviceId, nil
+	}
+	cn := r.TLS.PeerCertificates[0].Subject.CommonName
+	if cn == "" {
+		return "", errors.New("客户端证书缺少CN")
+	}
+	if deviceId != "" && deviceId != cn {
+		return "", fmt.Errorf("Device-Id %s 与客户端证书CN %s 不一致", deviceId, cn)
+	}
+	return cn, nil
+}
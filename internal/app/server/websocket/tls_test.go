@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSignedCert 生成自签名证书写入 dir, 返回证书和私钥路径
+func writeSelfSignedCert(t *testing.T, dir string, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func currentCN(t *testing.T, r *certReloader) string {
+	cert, err := r.getCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader_ReloadOnChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "old")
+
+	r, err := newCertReloader(&TlsConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	require.NoError(t, r.watch())
+	defer r.close()
+	assert.Equal(t, "old", currentCN(t, r))
+
+	writeSelfSignedCert(t, dir, "new")
+	assert.Eventually(t, func() bool {
+		return currentCN(t, r) == "new"
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestCertReloader_KeepOldCertOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "old")
+
+	r, err := newCertReloader(&TlsConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0600))
+	assert.Error(t, r.reload())
+	assert.Equal(t, "old", currentCN(t, r))
+}
+
+func TestGetDeviceId_ClientCertCN(t *testing.T) {
+	s := &WebSocketServer{tlsConfig: &TlsConfig{CnAsDeviceId: true}}
+	peer := &x509.Certificate{Subject: pkix.Name{CommonName: "aa:bb:cc:dd:ee:ff"}}
+
+	newRequest := func(header string, withCert bool) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "/xiaozhi/v1/", nil)
+		if header != "" {
+			r.Header.Set("Device-Id", header)
+		}
+		if withCert {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{peer}}
+		}
+		return r
+	}
+
+	deviceId, err := s.GetDeviceId(newRequest("", true))
+	assert.NoError(t, err)
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", deviceId)
+
+	deviceId, err = s.GetDeviceId(newRequest("aa:bb:cc:dd:ee:ff", true))
+	assert.NoError(t, err)
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", deviceId)
+
+	_, err = s.GetDeviceId(newRequest("11:22:33:44:55:66", true))
+	assert.Error(t, err)
+
+	// 未提供证书时沿用请求头
+	deviceId, err = s.GetDeviceId(newRequest("11:22:33:44:55:66", false))
+	assert.NoError(t, err)
+	assert.Equal(t, "11:22:33:44:55:66", deviceId)
+}
@@ -18,7 +18,12 @@ func (s *WebSocketServer) handleVisionAPI(w http.ResponseWriter, r *http.Request
 	}
 
 	//从header头部获取Device-Id和Client-Id
-	deviceId := r.Header.Get("Device-Id")
+	deviceId, err := s.GetDeviceId(r)
+	if err != nil {
+		log.Warnf("设备身份校验失败: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	clientId := r.Header.Get("Client-Id")
 	_ = clientId
 	if deviceId == "" {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"sync"
 	"time"
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
 	log "xiaozhi-esp32-server-golang/logger"
 
@@ -25,13 +26,15 @@ type WebSocketConn struct {
 	isMqttUdpBridge bool
 	recvCmdChan     chan []byte
 	recvAudioChan   chan []byte
+	// 上行音频帧率限制, 为 nil 时不限制
+	audioLimiter *ratelimit.AudioLimiter
 
 	closed bool
 	sync.RWMutex
 }
 
 // NewWebSocketConn 创建一个新的 WebSocketConn 实例
-func NewWebSocketConn(conn *websocket.Conn, deviceID string, isMqttUdpBridge bool) *WebSocketConn {
+func NewWebSocketConn(conn *websocket.Conn, deviceID string, isMqttUdpBridge bool, audioLimiter *ratelimit.AudioLimiter) *WebSocketConn {
 	ctx, cancel := context.WithCancel(context.Background())
 	instance := &WebSocketConn{
 		ctx:             ctx,
@@ -41,6 +44,7 @@ func NewWebSocketConn(conn *websocket.Conn, deviceID string, isMqttUdpBridge boo
 		isMqttUdpBridge: isMqttUdpBridge,
 		recvCmdChan:     make(chan []byte, 100),
 		recvAudioChan:   make(chan []byte, 100),
+		audioLimiter:    audioLimiter,
 	}
 
 	// 设置pong处理器
@@ -94,6 +98,14 @@ func NewWebSocketConn(conn *websocket.Conn, deviceID string, isMqttUdpBridge boo
 						log.Errorf("recv cmd channel is full")
 					}
 				} else if msgType == websocket.BinaryMessage {
+					if !instance.audioLimiter.Allow() {
+						// 持续超速说明不是正常设备, 断开连接, 偶发的突发只丢帧
+						if instance.audioLimiter.Flooding() {
+							log.Warnf("设备 %s 上行音频帧率超限, 断开连接", deviceID)
+							instance.closeWithReason(ratelimit.ReasonAudioRate)
+						}
+						continue
+					}
 					if instance.isMqttUdpBridge {
 						audio = instance.tryUnpackUdpBridgeAudioPacket(audio)
 					}
@@ -236,6 +248,18 @@ func (w *WebSocketConn) Close() error {
 	return nil
 }
 
+// closeWithReason 发送带原因的关闭帧后断开, 读协程随之退出并通知注册方
+func (w *WebSocketConn) closeWithReason(reason string) {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return
+	}
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	w.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	w.conn.Close()
+}
+
 func (w *WebSocketConn) OnClose(cb func(deviceId string)) {
 	w.onCloseCbList = append(w.onCloseCbList, cb)
 }
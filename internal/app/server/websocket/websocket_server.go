@@ -1,14 +1,19 @@
 package websocket
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
 	"xiaozhi-esp32-server-golang/internal/app/server/auth"
+	"xiaozhi-esp32-server-golang/internal/app/server/ratelimit"
 	"xiaozhi-esp32-server-golang/internal/app/server/types"
 	"xiaozhi-esp32-server-golang/internal/domain/mcp"
 	log "xiaozhi-esp32-server-golang/logger"
@@ -28,6 +33,21 @@ type WebSocketServer struct {
 	globalMCPManager *mcp.GlobalMCPManager
 
 	onNewConnection types.OnNewConnection
+	onNewTextChat   OnNewTextChat
+
+	// 在构造时创建, Shutdown 可能早于 Start 中的监听执行
+	httpServer *http.Server
+	// 为空时以明文 http/ws 提供服务
+	tlsConfig *TlsConfig
+	// certReloader 在 Start 所在协程中创建, 由 reloaderLock 保护
+	certReloader *certReloader
+	reloaderLock sync.Mutex
+	// 排空中, 不再接受新的设备连接
+	draining atomic.Bool
+	// 连接数/握手频率/音频帧率限制, 为 nil 时不限制
+	limiter *ratelimit.Limiter
+	// 受信任的反向代理, 来自这些地址的请求才采用 X-Real-IP/X-Forwarded-For
+	trustedProxies []*net.IPNet
 }
 
 // Option 类型定义
@@ -48,12 +68,40 @@ func WithMCPManager(mcpManager *mcp.GlobalMCPManager) WebSocketServerOption {
 	}
 }
 
+// WithTls 开启 wss/https, 证书文件变化时自动重新加载
+func WithTls(tlsConfig *TlsConfig) WebSocketServerOption {
+	return func(s *WebSocketServer) {
+		s.tlsConfig = tlsConfig
+	}
+}
+
+// WithLimiter 设置按 Device-Id/IP 的连接和频率限制
+func WithLimiter(limiter *ratelimit.Limiter) WebSocketServerOption {
+	return func(s *WebSocketServer) {
+		s.limiter = limiter
+	}
+}
+
+// WithTrustedProxies 设置受信任的反向代理地址(ip 或 CIDR), 用于获取限流时的客户端ip
+func WithTrustedProxies(proxies []string) WebSocketServerOption {
+	return func(s *WebSocketServer) {
+		s.trustedProxies = parseTrustedProxies(proxies)
+	}
+}
+
 func WithOnNewConnection(onNewConnection types.OnNewConnection) WebSocketServerOption {
 	return func(s *WebSocketServer) {
 		s.onNewConnection = onNewConnection
 	}
 }
 
+// WithOnNewTextChat 设置文本对话的会话创建入口, 未设置时文本对话接口不可用
+func WithOnNewTextChat(onNewTextChat OnNewTextChat) WebSocketServerOption {
+	return func(s *WebSocketServer) {
+		s.onNewTextChat = onNewTextChat
+	}
+}
+
 // NewWebSocketServer 创建新的 WebSocket 服务器（WithOption 方式）
 func NewWebSocketServer(port int, opts ...WebSocketServerOption) *WebSocketServer {
 	s := &WebSocketServer{
@@ -68,6 +116,7 @@ func NewWebSocketServer(port int, opts ...WebSocketServerOption) *WebSocketServe
 		authManager:      auth.A(),
 		port:             port,
 		globalMCPManager: mcp.GetGlobalMCPManager(),
+		httpServer:       &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", port)},
 	}
 	for _, opt := range opts {
 		opt(s)
@@ -94,21 +143,72 @@ func (s *WebSocketServer) Start() error {
 	http.HandleFunc("/mcp", s.handleMCPWebSocket)
 	http.HandleFunc("/xiaozhi/api/mcp/tools/", s.handleMCPAPI)
 	http.HandleFunc("/xiaozhi/api/vision", s.handleVisionAPI) //图片识别API
+	http.HandleFunc("/xiaozhi/api/chat", s.handleTextChat)    //文本对话API(SSE)
 
-	http.HandleFunc("/admin/inject_msg", s.handleInjectMsg)
-
-	listenAddr := fmt.Sprintf("0.0.0.0:%d", s.port)
-	log.Infof("WebSocket 服务器启动在 ws://%s/xiaozhi/v1/", listenAddr)
-	log.Infof("MCP WebSocket 端点: ws://%s/mcp?token=xxx", listenAddr)
-	log.Infof("MCP API 端点: http://%s/xiaozhi/api/mcp/tools/{deviceId}", listenAddr)
+	listenAddr := s.httpServer.Addr
+	wsScheme, httpScheme := "ws", "http"
+	if s.tlsConfig != nil {
+		wsScheme, httpScheme = "wss", "https"
+	}
+	log.Infof("WebSocket 服务器启动在 %s://%s/xiaozhi/v1/", wsScheme, listenAddr)
+	log.Infof("MCP WebSocket 端点: %s://%s/mcp?token=xxx", wsScheme, listenAddr)
+	log.Infof("MCP API 端点: %s://%s/xiaozhi/api/mcp/tools/{deviceId}", httpScheme, listenAddr)
+	log.Infof("文本对话 端点: %s://%s/xiaozhi/api/chat", httpScheme, listenAddr)
 
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
+	var err error
+	if s.tlsConfig != nil {
+		err = s.listenAndServeTls()
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			log.Info("WebSocket 服务器已关闭")
+			return nil
+		}
 		log.Log().Fatalf("WebSocket 服务器启动失败: %v", err)
 		return err
 	}
 	return nil
 }
 
+func (s *WebSocketServer) listenAndServeTls() error {
+	reloader, err := newCertReloader(s.tlsConfig)
+	if err != nil {
+		return err
+	}
+	if err := reloader.watch(); err != nil {
+		// 不影响服务, 只是证书更新后需要重启
+		log.Warnf("证书热更新不可用: %v", err)
+	}
+	s.reloaderLock.Lock()
+	s.certReloader = reloader
+	s.reloaderLock.Unlock()
+	s.httpServer.TLSConfig = reloader.tlsConfig()
+	if s.tlsConfig.ClientCaFile != "" {
+		log.Infof("已开启客户端证书校验, 模式: %s, CN作为Device-Id: %v", s.tlsConfig.ClientAuth, s.tlsConfig.CnAsDeviceId)
+	}
+	return s.httpServer.ListenAndServeTLS("", "")
+}
+
+// Drain 进入排空状态, 新的设备连接直接返回 503, 已有连接不受影响
+func (s *WebSocketServer) Drain() {
+	s.draining.Store(true)
+}
+
+// Shutdown 关闭监听, 已升级为 websocket 的连接需由上层自行关闭
+func (s *WebSocketServer) Shutdown(ctx context.Context) error {
+	s.reloaderLock.Lock()
+	if s.certReloader != nil {
+		s.certReloader.close()
+	}
+	s.reloaderLock.Unlock()
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Shutdown(ctx)
+}
+
 // handleGetDeviceTools 获取设备的工具列表
 func (s *WebSocketServer) handleGetDeviceTools(w http.ResponseWriter, r *http.Request, deviceID string) {
 
@@ -134,8 +234,18 @@ func (s *WebSocketServer) handleMqttUdpChat(w http.ResponseWriter, r *http.Reque
 
 // handleWebSocket 处理 WebSocket 连接
 func (s *WebSocketServer) internalHandleChat(w http.ResponseWriter, r *http.Request, isMqttUdp bool) {
+	if s.draining.Load() {
+		http.Error(w, "服务器正在下线", http.StatusServiceUnavailable)
+		return
+	}
+
 	// 验证请求头
-	deviceID := r.Header.Get("Device-Id")
+	deviceID, err := s.GetDeviceId(r)
+	if err != nil {
+		log.Warnf("设备身份校验失败: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if deviceID == "" {
 		log.Warn("缺少 Device-Id 请求头")
 		http.Error(w, "缺少 Device-Id 请求头", http.StatusBadRequest)
@@ -159,21 +269,37 @@ func (s *WebSocketServer) internalHandleChat(w http.ResponseWriter, r *http.Requ
 		}
 	}*/
 
+	ip := s.ClientIp(r)
+	if err := s.limiter.AllowHandshake(types.TransportTypeWebsocket, deviceID, ip); err != nil {
+		ratelimit.RejectHttp(w, deviceID, ip, err)
+		return
+	}
+	release, err := s.limiter.Acquire(types.TransportTypeWebsocket, deviceID, ip)
+	if err != nil {
+		ratelimit.RejectHttp(w, deviceID, ip, err)
+		return
+	}
+
 	// 升级 HTTP 连接为 WebSocket
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		release()
 		log.Errorf("WebSocket 升级失败: %v", err)
 		return
 	}
 
 	// 适配为 IConn 接口
-	wsConn := NewWebSocketConn(conn, deviceID, isMqttUdp)
+	audioLimiter := s.limiter.NewAudioLimiter(types.TransportTypeWebsocket, deviceID)
+	wsConn := NewWebSocketConn(conn, deviceID, isMqttUdp, audioLimiter)
+	wsConn.OnClose(func(string) {
+		release()
+	})
+	go func() {
+		<-wsConn.ctx.Done()
+		release()
+	}()
 	if s.onNewConnection != nil {
 		s.onNewConnection(wsConn)
 	}
 
 }
-
-func (s *WebSocketServer) handleInjectMsg(w http.ResponseWriter, r *http.Request) {
-
-}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"sync"
 	"xiaozhi-esp32-server-golang/internal/domain/asr"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
 	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	log "xiaozhi-esp32-server-golang/logger"
 )
@@ -21,6 +22,12 @@ type Asr struct {
 	AsrResult        bytes.Buffer                   //保存此次识别到的最终文本
 	Statue           int                            //0:初始化 1:识别中 2:识别结束
 	AutoEnd          bool                           //auto_end是指使用asr自动判断结束，不再使用vad模块
+
+	// 收到每个识别结果片断时回调, 用于会话录制、链路追踪和错误计数
+	OnResult func(asr_types.StreamingResult)
+
+	// 按热词读音纠正最终识别结果, 没有热词时为 nil
+	HotwordCorrector *hotword.Corrector
 }
 
 func (a *Asr) Reset() {
@@ -37,12 +44,15 @@ func (a *Asr) RetireAsrResult(ctx context.Context) (string, bool, error) {
 			return "", false, nil
 		case result, ok := <-a.AsrResultChannel:
 			log.Debugf("asr result: %s, ok: %+v, isFinal: %+v, error: %+v", result.Text, ok, result.IsFinal, result.Error)
+			if ok && a.OnResult != nil {
+				a.OnResult(result)
+			}
 			if result.Error != nil {
 				return "", false, result.Error
 			}
 			a.AsrResult.WriteString(result.Text)
 			if a.AutoEnd || result.IsFinal {
-				text := a.AsrResult.String()
+				text := a.HotwordCorrector.Correct(a.AsrResult.String())
 				return text, true, nil
 			}
 			if !ok {
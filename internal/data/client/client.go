@@ -10,10 +10,14 @@ import (
 	"sync"
 
 	"xiaozhi-esp32-server-golang/internal/domain/asr"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
+	asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	utypes "xiaozhi-esp32-server-golang/internal/domain/config/types"
 	"xiaozhi-esp32-server-golang/internal/domain/llm"
 	llm_common "xiaozhi-esp32-server-golang/internal/domain/llm/common"
 	"xiaozhi-esp32-server-golang/internal/domain/memory"
+	"xiaozhi-esp32-server-golang/internal/domain/speaker"
+	"xiaozhi-esp32-server-golang/internal/domain/tracing"
 	"xiaozhi-esp32-server-golang/internal/domain/tts"
 
 	. "xiaozhi-esp32-server-golang/internal/data/audio"
@@ -29,14 +33,6 @@ type Dialogue struct {
 	Messages []*schema.Message
 }
 
-const (
-	ClientStatusInit       = "init"
-	ClientStatusListening  = "listening"
-	ClientStatusListenStop = "listenStop"
-	ClientStatusLLMStart   = "llmStart"
-	ClientStatusTTSStart   = "ttsStart"
-)
-
 type SendAudioData func(audioData []byte) error
 
 // ClientState 表示客户端状态
@@ -66,6 +62,9 @@ type ClientState struct {
 	// memory提供者
 	MemoryProvider memory.MemoryProvider
 	MemoryContext  string //memory context
+	// 本次会话读写过的记忆体 id, 识别出说话人时每人一份, 会话结束时逐个刷新
+	memoryKeys   map[string]struct{}
+	memoryKeysMu sync.Mutex
 
 	// 上下文控制
 	Ctx    context.Context
@@ -88,15 +87,16 @@ type ClientState struct {
 
 	VoiceStatus
 
-	UdpSendAudioData SendAudioData //发送音频数据
-	Statistic        Statistic     //耗时统计
-	MqttLastActiveTs int64         //最后活跃时间
-	VadLastActiveTs  int64         //vad最后活跃时间, 超过 60s && 没有在tts则断开连接
+	UdpSendAudioData SendAudioData    //发送音频数据
+	Statistic        Statistic        //耗时统计
+	Trace            *tracing.Session //每轮对话的链路追踪, 未开启时为 nil
+	MqttLastActiveTs int64            //最后活跃时间
+	VadLastActiveTs  int64            //vad最后活跃时间, 超过 60s && 没有在tts则断开连接
 
-	Status string //状态 listening, llmStart, ttsStart
+	// 对话状态, 通过 SetStatus 迁移, 见 state.go
+	state stateMachine
 
-	IsTtsStart        bool //是否tts开始
-	IsWelcomeSpeaking bool //是否已经欢迎语
+	IsWelcomeSpeaking bool //本次连接是否已经播放过欢迎语
 }
 
 func (c *ClientState) IsRealTime() bool {
@@ -110,6 +110,29 @@ func (c *ClientState) GetDeviceIDOrAgentID() string {
 	return c.DeviceID
 }
 
+// MemoryKey 说话人在记忆体中的 id, speakerName 为空时即 GetDeviceIDOrAgentID
+func (c *ClientState) MemoryKey(speakerName string) string {
+	key := speaker.MemoryKey(c.GetDeviceIDOrAgentID(), speakerName)
+	c.memoryKeysMu.Lock()
+	defer c.memoryKeysMu.Unlock()
+	if c.memoryKeys == nil {
+		c.memoryKeys = make(map[string]struct{})
+	}
+	c.memoryKeys[key] = struct{}{}
+	return key
+}
+
+// MemoryKeys 本次会话用到的全部记忆体 id
+func (c *ClientState) MemoryKeys() []string {
+	c.memoryKeysMu.Lock()
+	defer c.memoryKeysMu.Unlock()
+	keys := make([]string, 0, len(c.memoryKeys))
+	for key := range c.memoryKeys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 // 历史消息相关的方法开始
 func (c *ClientState) AddMessage(msg *schema.Message) {
 	if msg == nil {
@@ -223,14 +246,6 @@ func (c *ClientState) InitMessages(messages []*schema.Message) error {
 
 //历史消息相关的方法结束
 
-func (c *ClientState) SetTtsStart(isStart bool) {
-	c.IsTtsStart = isStart
-}
-
-func (c *ClientState) GetTtsStart() bool {
-	return c.IsTtsStart
-}
-
 func (c *ClientState) GetMaxIdleDuration() int64 {
 	maxIdleDuration := viper.GetInt64("chat.max_idle_duration")
 	if maxIdleDuration == 0 {
@@ -248,14 +263,6 @@ func (c *ClientState) IsActive() bool {
 	return c.MqttLastActiveTs > 0 && diff <= ClientActiveTs
 }
 
-func (c *ClientState) SetStatus(status string) {
-	c.Status = status
-}
-
-func (c *ClientState) GetStatus() string {
-	return c.Status
-}
-
 type Ctx struct {
 	sync.RWMutex
 	ctx    context.Context
@@ -304,20 +311,23 @@ func (s *ClientState) getLLMProvider() (llm.LLMProvider, error) {
 }
 
 func (s *ClientState) InitLlm() error {
-	ctx, cancel := context.WithCancel(s.Ctx)
-
 	llmProvider, err := s.getLLMProvider()
 	if err != nil {
 		log.Errorf("创建 LLM 提供者失败: %v", err)
 		return err
 	}
+	s.InitLlmWithProvider(llmProvider)
+	return nil
+}
 
+// InitLlmWithProvider 使用已创建好的 llm 提供者初始化
+func (s *ClientState) InitLlmWithProvider(llmProvider llm.LLMProvider) {
+	ctx, cancel := context.WithCancel(s.Ctx)
 	s.Llm = Llm{
 		Ctx:         ctx,
 		Cancel:      cancel,
 		LLMProvider: llmProvider,
 	}
-	return nil
 }
 
 func (s *ClientState) InitAsr() error {
@@ -325,12 +335,27 @@ func (s *ClientState) InitAsr() error {
 
 	log.Infof("初始化asr, asrConfig: %+v", asrConfig)
 
-	//初始化asr
-	asrProvider, err := asr.NewAsrProvider(asrConfig.Provider, asrConfig.Config)
+	//初始化asr, 热词随配置传给支持偏置的引擎
+	providerConfig := asrConfig.Config
+	if hotwords := s.asrHotwords(); len(hotwords) > 0 {
+		providerConfig = make(map[string]interface{}, len(asrConfig.Config)+1)
+		for k, v := range asrConfig.Config {
+			providerConfig[k] = v
+		}
+		providerConfig["hotwords"] = hotwords
+	}
+	asrProvider, err := asr.NewAsrProvider(asrConfig.Provider, providerConfig)
 	if err != nil {
 		log.Errorf("创建asr提供者失败: %v", err)
 		return fmt.Errorf("创建asr提供者失败: %v", err)
 	}
+	s.InitAsrWithProvider(asrProvider)
+	return nil
+}
+
+// InitAsrWithProvider 使用已创建好的 asr 提供者初始化, auto_end 仍从设备配置中读取
+func (s *ClientState) InitAsrWithProvider(asrProvider asr.AsrProvider) {
+	asrConfig := s.DeviceConfig.Asr
 	ctx, cancel := context.WithCancel(s.Ctx)
 	s.Asr = Asr{
 		Ctx:             ctx,
@@ -340,13 +365,21 @@ func (s *ClientState) InitAsr() error {
 		AsrEnd:          make(chan bool, 1),
 		AsrResult:       bytes.Buffer{},
 	}
+	// 不支持热词偏置的引擎靠识别后纠正兜底, funasr、豆包等已在服务端偏置, 不再重复纠正
+	if !asr.SupportsHotwords(asrProvider) {
+		s.Asr.HotwordCorrector = hotword.NewCorrector(s.asrHotwords())
+	}
 
 	if rawAutoEnd, ok := asrConfig.Config["auto_end"]; ok {
 		if autoEnd, ok := rawAutoEnd.(bool); ok {
 			s.Asr.AutoEnd = autoEnd
 		}
 	}
-	return nil
+}
+
+// asrHotwords asr 配置中的全局热词与智能体热词合并
+func (s *ClientState) asrHotwords() []asr_types.Hotword {
+	return hotword.Merge(hotword.Parse(s.DeviceConfig.Asr.Config["hotwords"]), s.DeviceConfig.Hotwords)
 }
 
 func (c *ClientState) Destroy() {
@@ -361,7 +394,6 @@ func (c *ClientState) Destroy() {
 
 	c.Statistic.Reset()
 	c.SetStatus(ClientStatusInit)
-	c.SetTtsStart(false)
 }
 
 func (c *ClientState) SetAsrPcmFrameSize(sampleRate int, channels int, perFrameDuration int) {
@@ -378,10 +410,12 @@ func (state *ClientState) OnVoiceSilence() {
 	state.Asr.Stop() //停止asr并获取结果，进行llm
 	//释放vad
 	state.Vad.Reset() //释放vad实例
+	state.Trace.SpeechEnd()
 	//asr统计
 	state.SetStartAsrTs() //进行asr统计
 
-	state.SetStatus(ClientStatusListenStop)
+	// realtime 模式下播放中也会持续拾音, 此时保持播放状态
+	state.CompareAndSetStatus(ClientStatusListenStop, ClientStatusListening)
 }
 
 type Llm struct {
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/domain/eventbus"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// ConversationState 对话状态
+type ConversationState string
+
+const (
+	ClientStatusInit       ConversationState = "init"       // 空闲
+	ClientStatusListening  ConversationState = "listening"  // 拾音中
+	ClientStatusListenStop ConversationState = "listenStop" // 停止说话, 等待asr结果
+	ClientStatusLLMStart   ConversationState = "llmStart"   // llm生成中
+	ClientStatusTTSStart   ConversationState = "ttsStart"   // 已下发 tts start, 设备播放中
+)
+
+// stateTransitions 允许的状态迁移, 任意状态都可以回到 init
+// 播放中或生成中要重新拾音, 必须先停止播放回到 init
+var stateTransitions = map[ConversationState][]ConversationState{
+	ClientStatusInit:       {ClientStatusListening, ClientStatusLLMStart, ClientStatusTTSStart},
+	ClientStatusListening:  {ClientStatusListenStop, ClientStatusLLMStart, ClientStatusTTSStart},
+	ClientStatusListenStop: {ClientStatusListening, ClientStatusLLMStart, ClientStatusTTSStart},
+	ClientStatusLLMStart:   {ClientStatusTTSStart},
+	// 工具调用时会在播放中再次请求llm
+	ClientStatusTTSStart: {ClientStatusLLMStart},
+}
+
+var ErrIllegalTransition = errors.New("illegal state transition")
+
+// CanTransition 是否允许从 from 迁移到 to, 保持原状态视为允许
+func CanTransition(from ConversationState, to ConversationState) bool {
+	if from == to || to == ClientStatusInit {
+		return true
+	}
+	for _, allowed := range stateTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateTransition 状态迁移事件, 通过 eventbus.TopicStateTransition 发布
+type StateTransition struct {
+	DeviceID  string            `json:"device_id"`
+	SessionID string            `json:"session_id"`
+	From      ConversationState `json:"from"`
+	To        ConversationState `json:"to"`
+	Time      time.Time         `json:"time"`
+}
+
+// stateMachine 保存当前状态, 零值为 init
+type stateMachine struct {
+	state ConversationState
+	since time.Time
+	mu    sync.RWMutex
+}
+
+func (m *stateMachine) get() (ConversationState, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.state == "" {
+		return ClientStatusInit, m.since
+	}
+	return m.state, m.since
+}
+
+// transition 当前状态在 from 中时迁移到 to, from 为空表示不限制当前状态
+// 返回迁移前的状态和状态是否发生了变化
+func (m *stateMachine) transition(to ConversationState, from ...ConversationState) (ConversationState, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := m.state
+	if current == "" {
+		current = ClientStatusInit
+	}
+	if len(from) > 0 && !containsState(from, current) {
+		return current, false, nil
+	}
+	if !CanTransition(current, to) {
+		return current, false, fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current, to)
+	}
+	if current == to {
+		return current, false, nil
+	}
+	m.state = to
+	m.since = time.Now()
+	return current, true, nil
+}
+
+func containsState(states []ConversationState, state ConversationState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStatus 迁移到新状态, 非法迁移会被拒绝并返回 ErrIllegalTransition
+func (c *ClientState) SetStatus(status ConversationState) error {
+	from, changed, err := c.state.transition(status)
+	if err != nil {
+		log.Warnf("设备 %s 拒绝非法状态迁移: %v", c.DeviceID, err)
+		return err
+	}
+	if changed {
+		c.publishTransition(from, status)
+	}
+	return nil
+}
+
+// CompareAndSetStatus 仅当前状态为 from 之一时迁移, 用于可能过期的异步回调, 如被打断后才结束的 tts
+func (c *ClientState) CompareAndSetStatus(status ConversationState, from ...ConversationState) bool {
+	prev, changed, err := c.state.transition(status, from...)
+	if err != nil {
+		log.Warnf("设备 %s 拒绝非法状态迁移: %v", c.DeviceID, err)
+		return false
+	}
+	if changed {
+		c.publishTransition(prev, status)
+	}
+	return changed
+}
+
+func (c *ClientState) publishTransition(from ConversationState, to ConversationState) {
+	log.Debugf("设备 %s 状态迁移: %s -> %s", c.DeviceID, from, to)
+	eventbus.Get().Publish(eventbus.TopicStateTransition, c, StateTransition{
+		DeviceID:  c.DeviceID,
+		SessionID: c.SessionID,
+		From:      from,
+		To:        to,
+		Time:      time.Now(),
+	})
+}
+
+func (c *ClientState) GetStatus() ConversationState {
+	status, _ := c.state.get()
+	return status
+}
+
+// GetStatusSince 进入当前状态的时间
+func (c *ClientState) GetStatusSince() time.Time {
+	_, since := c.state.get()
+	return since
+}
+
+// IsSpeaking 是否已下发 tts start 且还未结束
+func (c *ClientState) IsSpeaking() bool {
+	return c.GetStatus() == ClientStatusTTSStart
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"xiaozhi-esp32-server-golang/internal/domain/eventbus"
+)
+
+func TestClientState_SetStatus(t *testing.T) {
+	state := &ClientState{DeviceID: "state-test"}
+	assert.Equal(t, ClientStatusInit, state.GetStatus())
+
+	assert.NoError(t, state.SetStatus(ClientStatusListening))
+	assert.NoError(t, state.SetStatus(ClientStatusListenStop))
+	assert.NoError(t, state.SetStatus(ClientStatusLLMStart))
+	assert.NoError(t, state.SetStatus(ClientStatusTTSStart))
+
+	// 播放中不能直接进入拾音, 需先停止播放
+	err := state.SetStatus(ClientStatusListening)
+	assert.True(t, errors.Is(err, ErrIllegalTransition))
+	assert.Equal(t, ClientStatusTTSStart, state.GetStatus())
+
+	assert.NoError(t, state.SetStatus(ClientStatusInit))
+	assert.NoError(t, state.SetStatus(ClientStatusListening))
+}
+
+func TestClientState_CompareAndSetStatus(t *testing.T) {
+	state := &ClientState{DeviceID: "state-test"}
+	assert.NoError(t, state.SetStatus(ClientStatusListening))
+
+	// 被打断后才结束的 tts 不影响已经开始的拾音
+	assert.False(t, state.CompareAndSetStatus(ClientStatusInit, ClientStatusTTSStart, ClientStatusLLMStart))
+	assert.Equal(t, ClientStatusListening, state.GetStatus())
+
+	assert.True(t, state.CompareAndSetStatus(ClientStatusListenStop, ClientStatusListening))
+	assert.Equal(t, ClientStatusListenStop, state.GetStatus())
+}
+
+func TestClientState_PublishTransition(t *testing.T) {
+	deviceID := "state-publish-test"
+	var transitions []StateTransition
+	handler := func(clientState *ClientState, transition StateTransition) {
+		if transition.DeviceID == deviceID {
+			transitions = append(transitions, transition)
+		}
+	}
+	assert.NoError(t, eventbus.Get().Subscribe(eventbus.TopicStateTransition, handler))
+	defer eventbus.Get().Unsubscribe(eventbus.TopicStateTransition, handler)
+
+	state := &ClientState{DeviceID: deviceID}
+	state.SetStatus(ClientStatusListening)
+	// 保持原状态和非法迁移都不发布
+	state.SetStatus(ClientStatusListening)
+	state.SetStatus(ClientStatusLLMStart)
+	state.SetStatus(ClientStatusListening)
+	state.SetStatus(ClientStatusInit)
+
+	if assert.Equal(t, 3, len(transitions)) {
+		assert.Equal(t, ClientStatusInit, transitions[0].From)
+		assert.Equal(t, ClientStatusListening, transitions[0].To)
+		assert.Equal(t, ClientStatusLLMStart, transitions[1].To)
+		assert.Equal(t, ClientStatusLLMStart, transitions[2].From)
+		assert.Equal(t, ClientStatusInit, transitions[2].To)
+	}
+}
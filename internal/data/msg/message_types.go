@@ -23,6 +23,7 @@ const (
 	MessageTypeIot     = "iot"     // 物联网消息
 	MessageTypeMcp     = "mcp"     // MCP消息
 	MessageTypeGoodBye = "goodbye" // 再见消息
+	MessageTypeUdpKey  = "udp_key" // 设备确认已切换到新的udp密钥
 )
 
 // 服务器消息类型常量
@@ -34,6 +35,7 @@ const (
 	ServerMessageTypeLlm     = "llm"     // 大语言模型
 	ServerMessageTypeText    = "text"    // 文本消息
 	ServerMessageTypeGoodBye = "goodbye" // 再见消息
+	ServerMessageTypeUdpKey  = "udp_key" // 下发新的udp密钥
 )
 
 // 消息状态常量
@@ -64,6 +66,7 @@ type ServerMessage struct {
 	Transport   string                   `json:"transport,omitempty"`
 	AudioFormat *types_audio.AudioFormat `json:"audio_params,omitempty"`
 	Emotion     string                   `json:"emotion,omitempty"`
+	Partial     bool                     `json:"partial,omitempty"` // stt 消息为识别中间结果时为 true
 	Udp         *UdpConfig               `json:"udp,omitempty"`
 	PayLoad     json.RawMessage          `json:"payload,omitempty"`
 }
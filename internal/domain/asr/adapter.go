@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"xiaozhi-esp32-server-golang/internal/data/audio"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/funasr"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	log "xiaozhi-esp32-server-golang/logger"
 )
@@ -72,6 +73,7 @@ func NewFunasrAdapter(config map[string]interface{}) (AsrProvider, error) {
 	if autoEnd, ok := config["auto_end"].(bool); ok {
 		funasrConfig.AutoEnd = autoEnd
 	}
+	funasrConfig.Hotwords = hotword.Parse(config["hotwords"])
 
 	// 创建FunASR引擎
 	engine, err := funasr.NewFunasr(funasrConfig)
@@ -81,6 +83,11 @@ func NewFunasrAdapter(config map[string]interface{}) (AsrProvider, error) {
 	return &FunasrAdapter{engine: engine}, nil
 }
 
+// SupportsHotwords funasr 通过 hotwords 参数原生支持热词
+func (a *FunasrAdapter) SupportsHotwords() bool {
+	return true
+}
+
 // Process 实现 Asr 接口
 func (a *FunasrAdapter) Process(pcmData []float32) (string, error) {
 	return a.engine.Process(pcmData)
@@ -6,6 +6,9 @@ import (
 
 	"xiaozhi-esp32-server-golang/constants"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/doubao"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/failover"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/openai"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/sherpa"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	log "xiaozhi-esp32-server-golang/logger"
 )
@@ -22,8 +25,15 @@ type AsrProvider interface {
 	StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error)
 }
 
+// SupportsHotwords 引擎是否把热词作为识别偏置发给服务端, 不支持的引擎需要识别后再纠正
+func SupportsHotwords(provider AsrProvider) bool {
+	biaser, ok := provider.(interface{ SupportsHotwords() bool })
+	return ok && biaser.SupportsHotwords()
+}
+
 // NewAsrProvider 创建一个新的ASR实例
-// asrType: ASR引擎类型，目前支持 "funasr"
+// asrType: ASR引擎类型，目前支持 "funasr"、"doubao"、"sherpa"、"openai"，
+// 以及按顺序组合多个引擎的 "failover"
 // config: ASR引擎配置，为 map[string]interface{} 类型
 func NewAsrProvider(asrType string, config map[string]interface{}) (AsrProvider, error) {
 	switch asrType {
@@ -38,7 +48,29 @@ func NewAsrProvider(asrType string, config map[string]interface{}) (AsrProvider,
 			log.Info("豆包ASR适配器创建成功")
 		}
 		return provider, err
+	case constants.AsrTypeSherpa:
+		provider, err := sherpa.NewSherpa(config)
+		if err != nil {
+			log.Errorf("本地sherpa ASR创建失败: %v", err)
+			return nil, err
+		}
+		return provider, nil
+	case constants.AsrTypeOpenAI:
+		provider, err := openai.NewOpenAIAsrProvider(config)
+		if err != nil {
+			log.Errorf("OpenAI兼容ASR创建失败: %v", err)
+			return nil, err
+		}
+		return provider, nil
+	case constants.AsrTypeFailover:
+		provider, err := failover.NewFailover(config, func(providerType string, providerConfig map[string]interface{}) (failover.Provider, error) {
+			return NewAsrProvider(providerType, providerConfig)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
 	default:
-		return nil, fmt.Errorf("不支持的ASR引擎类型: %s，目前仅支持 'funasr'", asrType)
+		return nil, fmt.Errorf("不支持的ASR引擎类型: %s，目前支持 'funasr'、'doubao'、'sherpa'、'openai'、'failover'", asrType)
 	}
 }
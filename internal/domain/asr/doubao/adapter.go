@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	log "xiaozhi-esp32-server-golang/logger"
 )
@@ -57,6 +58,8 @@ func NewDoubaoV2Adapter(config map[string]interface{}) (*DoubaoV2Adapter, error)
 		doubaoConfig.Timeout = int(timeoutFloat)
 	}
 
+	doubaoConfig.Hotwords = hotword.Parse(config["hotwords"])
+
 	// 创建豆包ASR引擎
 	engine, err := NewDoubaoV2ASR(doubaoConfig)
 	if err != nil {
@@ -70,6 +73,11 @@ func NewDoubaoV2Adapter(config map[string]interface{}) (*DoubaoV2Adapter, error)
 	}, nil
 }
 
+// SupportsHotwords 豆包通过 corpus.context 原生支持热词
+func (d *DoubaoV2Adapter) SupportsHotwords() bool {
+	return true
+}
+
 // Process 实现一次性处理整段音频，返回完整识别结果
 func (d *DoubaoV2Adapter) Process(pcmData []float32) (string, error) {
 	return "", nil
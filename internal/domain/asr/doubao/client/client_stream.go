@@ -20,6 +20,7 @@ type AsrWsClient struct {
 	connect   *websocket.Conn
 	appId     string
 	accessKey string
+	corpus    request.CorpusMeta
 }
 
 func NewAsrWsClient(url string, appKey, accessKey string) *AsrWsClient {
@@ -31,6 +32,11 @@ func NewAsrWsClient(url string, appKey, accessKey string) *AsrWsClient {
 	}
 }
 
+// SetCorpus 设置热词等识别偏置, 在 SendFullClientRequest 之前调用
+func (c *AsrWsClient) SetCorpus(corpus request.CorpusMeta) {
+	c.corpus = corpus
+}
+
 func (c *AsrWsClient) CreateConnection(ctx context.Context) error {
 	header := request.NewAuthHeader(c.appId, c.accessKey)
 	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, c.url, header)
@@ -44,7 +50,7 @@ func (c *AsrWsClient) CreateConnection(ctx context.Context) error {
 }
 
 func (c *AsrWsClient) SendFullClientRequest() error {
-	fullClientRequest := request.NewFullClientRequest()
+	fullClientRequest := request.NewFullClientRequest(c.corpus)
 	c.seq++
 	err := c.connect.WriteMessage(websocket.BinaryMessage, fullClientRequest)
 	if err != nil {
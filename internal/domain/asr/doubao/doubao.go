@@ -2,10 +2,12 @@ package doubao
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"xiaozhi-esp32-server-golang/internal/domain/asr/doubao/client"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/doubao/request"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/doubao/response"
 	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
 	log "xiaozhi-esp32-server-golang/logger"
@@ -64,10 +66,33 @@ func NewDoubaoV2ASR(config DoubaoV2Config) (*DoubaoV2ASR, error) {
 	}, nil
 }
 
+// hotwordsContext 把热词编码为 corpus.context 要求的 {"hotwords":[{"word":"热词"}]}, 豆包不支持权重
+func (d *DoubaoV2ASR) hotwordsContext() string {
+	if len(d.config.Hotwords) == 0 {
+		return ""
+	}
+	type contextHotword struct {
+		Word string `json:"word"`
+	}
+	corpusContext := struct {
+		Hotwords []contextHotword `json:"hotwords"`
+	}{}
+	for _, hotword := range d.config.Hotwords {
+		corpusContext.Hotwords = append(corpusContext.Hotwords, contextHotword{Word: hotword.Word})
+	}
+	data, err := json.Marshal(corpusContext)
+	if err != nil {
+		log.Errorf("序列化热词失败: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
 // StreamingRecognize 实现流式识别接口
 func (d *DoubaoV2ASR) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
 	// 建立连接
 	d.c = client.NewAsrWsClient(d.config.WsURL, d.config.AppID, d.config.AccessToken)
+	d.c.SetCorpus(request.CorpusMeta{Context: d.hotwordsContext()})
 
 	// 豆包返回的识别结果
 	doubaoResultChan := make(chan *response.AsrResponse, 10)
@@ -122,6 +147,7 @@ func (d *DoubaoV2ASR) receiveStreamResults(ctx context.Context, resultChan chan
 				}
 				return
 			}
+			// 中间包的文本是截至目前的整句识别结果且可能修正前文, 与按增量拼接的中间结果约定不一致, 只转发最终结果
 			if result.IsLastPackage {
 				resultChan <- types.StreamingResult{
 					Text:    result.PayloadMsg.Result.Text,
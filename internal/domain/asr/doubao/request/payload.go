@@ -47,7 +47,7 @@ type AsrRequestPayload struct {
 	Request RequestMeta `json:"request"`
 }
 
-func NewFullClientRequest() []byte {
+func NewFullClientRequest(corpus CorpusMeta) []byte {
 	var request bytes.Buffer
 	request.Write(DefaultHeader().WithMessageTypeSpecificFlags(common.POS_SEQUENCE).toBytes())
 	payload := AsrRequestPayload{
@@ -68,6 +68,7 @@ func NewFullClientRequest() []byte {
 			EnableDDC:       true,
 			ShowUtterances:  true,
 			EnableNonstream: false,
+			Corpus:          corpus,
 		},
 	}
 	payloadArr, _ := sonic.Marshal(payload)
@@ -1,17 +1,20 @@
 package doubao
 
+import "xiaozhi-esp32-server-golang/internal/domain/asr/types"
+
 // DoubaoV2Config 豆包ASR配置结构体
 type DoubaoV2Config struct {
-	AppID         string // 应用ID
-	AccessToken   string // 访问令牌
-	WsURL         string // WebSocket URL
-	ModelName     string // 模型名称
-	EndWindowSize int    // 结束窗口大小
-	EnablePunc    bool   // 是否启用标点符号
-	EnableITN     bool   // 是否启用ITN
-	EnableDDC     bool   // 是否启用DDC
-	ChunkDuration int    // 分块时长(毫秒)
-	Timeout       int    // 超时时间(秒)
+	AppID         string          // 应用ID
+	AccessToken   string          // 访问令牌
+	WsURL         string          // WebSocket URL
+	ModelName     string          // 模型名称
+	EndWindowSize int             // 结束窗口大小
+	EnablePunc    bool            // 是否启用标点符号
+	EnableITN     bool            // 是否启用ITN
+	EnableDDC     bool            // 是否启用DDC
+	ChunkDuration int             // 分块时长(毫秒)
+	Timeout       int             // 超时时间(秒)
+	Hotwords      []types.Hotword // 热词, 通过 corpus.context 发给服务端
 }
 
 // DefaultConfig 默认配置
@@ -0,0 +1,413 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// ProviderType 故障转移本身的 asr 类型名, 不允许出现在 providers 列表中
+const ProviderType = "failover"
+
+// 切换原因, 用作 asr_failover_total 指标的 reason 标签
+const (
+	ReasonConnect = "connect"
+	ReasonError   = "error"
+	ReasonTimeout = "timeout"
+	ReasonClosed  = "closed"
+)
+
+// Provider 与 asr.AsrProvider 一致, 在这里重新声明以避免循环引用
+type Provider interface {
+	Process(pcmData []float32) (string, error)
+	StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error)
+}
+
+// Factory 按类型和配置创建 asr, 由 asr.NewAsrProvider 传入
+type Factory func(providerType string, config map[string]interface{}) (Provider, error)
+
+type member struct {
+	name     string
+	provider Provider
+}
+
+// Failover 按顺序组合多个 asr, 当前引擎连接失败、返回错误或超时未给出最终结果时,
+// 用缓存的整句音频在下一个引擎上重试
+type Failover struct {
+	members []member
+	// 输入结束后等待最终结果的时长
+	timeout time.Duration
+	// 连续失败多少次后标记为不健康
+	maxFailures int
+	// 不健康的引擎在冷却期内排到最后
+	cooldown time.Duration
+}
+
+// NewFailover 创建故障转移 asr, providers 中的每一项可以是引擎名(使用配置文件 asr.<name> 下的配置),
+// 也可以是带 provider 字段的完整配置
+func NewFailover(config map[string]interface{}, factory Factory) (*Failover, error) {
+	f := &Failover{
+		timeout:     10 * time.Second,
+		maxFailures: 3,
+		cooldown:    60 * time.Second,
+	}
+	if timeout := getFloat(config, "timeout"); timeout > 0 {
+		f.timeout = time.Duration(timeout * float64(time.Second))
+	}
+	if maxFailures := int(getFloat(config, "max_failures")); maxFailures > 0 {
+		f.maxFailures = maxFailures
+	}
+	if cooldown := getFloat(config, "cooldown"); cooldown > 0 {
+		f.cooldown = time.Duration(cooldown * float64(time.Second))
+	}
+
+	entries, _ := config["providers"].([]interface{})
+	if list, ok := config["providers"].([]string); ok {
+		for _, name := range list {
+			entries = append(entries, name)
+		}
+	}
+	for _, entry := range entries {
+		name, providerConfig := parseEntry(entry)
+		if name == "" || name == ProviderType {
+			log.Warnf("asr 故障转移忽略无效的引擎配置: %+v", entry)
+			continue
+		}
+		// 会话的热词对每个引擎都生效
+		if hotwords, ok := config["hotwords"]; ok {
+			if _, ok := providerConfig["hotwords"]; !ok {
+				providerConfig["hotwords"] = hotwords
+			}
+		}
+		provider, err := factory(name, providerConfig)
+		if err != nil {
+			// 备用引擎创建失败不影响其余引擎
+			log.Errorf("asr 故障转移创建引擎 %s 失败: %v", name, err)
+			continue
+		}
+		f.members = append(f.members, member{name: name, provider: provider})
+	}
+	if len(f.members) == 0 {
+		return nil, errors.New("asr 故障转移没有可用的引擎, 请检查 providers 配置")
+	}
+	return f, nil
+}
+
+func parseEntry(entry interface{}) (string, map[string]interface{}) {
+	var name string
+	var source map[string]interface{}
+	switch v := entry.(type) {
+	case string:
+		name, source = v, viper.GetStringMap("asr."+v)
+	case map[string]interface{}:
+		name, _ = v["provider"].(string)
+		source = v
+	default:
+		return "", nil
+	}
+	// 复制一份, 后续补充热词时不会改到全局配置
+	providerConfig := make(map[string]interface{}, len(source))
+	for key, value := range source {
+		if key != "provider" {
+			providerConfig[key] = value
+		}
+	}
+	return name, providerConfig
+}
+
+func getFloat(config map[string]interface{}, key string) float64 {
+	switch v := config[key].(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// candidates 返回本次识别的尝试顺序: 健康的引擎按配置顺序在前, 冷却中的排在最后,
+// 全部不健康时仍会逐个尝试, 不至于直接失聪
+func (f *Failover) candidates() []member {
+	healthy := make([]member, 0, len(f.members))
+	var unhealthy []member
+	for _, m := range f.members {
+		if isHealthy(m.name) {
+			healthy = append(healthy, m)
+		} else {
+			unhealthy = append(unhealthy, m)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// failed 记录一次失败并上报切换事件, next 为空表示已没有可切换的引擎
+func (f *Failover) failed(from string, next string, reason string, err error) {
+	markFailure(from, f.maxFailures, f.cooldown)
+	metrics.ProviderError(metrics.ProviderAsr, from)
+	metrics.AsrFailover(from, next, reason)
+	log.Warnf("asr 引擎 %s 失败(%s): %v, 切换到: %s", from, reason, err, next)
+}
+
+// SupportsHotwords 任一引擎不支持热词偏置时都需要识别后纠正, 因此要求所有引擎都支持
+func (f *Failover) SupportsHotwords() bool {
+	for _, m := range f.members {
+		biaser, ok := m.provider.(interface{ SupportsHotwords() bool })
+		if !ok || !biaser.SupportsHotwords() {
+			return false
+		}
+	}
+	return true
+}
+
+// Process 按顺序尝试各引擎, 返回第一个成功的结果
+func (f *Failover) Process(pcmData []float32) (string, error) {
+	candidates := f.candidates()
+	var lastErr error
+	for i, m := range candidates {
+		type processResult struct {
+			text string
+			err  error
+		}
+		done := make(chan processResult, 1)
+		go func() {
+			text, err := m.provider.Process(pcmData)
+			done <- processResult{text: text, err: err}
+		}()
+
+		reason := ReasonError
+		select {
+		case result := <-done:
+			if result.err == nil {
+				markSuccess(m.name)
+				return result.text, nil
+			}
+			lastErr = result.err
+		case <-time.After(f.timeout):
+			reason = ReasonTimeout
+			lastErr = fmt.Errorf("识别超时(%s)", f.timeout)
+		}
+		f.failed(m.name, nextName(candidates, i), reason, lastErr)
+	}
+	return "", fmt.Errorf("所有 asr 引擎均识别失败: %v", lastErr)
+}
+
+func nextName(candidates []member, i int) string {
+	if i+1 < len(candidates) {
+		return candidates[i+1].name
+	}
+	return ""
+}
+
+// StreamingRecognize 把输入音频转发给当前引擎并缓存下来, 当前引擎失败时用缓存的音频在下一个引擎上重试.
+// 非最终结果直接透传; 切换后如果新引擎的最终结果以已透传的文本开头, 只返回剩余部分, 避免调用方拼接出重复文本
+func (f *Failover) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	resultChan := make(chan types.StreamingResult, 10)
+	buffer := newAudioBuffer(ctx)
+	go buffer.collect(ctx, audioStream)
+
+	go func() {
+		defer close(resultChan)
+
+		send := func(result types.StreamingResult) bool {
+			select {
+			case resultChan <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var forwarded strings.Builder
+		candidates := f.candidates()
+		var lastErr error
+		for i, m := range candidates {
+			reason, err := f.attempt(ctx, m, buffer, &forwarded, send)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			lastErr = err
+			f.failed(m.name, nextName(candidates, i), reason, err)
+		}
+		send(types.StreamingResult{
+			IsFinal: true,
+			Error:   fmt.Errorf("所有 asr 引擎均识别失败: %v", lastErr),
+		})
+	}()
+
+	return resultChan, nil
+}
+
+// attempt 在一个引擎上识别, 返回 nil 表示已发出最终结果或 ctx 已取消
+func (f *Failover) attempt(ctx context.Context, m member, buffer *audioBuffer, forwarded *strings.Builder, send func(types.StreamingResult) bool) (string, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	audioChan := make(chan []float32, 100)
+	go buffer.replay(attemptCtx, audioChan)
+
+	results, err := m.provider.StreamingRecognize(attemptCtx, audioChan)
+	metrics.ProviderRequest(metrics.ProviderAsr, m.name, nil)
+	if err != nil {
+		return ReasonConnect, err
+	}
+
+	inputDone := buffer.done
+	var deadline <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return "", nil
+		case <-inputDone:
+			inputDone = nil
+			timer := time.NewTimer(f.timeout)
+			defer timer.Stop()
+			deadline = timer.C
+		case <-deadline:
+			return ReasonTimeout, fmt.Errorf("输入结束 %s 后仍未返回最终结果", f.timeout)
+		case result, ok := <-results:
+			if !ok {
+				return ReasonClosed, errors.New("未返回最终结果即关闭")
+			}
+			if result.Error != nil {
+				return ReasonError, result.Error
+			}
+			if !result.IsFinal {
+				if result.Text != "" {
+					forwarded.WriteString(result.Text)
+					send(result)
+				}
+				continue
+			}
+			markSuccess(m.name)
+			result.Text = strings.TrimPrefix(result.Text, forwarded.String())
+			send(result)
+			return "", nil
+		}
+	}
+}
+
+// audioBuffer 缓存一次识别的全部输入音频, 每个引擎都从头回放
+type audioBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	chunks [][]float32
+	closed bool
+	// 输入结束(audioStream 关闭)时关闭
+	done chan struct{}
+}
+
+func newAudioBuffer(ctx context.Context) *audioBuffer {
+	b := &audioBuffer{done: make(chan struct{})}
+	b.cond = sync.NewCond(&b.mu)
+	context.AfterFunc(ctx, b.broadcast)
+	return b
+}
+
+func (b *audioBuffer) broadcast() {
+	b.mu.Lock()
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *audioBuffer) collect(ctx context.Context, audioStream <-chan []float32) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case chunk, ok := <-audioStream:
+			b.mu.Lock()
+			if ok {
+				b.chunks = append(b.chunks, chunk)
+			} else {
+				b.closed = true
+				close(b.done)
+			}
+			b.cond.Broadcast()
+			b.mu.Unlock()
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// replay 从头把缓存的音频送入 out, 然后继续转发后续输入, 输入结束后关闭 out
+func (b *audioBuffer) replay(ctx context.Context, out chan<- []float32) {
+	stop := context.AfterFunc(ctx, b.broadcast)
+	defer stop()
+
+	for i := 0; ; i++ {
+		b.mu.Lock()
+		for i >= len(b.chunks) && !b.closed && ctx.Err() == nil {
+			b.cond.Wait()
+		}
+		if ctx.Err() != nil {
+			b.mu.Unlock()
+			return
+		}
+		if i >= len(b.chunks) {
+			b.mu.Unlock()
+			close(out)
+			return
+		}
+		chunk := b.chunks[i]
+		b.mu.Unlock()
+
+		select {
+		case out <- chunk:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 引擎健康状态按引擎名全局共享, 一个会话发现的故障会让其他会话直接跳过该引擎
+type health struct {
+	failures       int
+	unhealthyUntil time.Time
+}
+
+var (
+	healthMu    sync.Mutex
+	healthByAsr = make(map[string]*health)
+)
+
+func isHealthy(name string) bool {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	h, ok := healthByAsr[name]
+	return !ok || time.Now().After(h.unhealthyUntil)
+}
+
+func markSuccess(name string) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	delete(healthByAsr, name)
+}
+
+func markFailure(name string, maxFailures int, cooldown time.Duration) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	h, ok := healthByAsr[name]
+	if !ok {
+		h = &health{}
+		healthByAsr[name] = h
+	}
+	h.failures++
+	if h.failures >= maxFailures {
+		h.failures = 0
+		h.unhealthyUntil = time.Now().Add(cooldown)
+		log.Warnf("asr 引擎 %s 连续失败 %d 次, %s 内不再优先使用", name, maxFailures, cooldown)
+	}
+}
@@ -0,0 +1,212 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+type fakeProvider struct {
+	connectErr error
+	partial    string // 收到第一段音频时返回的非最终结果
+	final      string
+	err        error // 输入结束后返回的错误
+	hang       bool  // 输入结束后不返回任何结果
+	hotwords   bool  // 是否原生支持热词
+
+	mu       sync.Mutex
+	received int
+}
+
+func (p *fakeProvider) Process(pcmData []float32) (string, error) {
+	if p.hang {
+		time.Sleep(time.Second)
+	}
+	if p.err != nil {
+		return "", p.err
+	}
+	return p.final, nil
+}
+
+func (p *fakeProvider) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	if p.connectErr != nil {
+		return nil, p.connectErr
+	}
+	resultChan := make(chan types.StreamingResult, 10)
+	go func() {
+		defer close(resultChan)
+		first := true
+		for chunk := range audioStream {
+			p.mu.Lock()
+			p.received += len(chunk)
+			p.mu.Unlock()
+			if first && p.partial != "" {
+				resultChan <- types.StreamingResult{Text: p.partial}
+			}
+			first = false
+		}
+		switch {
+		case p.hang:
+			<-ctx.Done()
+		case p.err != nil:
+			resultChan <- types.StreamingResult{Error: p.err, IsFinal: true}
+		default:
+			resultChan <- types.StreamingResult{Text: p.final, IsFinal: true}
+		}
+	}()
+	return resultChan, nil
+}
+
+func (p *fakeProvider) SupportsHotwords() bool {
+	return p.hotwords
+}
+
+func (p *fakeProvider) samples() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.received
+}
+
+func newTestFailover(t *testing.T, config map[string]interface{}, providers map[string]*fakeProvider) *Failover {
+	healthMu.Lock()
+	healthByAsr = make(map[string]*health)
+	healthMu.Unlock()
+
+	f, err := NewFailover(config, func(name string, _ map[string]interface{}) (Provider, error) {
+		provider, ok := providers[name]
+		if !ok {
+			return nil, errors.New("unknown provider")
+		}
+		return provider, nil
+	})
+	require.NoError(t, err)
+	return f
+}
+
+func recognize(t *testing.T, f *Failover, chunks int) []types.StreamingResult {
+	audioStream := make(chan []float32, chunks)
+	for i := 0; i < chunks; i++ {
+		audioStream <- make([]float32, 160)
+	}
+	close(audioStream)
+
+	resultChan, err := f.StreamingRecognize(context.Background(), audioStream)
+	require.NoError(t, err)
+	var results []types.StreamingResult
+	for result := range resultChan {
+		results = append(results, result)
+	}
+	return results
+}
+
+func TestStreamingRecognize_ConnectError(t *testing.T) {
+	primary := &fakeProvider{connectErr: errors.New("dial failed")}
+	backup := &fakeProvider{final: "你好"}
+	f := newTestFailover(t, map[string]interface{}{
+		"providers": []interface{}{"connect_primary", "missing", "connect_backup"},
+	}, map[string]*fakeProvider{"connect_primary": primary, "connect_backup": backup})
+
+	results := recognize(t, f, 3)
+	require.Len(t, results, 1)
+	assert.Equal(t, types.StreamingResult{Text: "你好", IsFinal: true}, results[0])
+	assert.Equal(t, 3*160, backup.samples())
+}
+
+func TestStreamingRecognize_ReplayAfterError(t *testing.T) {
+	primary := &fakeProvider{partial: "今天", err: errors.New("server closed")}
+	backup := &fakeProvider{final: "今天天气怎么样"}
+	f := newTestFailover(t, map[string]interface{}{
+		"providers": []interface{}{"replay_primary", "replay_backup"},
+	}, map[string]*fakeProvider{"replay_primary": primary, "replay_backup": backup})
+
+	results := recognize(t, f, 5)
+	require.Len(t, results, 2)
+	assert.Equal(t, "今天", results[0].Text)
+	assert.False(t, results[0].IsFinal)
+	// 已透传的部分不再重复返回
+	assert.Equal(t, "天气怎么样", results[1].Text)
+	assert.True(t, results[1].IsFinal)
+	assert.Equal(t, 5*160, backup.samples())
+}
+
+func TestStreamingRecognize_Timeout(t *testing.T) {
+	primary := &fakeProvider{hang: true}
+	backup := &fakeProvider{final: "好的"}
+	f := newTestFailover(t, map[string]interface{}{
+		"providers": []interface{}{"timeout_primary", map[string]interface{}{"provider": "timeout_backup"}},
+		"timeout":   0.05,
+	}, map[string]*fakeProvider{"timeout_primary": primary, "timeout_backup": backup})
+
+	results := recognize(t, f, 2)
+	require.Len(t, results, 1)
+	assert.Equal(t, "好的", results[0].Text)
+}
+
+func TestStreamingRecognize_AllFailed(t *testing.T) {
+	f := newTestFailover(t, map[string]interface{}{
+		"providers": []interface{}{"all_a", "all_b"},
+	}, map[string]*fakeProvider{
+		"all_a": {err: errors.New("a")},
+		"all_b": {connectErr: errors.New("b")},
+	})
+
+	results := recognize(t, f, 1)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].IsFinal)
+	assert.Error(t, results[0].Error)
+}
+
+func TestUnhealthyProviderIsSkipped(t *testing.T) {
+	primary := &fakeProvider{err: errors.New("outage")}
+	backup := &fakeProvider{final: "ok"}
+	f := newTestFailover(t, map[string]interface{}{
+		"providers":    []interface{}{"health_primary", "health_backup"},
+		"max_failures": 2,
+		"cooldown":     60,
+	}, map[string]*fakeProvider{"health_primary": primary, "health_backup": backup})
+
+	assert.Equal(t, "health_primary", f.candidates()[0].name)
+	for i := 0; i < 2; i++ {
+		text, err := f.Process(make([]float32, 160))
+		require.NoError(t, err)
+		assert.Equal(t, "ok", text)
+	}
+	assert.Equal(t, []string{"health_backup", "health_primary"}, []string{f.candidates()[0].name, f.candidates()[1].name})
+
+	// 冷却期内不再先请求故障引擎
+	primary.mu.Lock()
+	primary.received = 0
+	primary.mu.Unlock()
+	recognize(t, f, 1)
+	assert.Equal(t, 0, primary.samples())
+}
+
+func TestNewFailover_NoProviders(t *testing.T) {
+	_, err := NewFailover(map[string]interface{}{"providers": []interface{}{ProviderType}}, func(string, map[string]interface{}) (Provider, error) {
+		return &fakeProvider{}, nil
+	})
+	assert.Error(t, err)
+}
+
+func TestSupportsHotwords(t *testing.T) {
+	config := map[string]interface{}{"providers": []interface{}{"a", "b"}}
+	f := newTestFailover(t, config, map[string]*fakeProvider{
+		"a": {hotwords: true},
+		"b": {hotwords: true},
+	})
+	assert.True(t, f.SupportsHotwords())
+
+	// 备用引擎不支持时仍需要识别后纠正
+	f = newTestFailover(t, config, map[string]*fakeProvider{
+		"a": {hotwords: true},
+		"b": {},
+	})
+	assert.False(t, f.SupportsHotwords())
+}
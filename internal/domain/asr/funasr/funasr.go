@@ -20,17 +20,21 @@ import (
 
 // FunasrConfig 配置结构体
 type FunasrConfig struct {
-	Host           string // FunASR 服务主机地址
-	Port           string // FunASR 服务端口
-	Mode           string // 识别模式，如 "online"
-	SampleRate     int    // 采样率
-	ChunkSize      []int  // 分块大小
-	ChunkInterval  int    // 分块间隔
-	MaxConnections int    // 最大连接数
-	Timeout        int    // 连接超时时间（秒）
-	AutoEnd        bool   // 是否超时 xx ms自动结束，不依赖 isSpeaking为false
+	Host           string          // FunASR 服务主机地址
+	Port           string          // FunASR 服务端口
+	Mode           string          // 识别模式，如 "online"
+	SampleRate     int             // 采样率
+	ChunkSize      []int           // 分块大小
+	ChunkInterval  int             // 分块间隔
+	MaxConnections int             // 最大连接数
+	Timeout        int             // 连接超时时间（秒）
+	AutoEnd        bool            // 是否超时 xx ms自动结束，不依赖 isSpeaking为false
+	Hotwords       []types.Hotword // 热词, 在首个请求中发给服务端
 }
 
+// 热词未配置权重时使用的默认权重
+const defaultHotwordWeight = 20
+
 // DefaultConfig 默认配置
 var DefaultConfig = FunasrConfig{
 	Host:           "localhost",
@@ -97,6 +101,26 @@ func NewFunasr(config FunasrConfig) (*Funasr, error) {
 	return f, nil
 }
 
+// hotwords 按 FunASR 要求编码为 {"热词": 权重} 形式的 json 字符串
+func (f *Funasr) hotwords() string {
+	if len(f.config.Hotwords) == 0 {
+		return ""
+	}
+	weights := make(map[string]int, len(f.config.Hotwords))
+	for _, hotword := range f.config.Hotwords {
+		weights[hotword.Word] = hotword.Weight
+		if hotword.Weight <= 0 {
+			weights[hotword.Word] = defaultHotwordWeight
+		}
+	}
+	data, err := json.Marshal(weights)
+	if err != nil {
+		log.Errorf("序列化热词失败: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
 // createConnection 创建一个新的WebSocket连接
 func (f *Funasr) createConnection() (*websocket.Conn, error) {
 	url := fmt.Sprintf("ws://%s:%s/", f.config.Host, f.config.Port)
@@ -260,7 +284,7 @@ func (f *Funasr) StreamingRecognize(ctx context.Context, audioStream <-chan []fl
 		WavName:       "stream",
 		WavFormat:     "pcm",
 		IsSpeaking:    true,
-		Hotwords:      "{\"阿里巴巴\":20,\"hello world\":40}",
+		Hotwords:      f.hotwords(),
 		Itn:           true,
 	}
 
@@ -414,7 +438,7 @@ func (f *Funasr) Process(pcmData []float32) (string, error) {
 		WavName:       "stream",
 		WavFormat:     "pcm",
 		IsSpeaking:    true,
-		Hotwords:      "",
+		Hotwords:      f.hotwords(),
 		Itn:           true,
 	}
 
@@ -0,0 +1,247 @@
+package hotword
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mozillazg/go-pinyin"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+// DefaultWeight 未配置权重时使用, 与 FunASR 文档中的示例一致
+const DefaultWeight = 20
+
+// Parse 解析热词配置, 支持:
+//   - 文本, 每行(或逗号分隔)一个热词, 可在词后用空格或冒号跟权重, 如 "阿嘟 30"
+//   - 字符串列表, 元素格式同上
+//   - {word, weight} 对象列表
+//   - FunASR 风格的 {"词": 权重} 对象
+func Parse(v interface{}) []types.Hotword {
+	var hotwords []types.Hotword
+	switch value := v.(type) {
+	case []types.Hotword:
+		hotwords = append(hotwords, value...)
+	case string:
+		fields := strings.FieldsFunc(value, func(r rune) bool {
+			return r == '\n' || r == ',' || r == '，' || r == ';' || r == '；'
+		})
+		for _, field := range fields {
+			hotwords = append(hotwords, parseItem(field))
+		}
+	case []string:
+		for _, item := range value {
+			hotwords = append(hotwords, parseItem(item))
+		}
+	case []interface{}:
+		for _, item := range value {
+			switch item := item.(type) {
+			case string:
+				hotwords = append(hotwords, parseItem(item))
+			case map[string]interface{}:
+				word, _ := item["word"].(string)
+				hotwords = append(hotwords, types.Hotword{Word: strings.TrimSpace(word), Weight: toInt(item["weight"])})
+			}
+		}
+	case map[string]interface{}:
+		for word, weight := range value {
+			hotwords = append(hotwords, types.Hotword{Word: strings.TrimSpace(word), Weight: toInt(weight)})
+		}
+		sort.Slice(hotwords, func(i, j int) bool { return hotwords[i].Word < hotwords[j].Word })
+	}
+	return Merge(hotwords)
+}
+
+func parseItem(item string) types.Hotword {
+	item = strings.TrimSpace(item)
+	i := strings.LastIndexFunc(item, func(r rune) bool { return r == ' ' || r == ':' || r == '：' })
+	if i > 0 {
+		_, size := utf8.DecodeRuneInString(item[i:])
+		if weight, err := strconv.Atoi(item[i+size:]); err == nil {
+			return types.Hotword{Word: strings.TrimSpace(item[:i]), Weight: weight}
+		}
+	}
+	return types.Hotword{Word: item}
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
+
+// Merge 合并多组热词, 去掉空词; 同一个词出现多次时保留位置靠前的, 权重取后出现的非零值
+func Merge(lists ...[]types.Hotword) []types.Hotword {
+	var merged []types.Hotword
+	index := make(map[string]int)
+	for _, list := range lists {
+		for _, hotword := range list {
+			if hotword.Word == "" {
+				continue
+			}
+			if i, ok := index[hotword.Word]; ok {
+				if hotword.Weight != 0 {
+					merged[i].Weight = hotword.Weight
+				}
+				continue
+			}
+			index[hotword.Word] = len(merged)
+			merged = append(merged, hotword)
+		}
+	}
+	return merged
+}
+
+// Words 返回热词文本列表
+func Words(hotwords []types.Hotword) []string {
+	words := make([]string, 0, len(hotwords))
+	for _, hotword := range hotwords {
+		words = append(words, hotword.Word)
+	}
+	return words
+}
+
+// Weight 返回热词权重, 未配置时为 DefaultWeight
+func Weight(hotword types.Hotword) int {
+	if hotword.Weight > 0 {
+		return hotword.Weight
+	}
+	return DefaultWeight
+}
+
+var pinyinArgs = func() pinyin.Args {
+	args := pinyin.NewArgs()
+	args.Heteronym = true
+	return args
+}()
+
+// syllables 返回一个汉字所有读音的模糊拼音, 不是汉字时返回 nil
+func syllables(r rune) []string {
+	if !unicode.Is(unicode.Han, r) {
+		return nil
+	}
+	readings := pinyin.SinglePinyin(r, pinyinArgs)
+	for i, reading := range readings {
+		readings[i] = fuzzy(reading)
+	}
+	return readings
+}
+
+// fuzzy 归并识别中最常混淆的读音: 平翘舌、n/l、前后鼻音
+func fuzzy(syllable string) string {
+	for _, pair := range [][2]string{{"zh", "z"}, {"ch", "c"}, {"sh", "s"}} {
+		if strings.HasPrefix(syllable, pair[0]) {
+			syllable = pair[1] + syllable[len(pair[0]):]
+			break
+		}
+	}
+	if strings.HasPrefix(syllable, "n") && len(syllable) > 1 && syllable != "ng" {
+		syllable = "l" + syllable[1:]
+	}
+	return strings.TrimSuffix(syllable, "g")
+}
+
+type entry struct {
+	word      []rune
+	syllables [][]string
+}
+
+// Corrector 识别后的热词纠正, 把读音与热词相同(模糊拼音)但写法不同的片段替换为热词,
+// 用于不支持热词偏置的引擎
+type Corrector struct {
+	entries []entry
+}
+
+// NewCorrector 只收录两个字及以上的纯汉字热词, 没有可用热词时返回 nil
+func NewCorrector(hotwords []types.Hotword) *Corrector {
+	var entries []entry
+	for _, hotword := range hotwords {
+		word := []rune(hotword.Word)
+		if len(word) < 2 {
+			continue
+		}
+		e := entry{word: word}
+		for _, r := range word {
+			readings := syllables(r)
+			if len(readings) == 0 {
+				e.syllables = nil
+				break
+			}
+			e.syllables = append(e.syllables, readings)
+		}
+		if e.syllables != nil {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	// 长词优先, 避免短词抢先匹配了长词的一部分
+	sort.SliceStable(entries, func(i, j int) bool { return len(entries[i].word) > len(entries[j].word) })
+	return &Corrector{entries: entries}
+}
+
+// Correct 返回纠正后的文本
+func (c *Corrector) Correct(text string) string {
+	if c == nil || text == "" {
+		return text
+	}
+	runes := []rune(text)
+	readings := make([][]string, len(runes))
+	for i, r := range runes {
+		readings[i] = syllables(r)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		matched := false
+		for _, e := range c.entries {
+			if e.match(readings[i:]) {
+				sb.WriteString(string(e.word))
+				i += len(e.word)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			sb.WriteRune(runes[i])
+			i++
+		}
+	}
+	return sb.String()
+}
+
+func (e entry) match(readings [][]string) bool {
+	if len(readings) < len(e.syllables) {
+		return false
+	}
+	for i, want := range e.syllables {
+		if !intersects(want, readings[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
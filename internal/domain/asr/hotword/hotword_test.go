@@ -0,0 +1,59 @@
+package hotword
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+func TestParse(t *testing.T) {
+	expected := []types.Hotword{{Word: "阿嘟", Weight: 30}, {Word: "小智"}, {Word: "hello world", Weight: 40}}
+
+	assert.Equal(t, expected, Parse("阿嘟 30\n小智，hello world:40\n\n"))
+	assert.Equal(t, expected, Parse([]interface{}{
+		"阿嘟：30",
+		map[string]interface{}{"word": "小智"},
+		map[string]interface{}{"word": "hello world", "weight": float64(40)},
+	}))
+	assert.Equal(t, []types.Hotword{{Word: "小智", Weight: 20}, {Word: "阿嘟", Weight: 30}},
+		Parse(map[string]interface{}{"阿嘟": 30, "小智": 20}))
+	assert.Nil(t, Parse(nil))
+}
+
+func TestMerge(t *testing.T) {
+	merged := Merge(
+		[]types.Hotword{{Word: "小智", Weight: 20}, {Word: "阿嘟"}},
+		[]types.Hotword{{Word: "阿嘟", Weight: 50}, {Word: ""}, {Word: "小智"}},
+	)
+	assert.Equal(t, []types.Hotword{{Word: "小智", Weight: 20}, {Word: "阿嘟", Weight: 50}}, merged)
+	assert.Equal(t, []string{"小智", "阿嘟"}, Words(merged))
+	assert.Equal(t, DefaultWeight, Weight(types.Hotword{Word: "x"}))
+}
+
+func TestCorrector(t *testing.T) {
+	corrector := NewCorrector([]types.Hotword{{Word: "阿嘟"}, {Word: "乐乐"}, {Word: "小智同学"}, {Word: "智"}, {Word: "iPhone"}})
+
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"阿杜你好", "阿嘟你好"},
+		{"我叫阿嘟", "我叫阿嘟"},
+		// 多音字和 n/l 不分
+		{"叫上勒勒一起玩", "叫上乐乐一起玩"},
+		{"呢呢在哪", "乐乐在哪"},
+		// 平翘舌不分, 长词优先
+		{"小子同学，开灯", "小智同学，开灯"},
+		{"今天天气不错", "今天天气不错"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, corrector.Correct(c.text), c.text)
+	}
+
+	assert.Nil(t, NewCorrector([]types.Hotword{{Word: "智"}, {Word: "iPhone"}}))
+	var nilCorrector *Corrector
+	assert.Equal(t, "阿杜", nilCorrector.Correct("阿杜"))
+}
@@ -0,0 +1,150 @@
+package hotword
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ParsePhrases 解析唤醒词、停止词等短语配置, 支持每行(或逗号分隔)一个的文本和字符串列表
+func ParsePhrases(v interface{}) []string {
+	var items []string
+	switch value := v.(type) {
+	case string:
+		items = strings.FieldsFunc(value, func(r rune) bool {
+			return r == '\n' || r == ',' || r == '，' || r == ';' || r == '；'
+		})
+	case []string:
+		items = value
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	}
+
+	var phrases []string
+	seen := make(map[string]bool)
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		phrases = append(phrases, item)
+	}
+	return phrases
+}
+
+type phrase struct {
+	text   string
+	tokens [][]string
+}
+
+// PhraseMatcher 判断一句话是否就是某个短语, 按模糊拼音比较, 同音字、繁体字和常见的读音混淆都能匹配上,
+// 英文按单词比较, 忽略大小写、标点和空格
+type PhraseMatcher struct {
+	phrases     []phrase
+	maxDistance int
+}
+
+// NewPhraseMatcher maxDistance 为允许相差(多、少、错)的字数, 但每三个字最多容错一个,
+// 两个字的短语必须逐字读音相同, 避免 "小智" 匹配上 "小明". 没有可用短语时返回 nil
+func NewPhraseMatcher(phrases []string, maxDistance int) *PhraseMatcher {
+	m := &PhraseMatcher{maxDistance: maxDistance}
+	for _, text := range phrases {
+		if tokens := tokenize(text); len(tokens) > 0 {
+			m.phrases = append(m.phrases, phrase{text: text, tokens: tokens})
+		}
+	}
+	if len(m.phrases) == 0 {
+		return nil
+	}
+	return m
+}
+
+// Match 返回与 text 匹配的短语, 多个短语都匹配时取相差最少的
+func (m *PhraseMatcher) Match(text string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return "", false
+	}
+	best, bestDistance := "", -1
+	for _, p := range m.phrases {
+		allowed := m.maxDistance
+		if limit := len(p.tokens) / 3; allowed > limit {
+			allowed = limit
+		}
+		d := distance(p.tokens, tokens, allowed)
+		if d > allowed {
+			continue
+		}
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = p.text, d
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+// tokenize 汉字一个字一个词, 取所有读音的模糊拼音; 连续的字母数字为一个词, 转为小写; 其余字符忽略
+func tokenize(text string) [][]string {
+	var tokens [][]string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, []string{word.String()})
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		if readings := syllables(r); len(readings) > 0 {
+			flush()
+			tokens = append(tokens, readings)
+			continue
+		}
+		if unicode.Is(unicode.Han, r) {
+			flush()
+			tokens = append(tokens, []string{string(r)})
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		flush()
+	}
+	flush()
+	return tokens
+}
+
+// distance 两组词的编辑距离, 读音有交集的词视为相同; 超过 limit 后提前返回 limit+1
+func distance(a, b [][]string, limit int) int {
+	if diff := len(a) - len(b); diff > limit || -diff > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if intersects(a[i-1], b[j-1]) {
+				cost = 0
+			}
+			cur[j] = min(prev[j-1]+cost, prev[j]+1, cur[j-1]+1)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
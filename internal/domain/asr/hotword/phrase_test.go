@@ -0,0 +1,49 @@
+package hotword
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePhrases(t *testing.T) {
+	expected := []string{"小智", "你好小智", "hey jarvis"}
+	assert.Equal(t, expected, ParsePhrases("小智\n你好小智，hey jarvis\n\n小智"))
+	assert.Equal(t, expected, ParsePhrases([]interface{}{" 小智 ", "你好小智", "hey jarvis", 1}))
+	assert.Nil(t, ParsePhrases(nil))
+}
+
+func TestPhraseMatcher(t *testing.T) {
+	m := NewPhraseMatcher([]string{"小智", "你好小智", "别说了", "Stop"}, 1)
+
+	for text, want := range map[string]string{
+		"小智":    "小智",
+		"小志。":   "小智",   // 同音字
+		"你好，小知": "你好小智", // 平翘舌
+		"妳好小智":  "你好小智", // 繁体字
+		"你好小":   "你好小智", // 少一个字
+		"别说啦":   "别说了",
+		"STOP!": "Stop",
+	} {
+		got, ok := m.Match(text)
+		assert.True(t, ok, text)
+		assert.Equal(t, want, got, text)
+	}
+
+	for _, text := range []string{"小明", "小", "你好", "今天天气怎么样", "stopped", ""} {
+		_, ok := m.Match(text)
+		assert.False(t, ok, text)
+	}
+
+	// 不容错时必须读音逐字相同
+	m = NewPhraseMatcher([]string{"你好小智"}, 0)
+	_, ok := m.Match("你好小")
+	assert.False(t, ok)
+	_, ok = m.Match("尼好小知")
+	assert.True(t, ok)
+
+	var nilMatcher *PhraseMatcher
+	_, ok = nilMatcher.Match("小智")
+	assert.False(t, ok)
+	assert.Nil(t, NewPhraseMatcher([]string{"", "，"}, 1))
+}
@@ -0,0 +1,202 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"xiaozhi-esp32-server-golang/internal/data/audio"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// OpenAIAsrProvider 调用 OpenAI 兼容的 /audio/transcriptions 接口识别语音,
+// faster-whisper-server、SenseVoice 封装、vLLM 等自建服务均可使用
+type OpenAIAsrProvider struct {
+	BaseURL    string
+	APIKey     string
+	Model      string
+	Language   string
+	Prompt     string
+	SampleRate int
+
+	client *http.Client
+}
+
+type transcriptionResponse struct {
+	Text string `json:"text"`
+}
+
+// NewOpenAIAsrProvider 创建 OpenAI 兼容的 ASR
+func NewOpenAIAsrProvider(config map[string]interface{}) (*OpenAIAsrProvider, error) {
+	provider := &OpenAIAsrProvider{
+		BaseURL:    "https://api.openai.com/v1",
+		Model:      "whisper-1",
+		SampleRate: audio.SampleRate,
+	}
+	timeout := 30
+
+	if baseURL, ok := config["base_url"].(string); ok && baseURL != "" {
+		provider.BaseURL = baseURL
+	}
+	provider.BaseURL = strings.TrimRight(provider.BaseURL, "/")
+	provider.APIKey, _ = config["api_key"].(string)
+	if model, ok := config["model"].(string); ok && model != "" {
+		provider.Model = model
+	}
+	provider.Language, _ = config["language"].(string)
+	provider.Prompt, _ = config["prompt"].(string)
+	// 接口没有热词参数, 把热词放进提示词, whisper 类模型会倾向于输出提示词中出现过的写法
+	if words := hotword.Words(hotword.Parse(config["hotwords"])); len(words) > 0 {
+		provider.Prompt = strings.TrimSpace(provider.Prompt + " " + strings.Join(words, "，"))
+	}
+	if sampleRate, ok := config["sample_rate"].(int); ok && sampleRate > 0 {
+		provider.SampleRate = sampleRate
+	} else if sampleRateFloat, ok := config["sample_rate"].(float64); ok && sampleRateFloat > 0 {
+		provider.SampleRate = int(sampleRateFloat)
+	}
+	if t, ok := config["timeout"].(int); ok && t > 0 {
+		timeout = t
+	} else if tFloat, ok := config["timeout"].(float64); ok && tFloat > 0 {
+		timeout = int(tFloat)
+	}
+
+	if _, err := http.NewRequest(http.MethodPost, provider.BaseURL, nil); err != nil {
+		return nil, fmt.Errorf("openai asr base_url 无效: %v", err)
+	}
+	provider.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	return provider, nil
+}
+
+// Process 一次性识别整段音频
+func (p *OpenAIAsrProvider) Process(pcmData []float32) (string, error) {
+	return p.transcribe(context.Background(), pcmData)
+}
+
+// StreamingRecognize 接口本身不支持流式, 缓存 VAD 切分好的整句音频,
+// audioStream 关闭后一次性提交, 只返回一个最终结果
+func (p *OpenAIAsrProvider) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	resultChan := make(chan types.StreamingResult, 1)
+
+	go func() {
+		defer close(resultChan)
+
+		var pcmData []float32
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case chunk, ok := <-audioStream:
+				if ok {
+					pcmData = append(pcmData, chunk...)
+					continue
+				}
+				text, err := p.transcribe(ctx, pcmData)
+				if err != nil {
+					log.Errorf("openai asr 识别失败: %v", err)
+				}
+				resultChan <- types.StreamingResult{Text: text, IsFinal: true, Error: err}
+				return
+			}
+		}
+	}()
+
+	return resultChan, nil
+}
+
+func (p *OpenAIAsrProvider) transcribe(ctx context.Context, pcmData []float32) (string, error) {
+	if len(pcmData) == 0 {
+		return "", nil
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fileWriter, err := writer.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", err
+	}
+	if _, err := fileWriter.Write(encodeWav(pcmData, p.SampleRate)); err != nil {
+		return "", err
+	}
+	fields := map[string]string{
+		"model":           p.Model,
+		"language":        p.Language,
+		"prompt":          p.Prompt,
+		"response_format": "json",
+	}
+	for key, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := writer.WriteField(key, value); err != nil {
+			return "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+"/audio/transcriptions", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("请求 openai asr 失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("读取 openai asr 响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("openai asr 返回错误状态码 %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result transcriptionResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("解析 openai asr 响应失败: %v", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// encodeWav 把单声道 float32 PCM 编码为 16bit WAV
+func encodeWav(pcmData []float32, sampleRate int) []byte {
+	const bitsPerSample = 16
+	dataSize := len(pcmData) * bitsPerSample / 8
+
+	buf := bytes.NewBuffer(make([]byte, 0, 44+dataSize))
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(16))
+	binary.Write(buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(buf, binary.LittleEndian, uint16(1)) // 单声道
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate*bitsPerSample/8))
+	binary.Write(buf, binary.LittleEndian, uint16(bitsPerSample/8))
+	binary.Write(buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, uint32(dataSize))
+	for _, sample := range pcmData {
+		sample = float32(math.Max(-1, math.Min(1, float64(sample))))
+		binary.Write(buf, binary.LittleEndian, int16(sample*math.MaxInt16))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,124 @@
+package openai
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockTranscriptionServer(t *testing.T, status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/audio/transcriptions", r.URL.Path)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+
+		if !assert.NoError(t, r.ParseMultipartForm(1<<20)) {
+			return
+		}
+		assert.Equal(t, "sensevoice", r.FormValue("model"))
+		assert.Equal(t, "zh", r.FormValue("language"))
+		assert.Equal(t, "小智", r.FormValue("prompt"))
+		assert.Equal(t, "json", r.FormValue("response_format"))
+
+		file, header, err := r.FormFile("file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer file.Close()
+		assert.Equal(t, "audio.wav", header.Filename)
+		wav, _ := io.ReadAll(file)
+		if !assert.Len(t, wav, 44+1600*2) {
+			return
+		}
+		assert.Equal(t, "RIFF", string(wav[0:4]))
+		assert.Equal(t, "WAVE", string(wav[8:12]))
+		assert.Equal(t, uint32(16000), binary.LittleEndian.Uint32(wav[24:28]))
+
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func newTestProvider(t *testing.T, server *httptest.Server) *OpenAIAsrProvider {
+	provider, err := NewOpenAIAsrProvider(map[string]interface{}{
+		"base_url": server.URL + "/v1/",
+		"api_key":  "test-key",
+		"model":    "sensevoice",
+		"language": "zh",
+		"prompt":   "小智",
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestProcess(t *testing.T) {
+	server := mockTranscriptionServer(t, http.StatusOK, `{"text":" 你好小智 "}`)
+	defer server.Close()
+
+	text, err := newTestProvider(t, server).Process(make([]float32, 1600))
+	require.NoError(t, err)
+	assert.Equal(t, "你好小智", text)
+}
+
+func TestStreamingRecognize(t *testing.T) {
+	server := mockTranscriptionServer(t, http.StatusOK, `{"text":"今天天气怎么样"}`)
+	defer server.Close()
+
+	audioStream := make(chan []float32, 2)
+	audioStream <- make([]float32, 800)
+	audioStream <- make([]float32, 800)
+	close(audioStream)
+
+	resultChan, err := newTestProvider(t, server).StreamingRecognize(context.Background(), audioStream)
+	require.NoError(t, err)
+
+	var results []string
+	for result := range resultChan {
+		require.NoError(t, result.Error)
+		assert.True(t, result.IsFinal)
+		results = append(results, result.Text)
+	}
+	assert.Equal(t, []string{"今天天气怎么样"}, results)
+}
+
+func TestStreamingRecognize_ServerError(t *testing.T) {
+	server := mockTranscriptionServer(t, http.StatusInternalServerError, `{"error":"boom"}`)
+	defer server.Close()
+
+	audioStream := make(chan []float32, 1)
+	audioStream <- make([]float32, 1600)
+	close(audioStream)
+
+	resultChan, err := newTestProvider(t, server).StreamingRecognize(context.Background(), audioStream)
+	require.NoError(t, err)
+
+	result := <-resultChan
+	assert.True(t, result.IsFinal)
+	assert.Error(t, result.Error)
+	_, ok := <-resultChan
+	assert.False(t, ok)
+}
+
+func TestProcess_EmptyAudio(t *testing.T) {
+	provider, err := NewOpenAIAsrProvider(map[string]interface{}{"base_url": "http://127.0.0.1:0/v1"})
+	require.NoError(t, err)
+
+	text, err := provider.Process(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", text)
+}
+
+func TestNewOpenAIAsrProvider_Hotwords(t *testing.T) {
+	provider, err := NewOpenAIAsrProvider(map[string]interface{}{
+		"prompt":   "儿童对话",
+		"hotwords": "阿嘟 30\n乐乐",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "儿童对话 阿嘟，乐乐", provider.Prompt)
+}
@@ -0,0 +1,118 @@
+package sherpa
+
+import (
+	"fmt"
+	"os"
+
+	"xiaozhi-esp32-server-golang/internal/data/audio"
+)
+
+// Config 本地流式识别模型配置, 模型可从 sherpa-onnx 发布页下载(streaming zipformer / paraformer)
+type Config struct {
+	Tokens string
+	// transducer 模型需要 encoder/decoder/joiner 三个文件, 不配置 joiner 时按 paraformer 加载
+	Encoder string
+	Decoder string
+	Joiner  string
+	// zipformer2 ctc 模型只有一个文件, 配置后忽略 encoder/decoder/joiner
+	CtcModel string
+
+	NumThreads     int
+	DecodingMethod string
+	SampleRate     int
+	FeatureDim     int
+
+	// 端点检测, 用于流式识别时把长音频切成多段
+	EnableEndpoint          bool
+	Rule1MinTrailingSilence float32
+	Rule2MinTrailingSilence float32
+	Rule3MinUtteranceLength float32
+}
+
+func parseConfig(config map[string]interface{}) (Config, error) {
+	cfg := Config{
+		NumThreads:              2,
+		DecodingMethod:          "greedy_search",
+		SampleRate:              audio.SampleRate,
+		FeatureDim:              80,
+		EnableEndpoint:          true,
+		Rule1MinTrailingSilence: 2.4,
+		Rule2MinTrailingSilence: 1.2,
+		Rule3MinUtteranceLength: 20,
+	}
+
+	cfg.Tokens, _ = config["tokens"].(string)
+	cfg.Encoder, _ = config["encoder"].(string)
+	cfg.Decoder, _ = config["decoder"].(string)
+	cfg.Joiner, _ = config["joiner"].(string)
+	cfg.CtcModel, _ = config["ctc_model"].(string)
+
+	if numThreads := getInt(config, "num_threads"); numThreads > 0 {
+		cfg.NumThreads = numThreads
+	}
+	if decodingMethod, ok := config["decoding_method"].(string); ok && decodingMethod != "" {
+		cfg.DecodingMethod = decodingMethod
+	}
+	if sampleRate := getInt(config, "sample_rate"); sampleRate > 0 {
+		cfg.SampleRate = sampleRate
+	}
+	if featureDim := getInt(config, "feature_dim"); featureDim > 0 {
+		cfg.FeatureDim = featureDim
+	}
+	if enableEndpoint, ok := config["enable_endpoint"].(bool); ok {
+		cfg.EnableEndpoint = enableEndpoint
+	}
+	if silence := getFloat(config, "rule1_min_trailing_silence"); silence > 0 {
+		cfg.Rule1MinTrailingSilence = silence
+	}
+	if silence := getFloat(config, "rule2_min_trailing_silence"); silence > 0 {
+		cfg.Rule2MinTrailingSilence = silence
+	}
+	if length := getFloat(config, "rule3_min_utterance_length"); length > 0 {
+		cfg.Rule3MinUtteranceLength = length
+	}
+
+	files := map[string]string{"tokens": cfg.Tokens}
+	if cfg.CtcModel != "" {
+		files["ctc_model"] = cfg.CtcModel
+	} else {
+		files["encoder"] = cfg.Encoder
+		files["decoder"] = cfg.Decoder
+		if cfg.Joiner != "" {
+			files["joiner"] = cfg.Joiner
+		}
+	}
+	for key, path := range files {
+		if path == "" {
+			return cfg, fmt.Errorf("sherpa asr 缺少模型配置: %s", key)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return cfg, fmt.Errorf("sherpa asr 模型文件不可用 %s: %v", key, err)
+		}
+	}
+	return cfg, nil
+}
+
+func getInt(config map[string]interface{}, key string) int {
+	switch v := config[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+func getFloat(config map[string]interface{}, key string) float32 {
+	switch v := config[key].(type) {
+	case float64:
+		return float32(v)
+	case int:
+		return float32(v)
+	case int64:
+		return float32(v)
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+//go:build sherpa
+
+package sherpa
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	sherpa_onnx "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// 整段识别时在音频末尾补的静音时长(秒), 流式模型需要一点尾部静音才会吐出最后几个字
+const tailPaddingSeconds = 0.3
+
+// Sherpa 基于 sherpa-onnx 的本地 ASR, 纯 CPU 推理, 不依赖任何外部服务
+type Sherpa struct {
+	config     Config
+	recognizer *sherpa_onnx.OnlineRecognizer
+}
+
+var (
+	// 模型只加载一次, 相同配置的会话共用同一个识别器, 每次识别使用独立的 stream
+	recognizers   = make(map[Config]*sherpa_onnx.OnlineRecognizer)
+	recognizersMu sync.Mutex
+)
+
+// NewSherpa 创建本地 ASR, 首次使用某个模型时从磁盘加载
+func NewSherpa(config map[string]interface{}) (*Sherpa, error) {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	recognizer, err := getRecognizer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Sherpa{config: cfg, recognizer: recognizer}, nil
+}
+
+func getRecognizer(cfg Config) (*sherpa_onnx.OnlineRecognizer, error) {
+	recognizersMu.Lock()
+	defer recognizersMu.Unlock()
+
+	if recognizer, ok := recognizers[cfg]; ok {
+		return recognizer, nil
+	}
+
+	recognizerConfig := sherpa_onnx.OnlineRecognizerConfig{}
+	recognizerConfig.FeatConfig.SampleRate = cfg.SampleRate
+	recognizerConfig.FeatConfig.FeatureDim = cfg.FeatureDim
+	recognizerConfig.ModelConfig.Tokens = cfg.Tokens
+	recognizerConfig.ModelConfig.NumThreads = cfg.NumThreads
+	recognizerConfig.ModelConfig.Provider = "cpu"
+	switch {
+	case cfg.CtcModel != "":
+		recognizerConfig.ModelConfig.Zipformer2Ctc.Model = cfg.CtcModel
+	case cfg.Joiner != "":
+		recognizerConfig.ModelConfig.Transducer.Encoder = cfg.Encoder
+		recognizerConfig.ModelConfig.Transducer.Decoder = cfg.Decoder
+		recognizerConfig.ModelConfig.Transducer.Joiner = cfg.Joiner
+	default:
+		recognizerConfig.ModelConfig.Paraformer.Encoder = cfg.Encoder
+		recognizerConfig.ModelConfig.Paraformer.Decoder = cfg.Decoder
+	}
+	recognizerConfig.DecodingMethod = cfg.DecodingMethod
+	if cfg.EnableEndpoint {
+		recognizerConfig.EnableEndpoint = 1
+	}
+	recognizerConfig.Rule1MinTrailingSilence = cfg.Rule1MinTrailingSilence
+	recognizerConfig.Rule2MinTrailingSilence = cfg.Rule2MinTrailingSilence
+	recognizerConfig.Rule3MinUtteranceLength = cfg.Rule3MinUtteranceLength
+
+	recognizer := sherpa_onnx.NewOnlineRecognizer(&recognizerConfig)
+	if recognizer == nil {
+		return nil, fmt.Errorf("sherpa asr 加载模型失败, 请检查模型文件与配置是否匹配")
+	}
+	recognizers[cfg] = recognizer
+	log.Infof("sherpa asr 模型加载完成: %+v", cfg)
+	return recognizer, nil
+}
+
+// Process 一次性识别整段音频
+func (s *Sherpa) Process(pcmData []float32) (string, error) {
+	stream := sherpa_onnx.NewOnlineStream(s.recognizer)
+	defer sherpa_onnx.DeleteOnlineStream(stream)
+
+	stream.AcceptWaveform(s.config.SampleRate, pcmData)
+	stream.AcceptWaveform(s.config.SampleRate, make([]float32, int(float64(s.config.SampleRate)*tailPaddingSeconds)))
+	stream.InputFinished()
+	s.decode(stream)
+
+	return strings.TrimSpace(s.recognizer.GetResult(stream).Text), nil
+}
+
+// StreamingRecognize 流式识别, 检测到端点时先返回已识别的一段(非最终结果),
+// audioStream 关闭后返回最后一段作为最终结果
+func (s *Sherpa) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	resultChan := make(chan types.StreamingResult, 10)
+
+	go func() {
+		defer close(resultChan)
+
+		stream := sherpa_onnx.NewOnlineStream(s.recognizer)
+		defer sherpa_onnx.DeleteOnlineStream(stream)
+
+		send := func(result types.StreamingResult) bool {
+			select {
+			case resultChan <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case pcmData, ok := <-audioStream:
+				if !ok {
+					stream.AcceptWaveform(s.config.SampleRate, make([]float32, int(float64(s.config.SampleRate)*tailPaddingSeconds)))
+					stream.InputFinished()
+					s.decode(stream)
+					send(types.StreamingResult{
+						Text:    strings.TrimSpace(s.recognizer.GetResult(stream).Text),
+						IsFinal: true,
+					})
+					return
+				}
+
+				stream.AcceptWaveform(s.config.SampleRate, pcmData)
+				s.decode(stream)
+				if !s.recognizer.IsEndpoint(stream) {
+					continue
+				}
+				// 每段只返回新识别的文本, 调用方会把各段拼接起来
+				text := strings.TrimSpace(s.recognizer.GetResult(stream).Text)
+				s.recognizer.Reset(stream)
+				if text != "" && !send(types.StreamingResult{Text: text}) {
+					return
+				}
+			}
+		}
+	}()
+
+	return resultChan, nil
+}
+
+func (s *Sherpa) decode(stream *sherpa_onnx.OnlineStream) {
+	for s.recognizer.IsReady(stream) {
+		s.recognizer.Decode(stream)
+	}
+}
@@ -0,0 +1,63 @@
+package sherpa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func touch(t *testing.T, dir string, name string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+	tokens := touch(t, dir, "tokens.txt")
+	encoder := touch(t, dir, "encoder.onnx")
+	decoder := touch(t, dir, "decoder.onnx")
+
+	cfg, err := parseConfig(map[string]interface{}{
+		"tokens":                     tokens,
+		"encoder":                    encoder,
+		"decoder":                    decoder,
+		"num_threads":                float64(4),
+		"enable_endpoint":            false,
+		"rule2_min_trailing_silence": 0.8,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, cfg.NumThreads)
+	assert.Equal(t, 16000, cfg.SampleRate)
+	assert.Equal(t, "greedy_search", cfg.DecodingMethod)
+	assert.False(t, cfg.EnableEndpoint)
+	assert.Equal(t, float32(0.8), cfg.Rule2MinTrailingSilence)
+	assert.Equal(t, float32(2.4), cfg.Rule1MinTrailingSilence)
+}
+
+func TestParseConfig_MissingModel(t *testing.T) {
+	dir := t.TempDir()
+	tokens := touch(t, dir, "tokens.txt")
+
+	_, err := parseConfig(map[string]interface{}{"tokens": tokens})
+	assert.Error(t, err)
+
+	// transducer 模型缺少 joiner 文件
+	_, err = parseConfig(map[string]interface{}{
+		"tokens":  tokens,
+		"encoder": touch(t, dir, "encoder.onnx"),
+		"decoder": touch(t, dir, "decoder.onnx"),
+		"joiner":  filepath.Join(dir, "joiner.onnx"),
+	})
+	assert.Error(t, err)
+
+	// ctc 模型只需要一个文件
+	_, err = parseConfig(map[string]interface{}{
+		"tokens":    tokens,
+		"ctc_model": touch(t, dir, "model.onnx"),
+	})
+	assert.NoError(t, err)
+}
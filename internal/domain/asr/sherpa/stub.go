@@ -0,0 +1,28 @@
+//go:build !sherpa
+
+package sherpa
+
+import (
+	"context"
+	"errors"
+
+	"xiaozhi-esp32-server-golang/internal/domain/asr/types"
+)
+
+// ErrNotCompiled sherpa-onnx 依赖 cgo 动态库, 默认不编译进程序, 需要使用 -tags sherpa 构建
+var ErrNotCompiled = errors.New("sherpa asr 未编译进当前程序, 请使用 -tags sherpa 重新构建")
+
+// Sherpa 未使用 sherpa 标签构建时的占位实现, 创建时即返回 ErrNotCompiled
+type Sherpa struct{}
+
+func NewSherpa(config map[string]interface{}) (*Sherpa, error) {
+	return nil, ErrNotCompiled
+}
+
+func (s *Sherpa) Process(pcmData []float32) (string, error) {
+	return "", ErrNotCompiled
+}
+
+func (s *Sherpa) StreamingRecognize(ctx context.Context, audioStream <-chan []float32) (chan types.StreamingResult, error) {
+	return nil, ErrNotCompiled
+}
@@ -6,3 +6,9 @@ type StreamingResult struct {
 	IsFinal bool   // 是否为最终结果
 	Error   error  // 错误信息
 }
+
+// Hotword 识别热词, Weight 为偏置权重, 只有支持权重的引擎(如 FunASR)会使用
+type Hotword struct {
+	Word   string `json:"word"`
+	Weight int    `json:"weight,omitempty"`
+}
@@ -47,6 +47,32 @@ func (a *AudioProcesser) DecoderFloat32(audio []byte, pcmData []float32) (int, e
 	return a.decoder.DecodeFloat32(audio, pcmData)
 }
 
+// DecodeWithConcealFloat32 解码一帧, lostCount 为该帧之前连续丢失的帧数
+// 丢失的帧中最后一帧尝试用本帧携带的 FEC 数据恢复, 其余使用 PLC 补齐, 补偿数据拼接在本帧数据之前
+func (a *AudioProcesser) DecodeWithConcealFloat32(audio []byte, lostCount int, frameSize int) ([]float32, error) {
+	if a.decoder == nil {
+		return nil, errors.New("decoder is nil")
+	}
+	pcmData := make([]float32, frameSize*(lostCount+1))
+	for i := 0; i < lostCount; i++ {
+		concealFrame := pcmData[i*frameSize : (i+1)*frameSize]
+		var err error
+		if i == lostCount-1 {
+			err = a.decoder.DecodeFECFloat32(audio, concealFrame)
+		} else {
+			err = a.decoder.DecodePLCFloat32(concealFrame)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	n, err := a.decoder.DecodeFloat32(audio, pcmData[lostCount*frameSize:])
+	if err != nil {
+		return nil, err
+	}
+	return pcmData[:lostCount*frameSize+n], nil
+}
+
 func (a *AudioProcesser) Encoder(pcmData []int16, audio []byte) (int, error) {
 	if a.encoder == nil {
 		return 0, errors.New("encoder is nil")
@@ -0,0 +1,80 @@
+package preprocess
+
+import "math"
+
+const (
+	DefaultAgcTargetDb  = -20.0
+	DefaultAgcMaxGainDb = 20.0
+
+	// 低于该电平视为静音, 保持当前增益, 不把底噪放大到目标电平
+	agcSilenceDb = -50.0
+	// 增益下降快, 避免突然大声时削波; 上升慢, 避免句中停顿后底噪被猛然放大
+	agcAttackSeconds  = 0.05
+	agcReleaseSeconds = 0.4
+	// 放大后的峰值不超过该值
+	agcPeakLimit = 0.95
+)
+
+// AGC 自动增益, 把说话的音量调到目标电平, 麦克风灵敏度低或离得远时提高识别率
+type AGC struct {
+	sampleRate int
+	target     float64
+	maxGain    float64
+	silence    float64
+	gain       float64
+}
+
+// NewAGC targetDb 为目标 RMS 电平(dBFS), maxGainDb 为最大放大倍数
+func NewAGC(sampleRate int, targetDb float64, maxGainDb float64) *AGC {
+	if maxGainDb < 0 {
+		maxGainDb = 0
+	}
+	return &AGC{
+		sampleRate: sampleRate,
+		target:     dbToGain(targetDb),
+		maxGain:    dbToGain(maxGainDb),
+		silence:    dbToGain(agcSilenceDb),
+		gain:       1,
+	}
+}
+
+func (a *AGC) Process(pcm []float32) []float32 {
+	if len(pcm) == 0 {
+		return pcm
+	}
+	level := rms(pcm)
+	desired := a.gain
+	if level > a.silence {
+		desired = math.Min(a.target/level, a.maxGain)
+	}
+
+	elapsed := float64(len(pcm)) / float64(a.sampleRate)
+	gain := a.gain
+	if desired < gain {
+		gain += (desired - gain) * smoothing(elapsed, agcAttackSeconds)
+	} else {
+		gain += (desired - gain) * smoothing(elapsed, agcReleaseSeconds)
+	}
+
+	// 削峰优先于平滑, 本帧放大后不能超过上限
+	var peak float64
+	for _, s := range pcm {
+		peak = math.Max(peak, math.Abs(float64(s)))
+	}
+	if peak > 0 && peak*gain > agcPeakLimit {
+		gain = agcPeakLimit / peak
+	}
+
+	applyRamp(pcm, math.Min(a.gain, gain), gain)
+	a.gain = gain
+	return pcm
+}
+
+// Gain 当前增益, 线性倍数
+func (a *AGC) Gain() float64 {
+	return a.gain
+}
+
+func (a *AGC) Reset() {
+	a.gain = 1
+}
@@ -0,0 +1,50 @@
+package preprocess
+
+import "math"
+
+const (
+	DefaultClipLevel = 0.99
+
+	// 一秒内削波点占比达到该值才上报, 偶尔一两个满幅采样点不影响识别
+	clipReportRatio = 0.001
+)
+
+// ClipDetector 削波检测, 不修改音频. 设备麦克风增益过大时语音失真, 识别率明显下降,
+// 需要调低设备端增益, 服务端无法恢复
+type ClipDetector struct {
+	sampleRate int
+	level      float32
+	onClip     func(ratio float64)
+
+	samples int
+	clipped int
+}
+
+// NewClipDetector 绝对值不低于 level 的采样点视为削波, 每秒统计一次, 削波较多时调用 onClip
+func NewClipDetector(sampleRate int, level float64, onClip func(ratio float64)) *ClipDetector {
+	if level <= 0 || level > 1 {
+		level = DefaultClipLevel
+	}
+	return &ClipDetector{sampleRate: sampleRate, level: float32(level), onClip: onClip}
+}
+
+func (c *ClipDetector) Process(pcm []float32) []float32 {
+	for _, s := range pcm {
+		if float32(math.Abs(float64(s))) >= c.level {
+			c.clipped++
+		}
+	}
+	c.samples += len(pcm)
+	if c.samples >= c.sampleRate {
+		ratio := float64(c.clipped) / float64(c.samples)
+		if ratio >= clipReportRatio && c.onClip != nil {
+			c.onClip(ratio)
+		}
+		c.samples, c.clipped = 0, 0
+	}
+	return pcm
+}
+
+func (c *ClipDetector) Reset() {
+	c.samples, c.clipped = 0, 0
+}
@@ -0,0 +1,69 @@
+package preprocess
+
+import "math"
+
+// DefaultHighPassCutoff 人声基频一般高于 85Hz, 截止频率取 80Hz 不影响语音
+const DefaultHighPassCutoff = 80.0
+
+// 四阶巴特沃斯由两节二阶滤波级联, 两节的 Q 值
+var highPassQ = [2]float64{0.5412, 1.3066}
+
+// HighPass 四阶巴特沃斯高通滤波, 去掉直流偏置、工频嗡声和抽油烟机一类的低频噪声
+type HighPass struct {
+	sections [2]biquad
+}
+
+func NewHighPass(sampleRate int, cutoff float64) *HighPass {
+	if cutoff <= 0 || cutoff >= float64(sampleRate)/2 {
+		cutoff = DefaultHighPassCutoff
+	}
+	h := &HighPass{}
+	for i, q := range highPassQ {
+		h.sections[i] = newHighPassBiquad(sampleRate, cutoff, q)
+	}
+	return h
+}
+
+func (h *HighPass) Process(pcm []float32) []float32 {
+	for i, s := range pcm {
+		x := float64(s)
+		for j := range h.sections {
+			x = h.sections[j].process(x)
+		}
+		pcm[i] = float32(x)
+	}
+	return pcm
+}
+
+func (h *HighPass) Reset() {
+	for i := range h.sections {
+		h.sections[i].x1, h.sections[i].x2, h.sections[i].y1, h.sections[i].y2 = 0, 0, 0, 0
+	}
+}
+
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// newHighPassBiquad 系数见 RBJ Audio EQ Cookbook
+func newHighPassBiquad(sampleRate int, cutoff float64, q float64) biquad {
+	w0 := 2 * math.Pi * cutoff / float64(sampleRate)
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func (b *biquad) process(x float64) float64 {
+	y := b.b0*x + b.b1*b.x1 + b.b2*b.x2 - b.a1*b.y1 - b.a2*b.y2
+	b.x2, b.x1 = b.x1, x
+	b.y2, b.y1 = b.y1, y
+	return y
+}
@@ -0,0 +1,74 @@
+package preprocess
+
+import "math"
+
+const (
+	DefaultNoiseThresholdDb   = 6.0
+	DefaultNoiseAttenuationDb = 12.0
+
+	// 底噪估计每秒最多上升的分贝数, 说话时底噪估计不会很快被语音抬高
+	noiseFloorRiseDbPerSecond = 3.0
+	// 数字静音时底噪的下限, 约 -100dBFS
+	noiseFloorMin = 1e-5
+	// 有语音时立即打开, 语音结束后慢慢关闭, 不截掉尾音
+	noiseOpenSeconds  = 0.005
+	noiseCloseSeconds = 0.1
+)
+
+// NoiseSuppressor 按底噪估计做下行扩展: 电平接近底噪的帧(说话停顿、背景噪声)整体衰减,
+// 明显高于底噪的帧原样通过. 只处理平稳的背景噪声, 不做频域降噪, 语音中夹杂的噪声不会被去掉
+type NoiseSuppressor struct {
+	sampleRate  int
+	threshold   float64
+	attenuation float64
+	floor       float64
+	gain        float64
+}
+
+// NewNoiseSuppressor 电平高出底噪 thresholdDb 以上视为语音, 否则衰减 attenuationDb
+func NewNoiseSuppressor(sampleRate int, thresholdDb float64, attenuationDb float64) *NoiseSuppressor {
+	return &NoiseSuppressor{
+		sampleRate:  sampleRate,
+		threshold:   dbToGain(thresholdDb),
+		attenuation: dbToGain(-math.Abs(attenuationDb)),
+		gain:        1,
+	}
+}
+
+func (n *NoiseSuppressor) Process(pcm []float32) []float32 {
+	if len(pcm) == 0 {
+		return pcm
+	}
+	elapsed := float64(len(pcm)) / float64(n.sampleRate)
+	level := math.Max(rms(pcm), noiseFloorMin)
+	if n.floor == 0 || level < n.floor {
+		n.floor = level
+	} else {
+		n.floor *= dbToGain(noiseFloorRiseDbPerSecond * elapsed)
+	}
+
+	desired := 1.0
+	if level < n.floor*n.threshold {
+		desired = n.attenuation
+	}
+	gain := n.gain
+	if desired > gain {
+		gain += (desired - gain) * smoothing(elapsed, noiseOpenSeconds)
+	} else {
+		gain += (desired - gain) * smoothing(elapsed, noiseCloseSeconds)
+	}
+
+	applyRamp(pcm, n.gain, gain)
+	n.gain = gain
+	return pcm
+}
+
+// NoiseFloor 当前的底噪估计, RMS 线性值
+func (n *NoiseSuppressor) NoiseFloor() float64 {
+	return n.floor
+}
+
+func (n *NoiseSuppressor) Reset() {
+	n.floor = 0
+	n.gain = 1
+}
@@ -0,0 +1,162 @@
+package preprocess
+
+import (
+	"fmt"
+	"math"
+)
+
+// 各处理环节的名字, 用于 stages 配置
+const (
+	StageClipDetect    = "clip_detect"
+	StageHighPass      = "high_pass"
+	StageNoiseSuppress = "noise_suppress"
+	StageAgc           = "agc"
+)
+
+// DefaultStages 未配置 stages 时的处理顺序: 削波检测要看原始输入, 放在最前;
+// 先去掉低频嗡声再估计底噪, 最后把音量调到目标电平
+var DefaultStages = []string{StageClipDetect, StageHighPass, StageNoiseSuppress, StageAgc}
+
+// Filter 处理一帧单声道 float32 PCM, 可以原地修改, 返回处理后的数据
+type Filter interface {
+	Process(pcm []float32) []float32
+	// Reset 清除跨帧保存的状态
+	Reset()
+}
+
+// Chain 按顺序执行的一组 Filter, 位于解码之后、vad 与 asr 之前
+type Chain struct {
+	filters []Filter
+}
+
+type ChainOption func(*chainOptions)
+
+type chainOptions struct {
+	onClip func(ratio float64)
+}
+
+// WithClipHandler 检测到削波时回调, ratio 为最近一秒内削波采样点的占比
+func WithClipHandler(onClip func(ratio float64)) ChainOption {
+	return func(o *chainOptions) {
+		o.onClip = onClip
+	}
+}
+
+// NewChain 按配置创建处理链, 未开启时返回 nil, 配置格式见 config.yaml 中的 audio_preprocess
+func NewChain(sampleRate int, config map[string]interface{}, opts ...ChainOption) (*Chain, error) {
+	if enable, _ := config["enable"].(bool); !enable {
+		return nil, nil
+	}
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("无效的采样率: %d", sampleRate)
+	}
+	var o chainOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	stages := DefaultStages
+	if raw, ok := config["stages"].([]interface{}); ok {
+		stages = make([]string, 0, len(raw))
+		for _, stage := range raw {
+			if name, ok := stage.(string); ok {
+				stages = append(stages, name)
+			}
+		}
+	}
+
+	c := &Chain{}
+	for _, stage := range stages {
+		params, _ := config[stage].(map[string]interface{})
+		switch stage {
+		case StageClipDetect:
+			c.filters = append(c.filters, NewClipDetector(sampleRate, getFloat(params, "level", DefaultClipLevel), o.onClip))
+		case StageHighPass:
+			c.filters = append(c.filters, NewHighPass(sampleRate, getFloat(params, "cutoff", DefaultHighPassCutoff)))
+		case StageNoiseSuppress:
+			c.filters = append(c.filters, NewNoiseSuppressor(sampleRate,
+				getFloat(params, "threshold_db", DefaultNoiseThresholdDb),
+				getFloat(params, "attenuation_db", DefaultNoiseAttenuationDb)))
+		case StageAgc:
+			c.filters = append(c.filters, NewAGC(sampleRate,
+				getFloat(params, "target_db", DefaultAgcTargetDb),
+				getFloat(params, "max_gain_db", DefaultAgcMaxGainDb)))
+		default:
+			return nil, fmt.Errorf("不支持的音频预处理环节: %s", stage)
+		}
+	}
+	if len(c.filters) == 0 {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// NewChainWithFilters 直接用给定的 Filter 组成处理链
+func NewChainWithFilters(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+func (c *Chain) Process(pcm []float32) []float32 {
+	if c == nil {
+		return pcm
+	}
+	for _, f := range c.filters {
+		pcm = f.Process(pcm)
+	}
+	return pcm
+}
+
+func (c *Chain) Reset() {
+	if c == nil {
+		return
+	}
+	for _, f := range c.filters {
+		f.Reset()
+	}
+}
+
+func getFloat(params map[string]interface{}, key string, def float64) float64 {
+	switch v := params[key].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return def
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+func rms(pcm []float32) float64 {
+	if len(pcm) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range pcm {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(pcm)))
+}
+
+// smoothing 一阶平滑系数, 每过 elapsed 秒向目标值靠近 1-exp(-elapsed/tau)
+func smoothing(elapsed, tau float64) float64 {
+	if tau <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-elapsed/tau)
+}
+
+// applyRamp 在一帧内把增益从 from 线性过渡到 to, 避免帧边界处增益跳变产生咔哒声
+func applyRamp(pcm []float32, from, to float64) {
+	n := float64(len(pcm))
+	for i := range pcm {
+		g := from + (to-from)*float64(i+1)/n
+		pcm[i] = float32(float64(pcm[i]) * g)
+	}
+}
@@ -0,0 +1,177 @@
+package preprocess
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/go-audio/wav"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata 下的音频均为 16kHz 单声道, 2 秒:
+//   - hum.wav: 类语音信号叠加 50Hz 工频嗡声和直流偏置
+//   - quiet.wav: 0.5~1.5 秒为约 -35dBFS 的小声说话, 其余为极低的底噪
+//   - noisy.wav: 0.5~1.5 秒为正常音量说话, 全程有约 -46dBFS 的白噪声
+//   - clipped.wav: 音量过大被削波的说话
+const (
+	testSampleRate = 16000
+	// 设备上行一帧 60ms
+	testFrameSize = 960
+)
+
+func readWav(t *testing.T, name string) []float32 {
+	f, err := os.Open("testdata/" + name)
+	require.NoError(t, err)
+	defer f.Close()
+	buf, err := wav.NewDecoder(f).FullPCMBuffer()
+	require.NoError(t, err)
+	require.Equal(t, testSampleRate, buf.Format.SampleRate)
+	pcm := make([]float32, len(buf.Data))
+	for i, s := range buf.Data {
+		pcm[i] = float32(s) / 32768
+	}
+	return pcm
+}
+
+// process 按设备上行的帧长逐帧处理
+func process(f Filter, pcm []float32) []float32 {
+	out := make([]float32, 0, len(pcm))
+	for i := 0; i < len(pcm); i += testFrameSize {
+		frame := append([]float32(nil), pcm[i:min(i+testFrameSize, len(pcm))]...)
+		out = append(out, f.Process(frame)...)
+	}
+	return out
+}
+
+func seconds(pcm []float32, from, to float64) []float32 {
+	return pcm[int(from*testSampleRate):int(to*testSampleRate)]
+}
+
+func toDb(v float64) float64 {
+	return 20 * math.Log10(v)
+}
+
+// magnitude 用 Goertzel 算法求某一频率分量的幅度
+func magnitude(pcm []float32, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/testSampleRate)
+	var s1, s2 float64
+	for _, x := range pcm {
+		s1, s2 = float64(x)+coeff*s1-s2, s1
+	}
+	return math.Sqrt(s1*s1+s2*s2-coeff*s1*s2) * 2 / float64(len(pcm))
+}
+
+func mean(pcm []float32) float64 {
+	var sum float64
+	for _, s := range pcm {
+		sum += float64(s)
+	}
+	return sum / float64(len(pcm))
+}
+
+func TestHighPass(t *testing.T) {
+	in := readWav(t, "hum.wav")
+	out := process(NewHighPass(testSampleRate, DefaultHighPassCutoff), append([]float32(nil), in...))
+	require.Len(t, out, len(in))
+
+	// 跳过滤波器刚启动的一段
+	before, after := seconds(in, 0.5, 2), seconds(out, 0.5, 2)
+	assert.Less(t, toDb(magnitude(after, 50)), toDb(magnitude(before, 50))-15, "50Hz 嗡声应被衰减")
+	assert.InDelta(t, toDb(magnitude(before, 300)), toDb(magnitude(after, 300)), 1, "语音频段不受影响")
+	assert.InDelta(t, toDb(magnitude(before, 1200)), toDb(magnitude(after, 1200)), 1)
+	assert.InDelta(t, 0, mean(after), 0.002, "直流偏置应被去掉")
+}
+
+func TestAGC(t *testing.T) {
+	in := readWav(t, "quiet.wav")
+	agc := NewAGC(testSampleRate, DefaultAgcTargetDb, DefaultAgcMaxGainDb)
+	out := process(agc, append([]float32(nil), in...))
+
+	// 说话前的静音不被放大
+	assert.InDelta(t, rms(seconds(in, 0, 0.5)), rms(seconds(out, 0, 0.5)), 1e-6)
+	// 说话后半段增益已稳定, 音量接近目标电平
+	assert.InDelta(t, DefaultAgcTargetDb, toDb(rms(seconds(out, 1, 1.5))), 3)
+	assert.LessOrEqual(t, agc.Gain(), dbToGain(DefaultAgcMaxGainDb)+1e-9)
+	for _, s := range out {
+		require.LessOrEqual(t, math.Abs(float64(s)), agcPeakLimit+1e-6)
+	}
+
+	// 原本就很大声时只会减小
+	loud := readWav(t, "noisy.wav")
+	out = process(NewAGC(testSampleRate, DefaultAgcTargetDb, DefaultAgcMaxGainDb), loud)
+	for _, s := range out {
+		require.LessOrEqual(t, math.Abs(float64(s)), agcPeakLimit+1e-6)
+	}
+
+	agc.Reset()
+	assert.Equal(t, 1.0, agc.Gain())
+}
+
+func TestNoiseSuppressor(t *testing.T) {
+	in := readWav(t, "noisy.wav")
+	ns := NewNoiseSuppressor(testSampleRate, DefaultNoiseThresholdDb, DefaultNoiseAttenuationDb)
+	out := process(ns, append([]float32(nil), in...))
+
+	// 说话结束后的停顿, 跳过关闭过程
+	pauseIn, pauseOut := seconds(in, 1.9, 2), seconds(out, 1.9, 2)
+	assert.Less(t, toDb(rms(pauseOut)), toDb(rms(pauseIn))-DefaultNoiseAttenuationDb+2)
+	// 语音基本不受影响
+	assert.InDelta(t, toDb(rms(seconds(in, 0.6, 1.5))), toDb(rms(seconds(out, 0.6, 1.5))), 1)
+	// 底噪估计接近实际的白噪声电平
+	assert.InDelta(t, toDb(rms(pauseIn)), toDb(ns.NoiseFloor()), 3)
+}
+
+func TestClipDetector(t *testing.T) {
+	var reports []float64
+	detector := NewClipDetector(testSampleRate, DefaultClipLevel, func(ratio float64) {
+		reports = append(reports, ratio)
+	})
+
+	in := readWav(t, "clipped.wav")
+	out := process(detector, append([]float32(nil), in...))
+	assert.Equal(t, in, out, "削波检测不修改音频")
+	require.NotEmpty(t, reports)
+	assert.Greater(t, reports[0], 0.01)
+
+	reports = nil
+	detector.Reset()
+	process(detector, readWav(t, "noisy.wav"))
+	assert.Empty(t, reports)
+}
+
+func TestNewChain(t *testing.T) {
+	chain, err := NewChain(testSampleRate, map[string]interface{}{"enable": false})
+	require.NoError(t, err)
+	assert.Nil(t, chain)
+	pcm := []float32{0.1, 0.2}
+	assert.Equal(t, pcm, chain.Process(pcm), "未开启时原样返回")
+
+	chain, err = NewChain(testSampleRate, map[string]interface{}{"enable": true})
+	require.NoError(t, err)
+	assert.Len(t, chain.filters, len(DefaultStages))
+
+	chain, err = NewChain(testSampleRate, map[string]interface{}{
+		"enable":    true,
+		"stages":    []interface{}{"high_pass", "agc"},
+		"high_pass": map[string]interface{}{"cutoff": 120},
+		"agc":       map[string]interface{}{"target_db": -18.0, "max_gain_db": 12},
+	})
+	require.NoError(t, err)
+	require.Len(t, chain.filters, 2)
+	assert.IsType(t, &HighPass{}, chain.filters[0])
+	assert.InDelta(t, dbToGain(12), chain.filters[1].(*AGC).maxGain, 1e-9)
+
+	_, err = NewChain(testSampleRate, map[string]interface{}{"enable": true, "stages": []interface{}{"echo_cancel"}})
+	assert.Error(t, err)
+
+	// 完整处理链: 削波时回调, 输出长度不变
+	var clipped bool
+	chain, err = NewChain(testSampleRate, map[string]interface{}{"enable": true}, WithClipHandler(func(float64) { clipped = true }))
+	require.NoError(t, err)
+	in := readWav(t, "clipped.wav")
+	out := process(chain, append([]float32(nil), in...))
+	assert.Len(t, out, len(in))
+	assert.True(t, clipped)
+}
@@ -27,3 +27,9 @@ type UserConfigProvider interface {
 	//注册下行事件处理函数(比如消息注入等)
 	RegisterMessageEventHandler(ctx context.Context, eventType string, eventHandler types.EventHandler)
 }
+
+// AgentConfigProvider 按智能体获取配置, 为可选接口, 只有 manager 提供者实现
+// 网页/App 的文本对话可以不经过设备直接和智能体对话
+type AgentConfigProvider interface {
+	GetAgentConfig(ctx context.Context, agentID string) (types.UConfig, error)
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"time"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
 	"xiaozhi-esp32-server-golang/internal/domain/config/types"
 	log "xiaozhi-esp32-server-golang/logger"
 )
@@ -45,8 +47,18 @@ func NewManagerUserConfigProvider(config map[string]interface{}) (*ConfigManager
 }
 
 func (c *ConfigManager) GetUserConfig(ctx context.Context, deviceID string) (types.UConfig, error) {
+	return c.getConfig(ctx, "device_id", deviceID)
+}
+
+// GetAgentConfig 按智能体获取配置, 供没有设备的网页/App 文本对话使用
+func (c *ConfigManager) GetAgentConfig(ctx context.Context, agentID string) (types.UConfig, error) {
+	return c.getConfig(ctx, "agent_id", agentID)
+}
+
+// getConfig 从后端获取设备或智能体的配置, key 为 device_id 或 agent_id
+func (c *ConfigManager) getConfig(ctx context.Context, key string, id string) (types.UConfig, error) {
 	// 构建请求URL
-	url := c.baseURL + "/api/configs?device_id=" + deviceID
+	url := c.baseURL + "/api/configs?" + key + "=" + neturl.QueryEscape(id)
 
 	// 创建HTTP请求
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -92,8 +104,12 @@ func (c *ConfigManager) GetUserConfig(ctx context.Context, deviceID string) (typ
 				Provider string `json:"provider"`
 				JsonData string `json:"json_data"`
 			} `json:"memory"`
-			Prompt  string `json:"prompt"`
-			AgentId string `json:"agent_id"`
+			Prompt      string `json:"prompt"`
+			AgentId     string `json:"agent_id"`
+			Hotwords    string `json:"hotwords"`
+			WakeupWords string `json:"wakeup_words"`
+			// 智能体的音频预处理配置, json 格式
+			AudioPreprocess string `json:"audio_preprocess"`
 		} `json:"data"`
 	}
 
@@ -137,10 +153,13 @@ func (c *ConfigManager) GetUserConfig(ctx context.Context, deviceID string) (typ
 			Provider: response.Data.Memory.Provider,
 			Config:   parseJsonData(response.Data.Memory.JsonData),
 		},
-		AgentId: response.Data.AgentId,
+		AgentId:         response.Data.AgentId,
+		Hotwords:        hotword.Parse(response.Data.Hotwords),
+		WakeupWords:     hotword.ParsePhrases(response.Data.WakeupWords),
+		AudioPreprocess: parseJsonData(response.Data.AudioPreprocess),
 	}
 
-	log.Log().Infof("成功获取设备配置: deviceId: %s, config: %+v", deviceID, config)
+	log.Log().Infof("成功获取配置: %s: %s, config: %+v", key, id, config)
 	return config, nil
 }
 
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -46,3 +48,35 @@ func TestConfigManager_GetSystemConfig(t *testing.T) {
 	fmt.Printf("获取到的系统配置: %s\n", configJSON)
 	t.Logf("配置大小: %d 字节", len(configJSON))
 }
+
+func TestConfigManager_GetAgentConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/configs" || r.URL.Query().Get("agent_id") != "12" || r.URL.Query().Has("device_id") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"prompt":"你是小智","agent_id":"12","llm":{"provider":"openai","json_data":"{\"model\":\"gpt-4o\"}"}}}`)
+	}))
+	defer server.Close()
+
+	manager, err := NewManagerUserConfigProvider(map[string]interface{}{"backend_url": server.URL})
+	if err != nil {
+		t.Fatalf("创建配置管理器失败: %v", err)
+	}
+
+	config, err := manager.GetAgentConfig(context.Background(), "12")
+	if err != nil {
+		t.Fatalf("获取智能体配置失败: %v", err)
+	}
+	if config.AgentId != "12" || config.SystemPrompt != "你是小智" {
+		t.Errorf("智能体配置不正确: %+v", config)
+	}
+	if config.Llm.Provider != "openai" || config.Llm.Config["model"] != "gpt-4o" {
+		t.Errorf("llm 配置不正确: %+v", config.Llm)
+	}
+
+	// 参数经过转义, 不能借 agent_id 改写请求
+	if _, err := manager.GetAgentConfig(context.Background(), "12&device_id=aa"); err == nil {
+		t.Error("未转义的 agent_id 不应请求成功")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	log "xiaozhi-esp32-server-golang/logger"
 
 	i_redis "xiaozhi-esp32-server-golang/internal/db/redis"
+	"xiaozhi-esp32-server-golang/internal/domain/asr/hotword"
 	"xiaozhi-esp32-server-golang/internal/domain/config/types"
 
 	"github.com/redis/go-redis/v9"
@@ -101,6 +102,13 @@ func (u *UserConfig) GetUserConfig(ctx context.Context, userID string) (types.UC
 		}
 	}
 	ret.Vad = u.getVadConfig(ctx)
+	ret.Hotwords = hotword.Parse(redisConfig["hotwords"])
+	ret.WakeupWords = hotword.ParsePhrases(redisConfig["wakeup_words"])
+	if rv := redisConfig["audio_preprocess"]; rv != "" {
+		if err := json.Unmarshal([]byte(rv), &ret.AudioPreprocess); err != nil {
+			log.Log().Errorf("redis audio_preprocess unmarshal error: %+v", err)
+		}
+	}
 
 	log.Log().Infof("userconfig: %+v", ret)
 	return ret, nil
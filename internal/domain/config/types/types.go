@@ -1,5 +1,7 @@
 package types
 
+import asr_types "xiaozhi-esp32-server-golang/internal/domain/asr/types"
+
 type AsrConfig struct {
 	Provider string                 `json:"provider"`
 	Config   map[string]interface{} `json:"config"`
@@ -38,4 +40,10 @@ type UConfig struct {
 	Vad          VadConfig    `json:"vad"`
 	Memory       MemoryConfig `json:"memory"`
 	AgentId      string       `json:"agent_id"` //所属agent_id
+	// 智能体的识别热词, 与 asr 配置中的 hotwords 合并后传给引擎
+	Hotwords []asr_types.Hotword `json:"hotwords"`
+	// 智能体的唤醒词, 与全局 wakeup_words 合并
+	WakeupWords []string `json:"wakeup_words"`
+	// 音频预处理, 按 key 覆盖全局 audio_preprocess 配置, 为空时使用全局配置
+	AudioPreprocess map[string]interface{} `json:"audio_preprocess"`
 }
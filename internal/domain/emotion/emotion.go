@@ -0,0 +1,174 @@
+package emotion
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+// 情绪名称与固件的表情一致
+const (
+	Neutral     = "neutral"
+	Happy       = "happy"
+	Laughing    = "laughing"
+	Funny       = "funny"
+	Sad         = "sad"
+	Angry       = "angry"
+	Crying      = "crying"
+	Loving      = "loving"
+	Embarrassed = "embarrassed"
+	Surprised   = "surprised"
+	Shocked     = "shocked"
+	Thinking    = "thinking"
+	Winking     = "winking"
+	Cool        = "cool"
+	Relaxed     = "relaxed"
+	Delicious   = "delicious"
+	Kissy       = "kissy"
+	Confident   = "confident"
+	Sleepy      = "sleepy"
+	Silly       = "silly"
+	Confused    = "confused"
+)
+
+var emojis = []struct {
+	emoji   string
+	emotion string
+}{
+	{"😶", Neutral},
+	{"🙂", Happy},
+	{"😆", Laughing},
+	{"😂", Funny},
+	{"😔", Sad},
+	{"😠", Angry},
+	{"😭", Crying},
+	{"😍", Loving},
+	{"😳", Embarrassed},
+	{"😲", Surprised},
+	{"😱", Shocked},
+	{"🤔", Thinking},
+	{"😉", Winking},
+	{"😎", Cool},
+	{"😌", Relaxed},
+	{"🤤", Delicious},
+	{"😘", Kissy},
+	{"😏", Confident},
+	{"😴", Sleepy},
+	{"😜", Silly},
+	{"🙄", Confused},
+}
+
+var (
+	emoji2Emotion = make(map[string]string, len(emojis))
+	emotion2Emoji = make(map[string]string, len(emojis))
+)
+
+func init() {
+	for _, e := range emojis {
+		emoji2Emotion[e.emoji] = e.emotion
+		emotion2Emoji[e.emotion] = e.emoji
+	}
+	// 模型常用但固件没有的表情, 归到相近的情绪
+	for emoji, emotion := range map[string]string{
+		"😊": Happy,
+		"😄": Laughing,
+		"😁": Laughing,
+		"🤣": Funny,
+		"😢": Sad,
+		"😡": Angry,
+		"🥰": Loving,
+		"😮": Surprised,
+	} {
+		emoji2Emotion[emoji] = emotion
+	}
+}
+
+const defaultPrompt = "每次回复的第一个字符必须是一个表示你当前情绪的表情, 只能从以下表情中选择: " +
+	"😶🙂😆😂😔😠😭😍😳😲😱🤔😉😎😌🤤😘😏😴😜🙄, 表情之后再开始正文."
+
+// Enabled 是否要求模型输出情绪并下发给设备
+func Enabled() bool {
+	return viper.GetBool("emotion.enable")
+}
+
+// Prompt 追加到 system prompt 中, 要求模型在回复开头输出表情
+func Prompt() string {
+	if prompt := viper.GetString("emotion.prompt"); prompt != "" {
+		return prompt
+	}
+	return defaultPrompt
+}
+
+// Emoji 情绪对应的固件表情, 未知的情绪返回空
+func Emoji(emotion string) string {
+	return emotion2Emoji[emotion]
+}
+
+// Parse 解析句首的情绪, 支持表情以及 [happy]、【happy】 形式的标签.
+// 返回情绪和去掉开头所有表情、标签后的文本, 句首没有可识别的情绪时原样返回文本
+func Parse(text string) (string, string) {
+	var emotion string
+	rest := strings.TrimLeftFunc(text, unicode.IsSpace)
+	for {
+		e, n := parseOne(rest)
+		if n == 0 {
+			break
+		}
+		if emotion == "" {
+			emotion = e
+		}
+		rest = strings.TrimLeftFunc(rest[n:], unicode.IsSpace)
+	}
+	if emotion == "" {
+		return "", text
+	}
+	return emotion, rest
+}
+
+// parseOne 解析开头的一个表情或标签, 返回情绪和占用的字节数
+func parseOne(text string) (string, int) {
+	for _, pair := range [][2]string{{"[", "]"}, {"【", "】"}} {
+		if !strings.HasPrefix(text, pair[0]) {
+			continue
+		}
+		end := strings.Index(text, pair[1])
+		if end < 0 {
+			return "", 0
+		}
+		name := strings.ToLower(strings.TrimSpace(text[len(pair[0]):end]))
+		if _, ok := emotion2Emoji[name]; !ok {
+			return "", 0
+		}
+		return name, end + len(pair[1])
+	}
+
+	r, n := utf8.DecodeRuneInString(text)
+	emotion, ok := emoji2Emotion[string(r)]
+	if !ok {
+		return "", 0
+	}
+	// 跳过表情后的变体选择符
+	if next, size := utf8.DecodeRuneInString(text[n:]); next == '\uFE0F' {
+		n += size
+	}
+	return emotion, n
+}
+
+type ctxKey struct{}
+
+// WithContext 把情绪放入 ctx, 支持情绪的 tts 据此选择说话风格
+func WithContext(ctx context.Context, emotion string) context.Context {
+	if emotion == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, emotion)
+}
+
+// FromContext 取出 WithContext 放入的情绪, 没有时返回空
+func FromContext(ctx context.Context) string {
+	emotion, _ := ctx.Value(ctxKey{}).(string)
+	return emotion
+}
@@ -0,0 +1,50 @@
+package emotion
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		emotion string
+		rest    string
+	}{
+		{"表情", "😆哈哈, 太好笑了!", Laughing, "哈哈, 太好笑了!"},
+		{"前后空白", " 🤔 让我想想。", Thinking, "让我想想。"},
+		{"变体选择符", "😶\uFE0F好的", Neutral, "好的"},
+		{"相近的表情", "😊你好呀", Happy, "你好呀"},
+		{"多个表情取第一个", "😭😔我好难过", Crying, "我好难过"},
+		{"英文标签", "[Sad] 别难过", Sad, "别难过"},
+		{"中文括号标签", "【surprised】真的吗", Surprised, "真的吗"},
+		{"未知标签", "[note] 备注", "", "[note] 备注"},
+		{"未闭合标签", "[happy 你好", "", "[happy 你好"},
+		{"不在句首", "你好😆", "", "你好😆"},
+		{"未知表情", "🐼熊猫来啦", "", "🐼熊猫来啦"},
+		{"只有表情", "😍", Loving, ""},
+		{"空文本", "", "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			emotion, rest := Parse(c.text)
+			assert.Equal(t, c.emotion, emotion)
+			assert.Equal(t, c.rest, rest)
+		})
+	}
+}
+
+func TestEmoji(t *testing.T) {
+	assert.Equal(t, "😆", Emoji(Laughing))
+	assert.Equal(t, "", Emoji("unknown"))
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", FromContext(ctx))
+	assert.Equal(t, ctx, WithContext(ctx, ""))
+	assert.Equal(t, Happy, FromContext(WithContext(ctx, Happy)))
+}
@@ -1,6 +1,9 @@
 package eventbus
 
 const (
+	// 参数为 (*ClientState, schema.Message, 记忆体 id)
 	TopicAddMessage = "add_message"
 	TopicSessionEnd = "session_end"
+	// 对话状态迁移, 参数为 (*ClientState, StateTransition)
+	TopicStateTransition = "state_transition"
 )
@@ -0,0 +1,65 @@
+package language
+
+import (
+	"context"
+	"unicode"
+)
+
+const (
+	Zh = "zh"
+	En = "en"
+	Ja = "ja"
+	Ko = "ko"
+)
+
+// Detect 按文字的书写系统判断语言, 只能区分中、英、日、韩. 中英混排时比较汉字数和英文单词数,
+// 一个汉字大致相当于一个英文单词, 如 "iPhone 15 Pro Max 很好用" 判断为中文.
+// 没有可识别的文字(如只有数字、标点、表情)时返回空
+func Detect(text string) string {
+	var han, kana, hangul, latinWords int
+	inWord := false
+	for _, r := range text {
+		isLatin := r < unicode.MaxASCII && unicode.IsLetter(r)
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case isLatin && !inWord:
+			latinWords++
+		}
+		// 单词中的撇号不拆分单词, 如 What's
+		inWord = isLatin || (inWord && r == '\'')
+	}
+	switch {
+	case kana > 0:
+		// 日文中常夹有汉字, 有假名即认为是日文
+		return Ja
+	case hangul > 0 && hangul >= han:
+		return Ko
+	case han == 0 && latinWords == 0:
+		return ""
+	case han >= latinWords:
+		return Zh
+	default:
+		return En
+	}
+}
+
+type ctxKey struct{}
+
+// WithContext 把用户这句话的语言放入 ctx, 回复中无法判断语言的句子沿用它
+func WithContext(ctx context.Context, lang string) context.Context {
+	if lang == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, lang)
+}
+
+// FromContext 取出 WithContext 放入的语言, 没有时返回空
+func FromContext(ctx context.Context) string {
+	lang, _ := ctx.Value(ctxKey{}).(string)
+	return lang
+}
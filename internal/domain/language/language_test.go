@@ -0,0 +1,38 @@
+package language
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		text string
+		lang string
+	}{
+		{"今天天气怎么样?", Zh},
+		{"What's the weather like today?", En},
+		{"我喜欢 Apple 的手机", Zh},
+		{"I really like 熊猫", En},
+		{"iPhone 15 Pro Max 很好用", Zh},
+		{"帮我打开 Bluetooth 和 Wi-Fi", Zh},
+		{"What's 熊猫 in English?", En},
+		{"こんにちは、元気ですか", Ja},
+		{"안녕하세요", Ko},
+		{"123, 456!", ""},
+		{"😆", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.lang, Detect(c.text), c.text)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", FromContext(ctx))
+	assert.Equal(t, ctx, WithContext(ctx, ""))
+	assert.Equal(t, En, FromContext(WithContext(ctx, En)))
+}
@@ -7,7 +7,9 @@ import (
 	"strings"
 	"time"
 	"unicode"
+	"xiaozhi-esp32-server-golang/internal/domain/language"
 	"xiaozhi-esp32-server-golang/internal/domain/llm/common"
+	"xiaozhi-esp32-server-golang/internal/domain/tracing"
 
 	log "xiaozhi-esp32-server-golang/logger"
 
@@ -41,10 +43,12 @@ func isSentencePausePunctuation(r rune) bool {
 }
 
 // HandleLLMWithContextAndTools 使用上下文控制来处理LLM响应（兼容带工具和不带工具）
+// ctx 中带有链路追踪的 span 时标记首 token 和首句, 响应结束时结束该 span
 func HandleLLMWithContextAndTools(ctx context.Context, llmProvider LLMProvider, dialogue []*schema.Message, tools []*schema.ToolInfo, sessionID string) (chan common.LLMResponseStruct, error) {
 	var (
 		llmResponse interface{}
 	)
+	span := tracing.SpanFromContext(ctx)
 	llmResponse = llmProvider.ResponseWithContext(ctx, sessionID, dialogue, tools)
 
 	sentenceChannel := make(chan common.LLMResponseStruct, 2)
@@ -57,6 +61,7 @@ func HandleLLMWithContextAndTools(ctx context.Context, llmProvider LLMProvider,
 	go func() {
 		defer func() {
 			log.Debugf("full Response with %d tools, fullText: %s", len(tools), fullText)
+			span.End(ctx.Err())
 			close(sentenceChannel)
 		}()
 		msgChan, ok := llmResponse.(chan *schema.Message)
@@ -101,19 +106,27 @@ func HandleLLMWithContextAndTools(ctx context.Context, llmProvider LLMProvider,
 				if message == nil {
 					break
 				}
+				span.Mark(tracing.MarkLlmFirstToken)
 				byteMessage, _ := json.Marshal(message)
 				log.Infof("收到message: %s", string(byteMessage))
 				if message.Content != "" {
 					fullText += message.Content
 					buffer.WriteString(message.Content)
-					if containsSentenceSeparator(message.Content, isFirst) {
-						sentences, remaining := extractSmartSentences(buffer.String(), 2, 100, isFirst)
+					// 按回复的语言断句, 还无法判断时沿用用户这句话的语言
+					lang := language.Detect(fullText)
+					if lang == "" {
+						lang = language.FromContext(ctx)
+					}
+					// 英文要看到标点后的内容才能断句, 标点之后到达的文本也要触发
+					if containsSentenceSeparator(message.Content, isFirst) || (lang == language.En && containsSentenceSeparator(buffer.String(), isFirst)) {
+						sentences, remaining := extractSmartSentences(buffer.String(), 2, 100, isFirst, lang)
 						if len(sentences) > 0 {
 							for _, sentence := range sentences {
 								if sentence != "" {
 									if !firstFrame {
 										firstFrame = true
 										log.Infof("耗时统计: llm工具首句: %d ms", time.Now().UnixMilli()-startTs)
+										span.Mark(tracing.MarkLlmFirstSentence)
 									}
 									log.Infof("处理完整句子: %s", sentence)
 									select {
@@ -4,6 +4,9 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"unicode"
+
+	"xiaozhi-esp32-server-golang/internal/domain/language"
 )
 
 var (
@@ -54,8 +57,43 @@ var (
 
 	// 预编译正则表达式
 	numberPrefixRegex = regexp.MustCompile(`(?m)^[\s]*\d{1,3}\.$`)
+
+	// 英文中以点号结尾但不是句子结束的缩写, 小写
+	englishAbbreviations = map[string]bool{
+		"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "jr": true, "sr": true,
+		"vs": true, "etc": true, "e.g": true, "i.e": true, "no": true, "approx": true,
+	}
 )
 
+// isEnglishSplitPoint 英文的半角标点后面必须跟空白才算断句, 避免把小数、网址、缩写拆开.
+// 标点在末尾时还不知道后面的内容, 等下一段文本到达再判断
+func isEnglishSplitPoint(text []rune, pos int) bool {
+	if text[pos] > unicode.MaxASCII || unicode.IsSpace(text[pos]) {
+		return true
+	}
+	next := pos + 1
+	// 跳过标点后的右引号、右括号
+	for next < len(text) && (text[next] == '"' || text[next] == '\'' || text[next] == ')') {
+		next++
+	}
+	if next >= len(text) || !unicode.IsSpace(text[next]) {
+		return false
+	}
+	if text[pos] != '.' {
+		return true
+	}
+	start := pos
+	for start > 0 && (unicode.IsLetter(text[start-1]) || text[start-1] == '.') {
+		start--
+	}
+	word := string(text[start:pos])
+	// 单个大写字母是姓名缩写, 如 J. K. Rowling
+	if len([]rune(word)) == 1 && unicode.IsUpper(text[start]) {
+		return false
+	}
+	return !englishAbbreviations[strings.ToLower(word)]
+}
+
 // 使用快速的字符检查替代正则
 func isNumberPrefix(text []rune, pos int) bool {
 	if pos <= 0 || text[pos] != '.' {
@@ -124,7 +162,14 @@ func findLastPunctuation(text []rune, separatorMap map[rune]bool) int {
 	return lastPos
 }
 
-func findNextSplitPoint(text []rune, startPos int, maxLen int, separatorMap map[rune]bool) int {
+func findNextSplitPoint(text []rune, startPos int, maxLen int, separatorMap map[rune]bool, lang string) int {
+	isSplitPoint := func(pos int) bool {
+		if !separatorMap[text[pos]] {
+			return false
+		}
+		return lang != language.En || isEnglishSplitPoint(text, pos)
+	}
+
 	// 计算查找的结束位置
 	endPos := startPos + maxLen
 	if endPos > len(text) {
@@ -148,7 +193,7 @@ func findNextSplitPoint(text []rune, startPos int, maxLen int, separatorMap map[
 		}
 
 		// 使用map检查是否是标点符号
-		if separatorMap[text[i]] {
+		if isSplitPoint(i) {
 			return i
 		}
 	}
@@ -156,7 +201,7 @@ func findNextSplitPoint(text []rune, startPos int, maxLen int, separatorMap map[
 	// 如果在maxLen范围内没找到，尝试在更大范围内查找
 	if endPos < len(text) {
 		for i := endPos; i < len(text); i++ {
-			if text[i] == '\n' || separatorMap[text[i]] {
+			if text[i] == '\n' || isSplitPoint(i) {
 				return i
 			}
 		}
@@ -165,7 +210,8 @@ func findNextSplitPoint(text []rune, startPos int, maxLen int, separatorMap map[
 	return -1
 }
 
-func extractSmartSentences(text string, minLen, maxLen int, isFirst bool) (sentences []string, remaining string) {
+// extractSmartSentences lang 为回复的语言, 英文按 isEnglishSplitPoint 的规则断句, 其他语言遇到标点即断句
+func extractSmartSentences(text string, minLen, maxLen int, isFirst bool, lang string) (sentences []string, remaining string) {
 	//当isFirst为true时, 放宽到逗号作为分隔符
 	separatorMap := punctuationMap
 	if isFirst {
@@ -203,7 +249,7 @@ func extractSmartSentences(text string, minLen, maxLen int, isFirst bool) (sente
 		}
 
 		// 查找下一个分割点
-		splitPos := findNextSplitPoint(currentRunes, startPos, maxLen, separatorMap)
+		splitPos := findNextSplitPoint(currentRunes, startPos, maxLen, separatorMap, lang)
 		if splitPos == -1 {
 			// 没有找到分割点，将剩余文本作为remaining
 			segment := trimSpaceRunes(currentRunes[startPos:])
@@ -0,0 +1,50 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"xiaozhi-esp32-server-golang/internal/domain/language"
+)
+
+func TestExtractSmartSentences_Chinese(t *testing.T) {
+	sentences, remaining := extractSmartSentences("你好，我是阿嘟。今天天气不错！我们去", 2, 100, false, language.Zh)
+	assert.Equal(t, []string{"你好，我是阿嘟。", "今天天气不错！"}, sentences)
+	assert.Equal(t, "我们去", remaining)
+
+	// 首句放宽到逗号
+	sentences, remaining = extractSmartSentences("你好，我是阿嘟", 2, 100, true, language.Zh)
+	assert.Equal(t, []string{"你好，"}, sentences)
+	assert.Equal(t, "我是阿嘟", remaining)
+}
+
+func TestExtractSmartSentences_English(t *testing.T) {
+	cases := []struct {
+		name      string
+		text      string
+		isFirst   bool
+		sentences []string
+		remaining string
+	}{
+		{"普通句子", "Hello there. How are you? I'm fine", false, []string{"Hello there.", "How are you?"}, "I'm fine"},
+		{"小数", "It costs 3.5 dollars. Cheap", false, []string{"It costs 3.5 dollars."}, "Cheap"},
+		{"缩写", "Mr. Smith and Dr. Lee met yesterday, etc. and more. Then", false, []string{"Mr. Smith and Dr. Lee met yesterday, etc. and more."}, "Then"},
+		{"姓名缩写", "J. K. Rowling wrote it. Yes", false, []string{"J. K. Rowling wrote it."}, "Yes"},
+		{"网址", "Visit example.com for details. OK", false, []string{"Visit example.com for details."}, "OK"},
+		{"标点在末尾时等待后续文本", "The answer is 3.", false, nil, "The answer is 3."},
+		{"首句放宽到逗号", "Sure, I can help", true, []string{"Sure,"}, "I can help"},
+		{"全角标点直接断句", "Hello。World", false, []string{"Hello。"}, "World"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sentences, remaining := extractSmartSentences(c.text, 2, 100, c.isFirst, language.En)
+			if c.sentences == nil {
+				assert.Empty(t, sentences)
+			} else {
+				assert.Equal(t, c.sentences, sentences)
+			}
+			assert.Equal(t, c.remaining, remaining)
+		})
+	}
+}
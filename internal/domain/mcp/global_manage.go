@@ -371,6 +371,20 @@ func (g *GlobalMCPManager) updateGlobalTools(serverName string, tools map[string
 	}
 }
 
+// GetServerStatus 各服务器当前是否已连接
+func (g *GlobalMCPManager) GetServerStatus() map[string]bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	result := make(map[string]bool, len(g.servers))
+	for name, conn := range g.servers {
+		conn.mu.RLock()
+		result[name] = conn.connected
+		conn.mu.RUnlock()
+	}
+	return result
+}
+
 // GetAllTools 获取所有可用工具
 func (g *GlobalMCPManager) GetAllTools() map[string]tool.InvokableTool {
 	g.mu.RLock()
@@ -76,6 +76,24 @@ func (p *McpClientPool) GetWsEndpointMcpTools(agentId string) (map[string]tool.I
 	return retTools, nil
 }
 
+// GetDeviceConnectionCount 设备侧已连接的 MCP 数, 分为 websocket 接入点和 iot over mcp 两类
+func GetDeviceConnectionCount() (wsEndpoint int, iotOverMcp int) {
+	for _, client := range mcpClientPool.device2McpClient.Items() {
+		client.wsEndPointMcp.Range(func(_, value interface{}) bool {
+			if value.(*McpClientInstance).IsConnected() {
+				wsEndpoint++
+			}
+			return true
+		})
+		client.iotMux.RLock()
+		if client.iotOverMcp != nil && client.iotOverMcp.IsConnected() {
+			iotOverMcp++
+		}
+		client.iotMux.RUnlock()
+	}
+	return wsEndpoint, iotOverMcp
+}
+
 func (p *McpClientPool) checkOffline() {
 	for _, client := range p.device2McpClient.Items() {
 		// 检查WebSocket端点MCP连接
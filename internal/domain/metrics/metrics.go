@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+)
+
+const namespace = "xiaozhi"
+
+// 提供者类型, 用作 provider_* 指标的 type 标签
+const (
+	ProviderAsr = "asr"
+	ProviderLlm = "llm"
+	ProviderTts = "tts"
+)
+
+// StageLabels 阶段耗时直方图的标签, 除 stage 外与链路追踪直方图的属性一致
+var StageLabels = []string{"stage", "agent_id", "asr", "llm", "tts"}
+
+var (
+	providerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_requests_total",
+		Help:      "asr/llm/tts 请求数",
+	}, []string{"type", "provider"})
+
+	providerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_errors_total",
+		Help:      "asr/llm/tts 请求失败数",
+	}, []string{"type", "provider"})
+
+	asrFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "asr_failover_total",
+		Help:      "asr 故障转移次数, to 为空表示已没有可切换的引擎",
+	}, []string{"from", "to", "reason"})
+
+	llmSpeculations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_speculation_total",
+		Help:      "按识别中间结果提前发起的 llm 请求数, result 为 hit(最终结果一致, 直接使用)或 miss",
+	}, []string{"result"})
+
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "turn_stage_duration_seconds",
+		Help:      "每轮对话各阶段的耗时",
+		Buckets:   []float64{0.05, 0.1, 0.2, 0.3, 0.5, 0.8, 1, 1.5, 2, 3, 5, 8, 13, 20},
+	}, StageLabels)
+
+	udpPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "udp_packets_total",
+		Help:      "mqtt-udp 音频包数, direction 为 in/out",
+	}, []string{"direction"})
+
+	udpDecryptErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "udp_decrypt_errors_total",
+		Help:      "mqtt-udp 解密失败的包数, reason 为 replay(重放)或 invalid",
+	}, []string{"reason"})
+)
+
+// Enabled 是否对外提供 /metrics, 默认关闭, 关闭时不再记录阶段耗时
+func Enabled() bool {
+	return viper.GetBool("metrics.enable")
+}
+
+// Handler 以 prometheus 文本格式输出全部指标
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ProviderRequest 记录一次提供者请求, err 不为 nil 时同时计入失败数
+func ProviderRequest(providerType, provider string, err error) {
+	providerRequests.WithLabelValues(providerType, provider).Inc()
+	if err != nil {
+		ProviderError(providerType, provider)
+	}
+}
+
+// ProviderError 记录请求发出后才出现的失败, 如流式识别中途返回的错误
+func ProviderError(providerType, provider string) {
+	providerErrors.WithLabelValues(providerType, provider).Inc()
+}
+
+// AsrFailover 记录一次 asr 引擎切换
+func AsrFailover(from, to, reason string) {
+	asrFailovers.WithLabelValues(from, to, reason).Inc()
+}
+
+// LlmSpeculation 记录一次投机请求的结果
+func LlmSpeculation(hit bool) {
+	if hit {
+		llmSpeculations.WithLabelValues("hit").Inc()
+		return
+	}
+	llmSpeculations.WithLabelValues("miss").Inc()
+}
+
+// ObserveStage 记录一个阶段的耗时, labels 的 key 见 StageLabels, 缺少的标签记为空
+func ObserveStage(labels prometheus.Labels, seconds float64) {
+	values := make([]string, len(StageLabels))
+	for i, name := range StageLabels {
+		values[i] = labels[name]
+	}
+	stageDuration.WithLabelValues(values...).Observe(seconds)
+}
+
+func UdpPacketIn() {
+	udpPackets.WithLabelValues("in").Inc()
+}
+
+func UdpPacketOut() {
+	udpPackets.WithLabelValues("out").Inc()
+}
+
+func UdpDecryptError(replay bool) {
+	if replay {
+		udpDecryptErrors.WithLabelValues("replay").Inc()
+		return
+	}
+	udpDecryptErrors.WithLabelValues("invalid").Inc()
+}
+
+// Sample gauge 的一个取值, Labels 与注册时的标签名一一对应
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// gaugeFunc 采集时才读取数值的 gauge, 用于会话数、资源池这类本身已有状态的统计, 避免重复计数
+type gaugeFunc struct {
+	desc    *prometheus.Desc
+	collect func() []Sample
+}
+
+func (g *gaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *gaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	for _, sample := range g.collect() {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, sample.Value, sample.Labels...)
+	}
+}
+
+// RegisterGaugeFunc 注册一个采集时调用 collect 取值的 gauge
+func RegisterGaugeFunc(name, help string, labels []string, collect func() []Sample) error {
+	return prometheus.Register(&gaugeFunc{
+		desc:    prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil),
+		collect: collect,
+	})
+}
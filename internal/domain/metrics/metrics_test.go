@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderRequest(t *testing.T) {
+	ProviderRequest(ProviderTts, "edge", nil)
+	ProviderRequest(ProviderTts, "edge", errors.New("timeout"))
+	ProviderError(ProviderTts, "edge")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(providerRequests.WithLabelValues(ProviderTts, "edge")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(providerErrors.WithLabelValues(ProviderTts, "edge")))
+}
+
+func TestObserveStage(t *testing.T) {
+	// 缺少的标签记为空
+	ObserveStage(prometheus.Labels{"stage": "llm", "llm": "openai"}, 0.3)
+	ObserveStage(prometheus.Labels{"stage": "llm", "llm": "openai"}, 1.2)
+
+	var m dto.Metric
+	observer, err := stageDuration.GetMetricWithLabelValues("llm", "", "", "openai", "")
+	require.NoError(t, err)
+	require.NoError(t, observer.(prometheus.Metric).Write(&m))
+	assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+	assert.InDelta(t, 1.5, m.GetHistogram().GetSampleSum(), 1e-9)
+}
+
+func TestUdpDecryptError(t *testing.T) {
+	UdpDecryptError(true)
+	UdpDecryptError(false)
+	UdpDecryptError(false)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(udpDecryptErrors.WithLabelValues("replay")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(udpDecryptErrors.WithLabelValues("invalid")))
+}
+
+func TestRegisterGaugeFunc(t *testing.T) {
+	sessions := map[string]int{"websocket": 2, "udp": 1}
+	require.NoError(t, RegisterGaugeFunc("test_sessions", "测试会话数", []string{"transport"}, func() []Sample {
+		var samples []Sample
+		for transport, count := range sessions {
+			samples = append(samples, Sample{Labels: []string{transport}, Value: float64(count)})
+		}
+		return samples
+	}))
+
+	expected := `
+# HELP xiaozhi_test_sessions 测试会话数
+# TYPE xiaozhi_test_sessions gauge
+xiaozhi_test_sessions{transport="udp"} 1
+xiaozhi_test_sessions{transport="websocket"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "xiaozhi_test_sessions"))
+
+	// 采集时读取当前值
+	sessions["udp"] = 0
+	expected = strings.Replace(expected, `transport="udp"} 1`, `transport="udp"} 0`, 1)
+	assert.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "xiaozhi_test_sessions"))
+
+	// 同名重复注册返回错误
+	assert.Error(t, RegisterGaugeFunc("test_sessions", "测试会话数", []string{"transport"}, func() []Sample { return nil }))
+}
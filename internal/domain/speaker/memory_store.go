@@ -0,0 +1,56 @@
+package speaker
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemoryStore 进程内存储, 重启后丢失, 适合单节点部署
+type MemoryStore struct {
+	speakers map[string]map[string]*Speaker
+	mu       sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{speakers: make(map[string]map[string]*Speaker)}
+}
+
+func (m *MemoryStore) List(ctx context.Context, owner string) ([]*Speaker, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*Speaker, 0, len(m.speakers[owner]))
+	for _, s := range m.speakers[owner] {
+		copied := *s
+		list = append(list, &copied)
+	}
+	sortByName(list)
+	return list, nil
+}
+
+func (m *MemoryStore) Save(ctx context.Context, owner string, s *Speaker) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.speakers[owner] == nil {
+		m.speakers[owner] = make(map[string]*Speaker)
+	}
+	copied := *s
+	m.speakers[owner][s.Name] = &copied
+	return nil
+}
+
+func (m *MemoryStore) Remove(ctx context.Context, owner string, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.speakers[owner][name]; !ok {
+		return false, nil
+	}
+	delete(m.speakers[owner], name)
+	return true, nil
+}
+
+func sortByName(list []*Speaker) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+}
@@ -0,0 +1,65 @@
+package speaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	redisdb "xiaozhi-esp32-server-golang/internal/db/redis"
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+// RedisStore 基于 redis 的存储, 多节点部署时共用
+//
+//	speaker:{owner} hash, field 为说话人名字, value 为声纹
+type RedisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func NewRedisStore(client *redis.Client, keyPrefix string) (*RedisStore, error) {
+	if client == nil {
+		return nil, fmt.Errorf("redis 未初始化")
+	}
+	return &RedisStore{client: client, keyPrefix: keyPrefix}, nil
+}
+
+func (r *RedisStore) List(ctx context.Context, owner string) ([]*Speaker, error) {
+	values, err := r.client.HGetAll(ctx, r.ownerKey(owner)).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Speaker, 0, len(values))
+	for name, data := range values {
+		var s Speaker
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			log.Warnf("解析说话人 %s 的声纹失败: %v", name, err)
+			continue
+		}
+		list = append(list, &s)
+	}
+	sortByName(list)
+	return list, nil
+}
+
+func (r *RedisStore) Save(ctx context.Context, owner string, s *Speaker) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, r.ownerKey(owner), s.Name, data).Err()
+}
+
+func (r *RedisStore) Remove(ctx context.Context, owner string, name string) (bool, error) {
+	removed, err := r.client.HDel(ctx, r.ownerKey(owner), name).Result()
+	if err != nil {
+		return false, err
+	}
+	return removed > 0, nil
+}
+
+func (r *RedisStore) ownerKey(owner string) string {
+	return redisdb.GetKeyWithPrefix(r.keyPrefix, "speaker:"+owner)
+}
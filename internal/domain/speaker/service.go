@@ -0,0 +1,140 @@
+package speaker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const (
+	DefaultThreshold   = 0.6
+	DefaultMinDuration = time.Second
+	DefaultMaxSpeakers = 10
+)
+
+// Service 声纹登记与识别
+type Service struct {
+	store    Store
+	embedder Embedder
+
+	// 余弦相似度不低于该值才认为是同一个人
+	threshold float32
+	// 短于该时长的语音不用于识别和登记
+	minDuration time.Duration
+	maxSpeakers int
+}
+
+type ServiceOption func(*Service)
+
+func WithThreshold(threshold float32) ServiceOption {
+	return func(s *Service) {
+		if threshold > 0 {
+			s.threshold = threshold
+		}
+	}
+}
+
+func WithMinDuration(duration time.Duration) ServiceOption {
+	return func(s *Service) {
+		if duration > 0 {
+			s.minDuration = duration
+		}
+	}
+}
+
+// WithMaxSpeakers 每个智能体最多登记的说话人数, <=0 表示不限制
+func WithMaxSpeakers(max int) ServiceOption {
+	return func(s *Service) {
+		s.maxSpeakers = max
+	}
+}
+
+func NewService(store Store, embedder Embedder, opts ...ServiceOption) *Service {
+	s := &Service{
+		store:       store,
+		embedder:    embedder,
+		threshold:   DefaultThreshold,
+		minDuration: DefaultMinDuration,
+		maxSpeakers: DefaultMaxSpeakers,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Embed 提取一句话的声纹, 语音短于最小时长时返回 ErrSpeechTooShort
+func (s *Service) Embed(samples []float32, sampleRate int) ([]float32, error) {
+	if sampleRate <= 0 || time.Duration(len(samples))*time.Second/time.Duration(sampleRate) < s.minDuration {
+		return nil, ErrSpeechTooShort
+	}
+	return s.embedder.Embed(samples, sampleRate)
+}
+
+// Identify 在 owner 登记过的说话人中查找声纹最接近的一位, 没有足够相似的返回空字符串
+func (s *Service) Identify(ctx context.Context, owner string, embedding []float32) (string, error) {
+	speakers, err := s.store.List(ctx, owner)
+	if err != nil || len(speakers) == 0 {
+		return "", err
+	}
+	best, score := identify(speakers, embedding, s.threshold)
+	if best == nil {
+		log.Debugf("%s 未识别出说话人, 最高相似度 %.3f", owner, score)
+		return "", nil
+	}
+	log.Debugf("%s 识别出说话人 %s, 相似度 %.3f", owner, best.Name, score)
+	return best.Name, nil
+}
+
+// Enroll 用一句话的声纹登记说话人, 已登记过时并入原有声纹, 多登记几句识别更准
+func (s *Service) Enroll(ctx context.Context, owner string, name string, embedding []float32) (*Speaker, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("说话人名字不能为空")
+	}
+	speakers, err := s.store.List(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	var target *Speaker
+	for _, sp := range speakers {
+		if sp.Name == name {
+			target = sp
+			break
+		}
+	}
+	if target == nil {
+		if s.maxSpeakers > 0 && len(speakers) >= s.maxSpeakers {
+			return nil, ErrTooManySpeakers
+		}
+		target = &Speaker{Name: name}
+	}
+	if err := target.merge(embedding); err != nil {
+		return nil, err
+	}
+	target.UpdatedAt = time.Now()
+	if err := s.store.Save(ctx, owner, target); err != nil {
+		return nil, err
+	}
+	log.Infof("%s 登记说话人 %s 的声纹, 已登记 %d 句", owner, name, target.Samples)
+	return target, nil
+}
+
+func (s *Service) List(ctx context.Context, owner string) ([]*Speaker, error) {
+	return s.store.List(ctx, owner)
+}
+
+// Remove 删除说话人的声纹, 不存在时返回 ErrSpeakerNotFound
+func (s *Service) Remove(ctx context.Context, owner string, name string) error {
+	ok, err := s.store.Remove(ctx, owner, strings.TrimSpace(name))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSpeakerNotFound
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build sherpa
+
+package speaker
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	sherpa_onnx "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+// SherpaEmbedder 基于 sherpa-onnx 的本地声纹模型(3D-Speaker / WeSpeaker 等), 纯 CPU 推理
+// 模型下载: https://github.com/k2-fsa/sherpa-onnx/releases/tag/speaker-recongition-models
+type SherpaEmbedder struct {
+	extractor *sherpa_onnx.SpeakerEmbeddingExtractor
+	// 各会话共用一个模型, 推理串行进行
+	mu sync.Mutex
+}
+
+// NewSherpaEmbedder 从磁盘加载声纹模型
+func NewSherpaEmbedder(model string, numThreads int, provider string) (*SherpaEmbedder, error) {
+	if model == "" {
+		return nil, fmt.Errorf("声纹模型未配置")
+	}
+	if _, err := os.Stat(model); err != nil {
+		return nil, fmt.Errorf("声纹模型文件不可用: %v", err)
+	}
+	if numThreads <= 0 {
+		numThreads = 1
+	}
+	if provider == "" {
+		provider = "cpu"
+	}
+	extractor := sherpa_onnx.NewSpeakerEmbeddingExtractor(&sherpa_onnx.SpeakerEmbeddingExtractorConfig{
+		Model:      model,
+		NumThreads: numThreads,
+		Provider:   provider,
+	})
+	if extractor == nil {
+		return nil, fmt.Errorf("加载声纹模型失败: %s", model)
+	}
+	return &SherpaEmbedder{extractor: extractor}, nil
+}
+
+func (e *SherpaEmbedder) Embed(samples []float32, sampleRate int) ([]float32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stream := e.extractor.CreateStream()
+	defer sherpa_onnx.DeleteOnlineStream(stream)
+	stream.AcceptWaveform(sampleRate, samples)
+	stream.InputFinished()
+	if !e.extractor.IsReady(stream) {
+		return nil, ErrSpeechTooShort
+	}
+	return e.extractor.Compute(stream), nil
+}
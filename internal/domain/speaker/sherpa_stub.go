@@ -0,0 +1,19 @@
+//go:build !sherpa
+
+package speaker
+
+import "errors"
+
+// ErrNotCompiled sherpa-onnx 依赖 cgo 动态库, 默认不编译进程序, 需要使用 -tags sherpa 构建
+var ErrNotCompiled = errors.New("声纹识别未编译进当前程序, 请使用 -tags sherpa 重新构建或关闭 speaker.enable")
+
+// SherpaEmbedder 未使用 sherpa 标签构建时的占位实现, 创建时即返回 ErrNotCompiled
+type SherpaEmbedder struct{}
+
+func NewSherpaEmbedder(model string, numThreads int, provider string) (*SherpaEmbedder, error) {
+	return nil, ErrNotCompiled
+}
+
+func (e *SherpaEmbedder) Embed(samples []float32, sampleRate int) ([]float32, error) {
+	return nil, ErrNotCompiled
+}
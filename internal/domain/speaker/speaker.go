@@ -0,0 +1,142 @@
+package speaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSpeakerNotFound = errors.New("speaker not found")
+	ErrTooManySpeakers = errors.New("too many speakers")
+	// 语音太短时声纹不稳定, 不用于识别和登记
+	ErrSpeechTooShort = errors.New("speech too short")
+)
+
+// Speaker 登记过声纹的一位说话人
+type Speaker struct {
+	Name string `json:"name"`
+	// 多次登记的声纹取平均后归一化
+	Embedding []float32 `json:"embedding"`
+	// 已登记的语句数
+	Samples   int       `json:"samples"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store 声纹的持久化存储, owner 为声纹所属的智能体或设备
+type Store interface {
+	// List owner 下的全部说话人, 按名字排序
+	List(ctx context.Context, owner string) ([]*Speaker, error)
+	// Save 新增或覆盖一位说话人
+	Save(ctx context.Context, owner string, s *Speaker) error
+	// Remove 删除一位说话人, 不存在时返回 false
+	Remove(ctx context.Context, owner string, name string) (bool, error)
+}
+
+// Embedder 从一段语音中提取声纹向量
+type Embedder interface {
+	Embed(samples []float32, sampleRate int) ([]float32, error)
+}
+
+// merge 把新登记的声纹并入已有声纹, 按语句数加权平均
+func (s *Speaker) merge(embedding []float32) error {
+	if s.Samples > 0 && len(s.Embedding) != len(embedding) {
+		return fmt.Errorf("声纹维度不一致: %d != %d", len(s.Embedding), len(embedding))
+	}
+	embedding = normalize(embedding)
+	if s.Samples == 0 {
+		s.Embedding = embedding
+	} else {
+		merged := make([]float32, len(embedding))
+		weight := float32(s.Samples)
+		for i := range merged {
+			merged[i] = (s.Embedding[i]*weight + embedding[i]) / (weight + 1)
+		}
+		s.Embedding = normalize(merged)
+	}
+	s.Samples++
+	return nil
+}
+
+// identify 返回与声纹最相似且相似度不低于 threshold 的说话人, 没有时返回 nil
+func identify(speakers []*Speaker, embedding []float32, threshold float32) (*Speaker, float32) {
+	var best *Speaker
+	var bestScore float32 = -1
+	for _, s := range speakers {
+		score := cosine(s.Embedding, embedding)
+		if score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+	if best == nil || bestScore < threshold {
+		return nil, bestScore
+	}
+	return best, bestScore
+}
+
+func cosine(a, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return -1
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return -1
+	}
+	return float32(dot / math.Sqrt(normA*normB))
+}
+
+func normalize(v []float32) []float32 {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if norm == 0 {
+		return out
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i, x := range v {
+		out[i] = x * scale
+	}
+	return out
+}
+
+type contextKey struct{}
+
+// WithContext 把本轮识别出的说话人放入 ctx, 后续的 llm 请求和记忆读写据此区分说话人
+func WithContext(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// FromContext 取出 WithContext 放入的说话人, 没有时返回空字符串
+func FromContext(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+// Annotate 在用户消息前标注说话人
+func Annotate(name string, text string) string {
+	if name == "" {
+		return text
+	}
+	return fmt.Sprintf("[%s] %s", name, text)
+}
+
+// MemoryKey 说话人在记忆体中的 id, 未识别出说话人时沿用 owner 本身
+func MemoryKey(owner string, name string) string {
+	if name == "" {
+		return owner
+	}
+	return owner + ":" + strings.ReplaceAll(name, ":", "_")
+}
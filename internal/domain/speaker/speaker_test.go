@@ -0,0 +1,111 @@
+package speaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEmbedder 按语音的第一个采样值返回预设的声纹
+type fakeEmbedder map[float32][]float32
+
+func (f fakeEmbedder) Embed(samples []float32, sampleRate int) ([]float32, error) {
+	return f[samples[0]], nil
+}
+
+func speech(id float32, seconds float64) []float32 {
+	samples := make([]float32, int(seconds*16000))
+	samples[0] = id
+	return samples
+}
+
+func newTestService(opts ...ServiceOption) *Service {
+	embedder := fakeEmbedder{
+		1: {1, 0, 0},
+		2: {0.9, 0.1, 0},
+		3: {0, 1, 0},
+		4: {0.1, 0.95, 0.1},
+		5: {0, 0, 1},
+	}
+	return NewService(NewMemoryStore(), embedder, opts...)
+}
+
+func enroll(t *testing.T, s *Service, owner string, name string, id float32) *Speaker {
+	embedding, err := s.Embed(speech(id, 2), 16000)
+	require.NoError(t, err)
+	sp, err := s.Enroll(context.Background(), owner, name, embedding)
+	require.NoError(t, err)
+	return sp
+}
+
+func TestService_Identify(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+
+	enroll(t, s, "agent1", "爸爸", 1)
+	enroll(t, s, "agent1", "Mia", 3)
+
+	for id, want := range map[float32]string{2: "爸爸", 4: "Mia", 5: ""} {
+		embedding, err := s.Embed(speech(id, 2), 16000)
+		require.NoError(t, err)
+		name, err := s.Identify(ctx, "agent1", embedding)
+		require.NoError(t, err)
+		assert.Equal(t, want, name, "speech %v", id)
+	}
+
+	// 不同智能体的声纹互不可见
+	embedding, _ := s.Embed(speech(1, 2), 16000)
+	name, err := s.Identify(ctx, "agent2", embedding)
+	require.NoError(t, err)
+	assert.Equal(t, "", name)
+}
+
+func TestService_EnrollMerge(t *testing.T) {
+	s := newTestService()
+
+	enroll(t, s, "agent1", "爸爸", 1)
+	sp := enroll(t, s, "agent1", " 爸爸 ", 2)
+	assert.Equal(t, "爸爸", sp.Name)
+	assert.Equal(t, 2, sp.Samples)
+	assert.InDelta(t, 1, float64(cosine(sp.Embedding, sp.Embedding)), 1e-6)
+	// 合并后的声纹介于两次登记之间
+	assert.Greater(t, cosine(sp.Embedding, []float32{1, 0, 0}), cosine([]float32{0.9, 0.1, 0}, []float32{1, 0, 0}))
+
+	list, err := s.List(context.Background(), "agent1")
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+func TestService_Limits(t *testing.T) {
+	s := newTestService(WithMaxSpeakers(1), WithMinDuration(1500*time.Millisecond))
+	ctx := context.Background()
+
+	_, err := s.Embed(speech(1, 1), 16000)
+	assert.ErrorIs(t, err, ErrSpeechTooShort)
+
+	enroll(t, s, "agent1", "爸爸", 1)
+	_, err = s.Enroll(ctx, "agent1", "Mia", []float32{0, 1, 0})
+	assert.ErrorIs(t, err, ErrTooManySpeakers)
+	_, err = s.Enroll(ctx, "agent1", "", []float32{0, 1, 0})
+	assert.Error(t, err)
+
+	assert.ErrorIs(t, s.Remove(ctx, "agent1", "Mia"), ErrSpeakerNotFound)
+	assert.NoError(t, s.Remove(ctx, "agent1", "爸爸"))
+	list, _ := s.List(ctx, "agent1")
+	assert.Empty(t, list)
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", FromContext(ctx))
+	assert.Equal(t, "Mia", FromContext(WithContext(ctx, "Mia")))
+
+	assert.Equal(t, "[Mia] 我喜欢恐龙", Annotate("Mia", "我喜欢恐龙"))
+	assert.Equal(t, "我喜欢恐龙", Annotate("", "我喜欢恐龙"))
+
+	assert.Equal(t, "agent1", MemoryKey("agent1", ""))
+	assert.Equal(t, "agent1:爸爸", MemoryKey("agent1", "爸爸"))
+}
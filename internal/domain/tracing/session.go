@@ -0,0 +1,341 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"xiaozhi-esp32-server-golang/internal/domain/metrics"
+)
+
+// Session 一个会话的链路追踪. 每轮对话一个根 span, 从开始拾音到下一次开始拾音或会话结束,
+// vad、asr 以及通过 StartSpan 创建的 llm/工具调用/tts 都是它的子 span.
+// 只开启了 prometheus 指标时 span 不上报, 仍记录各阶段耗时.
+// 两者都未开启时为 nil, 所有方法都可以在 nil 上调用
+type Session struct {
+	tracer trace.Tracer
+	// span 上的属性
+	spanAttrs []attribute.KeyValue
+	// 直方图上的属性, 不含设备 id 这类基数很大的属性
+	metricAttrs []attribute.KeyValue
+	// prometheus 直方图的标签, 为 nil 时不记录
+	promLabels map[string]string
+
+	turn *turn
+	mu   sync.Mutex
+}
+
+type turn struct {
+	ctx   context.Context
+	span  trace.Span
+	start time.Time
+
+	speech      trace.Span
+	speechStart time.Time
+	speechEnd   time.Time
+
+	asr        trace.Span
+	asrStart   time.Time
+	asrPartial bool
+
+	audioSent bool
+}
+
+// NewSession attrs 同时用于 span 和直方图, 应是智能体、提供者这类取值有限的属性
+func NewSession(deviceID string, attrs ...attribute.KeyValue) *Session {
+	if !Enabled() && !metrics.Enabled() {
+		return nil
+	}
+	s := &Session{
+		tracer:      tracer,
+		spanAttrs:   append([]attribute.KeyValue{attribute.String("device_id", deviceID)}, attrs...),
+		metricAttrs: attrs,
+	}
+	if s.tracer == nil {
+		s.tracer = noop.NewTracerProvider().Tracer(instrumentationName)
+	}
+	if metrics.Enabled() {
+		s.promLabels = make(map[string]string, len(attrs))
+		for _, attr := range attrs {
+			s.promLabels[string(attr.Key)] = attr.Value.Emit()
+		}
+	}
+	return s
+}
+
+func (s *Session) record(stage string, from time.Time) {
+	if from.IsZero() {
+		return
+	}
+	elapsed := time.Since(from)
+	if durations != nil {
+		attrs := append([]attribute.KeyValue{attribute.String("stage", stage)}, s.metricAttrs...)
+		durations.Record(context.Background(), float64(elapsed.Microseconds())/1000, metric.WithAttributes(attrs...))
+	}
+	if s.promLabels != nil {
+		labels := map[string]string{"stage": stage}
+		for k, v := range s.promLabels {
+			labels[k] = v
+		}
+		metrics.ObserveStage(labels, elapsed.Seconds())
+	}
+}
+
+// StartTurn 开始新的一轮对话, 结束上一轮
+func (s *Session) StartTurn(mode string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endTurn()
+
+	attrs := append([]attribute.KeyValue{attribute.String("listen_mode", mode)}, s.spanAttrs...)
+	ctx, span := s.tracer.Start(context.Background(), "turn", trace.WithAttributes(attrs...))
+	s.turn = &turn{ctx: ctx, span: span, start: time.Now()}
+}
+
+func (s *Session) endTurn() {
+	t := s.turn
+	if t == nil {
+		return
+	}
+	if t.speech != nil && t.speechEnd.IsZero() {
+		t.speech.End()
+	}
+	if t.asr != nil {
+		t.asr.End()
+	}
+	t.span.End()
+	s.turn = nil
+}
+
+// SpeechStart vad 检测到开口, 每轮只记录第一次
+func (s *Session) SpeechStart() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.turn
+	if t == nil || t.speech != nil {
+		return
+	}
+	_, t.speech = s.tracer.Start(t.ctx, "vad.speech")
+	t.speechStart = time.Now()
+}
+
+// SpeechEnd 检测到静音或设备停止拾音, 每轮只记录第一次
+func (s *Session) SpeechEnd() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.turn
+	if t == nil || t.speech == nil || !t.speechEnd.IsZero() {
+		return
+	}
+	t.speechEnd = time.Now()
+	t.speech.End()
+	s.record(StageSpeech, t.speechStart)
+}
+
+// AsrStart 开始一次识别, 同一轮中重新识别时结束上一次的 span
+func (s *Session) AsrStart() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.turn
+	if t == nil {
+		return
+	}
+	if t.asr != nil {
+		t.asr.End()
+	}
+	_, t.asr = s.tracer.Start(t.ctx, "asr")
+	t.asrStart = time.Now()
+	t.asrPartial = false
+}
+
+// AsrPartial 收到中间结果, 只记录第一个
+func (s *Session) AsrPartial() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.turn
+	if t == nil || t.asr == nil || t.asrPartial {
+		return
+	}
+	t.asrPartial = true
+	t.asr.AddEvent("first_partial")
+	if !t.speechStart.IsZero() {
+		s.record(StageAsrFirstPartial, t.speechStart)
+	} else {
+		s.record(StageAsrFirstPartial, t.asrStart)
+	}
+}
+
+// AsrFinal 收到最终结果, 结束 asr span
+func (s *Session) AsrFinal(text string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.turn
+	if t == nil || t.asr == nil {
+		return
+	}
+	t.asr.SetAttributes(attribute.Int("text_length", len([]rune(text))))
+	t.asr.End()
+	t.asr = nil
+	// asr 自动断句时没有先检测到静音, 这时说话结束就是收到结果的时刻, 不计入耗时
+	s.record(StageAsrFinal, t.speechEnd)
+}
+
+// StartSpan 创建一个阶段的 span. ctx 中已有 Span 时作为其子 span(如工具调用后再次请求 llm),
+// 否则挂在当前这一轮下. 返回的 ctx 携带新的 Span, 下游通过 SpanFromContext 取出标记时间点
+func (s *Session) StartSpan(ctx context.Context, stage string, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	if s == nil {
+		return ctx, nil
+	}
+	parentCtx := ctx
+	parent := SpanFromContext(ctx)
+	var t *turn
+	if parent != nil {
+		t = parent.turn
+	} else {
+		s.mu.Lock()
+		t = s.turn
+		s.mu.Unlock()
+		if t != nil {
+			parentCtx = trace.ContextWithSpan(ctx, t.span)
+		}
+	}
+
+	spanCtx, span := s.tracer.Start(parentCtx, stage, trace.WithAttributes(attrs...))
+	sp := &Span{
+		session: s,
+		turn:    t,
+		stage:   stage,
+		span:    span,
+		start:   time.Now(),
+		marked:  make(map[string]bool),
+	}
+	return context.WithValue(spanCtx, spanKey{}, sp), sp
+}
+
+// WithTurn 把当前这一轮放入 ctx, 之后用这个 ctx 创建的 span 都挂在这一轮下, 即使期间已经开始了新的一轮
+func (s *Session) WithTurn(ctx context.Context) context.Context {
+	if s == nil {
+		return ctx
+	}
+	s.mu.Lock()
+	t := s.turn
+	s.mu.Unlock()
+	if t == nil {
+		return ctx
+	}
+	// 只作为父节点, 不记录时间点也不重复结束
+	sp := &Span{session: s, turn: t, span: t.span, ended: true}
+	return context.WithValue(trace.ContextWithSpan(ctx, t.span), spanKey{}, sp)
+}
+
+// Close 会话结束, 结束当前这一轮
+func (s *Session) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endTurn()
+}
+
+type spanKey struct{}
+
+// SpanFromContext 取出 StartSpan 放入的 Span, 没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	sp, _ := ctx.Value(spanKey{}).(*Span)
+	return sp
+}
+
+// Span 一个阶段的 span, 为 nil 时所有方法都是空操作
+type Span struct {
+	session *Session
+	// 创建时所在的一轮, 实时模式下新一轮开始后上一轮的回复仍记在上一轮
+	turn  *turn
+	stage string
+	span  trace.Span
+	start time.Time
+
+	marked map[string]bool
+	ended  bool
+	mu     sync.Mutex
+}
+
+// Mark 标记阶段内的时间点, 同一个时间点只记录第一次
+func (sp *Span) Mark(mark string) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.ended || sp.marked[mark] {
+		return
+	}
+	sp.marked[mark] = true
+	sp.span.AddEvent(mark)
+	sp.session.record(mark, sp.start)
+}
+
+// AudioSent 第一帧音频下发到设备, 每轮只记录第一次
+func (sp *Span) AudioSent() {
+	if sp == nil || sp.turn == nil {
+		return
+	}
+	s := sp.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := sp.turn
+	if t.audioSent {
+		return
+	}
+	t.audioSent = true
+	t.span.AddEvent(StageFirstAudio)
+	sp.span.AddEvent(StageFirstAudio)
+	if !t.speechEnd.IsZero() {
+		s.record(StageFirstAudio, t.speechEnd)
+	} else {
+		s.record(StageFirstAudio, t.start)
+	}
+}
+
+// End 结束阶段并记录总耗时, 重复调用只生效一次
+func (sp *Span) End(err error) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.ended {
+		return
+	}
+	sp.ended = true
+	if err != nil {
+		sp.span.RecordError(err)
+		sp.span.SetStatus(codes.Error, err.Error())
+	}
+	sp.span.End()
+	sp.session.record(sp.stage, sp.start)
+}
@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setupTest(t *testing.T) (*tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	viper.Set("metrics.enable", true)
+	t.Cleanup(func() { viper.Set("metrics.enable", nil) })
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	require.NoError(t, setup(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	))
+	t.Cleanup(func() {
+		tracer = nil
+		durations = nil
+	})
+	return spans, reader
+}
+
+// stageCounts 各 stage 记录到直方图的次数
+func stageCounts(t *testing.T, reader *sdkmetric.ManualReader) map[string]uint64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	counts := make(map[string]uint64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			histogram, ok := m.Data.(metricdata.Histogram[float64])
+			if !ok {
+				continue
+			}
+			for _, point := range histogram.DataPoints {
+				stage, _ := point.Attributes.Value("stage")
+				counts[stage.AsString()] += point.Count
+			}
+		}
+	}
+	return counts
+}
+
+func TestSession_Disabled(t *testing.T) {
+	viper.Set("metrics.enable", false)
+	t.Cleanup(func() { viper.Set("metrics.enable", nil) })
+
+	s := NewSession("device")
+	assert.Nil(t, s)
+
+	// 未开启时所有埋点都是空操作
+	s.StartTurn("auto")
+	s.SpeechStart()
+	s.AsrStart()
+	ctx, span := s.StartSpan(context.Background(), StageLlm)
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+	span.Mark(MarkLlmFirstToken)
+	span.AudioSent()
+	span.End(nil)
+	assert.Equal(t, context.Background(), s.WithTurn(context.Background()))
+	s.Close()
+}
+
+func TestSession_Turn(t *testing.T) {
+	spans, reader := setupTest(t)
+
+	s := NewSession("device", attribute.String("agent_id", "agent"))
+	require.NotNil(t, s)
+
+	s.StartTurn("auto")
+	s.AsrStart()
+	s.SpeechStart()
+	s.AsrPartial()
+	s.AsrPartial()
+	s.SpeechEnd()
+	s.SpeechEnd()
+	s.AsrFinal("你好")
+
+	ctx, llmSpan := s.StartSpan(context.Background(), StageLlm)
+	llmSpan.Mark(MarkLlmFirstToken)
+	llmSpan.Mark(MarkLlmFirstSentence)
+
+	_, toolSpan := s.StartSpan(ctx, StageTool, attribute.String("tool", "get_weather"))
+	toolSpan.End(errors.New("timeout"))
+
+	_, ttsSpan := s.StartSpan(ctx, StageTts)
+	llmSpan.End(nil)
+	ttsSpan.Mark(MarkTtsFirstFrame)
+	ttsSpan.AudioSent()
+	ttsSpan.End(nil)
+	ttsSpan.End(nil)
+
+	// 同一轮的第二句话不再记录首帧下发
+	_, ttsSpan2 := s.StartSpan(ctx, StageTts)
+	ttsSpan2.AudioSent()
+	ttsSpan2.End(nil)
+
+	s.Close()
+
+	ended := spans.Ended()
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range ended {
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	require.Len(t, byName["turn"], 1)
+	turn := byName["turn"][0]
+	assert.Equal(t, 1, len(byName["vad.speech"]))
+	assert.Equal(t, 1, len(byName["asr"]))
+	assert.Equal(t, 1, len(byName[StageLlm]))
+	assert.Equal(t, 2, len(byName[StageTts]))
+
+	// llm 挂在这一轮下, 工具调用和 tts 挂在 llm 下
+	llm := byName[StageLlm][0]
+	assert.Equal(t, turn.SpanContext().SpanID(), llm.Parent().SpanID())
+	assert.Equal(t, llm.SpanContext().SpanID(), byName[StageTool][0].Parent().SpanID())
+	assert.Equal(t, llm.SpanContext().SpanID(), byName[StageTts][0].Parent().SpanID())
+	assert.Equal(t, "timeout", byName[StageTool][0].Status().Description)
+
+	counts := stageCounts(t, reader)
+	assert.Equal(t, map[string]uint64{
+		StageSpeech:          1,
+		StageAsrFirstPartial: 1,
+		StageAsrFinal:        1,
+		MarkLlmFirstToken:    1,
+		MarkLlmFirstSentence: 1,
+		StageTool:            1,
+		StageLlm:             1,
+		MarkTtsFirstFrame:    1,
+		StageFirstAudio:      1,
+		StageTts:             2,
+	}, counts)
+}
+
+func TestSession_WithTurn(t *testing.T) {
+	spans, _ := setupTest(t)
+
+	s := NewSession("device")
+	s.StartTurn("realtime")
+	ctx := s.WithTurn(context.Background())
+	// 实时模式下识别出一句话后立即开始下一轮, 这句话的回复仍属于上一轮
+	s.StartTurn("realtime")
+
+	_, span := s.StartSpan(ctx, StageLlm)
+	span.End(nil)
+	_, current := s.StartSpan(context.Background(), StageLlm)
+	current.End(nil)
+	s.Close()
+
+	var turns []sdktrace.ReadOnlySpan
+	var llms []sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		switch span.Name() {
+		case "turn":
+			turns = append(turns, span)
+		case StageLlm:
+			llms = append(llms, span)
+		}
+	}
+	require.Len(t, turns, 2)
+	require.Len(t, llms, 2)
+	assert.Equal(t, turns[0].SpanContext().SpanID(), llms[0].Parent().SpanID())
+	assert.Equal(t, turns[1].SpanContext().SpanID(), llms[1].Parent().SpanID())
+}
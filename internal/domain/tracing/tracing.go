@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	log "xiaozhi-esp32-server-golang/logger"
+)
+
+const instrumentationName = "xiaozhi-esp32-server-golang"
+
+// 子 span 的名称, 结束时以同名的 stage 记录整个阶段的耗时
+const (
+	StageLlm  = "llm"
+	StageTool = "tool"
+	StageTts  = "tts"
+)
+
+// 阶段内的关键时间点, 以同名的 stage 记录从阶段开始到该时间点的耗时
+const (
+	MarkLlmFirstToken    = "llm_first_token"
+	MarkLlmFirstSentence = "llm_first_sentence"
+	MarkTtsFirstFrame    = "tts_first_frame"
+)
+
+// 由 Session 直接记录的 stage
+const (
+	// 用户说话时长, vad 检测到开口到静音
+	StageSpeech = "vad_speech"
+	// 开口到 asr 返回第一个中间结果
+	StageAsrFirstPartial = "asr_first_partial"
+	// 说话结束到 asr 返回最终结果
+	StageAsrFinal = "asr_final"
+	// 说话结束到第一帧音频下发到设备, 即用户感受到的响应延迟
+	StageFirstAudio = "first_audio"
+)
+
+var (
+	tracer    trace.Tracer
+	durations metric.Float64Histogram
+	shutdowns []func(context.Context) error
+)
+
+// Init 按 tracing 配置初始化 otlp 导出, 未开启时 span 不上报
+func Init(ctx context.Context) error {
+	if !viper.GetBool("tracing.enable") {
+		return nil
+	}
+
+	endpoint := viper.GetString("tracing.endpoint")
+	if endpoint == "" {
+		endpoint = "localhost:4318"
+	}
+	serviceName := viper.GetString("tracing.service_name")
+	if serviceName == "" {
+		serviceName = "xiaozhi-server"
+	}
+	sampleRatio := 1.0
+	if viper.IsSet("tracing.sample_ratio") {
+		sampleRatio = viper.GetFloat64("tracing.sample_ratio")
+	}
+	interval := viper.GetDuration("tracing.metric_interval")
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	traceOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	metricOpts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(endpoint)}
+	if viper.GetBool("tracing.insecure") {
+		traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+		metricOpts = append(metricOpts, otlpmetrichttp.WithInsecure())
+	}
+	traceExporter, err := otlptracehttp.New(ctx, traceOpts...)
+	if err != nil {
+		return err
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOpts...)
+	if err != nil {
+		return err
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", serviceName))
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+	shutdowns = append(shutdowns, tp.Shutdown, mp.Shutdown)
+
+	if err := setup(tp, mp); err != nil {
+		return err
+	}
+	log.Infof("链路追踪已开启, 上报地址: %s, 采样比例: %.2f", endpoint, sampleRatio)
+	return nil
+}
+
+func setup(tp trace.TracerProvider, mp metric.MeterProvider) error {
+	histogram, err := mp.Meter(instrumentationName).Float64Histogram(
+		"xiaozhi.turn.stage.duration",
+		metric.WithUnit("ms"),
+		metric.WithDescription("每轮对话各阶段的耗时"),
+		metric.WithExplicitBucketBoundaries(50, 100, 200, 300, 500, 800, 1000, 1500, 2000, 3000, 5000, 8000, 13000, 20000),
+	)
+	if err != nil {
+		return err
+	}
+	tracer = tp.Tracer(instrumentationName)
+	durations = histogram
+	return nil
+}
+
+// Enabled 是否开启了链路追踪
+func Enabled() bool {
+	return tracer != nil
+}
+
+// Shutdown 上报剩余的 span 和指标
+func Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, shutdown := range shutdowns {
+		errs = append(errs, shutdown(ctx))
+	}
+	shutdowns = nil
+	return errors.Join(errs...)
+}
@@ -52,6 +52,40 @@ func GetTTSProvider(providerName string, config map[string]interface{}) (TTSProv
 	return provider, nil
 }
 
+// 各提供者配置中表示音色的字段
+var voiceKeys = map[string]string{
+	constants.TtsTypeDoubao:    "voice",
+	constants.TtsTypeDoubaoWS:  "voice",
+	constants.TtsTypeCosyvoice: "spk_id",
+	constants.TtsTypeEdge:      "voice",
+	constants.TtsTypeOpenAI:    "voice",
+}
+
+// VoiceConfig 按语言切换音色. 配置中的 voices 为语言到音色的映射, 如 {"en": "en-US-AriaNeural"},
+// 返回替换了音色的配置副本; 未配置该语言或提供者不支持切换音色时返回 nil
+func VoiceConfig(providerName string, config map[string]interface{}, lang string) map[string]interface{} {
+	key, ok := voiceKeys[providerName]
+	if !ok || lang == "" {
+		return nil
+	}
+	var voice string
+	switch voices := config["voices"].(type) {
+	case map[string]interface{}:
+		voice, _ = voices[lang].(string)
+	case map[string]string:
+		voice = voices[lang]
+	}
+	if voice == "" {
+		return nil
+	}
+	voiceConfig := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		voiceConfig[k] = v
+	}
+	voiceConfig[key] = voice
+	return voiceConfig
+}
+
 // ContextTTSAdapter 是一个适配器，为基础TTS提供者添加Context支持
 type ContextTTSAdapter struct {
 	Provider BaseTTSProvider
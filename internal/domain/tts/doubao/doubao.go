@@ -15,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"xiaozhi-esp32-server-golang/internal/domain/emotion"
 	"xiaozhi-esp32-server-golang/internal/util"
 	log "xiaozhi-esp32-server-golang/logger"
 )
@@ -84,6 +85,34 @@ type audioInfo struct {
 	SpeedRatio  float64 `json:"speed_ratio"`
 	VolumeRatio float64 `json:"volume_ratio"`
 	PitchRatio  float64 `json:"pitch_ratio"`
+	// 多情感音色的说话风格, 其他音色忽略
+	Emotion       string `json:"emotion,omitempty"`
+	EnableEmotion bool   `json:"enable_emotion,omitempty"`
+}
+
+// 多情感音色支持的风格, 固件的情绪按相近程度映射, 没有对应风格的情绪不设置
+var emotionStyles = map[string]string{
+	emotion.Happy:     "happy",
+	emotion.Laughing:  "happy",
+	emotion.Funny:     "happy",
+	emotion.Loving:    "happy",
+	emotion.Kissy:     "happy",
+	emotion.Winking:   "happy",
+	emotion.Silly:     "happy",
+	emotion.Delicious: "happy",
+	emotion.Sad:       "sad",
+	emotion.Crying:    "sad",
+	emotion.Angry:     "angry",
+	emotion.Surprised: "surprised",
+	emotion.Shocked:   "fear",
+	emotion.Neutral:   "neutral",
+	emotion.Relaxed:   "neutral",
+	emotion.Thinking:  "neutral",
+}
+
+// emotionStyle ctx 中情绪对应的说话风格
+func emotionStyle(ctx context.Context) string {
+	return emotionStyles[emotion.FromContext(ctx)]
 }
 
 type requestInfo struct {
@@ -137,6 +166,7 @@ func NewDoubaoTTSProvider(config map[string]interface{}) *DoubaoTTSProvider {
 
 // TextToSpeech 将文本转换为语音，返回音频帧数据和错误
 func (p *DoubaoTTSProvider) TextToSpeech(ctx context.Context, text string, sampleRate int, channels int, frameDuration int) ([][]byte, error) {
+	style := emotionStyle(ctx)
 	// 准备请求数据
 	reqData := doubaoRequest{
 		App: appInfo{
@@ -148,12 +178,14 @@ func (p *DoubaoTTSProvider) TextToSpeech(ctx context.Context, text string, sampl
 			UID: "1",
 		},
 		Audio: audioInfo{
-			VoiceType:   p.Voice,
-			Encoding:    "wav",
-			Rate:        sampleRate,
-			SpeedRatio:  1.0,
-			VolumeRatio: 1.0,
-			PitchRatio:  1.0,
+			VoiceType:     p.Voice,
+			Encoding:      "wav",
+			Rate:          sampleRate,
+			SpeedRatio:    1.0,
+			VolumeRatio:   1.0,
+			PitchRatio:    1.0,
+			Emotion:       style,
+			EnableEmotion: style != "",
 		},
 		Request: requestInfo{
 			ReqID:        generateUUID(),
@@ -311,7 +311,7 @@ func (p *DoubaoWSProvider) TextToSpeechStream(ctx context.Context, text string,
 	startTs := time.Now().UnixMilli()
 
 	// 准备请求数据
-	input := p.setupInput(text, p.Voice, operation, sampleRate, channels, frameDuration)
+	input := p.setupInput(text, p.Voice, emotionStyle(ctx), operation, sampleRate, channels, frameDuration)
 
 	// 获取或创建WebSocket连接
 	conn, err := p.getWSConnection()
@@ -493,7 +493,7 @@ func (p *DoubaoWSProvider) removeWSConnection(conn *websocket.Conn) {
 }
 
 // 设置请求输入
-func (p *DoubaoWSProvider) setupInput(text, voiceType, opt string, sampleRate int, channels int, frameDuration int) []byte {
+func (p *DoubaoWSProvider) setupInput(text, voiceType, style, opt string, sampleRate int, channels int, frameDuration int) []byte {
 	// 生成请求ID
 	reqID := generateUUID()
 
@@ -518,6 +518,10 @@ func (p *DoubaoWSProvider) setupInput(text, voiceType, opt string, sampleRate in
 	params["audio"]["speed_ratio"] = 1.0
 	params["audio"]["volume_ratio"] = 1.0
 	params["audio"]["pitch_ratio"] = 1.0
+	if style != "" {
+		params["audio"]["emotion"] = style
+		params["audio"]["enable_emotion"] = true
+	}
 
 	// 请求信息
 	params["request"] = make(map[string]interface{})
@@ -31,3 +31,15 @@ func ReleaseVAD(vad inter.VAD) error {
 	}
 	return nil
 }
+
+// PoolStats 各 vad 资源池的使用情况, 按 provider 区分, 未创建的资源池不返回
+func PoolStats() map[string]map[string]interface{} {
+	stats := make(map[string]map[string]interface{})
+	if s := silero_vad.GetPoolStats(); s != nil {
+		stats[constants.VadTypeSileroVad] = s
+	}
+	if s := webrtc_vad.GetPoolStats(); s != nil {
+		stats[constants.VadTypeWebRTCVad] = s
+	}
+	return stats
+}
@@ -267,6 +267,19 @@ func AcquireVAD(config map[string]interface{}) (VAD, error) {
 	return globalVADResourcePool.AcquireVAD()
 }
 
+// GetPoolStats 资源池使用情况, 字段与 util.ResourcePool.Stats 一致, 未初始化时返回 nil
+func GetPoolStats() map[string]interface{} {
+	pool := globalVADResourcePool
+	if pool == nil || !pool.initialized {
+		return nil
+	}
+	return map[string]interface{}{
+		"in_use_resources":    pool.GetActiveCount(),
+		"available_resources": pool.GetAvailableCount(),
+		"max_size":            pool.maxSize,
+	}
+}
+
 // ReleaseVAD 释放一个VAD实例
 func ReleaseVAD(vad VAD) error {
 	if globalVADResourcePool != nil && globalVADResourcePool.initialized {
@@ -53,6 +53,14 @@ func AcquireVAD(config map[string]interface{}) (inter.VAD, error) {
 	return vadPool.AcquireVAD()
 }
 
+// GetPoolStats 资源池使用情况, 首次获取 vad 之前资源池未创建, 返回 nil
+func GetPoolStats() map[string]interface{} {
+	if vadPool == nil {
+		return nil
+	}
+	return vadPool.Stats()
+}
+
 func ReleaseVAD(vad inter.VAD) error {
 	if vadPool != nil {
 		return vadPool.ReleaseVAD(vad)
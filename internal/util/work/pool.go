@@ -46,6 +46,11 @@ func (p *Pool) Submit(job Job) error {
 	return nil
 }
 
+// QueueLen returns the number of jobs waiting in the queue.
+func (p *Pool) QueueLen() int {
+	return len(p.jobQueue)
+}
+
 // Stop stops the worker pool and waits for all workers to finish.
 func (p *Pool) Stop() {
 	p.mu.Lock()
@@ -38,22 +38,27 @@ type AdminController struct {
 // 通用配置管理
 // GetDeviceConfigs 根据设备ID获取设备关联的配置信息
 // 如果设备不存在，则返回全局默认配置
+// 不传设备ID时可以通过 agent_id 直接获取智能体的配置，供网页/App 文本对话使用
 func (ac *AdminController) GetDeviceConfigs(c *gin.Context) {
 	deviceID := c.Query("device_id")
-	if deviceID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id parameter is required"})
+	agentID := c.Query("agent_id")
+	if deviceID == "" && agentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id or agent_id parameter is required"})
 		return
 	}
 
 	// 构建配置响应
 	type ConfigResponse struct {
-		VAD     models.Config `json:"vad"`
-		ASR     models.Config `json:"asr"`
-		LLM     models.Config `json:"llm"`
-		TTS     models.Config `json:"tts"`
-		Memory  models.Config `json:"memory"`
-		Prompt  string        `json:"prompt"`
-		AgentID string        `json:"agent_id"`
+		VAD             models.Config `json:"vad"`
+		ASR             models.Config `json:"asr"`
+		LLM             models.Config `json:"llm"`
+		TTS             models.Config `json:"tts"`
+		Memory          models.Config `json:"memory"`
+		Prompt          string        `json:"prompt"`
+		AgentID         string        `json:"agent_id"`
+		Hotwords        string        `json:"hotwords"`
+		WakeupWords     string        `json:"wakeup_words"`
+		AudioPreprocess string        `json:"audio_preprocess"`
 	}
 
 	var response ConfigResponse
@@ -63,7 +68,28 @@ func (ac *AdminController) GetDeviceConfigs(c *gin.Context) {
 	var agent models.Agent
 	var deviceFound bool
 
-	if err := ac.DB.Where("device_name = ?", deviceID).First(&device).Error; err != nil {
+	if deviceID == "" {
+		id, err := strconv.ParseUint(agentID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent_id"})
+			return
+		}
+		if err := ac.DB.First(&agent, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query agent"})
+			}
+			return
+		}
+		deviceFound = true
+		response.AgentID = agentID
+		response.Prompt = agent.CustomPrompt
+		response.Hotwords = agent.Hotwords
+		response.WakeupWords = agent.WakeupWords
+		response.AudioPreprocess = agent.AudioPreprocess
+		log.Printf("按智能体 %s 获取配置", agentID)
+	} else if err := ac.DB.Where("device_name = ?", deviceID).First(&device).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// 设备不存在，使用全局默认配置
 			deviceFound = false
@@ -89,6 +115,9 @@ func (ac *AdminController) GetDeviceConfigs(c *gin.Context) {
 			}
 		} else {
 			response.Prompt = agent.CustomPrompt
+			response.Hotwords = agent.Hotwords
+			response.WakeupWords = agent.WakeupWords
+			response.AudioPreprocess = agent.AudioPreprocess
 			log.Printf("智能体 %d 存在，使用自定义提示词", device.AgentID)
 		}
 	}
@@ -230,11 +230,14 @@ func (uc *UserController) CreateAgent(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 
 	var req struct {
-		Name         string  `json:"name" binding:"required,min=2,max=50"`
-		CustomPrompt string  `json:"custom_prompt"`
-		LLMConfigID  *string `json:"llm_config_id"`
-		TTSConfigID  *string `json:"tts_config_id"`
-		ASRSpeed     string  `json:"asr_speed"`
+		Name            string  `json:"name" binding:"required,min=2,max=50"`
+		CustomPrompt    string  `json:"custom_prompt"`
+		LLMConfigID     *string `json:"llm_config_id"`
+		TTSConfigID     *string `json:"tts_config_id"`
+		ASRSpeed        string  `json:"asr_speed"`
+		Hotwords        string  `json:"hotwords"`
+		WakeupWords     string  `json:"wakeup_words"`
+		AudioPreprocess string  `json:"audio_preprocess"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -248,13 +251,16 @@ func (uc *UserController) CreateAgent(c *gin.Context) {
 	}
 
 	agent := models.Agent{
-		UserID:       userID.(uint),
-		Name:         req.Name,
-		CustomPrompt: req.CustomPrompt,
-		LLMConfigID:  req.LLMConfigID,
-		TTSConfigID:  req.TTSConfigID,
-		ASRSpeed:     req.ASRSpeed,
-		Status:       "active",
+		UserID:          userID.(uint),
+		Name:            req.Name,
+		CustomPrompt:    req.CustomPrompt,
+		LLMConfigID:     req.LLMConfigID,
+		TTSConfigID:     req.TTSConfigID,
+		ASRSpeed:        req.ASRSpeed,
+		Hotwords:        req.Hotwords,
+		WakeupWords:     req.WakeupWords,
+		AudioPreprocess: req.AudioPreprocess,
+		Status:          "active",
 	}
 
 	if err := uc.DB.Create(&agent).Error; err != nil {
@@ -314,11 +320,14 @@ func (uc *UserController) UpdateAgent(c *gin.Context) {
 	}
 
 	var req struct {
-		Name         string  `json:"name" binding:"required,min=2,max=50"`
-		CustomPrompt string  `json:"custom_prompt"`
-		LLMConfigID  *string `json:"llm_config_id"`
-		TTSConfigID  *string `json:"tts_config_id"`
-		ASRSpeed     string  `json:"asr_speed"`
+		Name            string  `json:"name" binding:"required,min=2,max=50"`
+		CustomPrompt    string  `json:"custom_prompt"`
+		LLMConfigID     *string `json:"llm_config_id"`
+		TTSConfigID     *string `json:"tts_config_id"`
+		ASRSpeed        string  `json:"asr_speed"`
+		Hotwords        string  `json:"hotwords"`
+		WakeupWords     string  `json:"wakeup_words"`
+		AudioPreprocess string  `json:"audio_preprocess"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -331,6 +340,9 @@ func (uc *UserController) UpdateAgent(c *gin.Context) {
 	agent.CustomPrompt = req.CustomPrompt
 	agent.LLMConfigID = req.LLMConfigID
 	agent.TTSConfigID = req.TTSConfigID
+	agent.Hotwords = req.Hotwords
+	agent.WakeupWords = req.WakeupWords
+	agent.AudioPreprocess = req.AudioPreprocess
 
 	if req.ASRSpeed != "" {
 		agent.ASRSpeed = req.ASRSpeed
@@ -720,15 +720,18 @@ func (ctrl *WebSocketController) RequestPingFromClient(ctx context.Context, uuid
 
 // InjectMessageToDevice 向设备注入消息（广播方式）
 func (ctrl *WebSocketController) InjectMessageToDevice(ctx context.Context, deviceID, message string, skipLlm bool) error {
+	// 同一个 request_id 广播给所有节点, 节点间据此去重, 保证只注入一次
+	requestID := uuid.New().String()
 	body := map[string]interface{}{
-		"device_id": deviceID,
-		"message":   message,
-		"skip_llm":  skipLlm,
+		"request_id": requestID,
+		"device_id":  deviceID,
+		"message":    message,
+		"skip_llm":   skipLlm,
 	}
 
 	// 创建请求
 	request := WebSocketRequest{
-		ID:     uuid.New().String(),
+		ID:     requestID,
 		Method: "POST",
 		Path:   "/api/device/inject_msg",
 		Body:   body,
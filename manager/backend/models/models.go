@@ -32,16 +32,19 @@ type Device struct {
 
 // 智能体模型
 type Agent struct {
-	ID           uint      `json:"id" gorm:"primarykey"`
-	UserID       uint      `json:"user_id" gorm:"not null"`
-	Name         string    `json:"name" gorm:"type:varchar(100);not null"`             // 昵称
-	CustomPrompt string    `json:"custom_prompt" gorm:"type:text"`                     // 角色介绍(prompt)
-	LLMConfigID  *string   `json:"llm_config_id" gorm:"type:varchar(100)"`             // 语言模型配置ID
-	TTSConfigID  *string   `json:"tts_config_id" gorm:"type:varchar(100)"`             // 音色配置ID
-	ASRSpeed     string    `json:"asr_speed" gorm:"type:varchar(20);default:'normal'"` // 语音识别速度: normal/patient/fast
-	Status       string    `json:"status" gorm:"type:varchar(20);default:'active'"`    // active, inactive
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              uint      `json:"id" gorm:"primarykey"`
+	UserID          uint      `json:"user_id" gorm:"not null"`
+	Name            string    `json:"name" gorm:"type:varchar(100);not null"`             // 昵称
+	CustomPrompt    string    `json:"custom_prompt" gorm:"type:text"`                     // 角色介绍(prompt)
+	LLMConfigID     *string   `json:"llm_config_id" gorm:"type:varchar(100)"`             // 语言模型配置ID
+	TTSConfigID     *string   `json:"tts_config_id" gorm:"type:varchar(100)"`             // 音色配置ID
+	ASRSpeed        string    `json:"asr_speed" gorm:"type:varchar(20);default:'normal'"` // 语音识别速度: normal/patient/fast
+	Hotwords        string    `json:"hotwords" gorm:"type:text"`                          // 识别热词, 每行一个, 可在词后加空格和权重
+	WakeupWords     string    `json:"wakeup_words" gorm:"type:text"`                      // 唤醒词, 每行一个, 与全局唤醒词合并
+	AudioPreprocess string    `json:"audio_preprocess" gorm:"type:text"`                  // 音频预处理配置(json), 为空时使用全局配置
+	Status          string    `json:"status" gorm:"type:varchar(20);default:'active'"`    // active, inactive
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 // 通用配置模型